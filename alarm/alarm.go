@@ -0,0 +1,317 @@
+// Package alarm implements a rule-based alarm engine for the online monitoring.
+//
+// At each monitoring update, the engine evaluates its rules on a Snapshot of the
+// data quality quantities and run counters (channel rates, integrity errors, HV
+// values, monitoring buffer). Each rule reports the sources (e.g. a channel)
+// violating it with a severity. An alarm is raised when a violation has lasted
+// for the duration of the rule (see Rule.For), and cleared when the violation
+// disappears. Raised alarms are kept in the history of the engine, displayed on
+// the monitoring web page and passed to a local command (see Engine.Hook).
+package alarm
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
+	"go-hep.org/x/hep/csvutil"
+)
+
+// Severity is the severity of an alarm.
+type Severity byte
+
+const (
+	Info     Severity = iota // worth noting, no action needed
+	Warning                  // data quality may be degraded
+	Critical                 // data taking must be checked immediately
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	default:
+		return fmt.Sprintf("Severity(%d)", byte(s))
+	}
+}
+
+// Set implements flag.Value.
+func (s *Severity) Set(value string) error {
+	switch value {
+	case "info":
+		*s = Info
+	case "warning":
+		*s = Warning
+	case "critical":
+		*s = Critical
+	default:
+		return fmt.Errorf("invalid alarm severity %q", value)
+	}
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, so that severities are sent by name to the web client.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Snapshot holds the quantities on which rules are evaluated.
+type Snapshot struct {
+	Time            time.Time
+	ChannelCounts   []float64 // number of pulses with signal per channel since the start of the run
+	IntegrityErrors uint      // number of events which failed the integrity tests since the start of the run
+	HV              []float64 // HV values (V) per HV channel, nil if HV was not read for this snapshot
+	MonBufLen       int       // number of monitoring data waiting to be sent to the web client
+	MonBufSize      int       // capacity of the monitoring buffer
+}
+
+// Violation is reported by a rule for each source violating it.
+type Violation struct {
+	Source   string // e.g. "channel 12", empty if the rule applies to the whole detector
+	Severity Severity
+	Message  string
+}
+
+// Rule is a condition evaluated on snapshots.
+type Rule struct {
+	Name  string
+	For   time.Duration                 // minimal duration of a violation before an alarm is raised
+	Check func(s *Snapshot) []Violation // returns the violations for the snapshot s
+}
+
+// Alarm is a violation of a rule which lasted for at least the duration of the rule.
+type Alarm struct {
+	Rule     string    `json:"rule"`
+	Source   string    `json:"source"`
+	Severity Severity  `json:"severity"`
+	Message  string    `json:"message"`
+	Raised   time.Time `json:"raised"`
+	Cleared  time.Time `json:"cleared"` // zero while the alarm is active
+}
+
+// Active returns whether the alarm has not been cleared.
+func (a *Alarm) Active() bool {
+	return a.Cleared.IsZero()
+}
+
+func (a Alarm) String() string {
+	src := ""
+	if a.Source != "" {
+		src = " (" + a.Source + ")"
+	}
+	return fmt.Sprintf("%v: %v%v: %v", a.Severity, a.Rule, src, a.Message)
+}
+
+// Engine evaluates rules and keeps the history of the alarms.
+type Engine struct {
+	Rules   []Rule
+	History []Alarm // alarms raised since the creation of the engine, in order
+
+	// Hook is a shell command run (asynchronously) each time an alarm with
+	// a severity at least equal to HookSeverity is raised, e.g. to send a
+	// mail or play a sound. The alarm is given by the environment variables
+	// ALARM_SEVERITY, ALARM_RULE, ALARM_SOURCE, ALARM_MESSAGE and ALARM_TIME.
+	// No command is run if Hook is empty.
+	Hook         string
+	HookSeverity Severity
+
+	since  map[string]time.Time // start of the ongoing violations
+	active map[string]int       // index in History of the active alarms
+}
+
+// NewEngine returns an engine evaluating the given rules.
+func NewEngine(rules ...Rule) *Engine {
+	return &Engine{
+		Rules:        rules,
+		HookSeverity: Warning,
+		since:        make(map[string]time.Time),
+		active:       make(map[string]int),
+	}
+}
+
+func key(rule, source string) string {
+	return rule + "/" + source
+}
+
+// Evaluate evaluates the rules on s, raises the alarms whose violation lasted
+// long enough, clears the alarms whose violation disappeared and returns the
+// alarms raised. An active alarm whose severity changes is cleared and raised
+// again with the new severity.
+func (e *Engine) Evaluate(s *Snapshot) []Alarm {
+	var raised []Alarm
+	seen := make(map[string]bool)
+	for i := range e.Rules {
+		r := &e.Rules[i]
+		for _, v := range r.Check(s) {
+			k := key(r.Name, v.Source)
+			seen[k] = true
+			since, ok := e.since[k]
+			if !ok {
+				since = s.Time
+				e.since[k] = since
+			}
+			if ia, ok := e.active[k]; ok {
+				if e.History[ia].Severity == v.Severity {
+					e.History[ia].Message = v.Message
+					continue
+				}
+				e.History[ia].Cleared = s.Time
+				delete(e.active, k)
+			}
+			if s.Time.Sub(since) < r.For {
+				continue
+			}
+			a := Alarm{
+				Rule:     r.Name,
+				Source:   v.Source,
+				Severity: v.Severity,
+				Message:  v.Message,
+				Raised:   s.Time,
+			}
+			e.History = append(e.History, a)
+			e.active[k] = len(e.History) - 1
+			raised = append(raised, a)
+			e.runHook(a)
+		}
+	}
+	for k, ia := range e.active {
+		if !seen[k] {
+			e.History[ia].Cleared = s.Time
+			delete(e.active, k)
+		}
+	}
+	for k := range e.since {
+		if !seen[k] {
+			delete(e.since, k)
+		}
+	}
+	return raised
+}
+
+// Active returns the active alarms, the most severe first.
+func (e *Engine) Active() []Alarm {
+	alarms := make([]Alarm, 0, len(e.active))
+	for _, ia := range e.active {
+		alarms = append(alarms, e.History[ia])
+	}
+	sort.Slice(alarms, func(i, j int) bool {
+		if alarms[i].Severity != alarms[j].Severity {
+			return alarms[i].Severity > alarms[j].Severity
+		}
+		return alarms[i].Raised.Before(alarms[j].Raised)
+	})
+	return alarms
+}
+
+// Last returns a copy of the n last alarms of the history (all of them if there are less than n).
+func (e *Engine) Last(n int) []Alarm {
+	h := e.History
+	if len(h) > n {
+		h = h[len(h)-n:]
+	}
+	return append([]Alarm(nil), h...)
+}
+
+// Summary returns the number of alarms raised per severity, e.g.
+// "critical:1,warning:3", or "none". It contains no space, so that it
+// can be stored in a column of the runs csv file.
+func (e *Engine) Summary() string {
+	var counts [Critical + 1]int
+	for i := range e.History {
+		counts[e.History[i].Severity]++
+	}
+	var s []string
+	for sev := Critical; ; sev-- {
+		if counts[sev] > 0 {
+			s = append(s, fmt.Sprintf("%v:%v", sev, counts[sev]))
+		}
+		if sev == Info {
+			break
+		}
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, ",")
+}
+
+func (e *Engine) runHook(a Alarm) {
+	if e.Hook == "" || a.Severity < e.HookSeverity {
+		return
+	}
+	cmd := exec.Command("sh", "-c", e.Hook)
+	cmd.Env = append(os.Environ(),
+		"ALARM_SEVERITY="+a.Severity.String(),
+		"ALARM_RULE="+a.Rule,
+		"ALARM_SOURCE="+a.Source,
+		"ALARM_MESSAGE="+a.Message,
+		"ALARM_TIME="+a.Raised.Format(time.RFC3339))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	go func() {
+		if err := cmd.Run(); err != nil {
+			log.Printf("alarm hook %q failed: %v\n", e.Hook, err)
+		}
+	}()
+}
+
+// historyCSV is a line of the file written by WriteHistory.
+type historyCSV struct {
+	Raised   string
+	Cleared  string
+	Severity string
+	Rule     string
+	Source   string
+	Message  string
+}
+
+// FileName returns the name of the file in which the alarm history of
+// the run recorded in dataFileName is written (e.g. run123Alarms.csv for
+// run123.bin or its compressed version run123.bin.z).
+func FileName(dataFileName string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(dataFileName, rawz.Ext), ".bin") + "Alarms.csv"
+}
+
+// WriteHistory writes the history of the alarms in the csv file fileName,
+// one line per alarm. The clearing time of alarms still active is "active".
+func (e *Engine) WriteHistory(fileName string) error {
+	tbl, err := csvutil.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer tbl.Close()
+	tbl.Writer.Comma = ' '
+
+	err = tbl.WriteHeader("# Raised Cleared Severity Rule Source Message\n")
+	if err != nil {
+		return err
+	}
+	for i := range e.History {
+		a := &e.History[i]
+		cleared := "active"
+		if !a.Active() {
+			cleared = a.Cleared.Format(time.RFC3339)
+		}
+		err = tbl.WriteRow(historyCSV{
+			Raised:   a.Raised.Format(time.RFC3339),
+			Cleared:  cleared,
+			Severity: a.Severity.String(),
+			Rule:     a.Rule,
+			Source:   a.Source,
+			Message:  a.Message,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package alarm
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2018, 5, 2, 10, 0, 0, 0, time.UTC)
+
+// at returns the time sec seconds after t0.
+func at(sec int) time.Time {
+	return t0.Add(time.Duration(sec) * time.Second)
+}
+
+// sources returns the sources and severities of the violations or alarms, e.g. "warning channel 3".
+func sources(vs []Violation) []string {
+	var s []string
+	for _, v := range vs {
+		s = append(s, fmt.Sprintf("%v %v", v.Severity, v.Source))
+	}
+	return s
+}
+
+func TestEvaluate(t *testing.T) {
+	warn := []Violation{{Source: "a", Severity: Warning, Message: "warn"}}
+	crit := []Violation{{Source: "a", Severity: Critical, Message: "crit"}}
+	type step struct {
+		t      int         // time (s) of the snapshot
+		vs     []Violation // violations reported by the rule
+		raised []Severity  // severities of the alarms raised
+		active int         // number of active alarms after the evaluation
+	}
+	tests := []struct {
+		name    string
+		For     time.Duration
+		steps   []step
+		cleared []int // clearing time (s) of the alarms of the history, -1 if active
+	}{
+		{
+			name: "no delay",
+			steps: []step{
+				{0, warn, []Severity{Warning}, 1},
+				{1, warn, nil, 1},
+				{2, nil, nil, 0},
+			},
+			cleared: []int{2},
+		},
+		{
+			name: "delay",
+			For:  10 * time.Second,
+			steps: []step{
+				{0, warn, nil, 0},
+				{5, warn, nil, 0},
+				{10, warn, []Severity{Warning}, 1},
+				{11, warn, nil, 1},
+			},
+			cleared: []int{-1},
+		},
+		{
+			name: "interrupted violation",
+			For:  10 * time.Second,
+			steps: []step{
+				{0, warn, nil, 0},
+				{5, nil, nil, 0},
+				{10, warn, nil, 0},
+				{15, warn, nil, 0},
+				{20, warn, []Severity{Warning}, 1},
+			},
+			cleared: []int{-1},
+		},
+		{
+			name: "severity change",
+			steps: []step{
+				{0, warn, []Severity{Warning}, 1},
+				{1, crit, []Severity{Critical}, 1},
+				{2, crit, nil, 1},
+				{3, warn, []Severity{Warning}, 1},
+				{4, nil, nil, 0},
+			},
+			cleared: []int{1, 3, 4},
+		},
+		{
+			name: "severity change with delay",
+			For:  10 * time.Second,
+			steps: []step{
+				{0, warn, nil, 0},
+				{5, crit, nil, 0},
+				{10, crit, []Severity{Critical}, 1},
+				{12, warn, []Severity{Warning}, 1},
+			},
+			cleared: []int{12, -1},
+		},
+		{
+			name: "several sources",
+			steps: []step{
+				{0, warn, []Severity{Warning}, 1},
+				{1, append([]Violation{{Source: "b", Severity: Critical}}, warn...), []Severity{Critical}, 2},
+				{2, []Violation{{Source: "b", Severity: Critical}}, nil, 1},
+			},
+			cleared: []int{2, -1},
+		},
+	}
+	for _, test := range tests {
+		var vs []Violation
+		e := NewEngine(Rule{Name: "test", For: test.For, Check: func(s *Snapshot) []Violation { return vs }})
+		for _, st := range test.steps {
+			vs = st.vs
+			var raised []Severity
+			for _, a := range e.Evaluate(&Snapshot{Time: at(st.t)}) {
+				raised = append(raised, a.Severity)
+			}
+			if !reflect.DeepEqual(raised, st.raised) {
+				t.Errorf("%v: t=%v: raised %v, want %v", test.name, st.t, raised, st.raised)
+			}
+			if n := len(e.Active()); n != st.active {
+				t.Errorf("%v: t=%v: %v active alarms, want %v", test.name, st.t, n, st.active)
+			}
+		}
+		if len(e.History) != len(test.cleared) {
+			t.Errorf("%v: %v alarms in history, want %v", test.name, len(e.History), len(test.cleared))
+			continue
+		}
+		for i, a := range e.History {
+			switch c := test.cleared[i]; {
+			case c < 0 && !a.Active():
+				t.Errorf("%v: alarm %v cleared at %v, want active", test.name, i, a.Cleared)
+			case c >= 0 && !a.Cleared.Equal(at(c)):
+				t.Errorf("%v: alarm %v cleared at %v, want %v", test.name, i, a.Cleared, at(c))
+			}
+		}
+	}
+}
+
+func TestEvaluateMessage(t *testing.T) {
+	msg := "first"
+	e := NewEngine(Rule{Name: "test", Check: func(s *Snapshot) []Violation {
+		return []Violation{{Severity: Warning, Message: msg}}
+	}})
+	e.Evaluate(&Snapshot{Time: at(0)})
+	msg = "second"
+	if raised := e.Evaluate(&Snapshot{Time: at(1)}); len(raised) != 0 {
+		t.Errorf("alarm raised again when its message changed: %v", raised)
+	}
+	if active := e.Active(); len(active) != 1 || active[0].Message != "second" {
+		t.Errorf("active alarms = %v, want the alarm with the new message", active)
+	}
+}
+
+func TestChannelRateZero(t *testing.T) {
+	type snapshot struct {
+		t      int
+		counts []float64
+	}
+	tests := []struct {
+		name      string
+		snapshots []snapshot
+		want      []string
+	}{
+		{"first snapshot", []snapshot{{0, []float64{0, 0}}}, nil},
+		{"dead channel", []snapshot{{0, []float64{0, 0, 0}}, {5, []float64{1, 0, 1}}, {10, []float64{2, 0, 2}}}, []string{"warning channel 1"}},
+		{"not long enough", []snapshot{{0, []float64{0, 0}}, {9, []float64{1, 0}}}, nil},
+		{"beam off", []snapshot{{0, []float64{0, 0}}, {5, []float64{1, 0}}, {20, []float64{1, 0}}}, nil},
+		{"beam back", []snapshot{{0, []float64{0, 0}}, {20, []float64{0, 0}}, {25, []float64{1, 0}}, {30, []float64{2, 0}}}, []string{"warning channel 1"}},
+		{"channel recovered", []snapshot{{0, []float64{0, 0}}, {10, []float64{1, 0}}, {20, []float64{2, 1}}}, nil},
+		{"new channels", []snapshot{{0, []float64{0, 0}}, {10, []float64{1, 0, 0}}}, nil},
+	}
+	for _, test := range tests {
+		r := ChannelRateZero(10*time.Second, Warning)
+		var vs []Violation
+		for _, s := range test.snapshots {
+			vs = r.Check(&Snapshot{Time: at(s.t), ChannelCounts: s.counts})
+		}
+		if got := sources(vs); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: violations %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHVDeviation(t *testing.T) {
+	tests := []struct {
+		name string
+		hv   [][]float64 // HV values of the successive snapshots
+		want []string
+	}{
+		{"first reading", [][]float64{{1000, 1000}}, nil},
+		{"no reading", [][]float64{nil, nil}, nil},
+		{"warning", [][]float64{{1000, 1000}, {1006, 1000}}, []string{"warning HV channel 0"}},
+		{"critical", [][]float64{{1000, 1000}, {1000, 979}}, []string{"critical HV channel 1"}},
+		{"at the threshold", [][]float64{{1000}, {1005}}, nil},
+		{"snapshot without HV", [][]float64{{1000}, {1010}, nil}, []string{"warning HV channel 0"}},
+		{"back to normal", [][]float64{{1000}, {1030}, {1001}}, nil},
+	}
+	for _, test := range tests {
+		r := HVDeviation(5, 20)
+		var vs []Violation
+		for i, hv := range test.hv {
+			vs = r.Check(&Snapshot{Time: at(i), HV: hv})
+		}
+		if got := sources(vs); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: violations %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		data, want string
+	}{
+		{"run123.bin", "run123Alarms.csv"},
+		{"run123.bin.z", "run123Alarms.csv"},
+		{"data/run123.bin.z", "data/run123Alarms.csv"},
+		{"run123", "run123Alarms.csv"},
+	}
+	for _, test := range tests {
+		if got := FileName(test.data); got != test.want {
+			t.Errorf("FileName(%q) = %q, want %q", test.data, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package alarm
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Thresholds are the parameters of the default rules (see DefaultRules).
+type Thresholds struct {
+	RateZero      float64 // duration (s) without any pulse with signal after which a channel is reported as dead
+	IntegrityWarn uint    // number of integrity errors above which a warning is raised
+	IntegrityCrit uint    // number of integrity errors above which a critical alarm is raised
+	HVWarn        float64 // deviation (V) of a HV value from its first reading above which a warning is raised
+	HVCrit        float64 // deviation (V) of a HV value from its first reading above which a critical alarm is raised
+	MonBufWarn    float64 // fraction of the monitoring buffer filled above which a warning is raised
+	MonBufCrit    float64 // fraction of the monitoring buffer filled above which a critical alarm is raised
+}
+
+// DefaultThresholds are the thresholds used by the monitoring programs, which
+// may be changed with command line flags.
+var DefaultThresholds = Thresholds{
+	RateZero:      30,
+	IntegrityWarn: 0,
+	IntegrityCrit: 100,
+	HVWarn:        5,
+	HVCrit:        20,
+	MonBufWarn:    0.6,
+	MonBufCrit:    0.9,
+}
+
+// DefaultRules returns the rules on channel rates, integrity errors, HV values
+// and monitoring buffer, with the thresholds th.
+func DefaultRules(th Thresholds) []Rule {
+	return []Rule{
+		ChannelRateZero(time.Duration(th.RateZero*float64(time.Second)), Warning),
+		IntegrityErrors(th.IntegrityWarn, th.IntegrityCrit),
+		HVDeviation(th.HVWarn, th.HVCrit),
+		MonBuffer(th.MonBufWarn, th.MonBufCrit),
+	}
+}
+
+// ChannelRateZero returns a rule reporting the channels without any pulse with
+// signal for at least d. Channels are only checked while pulses with signal are
+// recorded in other channels, so that no alarm is raised when the beam is off.
+func ChannelRateZero(d time.Duration, sev Severity) Rule {
+	var counts []float64
+	var lastHit []time.Time
+	return Rule{
+		Name: "rate zero",
+		Check: func(s *Snapshot) []Violation {
+			if len(counts) != len(s.ChannelCounts) {
+				counts = append([]float64(nil), s.ChannelCounts...)
+				lastHit = make([]time.Time, len(counts))
+				for i := range lastHit {
+					lastHit[i] = s.Time
+				}
+				return nil
+			}
+			active := false
+			for i, n := range s.ChannelCounts {
+				if n != counts[i] {
+					active = true
+					break
+				}
+			}
+			var vs []Violation
+			for i, n := range s.ChannelCounts {
+				if n != counts[i] || !active {
+					counts[i] = n
+					lastHit[i] = s.Time
+					continue
+				}
+				if dt := s.Time.Sub(lastHit[i]); dt >= d {
+					vs = append(vs, Violation{
+						Source:   fmt.Sprintf("channel %v", i),
+						Severity: sev,
+						Message:  fmt.Sprintf("no pulse with signal for %.0f s", dt.Seconds()),
+					})
+				}
+			}
+			return vs
+		},
+	}
+}
+
+// IntegrityErrors returns a rule reporting a number of events which failed the
+// integrity tests (see event.Event.IntegrityFirstASMBoard) above warn or crit.
+func IntegrityErrors(warn, crit uint) Rule {
+	return Rule{
+		Name: "integrity errors",
+		Check: func(s *Snapshot) []Violation {
+			sev := Warning
+			switch {
+			case s.IntegrityErrors > crit:
+				sev = Critical
+			case s.IntegrityErrors > warn:
+			default:
+				return nil
+			}
+			return []Violation{{
+				Severity: sev,
+				Message:  fmt.Sprintf("%v events failed the integrity tests", s.IntegrityErrors),
+			}}
+		},
+	}
+}
+
+// HVDeviation returns a rule reporting the HV channels whose value deviates
+// from the first value read by more than warn or crit (V). The last values read
+// are used for snapshots without HV values.
+func HVDeviation(warn, crit float64) Rule {
+	var ref, last []float64
+	return Rule{
+		Name: "HV deviation",
+		Check: func(s *Snapshot) []Violation {
+			if s.HV != nil {
+				if len(ref) != len(s.HV) {
+					ref = append([]float64(nil), s.HV...)
+				}
+				last = append(last[:0], s.HV...)
+			}
+			var vs []Violation
+			for i := range last {
+				dev := last[i] - ref[i]
+				sev := Warning
+				switch {
+				case math.Abs(dev) > crit:
+					sev = Critical
+				case math.Abs(dev) > warn:
+				default:
+					continue
+				}
+				vs = append(vs, Violation{
+					Source:   fmt.Sprintf("HV channel %v", i),
+					Severity: sev,
+					Message:  fmt.Sprintf("HV = %.1f V, deviation of %+.1f V from start of run", last[i], dev),
+				})
+			}
+			return vs
+		},
+	}
+}
+
+// MonBuffer returns a rule reporting a monitoring buffer filled at more than
+// the fraction warn or crit of its capacity, in which case the monitoring (and
+// possibly the data taking) is slowed down by the web client.
+func MonBuffer(warn, crit float64) Rule {
+	return Rule{
+		Name: "monitoring buffer",
+		Check: func(s *Snapshot) []Violation {
+			if s.MonBufSize == 0 {
+				return nil
+			}
+			frac := float64(s.MonBufLen) / float64(s.MonBufSize)
+			sev := Warning
+			switch {
+			case frac >= crit:
+				sev = Critical
+			case frac >= warn:
+			default:
+				return nil
+			}
+			return []Violation{{
+				Severity: sev,
+				Message:  fmt.Sprintf("buffer filled at %.0f%% (%v/%v)", 100*frac, s.MonBufLen, s.MonBufSize),
+			}}
+		},
+	}
+}
@@ -136,6 +136,11 @@ type Channel struct {
 	// It is the number of ADC count corresponding to the 511 keV peak
 	EnergyCalib LinearCalib
 
+	// Relative detection efficiency of this channel, as determined by the
+	// normalization procedure (see dpgadetector.Detector.ComputeEfficiencies).
+	// A value of 0 means that no normalization has been loaded.
+	Efficiency float64
+
 	// The coordinates are those of the center of the front face of the crystal.
 	utils.CartCoord
 
@@ -376,10 +381,7 @@ func (q *Quartet) PlotPedestals(outDir string, plotStat bool, text string) {
 		q.channels[i].PlotStat(plotStat)
 	}
 
-	p, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	p := plot.New()
 
 	p.Title.Text = "Pedestal " + text
 	p.X.Label.Text = "capacitor"
@@ -391,7 +393,7 @@ func (q *Quartet) PlotPedestals(outDir string, plotStat bool, text string) {
 	}
 	p.Add(plotter.NewGrid())
 
-	err = plotutil.AddLinePoints(p, //AddScatters(p,
+	err := plotutil.AddLinePoints(p, //AddScatters(p,
 		q.channels[0].Name(), &q.channels[0],
 		q.channels[1].Name(), &q.channels[1],
 		q.channels[2].Name(), &q.channels[2],
@@ -2,19 +2,24 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"runtime"
 	"strings"
 
-	"gitlab.in2p3.fr/avirm/analysis-go/applyCorrCalib"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/calib/selectCalib"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dq"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/export"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/trees"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/pipeline"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/reconstruction"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
+	"gonum.org/v1/plot/vg"
 )
 
 func main() {
@@ -31,21 +36,40 @@ func main() {
 		dotree     = flag.Bool("dotree", false, "If specified, tree with all pulses is written")
 		dotree2    = flag.Bool("dotree2", false, "If specified, treeMult2 is written")
 		dotreeLOR  = flag.Bool("dotreeLOR", false, "If specified, treeLOR is written")
-		//wGob       = flag.String("wgob", "dqplots.gob", "Name of the output gob file containing dq plots. If not set, the gob file is not produced.")
-		rfcutmean  = flag.Float64("rfcutmean", 7, "Mean used to apply RF selection cut.")
-		rfcutwidth = flag.Float64("rfcutwidth", 5, "Width used to apply RF selection cut.")
+		doparquet  = flag.Bool("doparquet", false, "If specified, pulses and LORs are exported to Parquet files (see package dpga/export)")
+		doreport   = flag.Bool("doreport", false, "If specified, a html data quality report is written (see dq.DQPlot.WriteReport)")
+		wDQ        = flag.String("wdq", "", "Name of the output ROOT file containing dq plots (see dq.FormatVersion). If not set, the file is not produced.")
+		wGob       = flag.String("wgob", "", "Name of the output gob file containing dq plots (legacy format, prefer -wdq). If not set, the gob file is not produced.")
+		refplots   = flag.String("ref", "", "Name of the file (ROOT, or legacy gob with the .gob extension) containing reference dq plots. If set, the range shift with respect to the reference is computed.")
+		beamdir    = flag.Int("beamdir", 1, "Direction of the beam along the Z axis (1 or -1), used to determine the distal edge of the activity profile.")
+		mumap      = flag.String("mumap", "", "Name of the file containing the attenuation map. If set, LORs are corrected for attenuation.")
+		phantomR   = flag.Float64("phantomr", 50, "Radius (mm) of the cylindrical phantom.")
+		phantomL   = flag.Float64("phantoml", 200, "Length (mm) of the cylindrical phantom.")
+		timeWindow = flag.Float64("timewindow", 0, "Duration (s) of the time slices of the dq plots (0: no time slices)")
+		noWorkers  = flag.Int("j", runtime.NumCPU(), "Number of workers building and processing events in parallel.")
 	)
 
+	flag.Float64Var(&event.RFClassifier.Freq, "rffreq", event.RFClassifier.Freq, "Nominal frequency (MHz) of the accelerator RF signal.")
+	flag.Float64Var(&event.RFClassifier.PromptMean, "promptmean", event.RFClassifier.PromptMean, "Mean (ns, time since RF rising front) of the prompt-gamma window.")
+	flag.Float64Var(&event.RFClassifier.PromptWidth, "promptwidth", event.RFClassifier.PromptWidth, "Half-width (ns) of the prompt-gamma window.")
+	flag.Float64Var(&event.RFClassifier.MinAmpl, "rfminampl", event.RFClassifier.MinAmpl, "Minimal amplitude of the RF signal for the beam to be considered on.")
+	dq.ThresholdFlags()
+	var format rwi.Format
+	flag.Var(&format, "format", rwi.FormatUsage)
+	var phantom reconstruction.Phantom
+	flag.Var(&phantom, "phantom", "If set (possible values: water, pmma) and -mumap is not set, LORs are corrected for attenuation in a cylindrical phantom.")
+	flag.Var(&event.CrystPos, "crystpos", "Position in the crystals used as LOR endpoints: front, center (default) or doi (depth of interaction)")
+	flag.Var(&pulse.ExtraVars, "vars", pulse.ExtraVarsUsage())
 	flag.Parse()
 
 	err := os.RemoveAll("output")
 	if err != nil {
-		log.Fatalf("error removing output directory", err)
+		log.Fatalf("error removing output directory: %v\n", err)
 	}
 
 	err = os.Mkdir("output", 0777)
 	if err != nil {
-		log.Fatalf("error creating output directory", err)
+		log.Fatalf("error creating output directory: %v\n", err)
 	}
 
 	// Reader
@@ -55,10 +79,18 @@ func main() {
 	}
 	defer filer.Close()
 
-	r, err := rw.NewReader(bufio.NewReader(filer), rw.HeaderCAL)
+	r, err := rwi.NewReader(filer, format)
 	if err != nil {
 		log.Fatalf("could not open asm file: %v\n", err)
 	}
+	if *evtStart > 0 {
+		// Jump directly to the first event if the file is indexed,
+		// otherwise events before evtStart are skipped in the event loop
+		err = rwi.SeekFile(r, *infileName, uint64(*evtStart))
+		if err != nil {
+			log.Printf("could not seek to event %v (%v), skipping events\n", *evtStart, err)
+		}
+	}
 
 	// Start doing concrete analysis
 	doPedestal := false
@@ -76,7 +108,19 @@ func main() {
 			doEnergyCalib = true
 		}
 	}
+	attMap := phantom.MuMap(*phantomR, *phantomL)
+	if *mumap != "" {
+		attMap = reconstruction.ReadMuMap(*mumap)
+	}
+
 	dqplots := dq.NewDQPlot()
+	dqplots.TimeWindow = *timeWindow
+	if *refplots != "" {
+		dqplots.DQPlotRef, err = dq.ReadFile(*refplots)
+		if err != nil {
+			log.Fatalf("error reading reference dq plots: %v\n", err)
+		}
+	}
 
 	outrootfileName := strings.Replace(*infileName, ".bin", ".root", 1)
 	var tree *trees.Tree = nil
@@ -96,22 +140,50 @@ func main() {
 		treeLOR = trees.NewTreeLOR(outrootfileNameLOR)
 	}
 
-	hdr := r.Header()
+	var exp *export.Writer = nil
+	if *doparquet {
+		pulsesFileName, lorsFileName := export.FileNames(*infileName)
+		prov := export.Provenance{
+			Calib:         *calib,
+			Pedestal:      doPedestal,
+			TimeDepOffset: doTimeDepOffset,
+			EnergyCalib:   doEnergyCalib,
+			Input:         *infileName,
+		}
+		exp, err = export.NewWriter(pulsesFileName, lorsFileName, prov)
+		if err != nil {
+			log.Fatalf("could not create parquet files: %v\n", err)
+		}
+	}
+
+	// The run header is only available for DPGA files
+	hdr := &rw.Header{}
+	if rr, ok := r.(*rw.Reader); ok {
+		hdr = rr.Header()
+	}
+
+	///////////////////////////////////////////////////////////
+	// Event building, corrections, pulse features and LORs are
+	// computed in parallel, events are then processed in order
+	p := pipeline.New(r, *noWorkers,
+		pipeline.Select(func(e *event.Event) bool { return e.ID >= *evtStart }),
+		pipeline.Correct(doPedestal, doTimeDepOffset, doEnergyCalib),
+		pipeline.Features(),
+		pipeline.DefaultLORs(),
+		pipeline.AttCorrection(attMap))
+	defer p.Close()
+	///////////////////////////////////////////////////////////
 
-	for event, status := r.ReadNextEvent(); status && event.ID < *noEvents; event, status = r.ReadNextEvent() {
+	for event, err := p.ReadNextEvent(); event != nil && event.ID < *noEvents; event, err = p.ReadNextEvent() {
 		if event.ID%500 == 0 {
 			fmt.Printf("Processing event %v\n", event.ID)
 		}
-		if event.ID < *evtStart {
-			continue
+		if err != nil {
+			dqplots.AddIntegrityError(err)
 		}
 		// 		if event.ID < 86000 {
 		// 			continue
 		// 		}
-		///////////////////////////////////////////////////////////
-		// Corrections
-		event = applyCorrCalib.CorrectEvent(event, doPedestal, doTimeDepOffset, doEnergyCalib)
-		///////////////////////////////////////////////////////////
 
 		///////////////////////////////////////////////////////////
 		// Plotting
@@ -120,17 +192,16 @@ func main() {
 		// 			event.PlotPulses(pulse.XaxisCapacitor, false, pulse.YRangePedestal, true)
 		// 		}
 		// dq
-		dqplots.FillHistos(event, *rfcutmean, *rfcutwidth)
+		dqplots.FillHistos(event)
 		////////////////////////////////////////////////////////////
 
 		///////////////////////////////////////////////////////////
 		// ROOT Tree making
 		if tree != nil {
-			tree.Fill(hdr.RunNumber, r.Header(), event)
+			tree.Fill(hdr.RunNumber, hdr, event)
 		}
 		if treeLOR != nil {
-			timesRF := event.FindTimesRF()
-			treeLOR.Fill(hdr.RunNumber, r.Header(), event, timesRF)
+			treeLOR.Fill(hdr.RunNumber, hdr, event)
 		}
 
 		//fmt.Println(len(pulses511keV))
@@ -138,7 +209,13 @@ func main() {
 		if treeMult2 != nil {
 			pulses511keV := event.PulsesInEnergyWindow(511, 3, 28.3)
 			if len(pulses511keV) == 2 && !pulse.SameHemi(pulses511keV[0], pulses511keV[1]) {
-				treeMult2.Fill(hdr.RunNumber, r.Header(), event, pulses511keV[0], pulses511keV[1])
+				treeMult2.Fill(hdr.RunNumber, hdr, event, pulses511keV[0], pulses511keV[1])
+			}
+		}
+		if exp != nil {
+			err := exp.Fill(hdr.RunNumber, hdr, event)
+			if err != nil {
+				log.Fatalf("error exporting event %v: %v\n", event.ID, err)
 			}
 		}
 		////////////////////////////////////////////////////////////
@@ -154,4 +231,69 @@ func main() {
 	if treeMult2 != nil {
 		treeMult2.Close()
 	}
+	if exp != nil {
+		if err := exp.Close(); err != nil {
+			log.Fatalf("error closing parquet files: %v\n", err)
+		}
+	}
+
+	///////////////////////////////////////////////////////////
+	// Range verification
+	dir := reconstruction.BeamDir(*beamdir)
+	edge, shift, shiftErr := dqplots.FitRange(dir)
+	fmt.Printf("Distal edge of minimal reconstruction Z distribution:\n")
+	fmt.Printf("   R50 = %v +- %v mm (sigma = %v mm, chi2/ndf = %v/%v, converged = %v)\n",
+		edge.R50, edge.R50Err, edge.Sigma, edge.Chi2, edge.NDF, edge.Converged)
+	r50boot, r50bootErr := reconstruction.BootstrapDistal50(dqplots.MinRecZProfile(), dir, 1000, nil)
+	fmt.Printf("   R50 (bootstrap) = %v +- %v mm\n", r50boot, r50bootErr)
+	if dqplots.DQPlotRef != nil {
+		fmt.Printf("   range shift with respect to reference = %v +- %v mm\n", shift, shiftErr)
+	}
+	pRange := dqplots.MakeRangePlot(dir)
+	if err := pRange.Save(15*vg.Centimeter, 10*vg.Centimeter, "output/rangeFit.png"); err != nil {
+		log.Fatalf("error saving range plot: %v\n", err)
+	}
+	///////////////////////////////////////////////////////////
+
+	///////////////////////////////////////////////////////////
+	// Comparison with reference dq plots
+	if dqplots.DQPlotRef != nil {
+		comps := dqplots.Compare(dq.DefaultThresholds)
+		fmt.Printf("Comparison of dq histograms with reference (%v histograms compared):\n", len(comps))
+		for _, c := range comps {
+			if c.Status != dq.Pass {
+				fmt.Printf("   %v\n", c)
+			}
+		}
+		fmt.Printf("   run quality = %v\n", dq.RunQuality(comps))
+	}
+	///////////////////////////////////////////////////////////
+
+	if *doreport {
+		info := dq.RunInfo{
+			Input:         *infileName,
+			Calib:         *calib,
+			Pedestal:      doPedestal,
+			TimeDepOffset: doTimeDepOffset,
+			EnergyCalib:   doEnergyCalib,
+		}
+		if _, ok := r.(*rw.Reader); ok {
+			info.Header = hdr
+		}
+		if err := dqplots.WriteReport(dq.ReportFileName(*infileName), info); err != nil {
+			log.Fatalf("error writing data quality report: %v\n", err)
+		}
+	}
+
+	dqplots.RunNumber = hdr.RunNumber
+	if *wDQ != "" {
+		if err := dqplots.WriteROOT(*wDQ); err != nil {
+			log.Fatalf("error writing dq file: %v\n", err)
+		}
+	}
+	if *wGob != "" {
+		if err := dqplots.WriteGob(*wGob); err != nil {
+			log.Fatalf("error writing gob file: %v\n", err)
+		}
+	}
 }
@@ -0,0 +1,89 @@
+// Package computeNormalization computes crystal efficiencies used for the normalization of LOR data.
+// It should be run on data taken with a uniform (or rotating) source, after pedestal,
+// time dependent offset and energy calibrations have been determined.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/calib/selectCalib"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
+	"gitlab.in2p3.fr/avirm/analysis-go/pipeline"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
+)
+
+func main() {
+	log.SetFlags(log.Llongfile | log.LstdFlags)
+
+	var (
+		infileName  = flag.String("i", "", "Name of the input file (normalization run with uniform or rotating source)")
+		outfileName = flag.String("o", "output/normalization.csv", "Name of the output file")
+		noEvents    = flag.Uint("n", 10000000, "Number of events to process")
+		calib       = flag.String("calib", "", "String indicating which calib to use (e.g. A1 for period A, version 1)")
+		noIter      = flag.Int("niter", 10, "Number of fan-sum iterations")
+		emin        = flag.Float64("emin", 400, "Minimal energy (keV) of the pulses forming a LOR")
+		emax        = flag.Float64("emax", 650, "Maximal energy (keV) of the pulses forming a LOR")
+		noWorkers   = flag.Int("j", runtime.NumCPU(), "Number of workers building and processing events in parallel")
+		format      = rwi.FormatAuto
+	)
+
+	flag.Var(&format, "format", rwi.FormatUsage)
+	flag.Parse()
+
+	err := os.RemoveAll("output")
+	if err != nil {
+		log.Fatalf("error removing output directory: %v\n", err)
+	}
+
+	err = os.Mkdir("output", 0777)
+	if err != nil {
+		log.Fatalf("error creating output directory: %v\n", err)
+	}
+
+	file, err := os.Open(*infileName)
+	if err != nil {
+		log.Fatalf("error opening file %v", err)
+	}
+	defer file.Close()
+
+	r, err := rwi.NewReader(file, format)
+	if err != nil {
+		log.Fatalf("could not open asm file: %v\n", err)
+	}
+
+	switch *calib == "" {
+	case true:
+		panic("calibrations should be applied in order to determine normalization.")
+	case false:
+		selectCalib.Which(*calib)
+	}
+
+	var counts dpgadetector.NormCounts
+	noLORs := 0
+	// No cut on the radial distance of the minimal reconstruction point
+	// as the source covers the whole field of view.
+	p := pipeline.New(r, *noWorkers,
+		pipeline.Correct(true, true, true),
+		pipeline.LORs(0, 0, 1e6, 3*1.2, *emin, *emax, true))
+	defer p.Close()
+
+	for event, _ := p.ReadNextEvent(); event != nil && event.ID < *noEvents; event, _ = p.ReadNextEvent() {
+		if event.ID%500 == 0 {
+			fmt.Printf("Processing event %v\n", event.ID)
+		}
+
+		for i := range event.LORs {
+			lor := &event.LORs[i]
+			counts.Add(lor.Pulses[0].Channel, lor.Pulses[1].Channel)
+			noLORs++
+		}
+	}
+	fmt.Printf("Number of LORs used for normalization: %v\n", noLORs)
+
+	_, effErr := dpgadetector.Det.ComputeEfficiencies(&counts, *noIter)
+	dpgadetector.Det.WriteNormalizationFile(*outfileName, *infileName, effErr)
+}
@@ -3,14 +3,15 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"runtime"
 
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
-	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/pipeline"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
 )
 
 func main() {
@@ -20,20 +21,21 @@ func main() {
 		infileName  = flag.String("i", "testdata/tenevents_hex.txt", "Name of the input file")
 		outfileName = flag.String("o", "output/pedestals.csv", "Name of the output file")
 		noEvents    = flag.Uint("n", 10000000, "Number of events to process")
-		hdrType     = rw.HeaderCAL
+		noWorkers   = flag.Int("j", runtime.NumCPU(), "Number of workers building and processing events in parallel")
+		format      = rwi.FormatAuto
 	)
 
-	flag.Var(&hdrType, "h", "Type of header: HeaderCAL or HeaderOld")
+	flag.Var(&format, "format", rwi.FormatUsage)
 	flag.Parse()
 
 	err := os.RemoveAll("output")
 	if err != nil {
-		log.Fatalf("error removing output directory", err)
+		log.Fatalf("error removing output directory: %v\n", err)
 	}
 
 	err = os.Mkdir("output", 0777)
 	if err != nil {
-		log.Fatalf("error creating output directory", err)
+		log.Fatalf("error creating output directory: %v\n", err)
 	}
 
 	file, err := os.Open(*infileName)
@@ -42,12 +44,16 @@ func main() {
 	}
 	defer file.Close()
 
-	r, err := rw.NewReader(bufio.NewReader(file), hdrType)
+	r, err := rwi.NewReader(file, format)
 	if err != nil {
 		log.Fatalf("could not open asm file: %v\n", err)
 	}
 
-	for event, status := r.ReadNextEvent(); status && event.ID < *noEvents; event, status = r.ReadNextEvent() {
+	// Events are built in parallel, pedestal samples are pushed in order
+	p := pipeline.New(r, *noWorkers)
+	defer p.Close()
+
+	for event, _ := p.ReadNextEvent(); event != nil && event.ID < *noEvents; event, _ = p.ReadNextEvent() {
 		if event.ID%500 == 0 {
 			fmt.Printf("Processing event %v\n", event.ID)
 		}
@@ -3,15 +3,15 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"runtime"
 
-	"gitlab.in2p3.fr/avirm/analysis-go/applyCorrCalib"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
-	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/pipeline"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
 )
 
 func main() {
@@ -22,20 +22,21 @@ func main() {
 		outfileName = flag.String("o", "output/timeDepOffsets.csv", "Name of the output file")
 		noEvents    = flag.Uint("n", 10000000, "Number of events to process")
 		ped         = flag.String("ped", "", "Name of the csv file containing pedestal constants. If not set, pedestal corrections are not applied.")
-		hdrType     = rw.HeaderCAL
+		noWorkers   = flag.Int("j", runtime.NumCPU(), "Number of workers building and processing events in parallel")
+		format      = rwi.FormatAuto
 	)
 
-	flag.Var(&hdrType, "h", "Type of header: HeaderCAL or HeaderOld")
+	flag.Var(&format, "format", rwi.FormatUsage)
 	flag.Parse()
 
 	err := os.RemoveAll("output")
 	if err != nil {
-		log.Fatalf("error removing output directory", err)
+		log.Fatalf("error removing output directory: %v\n", err)
 	}
 
 	err = os.Mkdir("output", 0777)
 	if err != nil {
-		log.Fatalf("error creating output directory", err)
+		log.Fatalf("error creating output directory: %v\n", err)
 	}
 
 	file, err := os.Open(*infileName)
@@ -44,7 +45,7 @@ func main() {
 	}
 	defer file.Close()
 
-	r, err := rw.NewReader(bufio.NewReader(file), hdrType)
+	r, err := rwi.NewReader(file, format)
 	if err != nil {
 		log.Fatalf("could not open asm file: %v\n", err)
 	}
@@ -56,14 +57,16 @@ func main() {
 		dpgadetector.Det.ReadPedestalsFile(*ped)
 	}
 
-	for event, status := r.ReadNextEvent(); status && event.ID < *noEvents; event, status = r.ReadNextEvent() {
+	// Events are built and corrected in parallel, time dependent offset samples are pushed in order.
+	// This should be safe as pedestals calibration coefficients have been loaded previously.
+	p := pipeline.New(r, *noWorkers, pipeline.Correct(true, false, false))
+	defer p.Close()
+
+	for event, _ := p.ReadNextEvent(); event != nil && event.ID < *noEvents; event, _ = p.ReadNextEvent() {
 		if event.ID%500 == 0 {
 			fmt.Printf("Processing event %v\n", event.ID)
 		}
 
-		// this should be safe as pedestals calibration coefficients have been loaded previously.
-		event = applyCorrCalib.CorrectEvent(event, true, false, false)
-
 		event.PushTimeDepOffsetSamples()
 	}
 
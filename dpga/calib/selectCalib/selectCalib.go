@@ -15,6 +15,7 @@ func Which(s string) {
 	pedFile := os.Getenv("HOME") + "/godaq/calib/period" + chars[0] + "/v" + chars[1] + "/pedestals.csv"
 	tdoFile := os.Getenv("HOME") + "/godaq/calib/period" + chars[0] + "/v" + chars[1] + "/timeDepOffsets.csv"
 	enFile := os.Getenv("HOME") + "/godaq/calib/period" + chars[0] + "/v" + chars[1] + "/energy.csv"
+	normFile := os.Getenv("HOME") + "/godaq/calib/period" + chars[0] + "/v" + chars[1] + "/normalization.csv"
 	if _, err := os.Stat(pedFile); err == nil {
 		dpgadetector.Det.ReadPedestalsFile(pedFile)
 	} else {
@@ -30,4 +31,9 @@ func Which(s string) {
 	} else {
 		log.Printf("en file %s does not exist\n", enFile)
 	}
+	if _, err := os.Stat(normFile); err == nil {
+		dpgadetector.Det.ReadNormalizationFile(normFile)
+	} else {
+		log.Printf("normalization file %s does not exist\n", normFile)
+	}
 }
@@ -66,6 +66,7 @@ type Detector struct {
 	samplingFreq float64 // sampling frequency in ns
 	noSamples    int     // number of samples
 	hemispheres  [2]Hemisphere
+	normLoaded   bool // crystal efficiencies loaded (see NormLoaded)
 }
 
 func NewDetector() *Detector {
@@ -487,6 +488,15 @@ func FEIdAndChanIdToQuartetAbsIdx72(FEId uint16, ChanId uint16) uint8 {
 	return FifoID144ToQuartetAbsIdx72(FifoId144)
 }
 
+// QuartetAbsIdx72ToFEIdAndChanId is the inverse of FEIdAndChanIdToQuartetAbsIdx72:
+// it returns the front end (ASM) board id and the channel Id (0 -> 23) of the
+// first channel of the quartet of absolute Id iQuartetAbs (0 -> 71).
+func QuartetAbsIdx72ToFEIdAndChanId(iQuartetAbs uint8) (FEId uint16, ChanId uint16) {
+	FEId = 0x10 + uint16(iQuartetAbs/6)
+	ChanId = uint16(iQuartetAbs%6) * 4
+	return
+}
+
 type GeomCSV struct {
 	IChannelAbs240 uint16
 	X              float64
@@ -700,6 +710,11 @@ func (d *Detector) NoClusters() uint8 {
 	return uint8(5 * len(d.hemispheres) * len(d.hemispheres[0].asm))
 }
 
+// NoClustersWoData returns the number of clusters without data (one per ASM board).
+func (d *Detector) NoClustersWoData() uint8 {
+	return uint8(len(d.hemispheres) * len(d.hemispheres[0].asm))
+}
+
 func (d *Detector) NoSamples() int {
 	return d.noSamples
 }
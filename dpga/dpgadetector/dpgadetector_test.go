@@ -70,3 +70,14 @@ func TestChannelAbsIdx288ToRelIdx(t *testing.T) {
 	fmt.Fprintln(w)
 	w.Flush()
 }
+
+func TestQuartetAbsIdx72ToFEIdAndChanId(t *testing.T) {
+	for iQuartetAbs := uint8(0); iQuartetAbs < 72; iQuartetAbs++ {
+		FEId, ChanId := dpgadetector.QuartetAbsIdx72ToFEIdAndChanId(iQuartetAbs)
+		for i := uint16(0); i < 4; i++ {
+			if got := dpgadetector.FEIdAndChanIdToQuartetAbsIdx72(FEId, ChanId+i); got != iQuartetAbs {
+				t.Errorf("FEId=%x, ChanId=%v: got quartet %v, want %v\n", FEId, ChanId+i, got, iQuartetAbs)
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package dpgadetector
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"go-hep.org/x/hep/csvutil"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/detector"
+)
+
+// NoChannels240 is the number of DPGA physical channels
+const NoChannels240 = 240
+
+// normRefDist is the distance (in mm) between the front faces of two crystals
+// facing each other on opposite hemispheres.
+// It is used to make the geometric factor dimensionless.
+const normRefDist = 2 * 148.4
+
+// GeomFactor returns the geometric component of the normalization for the LOR
+// joining channels ch1 and ch2.
+// It accounts for the obliquity of the LOR with respect to the axes of the two
+// crystals and for the solid angle subtended by the crystals, and is equal to 1
+// for two crystals facing each other at a distance normRefDist.
+func GeomFactor(ch1, ch2 *detector.Channel) float64 {
+	ux := ch2.CartCoord.X - ch1.CartCoord.X
+	uy := ch2.CartCoord.Y - ch1.CartCoord.Y
+	uz := ch2.CartCoord.Z - ch1.CartCoord.Z
+	d := math.Sqrt(ux*ux + uy*uy + uz*uz)
+	if d == 0 {
+		return 0
+	}
+	cosTheta := func(ch *detector.Channel) float64 {
+		nx := ch.CrystCenter.X - ch.CartCoord.X
+		ny := ch.CrystCenter.Y - ch.CartCoord.Y
+		nz := ch.CrystCenter.Z - ch.CartCoord.Z
+		n := math.Sqrt(nx*nx + ny*ny + nz*nz)
+		if n == 0 {
+			return 1
+		}
+		return math.Abs(ux*nx+uy*ny+uz*nz) / (d * n)
+	}
+	return cosTheta(ch1) * cosTheta(ch2) * (normRefDist / d) * (normRefDist / d)
+}
+
+// NormLoaded returns true once crystal efficiencies have been read with
+// ReadNormalizationFile or computed with ComputeEfficiencies.
+func (d *Detector) NormLoaded() bool {
+	return d.normLoaded
+}
+
+// NormWeight returns the normalization weight of the LOR joining channels ch1 and ch2,
+// that is the inverse of the product of the crystal efficiencies and of the geometric factor.
+// Channels for which no efficiency has been loaded are given an efficiency of 1.
+// As long as no normalization has been loaded (see NormLoaded), the weight is 1.
+func (d *Detector) NormWeight(ch1, ch2 *detector.Channel) float64 {
+	if !d.normLoaded {
+		return 1
+	}
+	eff1 := ch1.Efficiency
+	if eff1 <= 0 {
+		eff1 = 1
+	}
+	eff2 := ch2.Efficiency
+	if eff2 <= 0 {
+		eff2 = 1
+	}
+	g := GeomFactor(ch1, ch2)
+	if g == 0 {
+		return 0
+	}
+	return 1 / (eff1 * eff2 * g)
+}
+
+// NormCounts holds the number of coincidences recorded for each pair of channels
+// during a normalization run (uniform or rotating source).
+// Both indices are absolute channel indices (0 -> 239).
+type NormCounts [NoChannels240][NoChannels240]float64
+
+// Add adds a coincidence between channels ch1 and ch2.
+func (c *NormCounts) Add(ch1, ch2 *detector.Channel) {
+	i, j := ch1.AbsID240(), ch2.AbsID240()
+	c[i][j]++
+	c[j][i]++
+}
+
+// ComputeEfficiencies computes the crystal efficiencies from the coincidence counts
+// of a normalization run, using the component-based approach: the expected number of
+// coincidences for the pair (i, j) is modelled as eff_i * eff_j * g_ij, where g_ij is
+// the geometric factor returned by GeomFactor.
+// The efficiencies are obtained iteratively with the fan-sum method:
+//
+//	eff_i = sum_j c_ij / sum_j (eff_j * g_ij)
+//
+// They are normalized such that their mean over channels having recorded coincidences is 1.
+// The efficiencies of the channels are set and returned together with their statistical errors.
+func (d *Detector) ComputeEfficiencies(c *NormCounts, noIter int) (eff, effErr [NoChannels240]float64) {
+	var fanSum [NoChannels240]float64
+	for i := range fanSum {
+		for j := range c[i] {
+			fanSum[i] += c[i][j]
+		}
+		eff[i] = 1
+	}
+
+	// Only LORs joining the two hemispheres are recorded, so that the
+	// geometric factor is left to 0 for pairs of channels in the same hemisphere.
+	var g [NoChannels240][NoChannels240]float64
+	for i := range g {
+		for j := range g[i] {
+			if !oppositeHemis(i, j) {
+				continue
+			}
+			g[i][j] = GeomFactor(d.ChannelFromIdAbs240(uint16(i)), d.ChannelFromIdAbs240(uint16(j)))
+		}
+	}
+
+	for iter := 0; iter < noIter; iter++ {
+		var newEff [NoChannels240]float64
+		for i := range newEff {
+			expected := 0.
+			for j := range g[i] {
+				expected += eff[j] * g[i][j]
+			}
+			if expected > 0 {
+				newEff[i] = fanSum[i] / expected
+			}
+		}
+		sum := 0.
+		n := 0
+		for i := range newEff {
+			if newEff[i] > 0 {
+				sum += newEff[i]
+				n++
+			}
+		}
+		if n == 0 {
+			log.Printf("no coincidences found, efficiencies cannot be computed\n")
+			return
+		}
+		for i := range newEff {
+			eff[i] = newEff[i] * float64(n) / sum
+		}
+	}
+
+	for i := range eff {
+		if fanSum[i] > 0 {
+			effErr[i] = eff[i] / math.Sqrt(fanSum[i])
+		}
+		d.ChannelFromIdAbs240(uint16(i)).Efficiency = eff[i]
+	}
+	d.normLoaded = true
+	return
+}
+
+// oppositeHemis returns true if channels with absolute indices i and j are on opposite hemispheres.
+func oppositeHemis(i, j int) bool {
+	return (i < NoChannels240/2) != (j < NoChannels240/2)
+}
+
+type NormalizationFile struct {
+	IChannelAbs240 uint16
+	Efficiency     float64
+	EfficiencyErr  float64
+}
+
+func (d *Detector) WriteNormalizationFile(outFileName string, inFileName string, effErr [NoChannels240]float64) {
+	fmt.Println("Writing normalization to", outFileName)
+	tbl, err := csvutil.Create(outFileName)
+	if err != nil {
+		log.Fatalf("could not create %s: %v\n", outFileName, err)
+	}
+	defer tbl.Close()
+	tbl.Writer.Comma = ' '
+
+	err = tbl.WriteHeader(fmt.Sprintf("# DPGA normalization file (creation date: %v, input file: %v)\n", time.Now(), inFileName))
+	if err != nil {
+		log.Fatalf("error writing header: %v\n", err)
+	}
+	err = tbl.WriteHeader("# iChannelAbs240 efficiency efficiencyErr")
+	if err != nil {
+		log.Fatalf("error writing header: %v\n", err)
+	}
+
+	for i := uint16(0); i < NoChannels240; i++ {
+		data := NormalizationFile{
+			IChannelAbs240: i,
+			Efficiency:     d.ChannelFromIdAbs240(i).Efficiency,
+			EfficiencyErr:  effErr[i],
+		}
+		err = tbl.WriteRow(data)
+		if err != nil {
+			log.Fatalf("error writing row: %v\n", err)
+		}
+	}
+
+	err = tbl.Close()
+	if err != nil {
+		log.Fatalf("error closing table: %v\n", err)
+	}
+}
+
+func (d *Detector) ReadNormalizationFile(fileName string) {
+	tbl, err := csvutil.Open(fileName)
+	if err != nil {
+		log.Fatalf("could not open %s: %v\n", fileName, err)
+	}
+	defer tbl.Close()
+	tbl.Reader.Comma = ' '
+	tbl.Reader.Comment = '#'
+
+	rows, err := tbl.ReadRows(0, -1)
+	if err != nil {
+		log.Fatalf("could read rows [0, -1): %v\n", err)
+	}
+	defer rows.Close()
+
+	var data NormalizationFile
+
+	for rows.Next() {
+		err = rows.Scan(&data)
+		if err != nil {
+			log.Fatalf("error reading row: %v\n", err)
+		}
+		ch := d.ChannelFromIdAbs240(data.IChannelAbs240)
+		ch.Efficiency = data.Efficiency
+	}
+	err = rows.Err()
+	if err != nil && err.Error() != "EOF" {
+		log.Fatalf("error: %v\n", err)
+	}
+	d.normLoaded = true
+}
@@ -0,0 +1,153 @@
+package dpgadetector_test
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/detector"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
+	"gitlab.in2p3.fr/avirm/analysis-go/utils"
+)
+
+// refDist is the distance between the front faces of two crystals facing
+// each other, for which the geometric factor is 1.
+const refDist = 2 * 148.4
+
+// testChannel returns a channel with its front face at (x, y, z) and the
+// axis of its crystal along z, the crystal lying on the side of the front
+// face opposite to the center of the detector.
+func testChannel(x, y, z float64) *detector.Channel {
+	return &detector.Channel{
+		CartCoord:   utils.CartCoord{X: x, Y: y, Z: z},
+		CrystCenter: utils.CartCoord{X: x, Y: y, Z: z + math.Copysign(10, z)},
+	}
+}
+
+func TestGeomFactor(t *testing.T) {
+	tests := []struct {
+		name     string
+		ch1, ch2 *detector.Channel
+		want     float64
+	}{
+		{"facing", testChannel(0, 0, -refDist/2), testChannel(0, 0, refDist/2), 1},
+		{"twice the distance", testChannel(0, 0, -refDist), testChannel(0, 0, refDist), 0.25},
+		// both obliquities are 45 degrees and the distance is refDist*sqrt(2)
+		{"oblique", testChannel(0, 0, -refDist/2), testChannel(refDist, 0, refDist/2), 0.25},
+		{"symmetric", testChannel(refDist, 0, refDist/2), testChannel(0, 0, -refDist/2), 0.25},
+		{"same position", testChannel(0, 0, refDist/2), testChannel(0, 0, refDist/2), 0},
+	}
+	for _, test := range tests {
+		if got := dpgadetector.GeomFactor(test.ch1, test.ch2); math.Abs(got-test.want) > 1e-12 {
+			t.Errorf("%v: GeomFactor = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNormWeight(t *testing.T) {
+	det := dpgadetector.NewDetector()
+	ch1 := det.ChannelFromIdAbs240(3)
+	ch2 := det.ChannelFromIdAbs240(dpgadetector.NoChannels240/2 + 50)
+	g := dpgadetector.GeomFactor(ch1, ch2)
+	if g <= 0 {
+		t.Fatalf("GeomFactor = %v for channels on opposite hemispheres", g)
+	}
+
+	ch1.Efficiency = 0.5
+	if w := det.NormWeight(ch1, ch2); det.NormLoaded() || w != 1 {
+		t.Errorf("weight = %v without normalization loaded, want 1", w)
+	}
+
+	dir, err := ioutil.TempDir("", "dpgadetector-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "normalization.csv")
+	var effErr [dpgadetector.NoChannels240]float64
+	det.WriteNormalizationFile(fileName, "test", effErr)
+
+	loaded := dpgadetector.NewDetector()
+	loaded.ReadNormalizationFile(fileName)
+	if !loaded.NormLoaded() {
+		t.Fatalf("normalization not loaded after ReadNormalizationFile")
+	}
+	if det.NormLoaded() || dpgadetector.Det.NormLoaded() {
+		t.Errorf("normalization loaded in other detectors")
+	}
+	ch1, ch2 = loaded.ChannelFromIdAbs240(3), loaded.ChannelFromIdAbs240(dpgadetector.NoChannels240/2+50)
+	// ch2 has no efficiency (0 in the file), it is given an efficiency of 1
+	if w, want := loaded.NormWeight(ch1, ch2), 1/(0.5*g); math.Abs(w-want) > 1e-9*want {
+		t.Errorf("weight = %v, want %v", w, want)
+	}
+	if w := loaded.NormWeight(ch1, ch1); w != 0 {
+		t.Errorf("weight = %v for a LOR joining a channel to itself, want 0", w)
+	}
+}
+
+func TestComputeEfficiencies(t *testing.T) {
+	det := dpgadetector.NewDetector()
+
+	// true efficiencies, with a mean of 1
+	var want [dpgadetector.NoChannels240]float64
+	for i := range want {
+		want[i] = 1 + 0.3*math.Sin(float64(i))
+	}
+	mean := 0.
+	for _, e := range want {
+		mean += e
+	}
+	mean /= dpgadetector.NoChannels240
+	for i := range want {
+		want[i] /= mean
+	}
+
+	// expected coincidences between the two hemispheres
+	const noPerPair = 1000
+	c := new(dpgadetector.NormCounts)
+	for i := 0; i < dpgadetector.NoChannels240/2; i++ {
+		for j := dpgadetector.NoChannels240 / 2; j < dpgadetector.NoChannels240; j++ {
+			ch1, ch2 := det.ChannelFromIdAbs240(uint16(i)), det.ChannelFromIdAbs240(uint16(j))
+			n := noPerPair * want[i] * want[j] * dpgadetector.GeomFactor(ch1, ch2)
+			c[i][j], c[j][i] = n, n
+		}
+	}
+
+	eff, effErr := det.ComputeEfficiencies(c, 50)
+	if !det.NormLoaded() {
+		t.Errorf("normalization not loaded after ComputeEfficiencies")
+	}
+	// Only coincidences between the two hemispheres are recorded, so that the
+	// efficiencies of one hemisphere can be scaled by a factor and those of the
+	// other by its inverse: only their ratios within a hemisphere and their
+	// mean are determined.
+	sum := 0.
+	for i := range eff {
+		ref := i - i%(dpgadetector.NoChannels240/2)
+		if got, want := eff[i]/eff[ref], want[i]/want[ref]; math.Abs(got-want) > 1e-9 {
+			t.Errorf("channel %v: efficiency relative to channel %v = %v, want %v", i, ref, got, want)
+		}
+		sum += eff[i]
+		if got := det.ChannelFromIdAbs240(uint16(i)).Efficiency; got != eff[i] {
+			t.Errorf("channel %v: efficiency of the channel = %v, want %v", i, got, eff[i])
+		}
+		fanSum := 0.
+		for j := range c[i] {
+			fanSum += c[i][j]
+		}
+		if want := eff[i] / math.Sqrt(fanSum); math.Abs(effErr[i]-want) > 1e-12 {
+			t.Errorf("channel %v: efficiency error = %v, want %v", i, effErr[i], want)
+		}
+	}
+	if mean := sum / dpgadetector.NoChannels240; math.Abs(mean-1) > 1e-12 {
+		t.Errorf("mean efficiency = %v, want 1", mean)
+	}
+
+	empty := dpgadetector.NewDetector()
+	empty.ComputeEfficiencies(new(dpgadetector.NormCounts), 10)
+	if empty.NormLoaded() {
+		t.Errorf("normalization loaded without coincidences")
+	}
+}
@@ -0,0 +1,370 @@
+package dq
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go-hep.org/x/hep/hbook"
+	"gonum.org/v1/gonum/stat/distuv"
+	"gonum.org/v1/plot/plotter"
+)
+
+// Status is the outcome of the comparison of a histogram with its reference.
+type Status uint8
+
+const (
+	Pass Status = iota
+	Warn
+	Fail
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "pass"
+	case Warn:
+		return "warn"
+	case Fail:
+		return "fail"
+	}
+	return "Status(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Thresholds holds the levels at which the comparison of a histogram with its
+// reference gives a warning or a failure.
+type Thresholds struct {
+	KSWarn     float64 // Kolmogorov-Smirnov probability below which the status is Warn
+	KSFail     float64 // Kolmogorov-Smirnov probability below which the status is Fail
+	Chi2Warn   float64 // chi2 probability below which the status is Warn
+	Chi2Fail   float64 // chi2 probability below which the status is Fail
+	PullWarn   float64 // maximum absolute per-bin pull above which the status is Warn
+	PullFail   float64 // maximum absolute per-bin pull above which the status is Fail
+	HVWarn     float64 // deviation (V) of the mean HV of a channel from the reference above which the status is Warn
+	HVFail     float64 // deviation (V) of the mean HV of a channel from the reference above which the status is Fail
+	MinEntries float64 // minimum sum of weights of both histograms for the comparison to be made
+}
+
+// DefaultThresholds are the thresholds used by DQPlot.Compare.
+// They are typically modified from the command line.
+var DefaultThresholds = Thresholds{
+	KSWarn:     0.01,
+	KSFail:     1e-4,
+	Chi2Warn:   0.01,
+	Chi2Fail:   1e-4,
+	PullWarn:   5,
+	PullFail:   10,
+	HVWarn:     5,
+	HVFail:     20,
+	MinEntries: 50,
+}
+
+// ThresholdFlags defines the command line flags setting DefaultThresholds.
+// It must be called before flag.Parse.
+func ThresholdFlags() {
+	flag.Float64Var(&DefaultThresholds.KSWarn, "kswarn", DefaultThresholds.KSWarn, "Kolmogorov-Smirnov probability below which a dq histogram is flagged as warn with respect to the reference.")
+	flag.Float64Var(&DefaultThresholds.KSFail, "ksfail", DefaultThresholds.KSFail, "Kolmogorov-Smirnov probability below which a dq histogram is flagged as fail with respect to the reference.")
+	flag.Float64Var(&DefaultThresholds.Chi2Warn, "chi2warn", DefaultThresholds.Chi2Warn, "Chi2 probability below which a dq histogram is flagged as warn with respect to the reference.")
+	flag.Float64Var(&DefaultThresholds.Chi2Fail, "chi2fail", DefaultThresholds.Chi2Fail, "Chi2 probability below which a dq histogram is flagged as fail with respect to the reference.")
+	flag.Float64Var(&DefaultThresholds.PullWarn, "pullwarn", DefaultThresholds.PullWarn, "Maximum per-bin pull above which a dq histogram is flagged as warn with respect to the reference.")
+	flag.Float64Var(&DefaultThresholds.PullFail, "pullfail", DefaultThresholds.PullFail, "Maximum per-bin pull above which a dq histogram is flagged as fail with respect to the reference.")
+	flag.Float64Var(&DefaultThresholds.HVWarn, "dqhvwarn", DefaultThresholds.HVWarn, "Deviation (V) of the mean HV of a channel from the reference above which it is flagged as warn.")
+	flag.Float64Var(&DefaultThresholds.HVFail, "dqhvfail", DefaultThresholds.HVFail, "Deviation (V) of the mean HV of a channel from the reference above which it is flagged as fail.")
+	flag.Float64Var(&DefaultThresholds.MinEntries, "dqminentries", DefaultThresholds.MinEntries, "Minimum effective number of entries of a dq histogram and of its reference for them to be compared.")
+}
+
+// Comparison is the result of the comparison of a histogram with its reference.
+// Histograms are compared in shape, i.e. after normalization to the same integral.
+//
+// The HV curves are compared through their mean values: for them, HV is true
+// and only HVDiff and Status are set.
+type Comparison struct {
+	Name     string
+	KSProb   float64 // Kolmogorov-Smirnov probability
+	Chi2     float64
+	NDF      int
+	Chi2Prob float64
+	MaxPull  float64 // maximum absolute per-bin pull
+	MaxBin   int     // index of the bin with the maximum absolute pull
+	HV       bool    // comparison of an HV curve
+	HVDiff   float64 // difference (V) of the mean HV with the reference
+	Status   Status
+}
+
+func (c Comparison) String() string {
+	if c.HV {
+		return fmt.Sprintf("%-18s %v (mean HV diff=%.1f V)", c.Name, c.Status, c.HVDiff)
+	}
+	return fmt.Sprintf("%-18s %v (KS prob=%.3g, chi2/ndf=%.1f/%d, chi2 prob=%.3g, max pull=%.1f in bin %d)",
+		c.Name, c.Status, c.KSProb, c.Chi2, c.NDF, c.Chi2Prob, c.MaxPull, c.MaxBin)
+}
+
+// Compare compares all the histograms of d with the ones of d.DQPlotRef, using
+// the thresholds th. Histograms with too few entries, in d or in the reference,
+// are not compared. The RF timing is compared through the projection of
+// HEnergyVsDeltaTggRF on its tgg - trf axis, and the HV curves through their
+// mean values (see compareHV). It returns nil if d.DQPlotRef is nil.
+func (d *DQPlot) Compare(th Thresholds) []Comparison {
+	ref := d.DQPlotRef
+	if ref == nil {
+		return nil
+	}
+	var comps []Comparison
+	add := func(name string, h, r binner) {
+		if c, ok := compare(name, h, r, th); ok {
+			comps = append(comps, c)
+		}
+	}
+	add("HFrequency", h1d{d.HFrequency}, h1d{ref.HFrequency})
+	add("HSatFrequency", h1d{d.HSatFrequency}, h1d{ref.HSatFrequency})
+	add("HMultiplicity", h1d{d.HMultiplicity}, h1d{ref.HMultiplicity})
+	add("HSatMultiplicity", h1d{d.HSatMultiplicity}, h1d{ref.HSatMultiplicity})
+	add("HLORMult", h1d{d.HLORMult}, h1d{ref.HLORMult})
+	add("HNoSignal", h1d{d.HNoSignal}, h1d{ref.HNoSignal})
+	add("HNoise", h1d{d.HNoise}, h1d{ref.HNoise})
+	add("HMinRecX", h1d{d.HMinRecX}, h1d{ref.HMinRecX})
+	add("HMinRecY", h1d{d.HMinRecY}, h1d{ref.HMinRecY})
+	add("HMinRecZ", h1d{d.HMinRecZ}, h1d{ref.HMinRecZ})
+	add("DeltaT30", h1d{d.DeltaT30}, h1d{ref.DeltaT30})
+	add("HEnergyAll", h1d{d.HEnergyAll}, h1d{ref.HEnergyAll})
+	add("AmplCorrelation", h2d{d.AmplCorrelation}, h2d{ref.AmplCorrelation})
+	add("EnergyCorrelation", h2d{d.EnergyCorrelation}, h2d{ref.EnergyCorrelation})
+	add("HitQuartets", h2d{d.HitQuartets}, h2d{ref.HitQuartets})
+	add("HEnergyVsDeltaTggRF", h2d{d.HEnergyVsDeltaTggRF}, h2d{ref.HEnergyVsDeltaTggRF})
+	add("DeltaTggRF", projX{d.HEnergyVsDeltaTggRF}, projX{ref.HEnergyVsDeltaTggRF})
+	for i := range d.HCharge {
+		if i >= len(ref.HCharge) {
+			break
+		}
+		for j := range d.HCharge[i] {
+			if j >= len(ref.HCharge[i]) {
+				break
+			}
+			idx := "[" + strconv.Itoa(i) + "][" + strconv.Itoa(j) + "]"
+			add("HCharge"+idx, h1d{&d.HCharge[i][j]}, h1d{&ref.HCharge[i][j]})
+			add("HAmplitude"+idx, h1d{&d.HAmplitude[i][j]}, h1d{&ref.HAmplitude[i][j]})
+			add("HEnergy"+idx, h1d{&d.HEnergy[i][j]}, h1d{&ref.HEnergy[i][j]})
+		}
+	}
+	for i := range d.HV {
+		for j := range d.HV[i] {
+			name := "HV[" + strconv.Itoa(i) + "][" + strconv.Itoa(j) + "]"
+			if c, ok := compareHV(name, d.HV[i][j], ref.HV[i][j], th); ok {
+				comps = append(comps, c)
+			}
+		}
+	}
+	return comps
+}
+
+// RunQuality returns the overall quality of a run, i.e. the worst status of the comparisons.
+func RunQuality(comps []Comparison) Status {
+	q := Pass
+	for _, c := range comps {
+		if c.Status > q {
+			q = c.Status
+		}
+	}
+	return q
+}
+
+// Summary returns the overall quality of the comparisons followed by the
+// names of the histograms which did not pass, e.g. "warn (HMinRecZ:warn HCharge[3][1]:warn)".
+func Summary(comps []Comparison) string {
+	var bad []string
+	for _, c := range comps {
+		if c.Status != Pass {
+			bad = append(bad, c.Name+":"+c.Status.String())
+		}
+	}
+	s := RunQuality(comps).String()
+	if len(bad) > 0 {
+		s += " (" + strings.Join(bad, " ") + ")"
+	}
+	return s
+}
+
+// binner gives access to the sum of weights and sum of squared weights of the
+// bins of a 1D or 2D histogram (under- and overflows excluded).
+// The bins of 2D histograms are ordered along x first: bin i is at (i%nx, i/nx).
+type binner interface {
+	len() int
+	nx() int
+	sumw(i int) float64
+	sumw2(i int) float64
+}
+
+type h1d struct{ h *hbook.H1D }
+
+func (h h1d) len() int {
+	if h.h == nil {
+		return 0
+	}
+	return len(h.h.Binning.Bins)
+}
+func (h h1d) nx() int             { return h.len() }
+func (h h1d) sumw(i int) float64  { return h.h.Binning.Bins[i].SumW() }
+func (h h1d) sumw2(i int) float64 { return h.h.Binning.Bins[i].SumW2() }
+
+type h2d struct{ h *hbook.H2D }
+
+func (h h2d) len() int {
+	if h.h == nil {
+		return 0
+	}
+	return len(h.h.Binning.Bins)
+}
+func (h h2d) nx() int {
+	if h.h == nil {
+		return 0
+	}
+	return h.h.Binning.Nx
+}
+func (h h2d) sumw(i int) float64  { return h.h.Binning.Bins[i].SumW() }
+func (h h2d) sumw2(i int) float64 { return h.h.Binning.Bins[i].SumW2() }
+
+// projX is the projection of a 2D histogram on its x axis.
+type projX struct{ h *hbook.H2D }
+
+func (h projX) len() int { return h2d(h).nx() }
+func (h projX) nx() int  { return h.len() }
+func (h projX) sumw(i int) float64 {
+	var w float64
+	for iy := 0; iy < h.h.Binning.Ny; iy++ {
+		w += h.h.Binning.Bins[iy*h.h.Binning.Nx+i].SumW()
+	}
+	return w
+}
+func (h projX) sumw2(i int) float64 {
+	var w2 float64
+	for iy := 0; iy < h.h.Binning.Ny; iy++ {
+		w2 += h.h.Binning.Bins[iy*h.h.Binning.Nx+i].SumW2()
+	}
+	return w2
+}
+
+// compare compares the shapes of h and r. The comparison is not made (ok is false)
+// if one of them is missing, if their binnings differ or if they have too few entries.
+//
+// The per-bin pull is the difference of the normalized contents divided by its error,
+// computed from the sums of squared weights so that histograms scaled by Finalize
+// are correctly handled. The chi2 is the sum of the squared pulls over the bins with
+// entries, with ndf the number of such bins minus one. The Kolmogorov-Smirnov
+// probability is computed from the maximum distance between the normalized cumulative
+// distributions and the effective numbers of entries. For 2D histograms, as in ROOT,
+// it is the mean of the probabilities obtained with the bins ordered along x first
+// and along y first.
+func compare(name string, h, r binner, th Thresholds) (c Comparison, ok bool) {
+	if h.len() == 0 || h.len() != r.len() || h.nx() != r.nx() {
+		return c, false
+	}
+	var w1, w2, s1, s2 float64
+	for i := 0; i < h.len(); i++ {
+		w1 += h.sumw(i)
+		w2 += r.sumw(i)
+		s1 += h.sumw2(i)
+		s2 += r.sumw2(i)
+	}
+	if w1 <= 0 || w2 <= 0 || s1 <= 0 || s2 <= 0 {
+		return c, false
+	}
+	// effective numbers of entries
+	n1 := w1 * w1 / s1
+	n2 := w2 * w2 / s2
+	if n1 < th.MinEntries || n2 < th.MinEntries {
+		return c, false
+	}
+
+	c = Comparison{Name: name, MaxBin: -1}
+	for i := 0; i < h.len(); i++ {
+		variance := h.sumw2(i)/(w1*w1) + r.sumw2(i)/(w2*w2)
+		if variance == 0 {
+			continue
+		}
+		pull := (h.sumw(i)/w1 - r.sumw(i)/w2) / math.Sqrt(variance)
+		c.Chi2 += pull * pull
+		c.NDF++
+		if math.Abs(pull) > c.MaxPull {
+			c.MaxPull = math.Abs(pull)
+			c.MaxBin = i
+		}
+	}
+	c.NDF--
+	c.Chi2Prob = 1
+	if c.NDF > 0 {
+		c.Chi2Prob = distuv.ChiSquared{K: float64(c.NDF)}.Survival(c.Chi2)
+	}
+	nx := h.nx()
+	ny := h.len() / nx
+	z := math.Sqrt(n1 * n2 / (n1 + n2))
+	c.KSProb = kolmogorovProb(ksDistance(h, r, w1, w2, func(k int) int { return k }) * z)
+	if ny > 1 {
+		byY := func(k int) int { return (k%ny)*nx + k/ny }
+		c.KSProb = 0.5 * (c.KSProb + kolmogorovProb(ksDistance(h, r, w1, w2, byY)*z))
+	}
+
+	switch {
+	case c.KSProb < th.KSFail, c.Chi2Prob < th.Chi2Fail, c.MaxPull > th.PullFail:
+		c.Status = Fail
+	case c.KSProb < th.KSWarn, c.Chi2Prob < th.Chi2Warn, c.MaxPull > th.PullWarn:
+		c.Status = Warn
+	}
+	return c, true
+}
+
+// ksDistance returns the maximum distance between the cumulative distributions
+// of h and r, normalized by w1 and w2, with the bins taken in the order given by bin.
+func ksDistance(h, r binner, w1, w2 float64, bin func(k int) int) float64 {
+	var cum1, cum2, dmax float64
+	for k := 0; k < h.len(); k++ {
+		i := bin(k)
+		cum1 += h.sumw(i) / w1
+		cum2 += r.sumw(i) / w2
+		dmax = math.Max(dmax, math.Abs(cum1-cum2))
+	}
+	return dmax
+}
+
+// compareHV compares the mean values of the HV curve xys and of its reference r.
+// The comparison is not made (ok is false) if one of them has no point.
+func compareHV(name string, xys, r plotter.XYs, th Thresholds) (c Comparison, ok bool) {
+	if len(xys) == 0 || len(r) == 0 {
+		return c, false
+	}
+	mean := func(xys plotter.XYs) float64 {
+		var sum float64
+		for _, xy := range xys {
+			sum += xy.Y
+		}
+		return sum / float64(len(xys))
+	}
+	c = Comparison{Name: name, HV: true, HVDiff: mean(xys) - mean(r), MaxBin: -1}
+	switch {
+	case math.Abs(c.HVDiff) > th.HVFail:
+		c.Status = Fail
+	case math.Abs(c.HVDiff) > th.HVWarn:
+		c.Status = Warn
+	}
+	return c, true
+}
+
+// kolmogorovProb returns the probability that the Kolmogorov-Smirnov statistic
+// z = D*sqrt(n1*n2/(n1+n2)) exceeds the observed value for compatible distributions.
+func kolmogorovProb(z float64) float64 {
+	if z < 0.2 {
+		return 1
+	}
+	var p float64
+	sign := 1.
+	for j := 1; j <= 100; j++ {
+		term := math.Exp(-2 * float64(j*j) * z * z)
+		p += sign * term
+		if term < 1e-10 {
+			break
+		}
+		sign = -sign
+	}
+	return math.Max(0, math.Min(1, 2*p))
+}
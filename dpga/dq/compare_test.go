@@ -0,0 +1,213 @@
+package dq
+
+import (
+	"math"
+	"testing"
+
+	"go-hep.org/x/hep/hbook"
+	"gonum.org/v1/plot/plotter"
+)
+
+// fillGaus fills h with about n entries of weight w following a gaussian
+// distribution, the bin contents being rounded to the expected numbers of entries.
+func fillGaus(h *hbook.H1D, n, mean, sigma, w float64) {
+	for _, bin := range h.Binning.Bins {
+		x := bin.XMid()
+		p := math.Exp(-0.5*(x-mean)*(x-mean)/(sigma*sigma)) * bin.XWidth() / (sigma * math.Sqrt(2*math.Pi))
+		for i := 0; i < int(math.Round(n*p)); i++ {
+			h.Fill(x, w)
+		}
+	}
+}
+
+// fillGaus2D fills h with about n entries of weight 1 following the product
+// of gaussian distributions along x and y.
+func fillGaus2D(h *hbook.H2D, n, xmean, xsigma, ymean, ysigma float64) {
+	for _, bin := range h.Binning.Bins {
+		x, y := bin.XMid(), bin.YMid()
+		dx, dy := (x-xmean)/xsigma, (y-ymean)/ysigma
+		p := math.Exp(-0.5*(dx*dx+dy*dy)) * bin.XWidth() * bin.YWidth() / (2 * math.Pi * xsigma * ysigma)
+		for i := 0; i < int(math.Round(n*p)); i++ {
+			h.Fill(x, y, 1)
+		}
+	}
+}
+
+func TestKolmogorovProb(t *testing.T) {
+	for _, test := range []struct {
+		z, want float64
+	}{
+		{0, 1},
+		{0.1, 1},
+		{0.5, 0.9639},
+		{1, 0.2700},
+		{1.36, 0.0495},
+		{2, 0.00067},
+		{5, 0},
+	} {
+		if got := kolmogorovProb(test.z); math.Abs(got-test.want) > 1e-4 {
+			t.Errorf("kolmogorovProb(%v) = %v, want %v", test.z, got, test.want)
+		}
+	}
+}
+
+func TestCompare1D(t *testing.T) {
+	gaus := func(nbins int, n, mean, w float64) *hbook.H1D {
+		h := hbook.NewH1D(nbins, -5, 5)
+		fillGaus(h, n, mean, 1, w)
+		return h
+	}
+	tests := []struct {
+		name   string
+		h, r   *hbook.H1D
+		ok     bool
+		status Status
+	}{
+		{"identical", gaus(50, 10000, 0, 1), gaus(50, 10000, 0, 1), true, Pass},
+		{"scaled", gaus(50, 10000, 0, 3), gaus(50, 10000, 0, 1), true, Pass},
+		{"different statistics", gaus(50, 5000, 0, 1), gaus(50, 20000, 0, 1), true, Pass},
+		{"shifted", gaus(50, 10000, 0.5, 1), gaus(50, 10000, 0, 1), true, Fail},
+		{"different binnings", gaus(40, 10000, 0, 1), gaus(50, 10000, 0, 1), false, Pass},
+		{"too few entries", gaus(50, 20, 0, 1), gaus(50, 10000, 0, 1), false, Pass},
+		{"empty", hbook.NewH1D(50, -5, 5), gaus(50, 10000, 0, 1), false, Pass},
+		{"missing", nil, gaus(50, 10000, 0, 1), false, Pass},
+	}
+	for _, test := range tests {
+		c, ok := compare(test.name, h1d{test.h}, h1d{test.r}, DefaultThresholds)
+		if ok != test.ok {
+			t.Errorf("%v: ok = %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		if ok && c.Status != test.status {
+			t.Errorf("%v: %v, want status %v", test.name, c, test.status)
+		}
+	}
+
+	h := gaus(50, 10000, 0, 1)
+	c, _ := compare("identical", h1d{h}, h1d{gaus(50, 10000, 0, 3)}, DefaultThresholds)
+	if c.KSProb != 1 || c.Chi2 != 0 || c.Chi2Prob != 1 || c.MaxPull != 0 || c.MaxBin != -1 {
+		t.Errorf("identical shapes: %v", c)
+	}
+	ndf := -1
+	for _, bin := range h.Binning.Bins {
+		if bin.Entries() > 0 {
+			ndf++
+		}
+	}
+	if c.NDF != ndf {
+		t.Errorf("identical shapes: ndf = %v, want %v (bins with entries minus one)", c.NDF, ndf)
+	}
+
+	// the status is Warn between the warning and failure thresholds
+	th := DefaultThresholds
+	th.KSWarn = 0.1
+	c, _ = compare("slightly shifted", h1d{gaus(50, 10000, 0.05, 1)}, h1d{gaus(50, 10000, 0, 1)}, th)
+	if c.Status != Warn || c.KSProb >= th.KSWarn || c.KSProb < th.KSFail {
+		t.Errorf("%v, want status %v from the KS probability", c, Warn)
+	}
+}
+
+func TestCompare2D(t *testing.T) {
+	gaus := func(nx int, n, xmean, ymean float64) *hbook.H2D {
+		h := hbook.NewH2D(nx, -5, 5, 20, -5, 5)
+		fillGaus2D(h, n, xmean, 1, ymean, 1)
+		return h
+	}
+	tests := []struct {
+		name   string
+		h, r   *hbook.H2D
+		ok     bool
+		status Status
+	}{
+		{"identical", gaus(20, 10000, 0, 0), gaus(20, 10000, 0, 0), true, Pass},
+		{"shifted along x", gaus(20, 10000, 0.5, 0), gaus(20, 10000, 0, 0), true, Fail},
+		{"shifted along y", gaus(20, 10000, 0, 0.5), gaus(20, 10000, 0, 0), true, Fail},
+		{"different binnings", gaus(10, 10000, 0, 0), gaus(20, 10000, 0, 0), false, Pass},
+		{"missing", gaus(20, 10000, 0, 0), nil, false, Pass},
+	}
+	for _, test := range tests {
+		c, ok := compare(test.name, h2d{test.h}, h2d{test.r}, DefaultThresholds)
+		if ok != test.ok {
+			t.Errorf("%v: ok = %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if c.Status != test.status {
+			t.Errorf("%v: %v, want status %v", test.name, c, test.status)
+		}
+		if c.KSProb < 0 || c.KSProb > 1 {
+			t.Errorf("%v: KS probability = %v", test.name, c.KSProb)
+		}
+	}
+
+	// the projection of a 2D histogram is the 1D histogram of its x values
+	h2 := gaus(20, 10000, 0.3, 0)
+	h1 := hbook.NewH1D(20, -5, 5)
+	for _, bin := range h2.Binning.Bins {
+		h1.Fill(bin.XMid(), bin.SumW())
+	}
+	p := projX{h2}
+	if p.len() != 20 {
+		t.Fatalf("projection: %v bins, want 20", p.len())
+	}
+	for i := 0; i < p.len(); i++ {
+		if math.Abs(p.sumw(i)-h1.Value(i)) > 1e-9 {
+			t.Errorf("projection: bin %v = %v, want %v", i, p.sumw(i), h1.Value(i))
+		}
+	}
+}
+
+func TestCompareDQPlot(t *testing.T) {
+	d := NewDQPlot()
+	if comps := d.Compare(DefaultThresholds); comps != nil {
+		t.Errorf("comparisons without reference: %v", comps)
+	}
+
+	// d differs from its reference by a shifted RF timing and a drifting HV
+	ref := NewDQPlot()
+	d.DQPlotRef = ref
+	for _, p := range []*DQPlot{d, ref} {
+		fillGaus(p.HNoise, 10000, 120, 30, 1)
+		fillGaus(p.HNoSignal, 10000, 120, 30, 1)
+		fillGaus(&p.HEnergy[3][1], 10000, 511, 50, 1)
+		p.HV[0][0] = plotter.XYs{{X: 0, Y: 1000}, {X: 1, Y: 1002}}
+		p.HV[1][2] = plotter.XYs{{X: 0, Y: 1100}}
+	}
+	fillGaus2D(d.HEnergyVsDeltaTggRF, 10000, 24, 4, 500, 150)
+	fillGaus2D(ref.HEnergyVsDeltaTggRF, 10000, 20, 4, 500, 150)
+	d.HV[1][2] = append(d.HV[1][2], plotter.XY{X: 1, Y: 1200})
+
+	want := map[string]Status{
+		"HNoise":              Pass,
+		"HNoSignal":           Pass,
+		"HEnergy[3][1]":       Pass,
+		"HEnergyVsDeltaTggRF": Fail,
+		"DeltaTggRF":          Fail,
+		"HV[0][0]":            Pass,
+		"HV[1][2]":            Fail,
+	}
+	comps := d.Compare(DefaultThresholds)
+	if len(comps) != len(want) {
+		t.Errorf("%v comparisons, want %v", len(comps), len(want))
+	}
+	for _, c := range comps {
+		status, ok := want[c.Name]
+		switch {
+		case !ok:
+			t.Errorf("unexpected comparison %v", c)
+		case c.Status != status:
+			t.Errorf("%v, want status %v", c, status)
+		}
+		if hv := c.Name[:2] == "HV"; hv != c.HV {
+			t.Errorf("%v: HV = %v", c.Name, c.HV)
+		}
+	}
+	if c, ok := compareHV("HV", d.HV[1][2], ref.HV[1][2], DefaultThresholds); !ok || c.HVDiff != 50 {
+		t.Errorf("HV comparison = %v, %v, want a difference of 50 V", c, ok)
+	}
+	if q := RunQuality(comps); q != Fail {
+		t.Errorf("run quality = %v, want %v", q, Fail)
+	}
+}
@@ -0,0 +1,156 @@
+// Command dqmerge combines the dq plots of several runs, saved by the analysis
+// command (option -wdq, or -wgob for legacy gob files), and makes trend plots of key quantities
+// across the runs, in order to spot gain drifts and failing channels over a beam
+// campaign.
+//
+// Runs are taken in the order of the input files. The run number is the one
+// stored in the file or, for older files, the one in the file name (runXXX).
+//
+// Outputs (in the directory given by -dir):
+//   - trends.csv: one line per run with the quantities not given per channel
+//   - trends.png: 511 keV peak, mean LOR multiplicity, mean MAR Z and mean pedestal noise versus run
+//   - trendRate.png, trendPeak511.png, trendNoise.png: per channel rate, 511 keV peak and pedestal noise versus run
+//
+// Channels with a rate below -lowrate times the median rate are printed for each run.
+//
+// Example:
+//
+//	dqmerge -o campaign.root -dir trends dq-run100.root dq-run101.root dq-run102.gob
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dq"
+	"go-hep.org/x/hep/csvutil"
+	"gonum.org/v1/plot/vg"
+)
+
+var (
+	outFile = flag.String("o", "", "Name of the output file containing the merged dq plots (ROOT, or legacy gob with the .gob extension). If not set, the merged dq plots are not written.")
+	dir     = flag.String("dir", "trends", "Name of the output directory for trend plots")
+	lowRate = flag.Float64("lowrate", 0.1, "Fraction of the median channel rate below which a channel is reported as failing")
+)
+
+// trendCSV is a line of trends.csv
+type trendCSV struct {
+	Run         uint32
+	Nevents     uint
+	Peak511All  float64
+	LORMultMean float64
+	MinRecZMean float64
+	MeanRate    float64
+	MeanNoise   float64
+}
+
+var runRE = regexp.MustCompile(`run(\d+)`)
+
+func main() {
+	log.SetFlags(log.Llongfile | log.LstdFlags)
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("usage: dqmerge [options] file1.root [file2.root ...]")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	err := os.MkdirAll(*dir, 0777)
+	if err != nil {
+		log.Fatalf("error creating output directory: %v\n", err)
+	}
+
+	var merged *dq.DQPlot
+	var points []dq.TrendPoint
+	for _, fileName := range flag.Args() {
+		d, err := dq.ReadFile(fileName)
+		if err != nil {
+			log.Fatalf("could not read dq plots: %v\n", err)
+		}
+		run := d.RunNumber
+		if run == 0 {
+			if m := runRE.FindStringSubmatch(filepath.Base(fileName)); m != nil {
+				r, _ := strconv.ParseUint(m[1], 10, 32)
+				run = uint32(r)
+			}
+		}
+		p := dq.NewTrendPoint(run, d)
+		points = append(points, p)
+		if chans := p.LowRateChannels(*lowRate); len(chans) > 0 {
+			fmt.Printf("run %v: low rate channels %v\n", run, chans)
+		}
+
+		switch merged {
+		case nil:
+			merged = d
+		default:
+			if err := merged.Add(d); err != nil {
+				log.Fatalf("could not merge the dq plots of %v: %v\n", fileName, err)
+			}
+		}
+	}
+
+	if *outFile != "" {
+		if err := merged.WriteFile(*outFile); err != nil {
+			log.Fatalf("error writing dq file: %v\n", err)
+		}
+	}
+
+	writeCSV(filepath.Join(*dir, "trends.csv"), points)
+
+	tp := dq.MakeTrendPlot(points)
+	if err := tp.Save(20*vg.Centimeter, 30*vg.Centimeter, filepath.Join(*dir, "trends.png")); err != nil {
+		log.Fatalf("error saving plot: %v\n", err)
+	}
+	for _, c := range []struct {
+		name  string
+		title string
+		val   func(p *dq.TrendPoint) []float64
+	}{
+		{"trendRate.png", "Pulses with signal per event", func(p *dq.TrendPoint) []float64 { return p.Rate }},
+		{"trendPeak511.png", "511 keV peak position (keV)", func(p *dq.TrendPoint) []float64 { return p.Peak511 }},
+		{"trendNoise.png", "Pedestal noise (ADC counts)", func(p *dq.TrendPoint) []float64 { return p.Noise }},
+	} {
+		p := dq.MakeChannelTrendPlot(points, c.title, c.val)
+		if err := p.Save(20*vg.Centimeter, 30*vg.Centimeter, filepath.Join(*dir, c.name)); err != nil {
+			log.Fatalf("error saving plot: %v\n", err)
+		}
+	}
+}
+
+func writeCSV(fileName string, points []dq.TrendPoint) {
+	tbl, err := csvutil.Create(fileName)
+	if err != nil {
+		log.Fatalf("could not create %v: %v\n", fileName, err)
+	}
+	defer tbl.Close()
+	tbl.Writer.Comma = ' '
+
+	err = tbl.WriteHeader("# Run Nevents Peak511All LORMultMean MinRecZMean MeanRate MeanNoise\n")
+	if err != nil {
+		log.Fatalf("error writing header: %v\n", err)
+	}
+	for i := range points {
+		p := &points[i]
+		data := trendCSV{
+			Run:         p.Run,
+			Nevents:     p.Nevents,
+			Peak511All:  p.Peak511All,
+			LORMultMean: p.LORMultMean,
+			MinRecZMean: p.MinRecZMean,
+			MeanRate:    dq.MeanOf(p.Rate),
+			MeanNoise:   dq.MeanOf(p.Noise),
+		}
+		err = tbl.WriteRow(data)
+		if err != nil {
+			log.Fatalf("error writing row: %v\n", err)
+		}
+	}
+}
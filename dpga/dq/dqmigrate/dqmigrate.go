@@ -0,0 +1,54 @@
+// Command dqmigrate converts dq plots saved in the legacy gob format (e.g. the
+// reference files of dpga/dqref) to the versioned ROOT format of package dq
+// (see dq.FormatVersion). It can also be used to rewrite ROOT files written with
+// a previous version of the format with the current version.
+//
+// Each input file is written next to it with the .root extension, unless -o
+// is given (single input file only).
+//
+// Example (the ROOT file obtained is shipped next to the gob reference):
+//
+//	dqmigrate dpga/dqref/dq-run37020evtsPedReference.gob
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dq"
+)
+
+var outFile = flag.String("o", "", "Name of the output file (single input file only)")
+
+func main() {
+	log.SetFlags(log.Llongfile | log.LstdFlags)
+
+	flag.Parse()
+
+	if flag.NArg() == 0 || (*outFile != "" && flag.NArg() > 1) {
+		fmt.Println("usage: dqmigrate [-o output.root] file1.gob [file2.gob ...]")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	for _, fileName := range flag.Args() {
+		d, err := dq.ReadFile(fileName)
+		if err != nil {
+			log.Fatalf("could not read dq plots: %v\n", err)
+		}
+		outFileName := *outFile
+		if outFileName == "" {
+			outFileName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".root"
+		}
+		if outFileName == fileName {
+			log.Fatalf("output file %v would overwrite input file, use -o\n", outFileName)
+		}
+		if err := d.WriteROOT(outFileName); err != nil {
+			log.Fatalf("could not write dq plots: %v\n", err)
+		}
+	}
+}
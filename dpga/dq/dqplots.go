@@ -11,6 +11,8 @@ import (
 
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/reconstruction"
+	"gitlab.in2p3.fr/avirm/analysis-go/rf"
 	"gitlab.in2p3.fr/avirm/analysis-go/utils"
 	"go-hep.org/x/hep/hbook"
 	"go-hep.org/x/hep/hplot"
@@ -23,12 +25,15 @@ import (
 )
 
 type DQPlot struct {
+	RunNumber           uint32
 	Nevents             uint
 	HFrequency          *hbook.H1D
 	HSatFrequency       *hbook.H1D
 	HMultiplicity       *hbook.H1D
 	HSatMultiplicity    *hbook.H1D
 	HLORMult            *hbook.H1D
+	HNoSignal           *hbook.H1D // number of pulses without signal per channel
+	HNoise              *hbook.H1D // sum of the RMS of the samples of pulses without signal per channel (see PedestalNoise)
 	HCharge             [][]hbook.H1D
 	HAmplitude          [][]hbook.H1D
 	HEnergy             [][]hbook.H1D
@@ -44,7 +49,21 @@ type DQPlot struct {
 
 	HV [4][16]plotter.XYs // first index refers to HV card (there are 4 cards), second index refers to channels (there are 16 channels per card)
 
+	IntegrityErrors map[string]uint // number of events with an integrity error, per error message (see AddIntegrityError)
+
+	// Time-resolved dq histograms, filled if TimeWindow is set before filling.
+	// As a slice is made for each window since the first event, the memory used
+	// grows with the duration of the run divided by TimeWindow, up to
+	// MaxTimeSlices slices.
+	TimeWindow float64     // duration (s) of the time slices (0: no time slices)
+	TimeOrigin uint64      // timestamp of the first event, from which the time slices start
+	TimeSlices []TimeSlice // time slices, in time order
+	LateEvents uint        // number of events beyond the last time slice, not filled in the time slices
+
 	DQPlotRef *DQPlot
+
+	refEdge   *reconstruction.DistalEdge // fit of the distal edge of refEdgeOf (see refDistalEdge)
+	refEdgeOf *DQPlot
 }
 
 func NewDQPlot() *DQPlot {
@@ -56,6 +75,8 @@ func NewDQPlot() *DQPlot {
 		HMultiplicity:    hbook.NewH1D(8, -0.5, 7.5),
 		HSatMultiplicity: hbook.NewH1D(8, -0.5, 7.5),
 		HLORMult:         hbook.NewH1D(20, 0, 20),
+		HNoSignal:        hbook.NewH1D(240, 0, 240),
+		HNoise:           hbook.NewH1D(240, 0, 240),
 		HCharge:          make([][]hbook.H1D, NoClusters),
 		HAmplitude:       make([][]hbook.H1D, NoClusters),
 		HEnergy:          make([][]hbook.H1D, NoClusters),
@@ -84,32 +105,19 @@ func NewDQPlot() *DQPlot {
 	return dqp
 }
 
+// NewDQPlotFromGob reads the dq plots written by WriteGob in fileName.
+// It panics in case of error (see ReadFile, which also reads the ROOT format).
 func NewDQPlotFromGob(fileName string) *DQPlot {
-	fmt.Printf("Reading gob file %s\n", fileName)
-	f, err := os.Open(fileName)
-	if err != nil {
-		panic(err)
-	}
-	defer f.Close()
-
-	dec := gob.NewDecoder(f)
-
-	dqplot := NewDQPlot()
-
-	err = dec.Decode(dqplot)
-	if err != nil {
-		panic(err)
-	}
-
-	err = f.Close()
+	dqplot, err := readGob(fileName)
 	if err != nil {
 		panic(err)
 	}
 	return dqplot
 }
 
-func (d *DQPlot) FillHistos(event *event.Event, RFcutMean, RFcutWidth float64) {
+func (d *DQPlot) FillHistos(event *event.Event) {
 	d.Nevents++
+	ts := d.timeSlice(event)
 
 	var mult uint8 = 0
 	var satmult uint8 = 0
@@ -123,6 +131,9 @@ func (d *DQPlot) FillHistos(event *event.Event, RFcutMean, RFcutWidth float64) {
 			pulse := &cluster.Pulses[j]
 			if pulse.HasSignal {
 				d.HFrequency.Fill(counter, 1)
+				if ts != nil {
+					ts.HFrequency.Fill(counter, 1)
+				}
 			}
 			if pulse.HasSatSignal {
 				d.HSatFrequency.Fill(counter, 1)
@@ -133,12 +144,22 @@ func (d *DQPlot) FillHistos(event *event.Event, RFcutMean, RFcutWidth float64) {
 				d.HAmplitude[i][j].Fill(ampl, 1)
 				d.HEnergy[i][j].Fill(pulse.E, 1)
 				d.HEnergyAll.Fill(pulse.E, 1)
+				if ts != nil {
+					ts.HEnergyAll.Fill(pulse.E, 1)
+				}
+			} else if len(pulse.Samples) > 0 {
+				d.HNoSignal.Fill(counter, 1)
+				d.HNoise.Fill(counter, pulse.RMS())
 			}
 			counter++
 		}
 	}
 
 	d.HMultiplicity.Fill(float64(mult), 1)
+	if ts != nil {
+		ts.Nevents++
+		ts.HMultiplicity.Fill(float64(mult), 1)
+	}
 	d.HSatMultiplicity.Fill(float64(satmult), 1)
 
 	d.HLORMult.Fill(float64(len(event.LORs)), 1)
@@ -156,10 +177,14 @@ func (d *DQPlot) FillHistos(event *event.Event, RFcutMean, RFcutWidth float64) {
 		d.HEnergyVsDeltaTggRF.Fill(timeDiff, lor.Pulses[0].E, 1)
 		d.HEnergyVsDeltaTggRF.Fill(timeDiff, lor.Pulses[1].E, 1)
 
-		if math.Abs(timeDiff-RFcutMean) > RFcutWidth {
-			d.HMinRecX.Fill(lor.Xmar, 1)
-			d.HMinRecY.Fill(lor.Ymar, 1)
-			d.HMinRecZ.Fill(lor.Zmar, 1)
+		// prompt gammas are excluded from the activity profiles
+		if lor.RFClass != rf.Prompt {
+			d.HMinRecX.Fill(lor.Xmar, lor.Weight())
+			d.HMinRecY.Fill(lor.Ymar, lor.Weight())
+			d.HMinRecZ.Fill(lor.Zmar, lor.Weight())
+			if ts != nil {
+				ts.HMinRecZ.Fill(lor.Zmar, lor.Weight())
+			}
 		}
 	}
 
@@ -176,6 +201,15 @@ func (d *DQPlot) AddHVPoint(idCard int, idChannel int, abscissa float64, val flo
 	d.HV[idCard][idChannel] = append(d.HV[idCard][idChannel], struct{ X, Y float64 }{X: abscissa, Y: val})
 }
 
+// AddIntegrityError counts an event for which err was returned while reading
+// or building it (e.g. missing magic words, inconsistent frames).
+func (d *DQPlot) AddIntegrityError(err error) {
+	if d.IntegrityErrors == nil {
+		d.IntegrityErrors = make(map[string]uint)
+	}
+	d.IntegrityErrors[err.Error()]++
+}
+
 func (d *DQPlot) Finalize() {
 	d.HFrequency.Scale(1 / float64(d.Nevents))
 	d.HSatFrequency.Scale(1 / float64(d.Nevents))
@@ -259,13 +293,10 @@ func (d *DQPlot) MakeMinRecXYDistrs() *hplot.TiledPlot {
 }
 
 func (d *DQPlot) MakeMinRecZDistr() *plot.Plot {
-	p, err := plot.New()
+	p := plot.New()
 
 	p.X.Min = -10
 	p.X.Max = 10
-	if err != nil {
-		panic(err)
-	}
 	p.X.Label.Text = "Z (mm)"
 	p.Y.Label.Text = "No entries"
 	p.X.Tick.Marker = &hplot.FreqTicks{N: 124, Freq: 6}
@@ -279,6 +310,80 @@ func (d *DQPlot) MakeMinRecZDistr() *plot.Plot {
 	return p
 }
 
+// MinRecZProfile returns the distribution of the Z coordinate of the minimal
+// reconstruction points as a reconstruction.Profile, with Z at the bin centers.
+func (d *DQPlot) MinRecZProfile() reconstruction.Profile {
+	var p reconstruction.Profile
+	for i := 0; i < d.HMinRecZ.Len(); i++ {
+		p.Z = append(p.Z, d.HMinRecZ.Binning.Bins[i].XMid())
+		p.N = append(p.N, d.HMinRecZ.Value(i))
+	}
+	return p
+}
+
+// FitRange fits the distal fall-off of the minimal reconstruction Z distribution.
+// If d.DQPlotRef is not nil, the reference distribution is fitted as well and
+// the range shift with respect to the reference is returned.
+// Otherwise, the returned shift and its uncertainty are NaN.
+func (d *DQPlot) FitRange(dir reconstruction.BeamDir) (edge reconstruction.DistalEdge, shift, shiftErr float64) {
+	edge = reconstruction.FitDistalEdge(d.MinRecZProfile(), dir)
+	shift, shiftErr = math.NaN(), math.NaN()
+	if d.DQPlotRef != nil {
+		shift, shiftErr = reconstruction.RangeShift(edge, d.refDistalEdge(dir), dir)
+	}
+	return
+}
+
+// refDistalEdge returns the fit of the distal fall-off of the reference
+// distribution. As the reference does not change during a run, it is only
+// fitted again if d.DQPlotRef or the beam direction changed.
+func (d *DQPlot) refDistalEdge(dir reconstruction.BeamDir) reconstruction.DistalEdge {
+	if d.refEdge == nil || d.refEdgeOf != d.DQPlotRef || d.refEdge.Dir != dir {
+		edge := reconstruction.FitDistalEdge(d.DQPlotRef.MinRecZProfile(), dir)
+		d.refEdge = &edge
+		d.refEdgeOf = d.DQPlotRef
+	}
+	return *d.refEdge
+}
+
+// MakeRangePlot makes the minimal reconstruction Z distribution overlaid
+// with the fit of its distal fall-off (and with the fit of the reference
+// distribution if d.DQPlotRef is not nil).
+func (d *DQPlot) MakeRangePlot(dir reconstruction.BeamDir) *plot.Plot {
+	p := plot.New()
+	p.X.Label.Text = "Z (mm)"
+	p.Y.Label.Text = "No entries"
+	p.X.Tick.Marker = &hplot.FreqTicks{N: 124, Freq: 6}
+	p.BackgroundColor = color.RGBA{R: 230, G: 247, B: 255, A: 255}
+
+	hplotZ := hplot.NewH1D(d.HMinRecZ)
+	hplotZ.FillColor = color.RGBA{R: 255, G: 204, B: 153, A: 255}
+	p.Add(hplotZ)
+	p.Add(hplot.NewGrid())
+
+	edge, shift, shiftErr := d.FitRange(dir)
+	if math.IsNaN(edge.R50) {
+		p.Title.Text = "R50: fit failed"
+		return p
+	}
+	f := plotter.NewFunction(edge.Func())
+	f.Color = color.RGBA{R: 255, A: 255}
+	f.Width = vg.Points(2)
+	f.Samples = 500
+	p.Add(f)
+	p.Title.Text = fmt.Sprintf("R50 = %.2f +- %.2f mm", edge.R50, edge.R50Err)
+	if d.DQPlotRef != nil {
+		edgeRef := d.refDistalEdge(dir)
+		fref := plotter.NewFunction(edgeRef.Func())
+		fref.Color = color.RGBA{B: 255, A: 255}
+		fref.Dashes = []vg.Length{vg.Points(5), vg.Points(5)}
+		fref.Samples = 500
+		p.Add(fref)
+		p.Title.Text += fmt.Sprintf(", shift = %.2f +- %.2f mm", shift, shiftErr)
+	}
+	return p
+}
+
 type WhichVar byte
 
 const (
@@ -509,10 +614,7 @@ func (d *DQPlot) MakeHVTiledPlot() *hplot.TiledPlot {
 }
 
 func (d *DQPlot) MakeAmplCorrelationPlot() *plot.Plot {
-	pCorrelation, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pCorrelation := plot.New()
 	pCorrelation.X.Label.Text = "Amplitude pulse 0 (ADC counts)"
 	pCorrelation.Y.Label.Text = "Amplitude pulse 1 (ADC counts)"
 	pCorrelation.X.Tick.Marker = &hplot.FreqTicks{N: 11, Freq: 2}
@@ -527,10 +629,7 @@ func (d *DQPlot) MakeAmplCorrelationPlot() *plot.Plot {
 }
 
 func (d *DQPlot) MakeEnergyCorrelationPlot() *plot.Plot {
-	pCorrelation, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pCorrelation := plot.New()
 	pCorrelation.X.Label.Text = "Energy pulse 0 (keV)"
 	pCorrelation.Y.Label.Text = "Energy pulse 1 (keV)"
 	pCorrelation.X.Tick.Marker = &hplot.FreqTicks{N: 11, Freq: 2}
@@ -546,10 +645,7 @@ func (d *DQPlot) MakeEnergyCorrelationPlot() *plot.Plot {
 }
 
 func (d *DQPlot) MakeRFPlotALaArnaud() *plot.Plot {
-	pRF, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pRF := plot.New()
 	pRF.X.Label.Text = "tgg - trf (ns)"
 	pRF.Y.Label.Text = "Energy (keV)"
 	pRF.X.Tick.Marker = &hplot.FreqTicks{N: 11, Freq: 2}
@@ -565,10 +661,7 @@ func (d *DQPlot) MakeRFPlotALaArnaud() *plot.Plot {
 }
 
 func (d *DQPlot) MakeHitQuartetsPlot() *plot.Plot {
-	pHitQuartets, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pHitQuartets := plot.New()
 	pHitQuartets.X.Label.Text = "Quartet Id (right hemisphere)"
 	pHitQuartets.Y.Label.Text = "Quartet Id (left hemisphere)"
 	pHitQuartets.X.Tick.Marker = &hplot.FreqTicks{N: 31, Freq: 2}
@@ -610,6 +703,28 @@ func (d *DQPlot) MakeLORMultPlot() *hplot.Plot {
 	return p
 }
 
+// MakeMultiplicityPlot makes the multiplicity plot, overlaid with the
+// multiplicity of pulses with saturation (dashed).
+func (d *DQPlot) MakeMultiplicityPlot() *hplot.Plot {
+	p := hplot.New()
+	p.X.Label.Text = "Multiplicity"
+	p.Y.Label.Text = "No entries"
+	p.X.Tick.Marker = &hplot.FreqTicks{N: 9, Freq: 1}
+	hp := hplot.NewH1D(d.HMultiplicity)
+	hp.FillColor = color.RGBA{R: 255, G: 204, B: 153, A: 255}
+	p.Add(hp)
+	hpsat := hplot.NewH1D(d.HSatMultiplicity)
+	hpsat.LineStyle.Dashes = []vg.Length{vg.Points(5), vg.Points(5)}
+	hpsat.Color = plotutil.Color(0)
+	p.Add(hpsat)
+	p.Legend.Add("all", hp)
+	p.Legend.Add("saturated", hpsat)
+	p.Legend.Top = true
+	p.Add(hplot.NewGrid())
+	p.BackgroundColor = color.RGBA{R: 230, G: 247, B: 255, A: 255}
+	return p
+}
+
 func (d *DQPlot) MakeEnergyPlot() *hplot.Plot {
 	p := hplot.New()
 	p.X.Label.Text = "Energy (keV)"
@@ -672,6 +787,9 @@ func (d *DQPlot) SaveHistos() {
 			utils.H1dptrToHplot(linestyleref, dqplotref.HSatMultiplicity)...)...)
 }
 
+// WriteGob writes d to fileName in the legacy gob format.
+// The ROOT format (see WriteROOT) should be preferred, as gob files cannot be read
+// anymore once DQPlot changes and cannot be opened with external tools.
 func (d *DQPlot) WriteGob(fileName string) error {
 	f, err := os.Create(fileName)
 	if err != nil {
@@ -0,0 +1,185 @@
+package dq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+
+	"go-hep.org/x/hep/hbook"
+	"gonum.org/v1/plot/plotter"
+)
+
+// The gob files written with the early versions of go-hep/hbook (e.g. the
+// reference files of dpga/dqref) encode the histograms with a GobEncode method
+// which has since been replaced by MarshalBinary, so that they can not be
+// decoded into DQPlot anymore. They are decoded into legacyDQPlot instead,
+// whose histograms implement the legacy encoding.
+
+func init() {
+	gob.RegisterName("*hbook.EvenBinAxis", &legacyEvenBinAxis{})
+}
+
+// legacyDQPlot holds the fields of DQPlot which may be found in legacy gob files.
+type legacyDQPlot struct {
+	RunNumber        uint32
+	Nevents          uint
+	HFrequency       *legacyH1D
+	HSatFrequency    *legacyH1D
+	HMultiplicity    *legacyH1D
+	HSatMultiplicity *legacyH1D
+	HLORMult         *legacyH1D
+	HCharge          [][]legacyH1D
+	HAmplitude       [][]legacyH1D
+	HEnergy          [][]legacyH1D
+	HMinRecX         *legacyH1D
+	HMinRecY         *legacyH1D
+	HMinRecZ         *legacyH1D
+	DeltaT30         *legacyH1D
+	HEnergyAll       *legacyH1D
+	HV               [4][16]plotter.XYs
+}
+
+// legacyH1D is a 1D histogram in the legacy gob encoding: the bins (underflow,
+// overflow, then the bins of the axis), the axis, the number of entries and the
+// annotation.
+type legacyH1D struct {
+	h *hbook.H1D
+}
+
+// legacyBin1D is a bin in the legacy gob encoding. The sum of the weights times
+// the square of x was not stored.
+type legacyBin1D struct {
+	N     int64
+	SumW  float64
+	SumWX float64
+	SumW2 float64
+}
+
+// legacyEvenBinAxis is the axis of the legacy histograms, with bins of equal width.
+type legacyEvenBinAxis struct {
+	NBins     int64
+	Low, High float64
+	Width     float64
+}
+
+func (b *legacyBin1D) GobDecode(data []byte) error {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	for _, v := range []interface{}{&b.N, &b.SumW, &b.SumWX, &b.SumW2} {
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *legacyEvenBinAxis) UnmarshalBinary(data []byte) error {
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, a)
+}
+
+func (h *legacyH1D) GobDecode(data []byte) error {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	var bins []legacyBin1D
+	if err := dec.Decode(&bins); err != nil {
+		return err
+	}
+	var axis interface{}
+	if err := dec.Decode(&axis); err != nil {
+		return err
+	}
+	a, ok := axis.(*legacyEvenBinAxis)
+	if !ok {
+		return fmt.Errorf("dq: legacy axis of type %T not supported", axis)
+	}
+	if int64(len(bins)) != a.NBins+2 {
+		return fmt.Errorf("dq: legacy histogram with %v bins for an axis of %v bins", len(bins), a.NBins)
+	}
+
+	h.h = hbook.NewH1D(int(a.NBins), a.Low, a.High)
+	bng := &h.h.Binning
+	for i := range bins {
+		var dist *hbook.Dist1D
+		switch i {
+		case 0:
+			dist = bng.Underflow()
+		case 1:
+			dist = bng.Overflow()
+		default:
+			dist = &bng.Bins[i-2].Dist
+		}
+		b := &bins[i]
+		dist.Dist = hbook.Dist0D{N: b.N, SumW: b.SumW, SumW2: b.SumW2}
+		dist.Stats.SumWX = b.SumWX
+		if b.SumW != 0 {
+			// all the entries of the bin are assumed to be at their mean
+			dist.Stats.SumWX2 = b.SumWX * b.SumWX / b.SumW
+		}
+		bng.Dist.Dist.N += dist.Dist.N
+		bng.Dist.Dist.SumW += dist.Dist.SumW
+		bng.Dist.Dist.SumW2 += dist.Dist.SumW2
+		bng.Dist.Stats.SumWX += dist.Stats.SumWX
+		bng.Dist.Stats.SumWX2 += dist.Stats.SumWX2
+	}
+	// the number of entries and the annotation that follow are not needed
+	return nil
+}
+
+// set replaces *dst by the histogram of h, if h was found in the file.
+func (h *legacyH1D) set(dst *hbook.H1D) {
+	if h != nil && h.h != nil {
+		*dst = *h.h
+	}
+}
+
+// decodeLegacyGob decodes the dq plots of a legacy gob file.
+func decodeLegacyGob(data []byte) (*DQPlot, error) {
+	var l legacyDQPlot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&l); err != nil {
+		return nil, err
+	}
+	d := NewDQPlot()
+	d.RunNumber = l.RunNumber
+	d.Nevents = l.Nevents
+	d.HV = l.HV
+	for _, h := range []struct {
+		l   *legacyH1D
+		dst *hbook.H1D
+	}{
+		{l.HFrequency, d.HFrequency},
+		{l.HSatFrequency, d.HSatFrequency},
+		{l.HMultiplicity, d.HMultiplicity},
+		{l.HSatMultiplicity, d.HSatMultiplicity},
+		{l.HLORMult, d.HLORMult},
+		{l.HMinRecX, d.HMinRecX},
+		{l.HMinRecY, d.HMinRecY},
+		{l.HMinRecZ, d.HMinRecZ},
+		{l.DeltaT30, d.DeltaT30},
+		{l.HEnergyAll, d.HEnergyAll},
+	} {
+		h.l.set(h.dst)
+	}
+	for _, hs := range []struct {
+		l   [][]legacyH1D
+		dst [][]hbook.H1D
+	}{
+		{l.HCharge, d.HCharge},
+		{l.HAmplitude, d.HAmplitude},
+		{l.HEnergy, d.HEnergy},
+	} {
+		if len(hs.l) == 0 {
+			continue
+		}
+		if len(hs.l) != len(hs.dst) {
+			return nil, fmt.Errorf("dq: legacy histograms for %v clusters, want %v", len(hs.l), len(hs.dst))
+		}
+		for i := range hs.l {
+			if len(hs.l[i]) != len(hs.dst[i]) {
+				return nil, fmt.Errorf("dq: %v legacy histograms for cluster %v, want %v", len(hs.l[i]), i, len(hs.dst[i]))
+			}
+			for j := range hs.l[i] {
+				hs.l[i][j].set(&hs.dst[i][j])
+			}
+		}
+	}
+	return d, nil
+}
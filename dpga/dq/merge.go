@@ -0,0 +1,144 @@
+package dq
+
+import (
+	"fmt"
+
+	"go-hep.org/x/hep/hbook"
+)
+
+// Add adds the dq plots of o to the ones of d, e.g. to combine the dq plots of
+// several runs (see ReadFile). Histograms are summed bin by bin, HV points
+// are appended and integrity error counts are summed. As the merged dq plots
+// do not belong to a single run, the run number is set to 0 and the time slices,
+// which are relative to the start of a run, are dropped.
+//
+// The dq plots must not have been finalized (see Finalize), as normalized
+// histograms cannot be summed.
+//
+// An error is returned, and d is left unchanged, if the histograms of o do not
+// have the binning of the ones of d, or if o does not have as many per channel
+// histograms as d (see checkAdd).
+func (d *DQPlot) Add(o *DQPlot) error {
+	if err := d.checkAdd(o); err != nil {
+		return err
+	}
+	d.RunNumber = 0
+	d.TimeWindow, d.TimeOrigin, d.TimeSlices, d.LateEvents = 0, 0, nil, 0
+	d.Nevents += o.Nevents
+	oh1ds := o.h1ds()
+	for i, h := range d.h1ds() {
+		addH1D(h.h, oh1ds[i].h)
+	}
+	oh2ds := o.h2ds()
+	for i, h := range d.h2ds() {
+		addH2D(h.h, oh2ds[i].h)
+	}
+	for i := range d.HV {
+		for j := range d.HV[i] {
+			d.HV[i][j] = append(d.HV[i][j], o.HV[i][j]...)
+		}
+	}
+	for msg, n := range o.IntegrityErrors {
+		if d.IntegrityErrors == nil {
+			d.IntegrityErrors = make(map[string]uint)
+		}
+		d.IntegrityErrors[msg] += n
+	}
+	return nil
+}
+
+// checkAdd returns an error if the dq plots of o cannot be added to the ones
+// of d (see Add): the per channel histograms of o must have the shape of the
+// ones of d, and each histogram of o the binning of the one of d. Histograms
+// missing in o are ignored, while a histogram of o with entries must not be
+// missing in d.
+func (d *DQPlot) checkAdd(o *DQPlot) error {
+	for _, hs := range []struct {
+		name string
+		d, o [][]hbook.H1D
+	}{
+		{"HCharge", d.HCharge, o.HCharge},
+		{"HAmplitude", d.HAmplitude, o.HAmplitude},
+		{"HEnergy", d.HEnergy, o.HEnergy},
+	} {
+		if len(hs.o) != len(hs.d) {
+			return fmt.Errorf("dq: %v histograms of %v clusters added to histograms of %v clusters", hs.name, len(hs.o), len(hs.d))
+		}
+		for i := range hs.d {
+			if len(hs.o[i]) != len(hs.d[i]) {
+				return fmt.Errorf("dq: %v %v histograms for cluster %v added to %v", len(hs.o[i]), hs.name, i, len(hs.d[i]))
+			}
+		}
+	}
+	oh1ds := o.h1ds()
+	for i, h := range d.h1ds() {
+		if err := checkAddH1D(h.name, h.h, oh1ds[i].h); err != nil {
+			return err
+		}
+	}
+	oh2ds := o.h2ds()
+	for i, h := range d.h2ds() {
+		if err := checkAddH2D(h.name, h.h, oh2ds[i].h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkAddH1D(name string, h, o *hbook.H1D) error {
+	switch {
+	case o == nil:
+		return nil
+	case h == nil:
+		if o.Entries() > 0 {
+			return fmt.Errorf("dq: %v with entries added to a missing histogram", name)
+		}
+		return nil
+	case h.Len() != o.Len() || h.XMin() != o.XMin() || h.XMax() != o.XMax():
+		return fmt.Errorf("dq: %v with %v bins in [%v, %v] added to %v bins in [%v, %v]",
+			name, o.Len(), o.XMin(), o.XMax(), h.Len(), h.XMin(), h.XMax())
+	}
+	return nil
+}
+
+func checkAddH2D(name string, h, o *hbook.H2D) error {
+	switch {
+	case o == nil:
+		return nil
+	case h == nil:
+		if o.Entries() > 0 {
+			return fmt.Errorf("dq: %v with entries added to a missing histogram", name)
+		}
+		return nil
+	case h.Binning.Nx != o.Binning.Nx || h.Binning.Ny != o.Binning.Ny ||
+		h.XMin() != o.XMin() || h.XMax() != o.XMax() || h.YMin() != o.YMin() || h.YMax() != o.YMax():
+		return fmt.Errorf("dq: %v with %vx%v bins in [%v, %v]x[%v, %v] added to %vx%v bins in [%v, %v]x[%v, %v]",
+			name, o.Binning.Nx, o.Binning.Ny, o.XMin(), o.XMax(), o.YMin(), o.YMax(),
+			h.Binning.Nx, h.Binning.Ny, h.XMin(), h.XMax(), h.YMin(), h.YMax())
+	}
+	return nil
+}
+
+// addH1D adds o to h, bin by bin (see checkAddH1D).
+func addH1D(h, o *hbook.H1D) {
+	if o == nil || o.Entries() == 0 {
+		return
+	}
+	*h = *hbook.AddH1D(h, o)
+}
+
+// addH2D adds o to h by filling h with the content of each bin of o at its center
+// (see checkAddH2D).
+// The sums of weights are therefore exact, while the number of entries and the
+// sums of squared weights are approximate (which is of no consequence for the
+// 2D dq plots, which are only displayed).
+func addH2D(h, o *hbook.H2D) {
+	if o == nil {
+		return
+	}
+	for _, bin := range o.Binning.Bins {
+		if w := bin.SumW(); w != 0 {
+			h.Fill(bin.XMid(), bin.YMid(), w)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package dq
+
+import (
+	"errors"
+	"testing"
+
+	"go-hep.org/x/hep/hbook"
+	"gonum.org/v1/plot/plotter"
+)
+
+// testDQPlot returns dq plots of the run run, with n events having a pulse
+// with signal in channel ichan, of energy e.
+func testDQPlot(run uint32, n int, ichan int, e float64) *DQPlot {
+	d := NewDQPlot()
+	d.RunNumber = run
+	d.Nevents = uint(n)
+	for i := 0; i < n; i++ {
+		d.HFrequency.Fill(float64(ichan), 1)
+		d.HMultiplicity.Fill(1, 1)
+		d.HEnergy[ichan/4][ichan%4].Fill(e, 1)
+		d.HEnergyAll.Fill(e, 1)
+		d.HitQuartets.Fill(float64(ichan/4), 30.5, 1)
+	}
+	d.HV[0][ichan%16] = plotter.XYs{{X: float64(run), Y: 1000}}
+	d.TimeWindow = 1
+	d.TimeSlices = []TimeSlice{NewTimeSlice(0)}
+	d.LateEvents = 2
+	d.AddIntegrityError(errors.New("missing frame"))
+	return d
+}
+
+func TestAdd(t *testing.T) {
+	d := testDQPlot(100, 10, 5, 511)
+	for _, o := range []*DQPlot{testDQPlot(101, 20, 7, 480), testDQPlot(102, 5, 5, 511)} {
+		if err := d.Add(o); err != nil {
+			t.Fatalf("could not add dq plots: %v\n", err)
+		}
+	}
+
+	if d.RunNumber != 0 {
+		t.Errorf("RunNumber = %v, want 0", d.RunNumber)
+	}
+	if d.TimeWindow != 0 || d.TimeSlices != nil || d.LateEvents != 0 {
+		t.Errorf("time slices kept: TimeWindow = %v, %v slices, %v late events", d.TimeWindow, len(d.TimeSlices), d.LateEvents)
+	}
+	if d.Nevents != 35 {
+		t.Errorf("Nevents = %v, want 35", d.Nevents)
+	}
+	for _, test := range []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"HFrequency[5]", d.HFrequency.Value(5), 15},
+		{"HFrequency[7]", d.HFrequency.Value(7), 20},
+		{"HMultiplicity entries", float64(d.HMultiplicity.Entries()), 35},
+		{"HEnergy[1][1] sum", d.HEnergy[1][1].SumW(), 15},
+		{"HEnergy[1][3] sum", d.HEnergy[1][3].SumW(), 20},
+		{"HEnergyAll sum", d.HEnergyAll.SumW(), 35},
+		{"HitQuartets sum", d.HitQuartets.SumW(), 35},
+		{"integrity errors", float64(d.IntegrityErrors["missing frame"]), 3},
+		{"HV points channel 5", float64(len(d.HV[0][5])), 2},
+		{"HV points channel 7", float64(len(d.HV[0][7])), 1},
+	} {
+		if test.got != test.want {
+			t.Errorf("%v = %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestAddEmpty(t *testing.T) {
+	d := testDQPlot(100, 10, 5, 511)
+	if err := d.Add(NewDQPlot()); err != nil {
+		t.Fatalf("could not add dq plots: %v\n", err)
+	}
+	if d.Nevents != 10 || d.HFrequency.Value(5) != 10 || d.HEnergyAll.SumW() != 10 {
+		t.Errorf("adding empty dq plots changed the content: Nevents = %v, HFrequency[5] = %v, HEnergyAll = %v",
+			d.Nevents, d.HFrequency.Value(5), d.HEnergyAll.SumW())
+	}
+}
+
+func TestAddIncompatible(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(o *DQPlot)
+	}{
+		{"1D binning", func(o *DQPlot) { o.HEnergyAll = hbook.NewH1D(100, 0, 1022) }},
+		{"1D range", func(o *DQPlot) { o.HEnergyAll = hbook.NewH1D(200, 0, 2044) }},
+		{"per channel binning", func(o *DQPlot) { o.HEnergy[3][2] = *hbook.NewH1D(50, 0, 1022) }},
+		{"2D binning", func(o *DQPlot) { o.HitQuartets = hbook.NewH2D(30, 0, 30, 60, 0, 60) }},
+		{"clusters", func(o *DQPlot) { o.HCharge = o.HCharge[:10] }},
+		{"channels", func(o *DQPlot) { o.HAmplitude[7] = o.HAmplitude[7][:2] }},
+	}
+	for _, test := range tests {
+		d := testDQPlot(100, 10, 5, 511)
+		o := testDQPlot(101, 20, 5, 511)
+		test.edit(o)
+		if err := d.Add(o); err == nil {
+			t.Errorf("%v: incompatible dq plots added without error", test.name)
+		}
+		if d.RunNumber != 100 || d.Nevents != 10 || d.HFrequency.Value(5) != 10 || len(d.HV[0][5]) != 1 {
+			t.Errorf("%v: dq plots changed by a failed Add", test.name)
+		}
+	}
+
+	// missing histograms are ignored, unless they have entries
+	d := testDQPlot(100, 10, 5, 511)
+	o := testDQPlot(101, 20, 5, 511)
+	o.HNoise, o.AmplCorrelation = nil, nil
+	if err := d.Add(o); err != nil {
+		t.Errorf("could not add dq plots with missing histograms: %v", err)
+	}
+	d.HEnergyAll = nil
+	if err := d.Add(testDQPlot(102, 5, 5, 511)); err == nil {
+		t.Errorf("histogram with entries added to a missing histogram without error")
+	}
+}
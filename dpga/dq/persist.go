@@ -0,0 +1,330 @@
+package dq
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+
+	"go-hep.org/x/hep/groot"
+	"go-hep.org/x/hep/groot/rbase"
+	"go-hep.org/x/hep/groot/rhist"
+	"go-hep.org/x/hep/groot/riofs"
+	"go-hep.org/x/hep/groot/root"
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hbook/rootcnv"
+	"gonum.org/v1/plot/plotter"
+)
+
+// FormatVersion is the version of the ROOT format written by WriteROOT.
+//
+// DQ plots are stored in ROOT files as TH1D and TH2D histograms named after the
+// fields of DQPlot (e.g. HFrequency, HCharge_12_3 for HCharge[12][3]), HV curves
+// as TGraphs (e.g. HV_1_15 for HV[1][15]), the histograms of the time slices
+// prefixed by the index of the slice (e.g. TS12_HMinRecZ for TimeSlices[12].HMinRecZ)
+// and the other information as a JSON encoded TObjString named meta, holding the
+// format version. They can therefore be opened with ROOT or uproot.
+//
+// The version must be incremented whenever the way DQPlot is stored changes (e.g.
+// a histogram is renamed or its meaning changes), and a migration from the
+// previous version must be appended to migrations so that files written with
+// previous versions, such as reference files, can still be read. Histograms
+// added to DQPlot do not require a new version: they are simply left empty when
+// reading older files.
+const FormatVersion = 1
+
+// metaKey is the name of the TObjString holding the meta information.
+const metaKey = "meta"
+
+// meta is the information stored with the histograms.
+type meta struct {
+	Version         int             `json:"version"`
+	RunNumber       uint32          `json:"run"`
+	Nevents         uint            `json:"nevents"`
+	IntegrityErrors map[string]uint `json:"integrityErrors,omitempty"`
+	TimeWindow      float64         `json:"timeWindow,omitempty"`
+	TimeOrigin      uint64          `json:"timeOrigin,omitempty"`
+	TimeSlices      []sliceMeta     `json:"timeSlices,omitempty"`
+	LateEvents      uint            `json:"lateEvents,omitempty"`
+}
+
+// sliceMeta is the information stored for each time slice, in addition to its histograms.
+type sliceMeta struct {
+	Start   float64 `json:"start"`
+	Nevents uint    `json:"nevents"`
+}
+
+type namedH1D struct {
+	name string
+	h    *hbook.H1D
+}
+
+type namedH2D struct {
+	name string
+	h    *hbook.H2D
+}
+
+// h1ds returns the 1D histograms of d, with the names under which they are stored.
+func (d *DQPlot) h1ds() []namedH1D {
+	hs := []namedH1D{
+		{"HFrequency", d.HFrequency},
+		{"HSatFrequency", d.HSatFrequency},
+		{"HMultiplicity", d.HMultiplicity},
+		{"HSatMultiplicity", d.HSatMultiplicity},
+		{"HLORMult", d.HLORMult},
+		{"HNoSignal", d.HNoSignal},
+		{"HNoise", d.HNoise},
+		{"HMinRecX", d.HMinRecX},
+		{"HMinRecY", d.HMinRecY},
+		{"HMinRecZ", d.HMinRecZ},
+		{"DeltaT30", d.DeltaT30},
+		{"HEnergyAll", d.HEnergyAll},
+	}
+	for i := range d.HCharge {
+		for j := range d.HCharge[i] {
+			idx := "_" + strconv.Itoa(i) + "_" + strconv.Itoa(j)
+			hs = append(hs,
+				namedH1D{"HCharge" + idx, &d.HCharge[i][j]},
+				namedH1D{"HAmplitude" + idx, &d.HAmplitude[i][j]},
+				namedH1D{"HEnergy" + idx, &d.HEnergy[i][j]})
+		}
+	}
+	return hs
+}
+
+// h1ds returns the histograms of the time slice ts of index i, with the names under which they are stored.
+func (ts *TimeSlice) h1ds(i int) []namedH1D {
+	prefix := "TS" + strconv.Itoa(i) + "_"
+	return []namedH1D{
+		{prefix + "HFrequency", ts.HFrequency},
+		{prefix + "HMultiplicity", ts.HMultiplicity},
+		{prefix + "HEnergyAll", ts.HEnergyAll},
+		{prefix + "HMinRecZ", ts.HMinRecZ},
+	}
+}
+
+// h2ds returns the 2D histograms of d, with the names under which they are stored.
+func (d *DQPlot) h2ds() []namedH2D {
+	return []namedH2D{
+		{"AmplCorrelation", d.AmplCorrelation},
+		{"EnergyCorrelation", d.EnergyCorrelation},
+		{"HitQuartets", d.HitQuartets},
+		{"HEnergyVsDeltaTggRF", d.HEnergyVsDeltaTggRF},
+	}
+}
+
+func hvName(card, channel int) string {
+	return "HV_" + strconv.Itoa(card) + "_" + strconv.Itoa(channel)
+}
+
+// WriteROOT writes d to the ROOT file fileName (see FormatVersion).
+// The reference plots (DQPlotRef) are not written.
+func (d *DQPlot) WriteROOT(fileName string) error {
+	fmt.Printf("Saving DQplot to %s\n", fileName)
+	f, err := groot.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dir := riofs.Dir(f)
+
+	mt := meta{
+		Version:         FormatVersion,
+		RunNumber:       d.RunNumber,
+		Nevents:         d.Nevents,
+		IntegrityErrors: d.IntegrityErrors,
+		TimeWindow:      d.TimeWindow,
+		TimeOrigin:      d.TimeOrigin,
+		LateEvents:      d.LateEvents,
+	}
+	for _, ts := range d.TimeSlices {
+		mt.TimeSlices = append(mt.TimeSlices, sliceMeta{Start: ts.Start, Nevents: ts.Nevents})
+	}
+	m, err := json.Marshal(mt)
+	if err != nil {
+		return err
+	}
+	put := func(name string, obj root.Object) {
+		if err == nil {
+			err = dir.Put(name, obj)
+		}
+	}
+	put(metaKey, rbase.NewObjString(string(m)))
+	hs := d.h1ds()
+	for i := range d.TimeSlices {
+		hs = append(hs, d.TimeSlices[i].h1ds(i)...)
+	}
+	for _, h := range hs {
+		put(h.name, rhist.NewH1DFrom(h.h))
+	}
+	for _, h := range d.h2ds() {
+		put(h.name, rhist.NewH2DFrom(h.h))
+	}
+	for i := range d.HV {
+		for j, xys := range d.HV[i] {
+			if len(xys) == 0 {
+				continue
+			}
+			pts := make([]hbook.Point2D, len(xys))
+			for k := range xys {
+				pts[k] = hbook.Point2D{X: xys[k].X, Y: xys[k].Y}
+			}
+			put(hvName(i, j), rhist.NewGraphFrom(hbook.NewS2D(pts...)))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// NewDQPlotFromROOT reads the dq plots written by WriteROOT in fileName,
+// possibly with a previous version of the format.
+func NewDQPlotFromROOT(fileName string) (*DQPlot, error) {
+	fmt.Printf("Reading ROOT file %s\n", fileName)
+	f, err := groot.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dir := riofs.Dir(f)
+
+	obj, err := dir.Get(metaKey)
+	if err != nil {
+		return nil, fmt.Errorf("dq: %v is not a dq file: %v", fileName, err)
+	}
+	str, ok := obj.(*rbase.ObjString)
+	if !ok {
+		return nil, fmt.Errorf("dq: %q in %v is not a TObjString (%T)", metaKey, fileName, obj)
+	}
+	var m meta
+	if err := json.Unmarshal([]byte(str.String()), &m); err != nil {
+		return nil, fmt.Errorf("dq: could not decode %q in %v: %v", metaKey, fileName, err)
+	}
+	if m.Version < 1 || m.Version > FormatVersion {
+		return nil, fmt.Errorf("dq: %v has format version %v, only versions up to %v are supported", fileName, m.Version, FormatVersion)
+	}
+
+	d := NewDQPlot()
+	d.RunNumber = m.RunNumber
+	d.Nevents = m.Nevents
+	d.IntegrityErrors = m.IntegrityErrors
+	d.TimeWindow = m.TimeWindow
+	d.TimeOrigin = m.TimeOrigin
+	d.LateEvents = m.LateEvents
+	hs := d.h1ds()
+	for i, sm := range m.TimeSlices {
+		ts := NewTimeSlice(sm.Start)
+		ts.Nevents = sm.Nevents
+		d.TimeSlices = append(d.TimeSlices, ts)
+		hs = append(hs, ts.h1ds(i)...)
+	}
+
+	// histograms missing in the file (added after it was written) are left empty
+	for _, h := range hs {
+		obj, err := dir.Get(h.name)
+		if err != nil {
+			continue
+		}
+		rh, ok := obj.(rhist.H1)
+		if !ok {
+			return nil, fmt.Errorf("dq: %q in %v is not a 1D histogram (%T)", h.name, fileName, obj)
+		}
+		*h.h = *rootcnv.H1D(rh)
+	}
+	for _, h := range d.h2ds() {
+		obj, err := dir.Get(h.name)
+		if err != nil {
+			continue
+		}
+		rh, ok := obj.(rhist.H2)
+		if !ok {
+			return nil, fmt.Errorf("dq: %q in %v is not a 2D histogram (%T)", h.name, fileName, obj)
+		}
+		*h.h = *rootcnv.H2D(rh)
+	}
+	for i := range d.HV {
+		for j := range d.HV[i] {
+			obj, err := dir.Get(hvName(i, j))
+			if err != nil {
+				continue
+			}
+			g, ok := obj.(rhist.Graph)
+			if !ok {
+				return nil, fmt.Errorf("dq: %q in %v is not a graph (%T)", hvName(i, j), fileName, obj)
+			}
+			s := rootcnv.S2D(g)
+			xys := make(plotter.XYs, s.Len())
+			for k := range xys {
+				pt := s.Point(k)
+				xys[k].X, xys[k].Y = pt.X, pt.Y
+			}
+			d.HV[i][j] = xys
+		}
+	}
+
+	if err := migrate(d, m.Version); err != nil {
+		return nil, fmt.Errorf("dq: could not migrate %v: %v", fileName, err)
+	}
+	return d, nil
+}
+
+// migrations[v-1] converts dq plots read from a file written with version v
+// of the format to version v+1. A migration must be appended whenever
+// FormatVersion is incremented (see TestMigrations).
+var migrations []func(d *DQPlot)
+
+// migrate converts the dq plots read from a file written with the given
+// version of the format to the current version, applying the migrations of
+// the successive versions. version is assumed not to be larger than FormatVersion.
+func migrate(d *DQPlot, version int) error {
+	for v := version; v < FormatVersion; v++ {
+		if v < 1 || v > len(migrations) {
+			return fmt.Errorf("no migration from format version %v to %v", v, v+1)
+		}
+		migrations[v-1](d)
+	}
+	return nil
+}
+
+// readGob reads the dq plots written by WriteGob in fileName, possibly with
+// the legacy encoding of the histograms (see legacyDQPlot).
+func readGob(fileName string) (*DQPlot, error) {
+	fmt.Printf("Reading gob file %s\n", fileName)
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	dqplot := NewDQPlot()
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(dqplot); err != nil {
+		legacy, lerr := decodeLegacyGob(data)
+		if lerr != nil {
+			return nil, fmt.Errorf("dq: could not decode gob file %v: %v (legacy encoding: %v)", fileName, err, lerr)
+		}
+		dqplot = legacy
+	}
+	return dqplot, nil
+}
+
+// ReadFile reads the dq plots of fileName, written with WriteFile: in the legacy
+// gob format if fileName has the .gob extension, in ROOT format otherwise.
+// Gob files can be converted to the ROOT format with the dqmigrate command.
+func ReadFile(fileName string) (*DQPlot, error) {
+	if filepath.Ext(fileName) == ".gob" {
+		return readGob(fileName)
+	}
+	return NewDQPlotFromROOT(fileName)
+}
+
+// WriteFile writes d to fileName: in the legacy gob format if fileName has the
+// .gob extension (see WriteGob), in ROOT format otherwise (see WriteROOT).
+func (d *DQPlot) WriteFile(fileName string) error {
+	if filepath.Ext(fileName) == ".gob" {
+		return d.WriteGob(fileName)
+	}
+	return d.WriteROOT(fileName)
+}
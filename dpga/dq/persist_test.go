@@ -0,0 +1,166 @@
+package dq
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// refGob is the legacy gob reference file shipped with the repository, and
+// refROOT the same reference converted to the ROOT format with dqmigrate.
+const (
+	refGob  = "../dqref/dq-run37020evtsPedReference.gob"
+	refROOT = "../dqref/dq-run37020evtsPedReference.root"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dq-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v\n", err)
+	}
+	return dir
+}
+
+// checkSame checks that the dq plots got, read back from a file, have the content of want.
+func checkSame(t *testing.T, got, want *DQPlot) {
+	if got.RunNumber != want.RunNumber || got.Nevents != want.Nevents {
+		t.Errorf("run = %v, Nevents = %v, want %v, %v", got.RunNumber, got.Nevents, want.RunNumber, want.Nevents)
+	}
+	if !reflect.DeepEqual(got.IntegrityErrors, want.IntegrityErrors) {
+		t.Errorf("integrity errors = %v, want %v", got.IntegrityErrors, want.IntegrityErrors)
+	}
+	if got.TimeWindow != want.TimeWindow || got.TimeOrigin != want.TimeOrigin || got.LateEvents != want.LateEvents {
+		t.Errorf("time window = %v, origin = %v, late events = %v, want %v, %v, %v",
+			got.TimeWindow, got.TimeOrigin, got.LateEvents, want.TimeWindow, want.TimeOrigin, want.LateEvents)
+	}
+	hgot, hwant := got.h1ds(), want.h1ds()
+	if len(got.TimeSlices) != len(want.TimeSlices) {
+		t.Fatalf("%v time slices, want %v", len(got.TimeSlices), len(want.TimeSlices))
+	}
+	for i := range got.TimeSlices {
+		gts, wts := &got.TimeSlices[i], &want.TimeSlices[i]
+		if gts.Start != wts.Start || gts.Nevents != wts.Nevents {
+			t.Errorf("time slice %v: start = %v, Nevents = %v, want %v, %v", i, gts.Start, gts.Nevents, wts.Start, wts.Nevents)
+		}
+		hgot = append(hgot, gts.h1ds(i)...)
+		hwant = append(hwant, wts.h1ds(i)...)
+	}
+	for i := range hgot {
+		g, w := hgot[i].h, hwant[i].h
+		if g.Len() != w.Len() || g.XMin() != w.XMin() || g.XMax() != w.XMax() {
+			t.Errorf("%v: binning differs", hwant[i].name)
+			continue
+		}
+		if g.Entries() != w.Entries() || g.SumW() != w.SumW() {
+			t.Errorf("%v: entries = %v, sum = %v, want %v, %v", hwant[i].name, g.Entries(), g.SumW(), w.Entries(), w.SumW())
+			continue
+		}
+		for j := 0; j < g.Len(); j++ {
+			if g.Value(j) != w.Value(j) {
+				t.Errorf("%v: bin %v = %v, want %v", hwant[i].name, j, g.Value(j), w.Value(j))
+				break
+			}
+		}
+	}
+	h2got, h2want := got.h2ds(), want.h2ds()
+	for i := range h2got {
+		g, w := h2got[i].h, h2want[i].h
+		if g.Entries() != w.Entries() || g.SumW() != w.SumW() {
+			t.Errorf("%v: entries = %v, sum = %v, want %v, %v", h2want[i].name, g.Entries(), g.SumW(), w.Entries(), w.SumW())
+		}
+	}
+	for i := range want.HV {
+		for j := range want.HV[i] {
+			if len(got.HV[i][j]) != len(want.HV[i][j]) || (len(want.HV[i][j]) > 0 && !reflect.DeepEqual(got.HV[i][j], want.HV[i][j])) {
+				t.Errorf("HV[%v][%v] = %v, want %v", i, j, got.HV[i][j], want.HV[i][j])
+			}
+		}
+	}
+}
+
+func TestROOTRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "dq.root")
+
+	d := testDQPlot(100, 10, 5, 505)
+	d.TimeOrigin = 123456
+	d.TimeSlices = append(d.TimeSlices, NewTimeSlice(1))
+	d.TimeSlices[1].Nevents = 3
+	d.TimeSlices[1].HFrequency.Fill(5, 3)
+	d.TimeSlices[1].HMinRecZ.Fill(-12, 2)
+	d.AddHVPoint(2, 15, 1, 1200)
+	d.AddHVPoint(2, 15, 2, 1201)
+	d.HNoise.Fill(7, 2.5)
+	d.EnergyCorrelation.Fill(511, 480, 1)
+
+	if err := d.WriteROOT(fileName); err != nil {
+		t.Fatalf("could not write dq plots: %v\n", err)
+	}
+	got, err := ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("could not read dq plots: %v\n", err)
+	}
+	checkSame(t, got, d)
+}
+
+func TestMigrateInvalidVersion(t *testing.T) {
+	if err := migrate(NewDQPlot(), 0); err == nil {
+		t.Errorf("migrated from version 0 without error")
+	}
+}
+
+// TestMigrations checks that there is a migration to each version of the format.
+func TestMigrations(t *testing.T) {
+	if len(migrations) != FormatVersion-1 {
+		t.Fatalf("%v migrations for format version %v, want %v", len(migrations), FormatVersion, FormatVersion-1)
+	}
+	for v := 1; v <= FormatVersion; v++ {
+		if err := migrate(NewDQPlot(), v); err != nil {
+			t.Errorf("could not migrate from version %v: %v", v, err)
+		}
+	}
+}
+
+// TestMigrateGob checks that the reference file shipped in the legacy gob
+// format can still be read and converted to the ROOT format, and that the
+// shipped ROOT reference is up to date.
+func TestMigrateGob(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "ref.root")
+
+	ref, err := ReadFile(refGob)
+	if err != nil {
+		t.Fatalf("could not read reference: %v\n", err)
+	}
+	// the reference holds the frequencies (scaled by the number of events) of
+	// 84672 pulses, 247 of which in channel 0, and 47 events in the overflow
+	// of the multiplicity
+	if ref.Nevents != 37020 || ref.HFrequency.Entries() != 84672 {
+		t.Fatalf("Nevents = %v, %v pulses, want 37020, 84672", ref.Nevents, ref.HFrequency.Entries())
+	}
+	if n := ref.HFrequency.Value(0) * float64(ref.Nevents); math.Abs(n-247) > 1e-9 {
+		t.Errorf("%v pulses in channel 0, want 247", n)
+	}
+	if n := ref.HMultiplicity.Binning.Overflow().Entries(); n != 47 {
+		t.Errorf("%v events in the overflow of the multiplicity, want 47", n)
+	}
+	if err := ref.WriteFile(fileName); err != nil {
+		t.Fatalf("could not write reference: %v\n", err)
+	}
+	got, err := ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("could not read converted reference: %v\n", err)
+	}
+	checkSame(t, got, ref)
+
+	shipped, err := ReadFile(refROOT)
+	if err != nil {
+		t.Fatalf("could not read ROOT reference: %v\n", err)
+	}
+	checkSame(t, shipped, ref)
+}
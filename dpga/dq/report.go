@@ -0,0 +1,243 @@
+package dq
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/utils"
+	"gonum.org/v1/plot/vg"
+)
+
+// RunInfo holds the information on the run and on its processing displayed
+// at the top of the data quality report (see WriteReport).
+type RunInfo struct {
+	Header        *rw.Header // run header (may be nil)
+	Input         string     // name of the binary data file
+	Calib         string     // calib used (e.g. A1 for period A, version 1), empty if none
+	Pedestal      bool       // pedestal correction applied
+	TimeDepOffset bool       // time dependent offset correction applied
+	EnergyCalib   bool       // energy calibration applied
+	Comment       string
+}
+
+// ReportFileName returns the name of the report corresponding to the binary
+// data file dataFileName (e.g. run100DQ.html for run100.bin).
+func ReportFileName(dataFileName string) string {
+	return strings.TrimSuffix(dataFileName, ".bin") + "DQ.html"
+}
+
+// reportPlot is a plot of the report, rendered as an inline SVG image.
+type reportPlot struct {
+	Title string
+	SVG   template.HTML
+}
+
+type reportField struct {
+	Name, Value string
+}
+
+type reportCount struct {
+	Name  string
+	Count uint
+}
+
+// WriteReport writes a self-contained HTML page with all the data quality plots
+// of d, the run information, the comparisons with the reference plots (if
+// d.DQPlotRef is not nil, using DefaultThresholds) and the integrity error counts.
+func (d *DQPlot) WriteReport(fileName string, info RunInfo) error {
+	data := struct {
+		Run        string
+		Generated  string
+		Header     []reportField
+		Processing []reportField
+		Nevents    uint
+		HasRef     bool
+		Quality    string
+		Comps      []Comparison
+		Integrity  []reportCount
+		Plots      []reportPlot
+	}{
+		Run:       "unknown",
+		Generated: time.Now().Format(time.UnixDate),
+		Nevents:   d.Nevents,
+		HasRef:    d.DQPlotRef != nil,
+	}
+
+	if hdr := info.Header; hdr != nil {
+		data.Run = strconv.FormatUint(uint64(hdr.RunNumber), 10)
+		data.Header = []reportField{
+			{"Start time", time.Unix(int64(hdr.TimeStart), 0).Format(time.UnixDate)},
+			{"Stop time", time.Unix(int64(hdr.TimeStop), 0).Format(time.UnixDate)},
+			{"Number of events", strconv.FormatUint(uint64(hdr.NoEvents), 10)},
+			{"Number of ASM boards", strconv.FormatUint(uint64(hdr.NoASMCards), 10)},
+			{"Number of samples", strconv.FormatUint(uint64(hdr.NoSamples), 10)},
+			{"Data read", "0x" + strconv.FormatUint(uint64(hdr.DataToRead), 16)},
+			{"Trigger equation", "0x" + strconv.FormatUint(uint64(hdr.TriggerEq), 16)},
+			{"Trigger delay", "0x" + strconv.FormatUint(uint64(hdr.TriggerDelay), 16)},
+			{"Channels used for trigger", "0x" + strconv.FormatUint(uint64(hdr.ChanUsedForTrig), 16)},
+			{"Threshold", strconv.FormatUint(uint64(hdr.Threshold), 10)},
+			{"Low and high thresholds", "0x" + strconv.FormatUint(uint64(hdr.LowHighThres), 16)},
+			{"Trigger signal shaping (high threshold)", "0x" + strconv.FormatUint(uint64(hdr.TrigSigShapingHighThres), 16)},
+			{"Trigger signal shaping (low threshold)", "0x" + strconv.FormatUint(uint64(hdr.TrigSigShapingLowThres), 16)},
+			{"History", strconv.FormatUint(uint64(hdr.History), 10)},
+		}
+	}
+	calib := info.Calib
+	if calib == "" {
+		calib = "none"
+	}
+	data.Processing = []reportField{
+		{"Input file", info.Input},
+		{"Calib", calib},
+		{"Pedestal correction", strconv.FormatBool(info.Pedestal)},
+		{"Time dependent offset correction", strconv.FormatBool(info.TimeDepOffset)},
+		{"Energy calibration", strconv.FormatBool(info.EnergyCalib)},
+		{"Comment", info.Comment},
+	}
+	if d.TimeWindow > 0 {
+		data.Processing = append(data.Processing, reportField{"Time slices",
+			fmt.Sprintf("%v slices of %v s, %v events beyond the last slice", len(d.TimeSlices), d.TimeWindow, d.LateEvents)})
+	}
+
+	if data.HasRef {
+		data.Comps = d.Compare(DefaultThresholds)
+		data.Quality = RunQuality(data.Comps).String()
+	}
+
+	for msg, n := range d.IntegrityErrors {
+		data.Integrity = append(data.Integrity, reportCount{msg, n})
+	}
+	sort.Slice(data.Integrity, func(i, j int) bool { return data.Integrity[i].Name < data.Integrity[j].Name })
+
+	add := func(title string, p utils.Drawer, w, h vg.Length) {
+		data.Plots = append(data.Plots, reportPlot{title, template.HTML(utils.RenderSVG(p, w, h))})
+	}
+	add("Frequency", d.MakeFreqTiledPlot(), 50, 10)
+	add("Multiplicity", d.MakeMultiplicityPlot(), 10, 7.5)
+	add("LOR multiplicity", d.MakeLORMultPlot(), 10, 7.5)
+	for _, v := range []struct {
+		name  string
+		which WhichVar
+	}{{"Charge", Charge}, {"Amplitude", Amplitude}, {"Energy", Energy}} {
+		add(v.name+" (left hemisphere)", d.MakeChargeAmplTiledPlot(v.which, dpgadetector.Left), 45, 30)
+		add(v.name+" (right hemisphere)", d.MakeChargeAmplTiledPlot(v.which, dpgadetector.Right), 45, 30)
+	}
+	add("Energy (all channels)", d.MakeEnergyPlot(), 10, 7.5)
+	add("Minimal reconstruction X and Y", d.MakeMinRecXYDistrs(), 13, 9)
+	add("Minimal reconstruction Z", d.MakeMinRecZDistr(), 25, 6)
+	add("Delta T30", d.MakeDeltaT30Plot(), 12, 7.5)
+	if d.AmplCorrelation.Entries() > 0 {
+		add("Amplitude correlation (multiplicity 2)", d.MakeAmplCorrelationPlot(), 9, 9)
+	}
+	if d.EnergyCorrelation.Entries() > 0 {
+		add("Energy correlation (multiplicity 2)", d.MakeEnergyCorrelationPlot(), 9, 9)
+	}
+	if d.HitQuartets.Entries() > 0 {
+		add("Hit quartets (multiplicity 2)", d.MakeHitQuartetsPlot(), 9, 9)
+	}
+	if d.HEnergyVsDeltaTggRF.Entries() > 0 {
+		add("RF plot", d.MakeRFPlotALaArnaud(), 9, 9)
+	}
+	if len(d.TimeSlices) > 0 {
+		add("Time slices", d.MakeStripCharts(), 25, 20)
+		add("Time slices (per channel rate and minimal reconstruction Z)", d.MakeTimeSliceMaps(), 30, 12)
+	}
+	if d.hasHV() {
+		add("HV", d.MakeHVTiledPlot(), 45, 30)
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := reportTemplate.Execute(f, data); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// hasHV returns whether HV values were recorded (see AddHVPoint).
+func (d *DQPlot) hasHV() bool {
+	for i := range d.HV {
+		for j := range d.HV[i] {
+			if len(d.HV[i][j]) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>DPGA data quality - run {{.Run}}</title>
+	<style>
+		body { font-family: sans-serif; }
+		table { border-collapse: collapse; margin-bottom: 1em; }
+		td, th { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+		.pass { color: green; }
+		.warn { color: orange; }
+		.fail { color: red; }
+	</style>
+</head>
+<body>
+	<h1>DPGA data quality - run {{.Run}}</h1>
+	<p>Report generated on {{.Generated}} from {{.Nevents}} events.</p>
+
+	<h2>Run header</h2>
+	{{if .Header}}
+	<table>
+	{{range .Header}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+	{{end}}
+	</table>
+	{{else}}
+	<p>No run header available.</p>
+	{{end}}
+
+	<h2>Processing</h2>
+	<table>
+	{{range .Processing}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+	{{end}}
+	</table>
+
+	<h2>Comparison with reference</h2>
+	{{if .HasRef}}
+	<p>Run quality: <b class="{{.Quality}}">{{.Quality}}</b> ({{len .Comps}} histograms compared)</p>
+	<table>
+	<tr><th>Histogram</th><th>Status</th><th>KS prob.</th><th>Chi2 / ndf</th><th>Chi2 prob.</th><th>Max pull (bin)</th></tr>
+	{{range .Comps}}<tr><td>{{.Name}}</td><td class="{{.Status}}">{{.Status}}</td>{{if .HV}}<td colspan="4">mean HV difference: {{printf "%.1f" .HVDiff}} V</td>{{else}}<td>{{printf "%.3g" .KSProb}}</td><td>{{printf "%.1f" .Chi2}} / {{.NDF}}</td><td>{{printf "%.3g" .Chi2Prob}}</td><td>{{printf "%.1f" .MaxPull}} ({{.MaxBin}})</td>{{end}}</tr>
+	{{end}}
+	</table>
+	{{else}}
+	<p>No reference plots.</p>
+	{{end}}
+
+	<h2>Integrity errors</h2>
+	{{if .Integrity}}
+	<table>
+	<tr><th>Error</th><th>Number of events</th></tr>
+	{{range .Integrity}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+	{{end}}
+	</table>
+	{{else}}
+	<p>None.</p>
+	{{end}}
+
+	<h2>Plots</h2>
+	{{range .Plots}}
+	<h3>{{.Title}}</h3>
+	<div>{{.SVG}}</div>
+	{{end}}
+</body>
+</html>
+`))
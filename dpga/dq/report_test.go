@@ -0,0 +1,93 @@
+package dq
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+)
+
+func TestWriteReport(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// the reference differs from d by the energy of the pulses
+	d := testDQPlot(100, 200, 5, 511)
+	d.DQPlotRef = testDQPlot(99, 200, 5, 480)
+
+	tests := []struct {
+		name   string
+		d      *DQPlot
+		info   RunInfo
+		want   []string // strings the report must contain
+		absent []string // strings the report must not contain
+	}{
+		{
+			name: "full",
+			d:    d,
+			info: RunInfo{
+				Header: &rw.Header{RunNumber: 100, NoEvents: 200, NoSamples: 999, TriggerEq: 0x1f},
+				Input:  "run100.bin",
+				Calib:  "A1",
+			},
+			want: []string{
+				"<title>DPGA data quality - run 100</title>",
+				"from 200 events",
+				"<tr><th>Number of events</th><td>200</td></tr>",
+				"<tr><th>Number of samples</th><td>999</td></tr>",
+				"<tr><th>Trigger equation</th><td>0x1f</td></tr>",
+				"<tr><th>Input file</th><td>run100.bin</td></tr>",
+				"<tr><th>Calib</th><td>A1</td></tr>",
+				`Run quality: <b class="fail">fail</b>`,
+				`<tr><td>HFrequency</td><td class="pass">pass</td>`,
+				`<tr><td>HEnergyAll</td><td class="fail">fail</td>`,
+				`<tr><td>HV[0][5]</td><td class="pass">pass</td><td colspan="4">mean HV difference: 0.0 V</td></tr>`,
+				"<tr><td>missing frame</td><td>1</td></tr>",
+				"<h3>Energy (all channels)</h3>",
+				"<h3>Time slices</h3>",
+				"<h3>HV</h3>",
+				"<svg",
+			},
+		},
+		{
+			name: "empty",
+			d:    NewDQPlot(),
+			want: []string{
+				"<title>DPGA data quality - run unknown</title>",
+				"No run header available.",
+				"<tr><th>Calib</th><td>none</td></tr>",
+				"No reference plots.",
+				"<p>None.</p>", // no integrity errors
+			},
+			absent: []string{"<h3>Time slices</h3>", "<h3>HV</h3>", "<h3>RF plot</h3>"},
+		},
+	}
+	for _, test := range tests {
+		fileName := filepath.Join(dir, test.name+".html")
+		if err := test.d.WriteReport(fileName, test.info); err != nil {
+			t.Fatalf("%v: error writing report: %v\n", test.name, err)
+		}
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatalf("%v: could not read report: %v\n", test.name, err)
+		}
+		report := string(data)
+		for _, s := range test.want {
+			if !strings.Contains(report, s) {
+				t.Errorf("%v: report does not contain %q", test.name, s)
+			}
+		}
+		for _, s := range test.absent {
+			if strings.Contains(report, s) {
+				t.Errorf("%v: report contains %q", test.name, s)
+			}
+		}
+	}
+
+	if err := d.WriteReport(filepath.Join(dir, "missing", "report.html"), RunInfo{}); err == nil {
+		t.Errorf("report written in a missing directory without error")
+	}
+}
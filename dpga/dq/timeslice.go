@@ -0,0 +1,169 @@
+package dq
+
+import (
+	"image/color"
+	"math"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// clockFreq is the frequency (Hz) of the clock giving the event timestamps.
+const clockFreq = 64e6
+
+// MaxTimeSlices is the maximal number of time slices of DQPlot, which bounds
+// the memory they use. Events beyond the last slice are not filled in the time
+// slices, but counted in DQPlot.LateEvents.
+const MaxTimeSlices = 1000
+
+// TimeSlice holds the dq histograms filled with the events of a time window
+// of the run (see DQPlot.TimeWindow), to locate beam spills, detector trips
+// or gain jumps in time.
+type TimeSlice struct {
+	Start         float64    // start (s) of the window, since the first event of the run
+	Nevents       uint       // number of events in the window
+	HFrequency    *hbook.H1D // number of pulses with signal per channel
+	HMultiplicity *hbook.H1D
+	HEnergyAll    *hbook.H1D
+	HMinRecZ      *hbook.H1D
+}
+
+// NewTimeSlice returns a time slice starting at start (s), with the same
+// binning as the corresponding histograms of DQPlot.
+func NewTimeSlice(start float64) TimeSlice {
+	return TimeSlice{
+		Start:         start,
+		HFrequency:    hbook.NewH1D(240, 0, 240),
+		HMultiplicity: hbook.NewH1D(8, -0.5, 7.5),
+		HEnergyAll:    hbook.NewH1D(200, 0, 1022),
+		HMinRecZ:      hbook.NewH1D(61, -97.5-3.25/2., 97.5+3.25/2.),
+	}
+}
+
+// timeSlice returns the time slice of e, creating it (and the empty slices
+// before it) if needed, or nil if time slices are not filled or if e is
+// beyond the last slice (see MaxTimeSlices).
+// Times are counted from the timestamp of the first event filled.
+func (d *DQPlot) timeSlice(e *event.Event) *TimeSlice {
+	if d.TimeWindow <= 0 {
+		return nil
+	}
+	if len(d.TimeSlices) == 0 {
+		d.TimeOrigin = e.TimeStamp
+	}
+	i := 0
+	if e.TimeStamp > d.TimeOrigin {
+		i = int(float64(e.TimeStamp-d.TimeOrigin) / clockFreq / d.TimeWindow)
+	}
+	if i >= MaxTimeSlices {
+		d.LateEvents++
+		return nil
+	}
+	for len(d.TimeSlices) <= i {
+		d.TimeSlices = append(d.TimeSlices, NewTimeSlice(float64(len(d.TimeSlices))*d.TimeWindow))
+	}
+	return &d.TimeSlices[i]
+}
+
+// stripChart is a quantity of the time slices displayed versus time.
+type stripChart struct {
+	label string
+	val   func(ts *TimeSlice, window float64) float64 // NaN if not available
+}
+
+var stripCharts = []stripChart{
+	{"Event rate (Hz)", func(ts *TimeSlice, window float64) float64 { return float64(ts.Nevents) / window }},
+	{"Mean multiplicity", func(ts *TimeSlice, window float64) float64 { return mean(ts.HMultiplicity) }},
+	{"511 keV peak (keV)", func(ts *TimeSlice, window float64) float64 {
+		return PeakPosition(ts.HEnergyAll, Peak511Window[0], Peak511Window[1])
+	}},
+	{"Mean MAR Z (mm)", func(ts *TimeSlice, window float64) float64 { return mean(ts.HMinRecZ) }},
+}
+
+// MakeStripCharts makes the plots of the event rate, the mean multiplicity, the
+// position of the 511 keV peak and the mean Z of the minimal reconstruction
+// points versus time, one point per time slice. Note that the last slice is
+// usually not complete, so that its event rate is underestimated.
+func (d *DQPlot) MakeStripCharts() *hplot.TiledPlot {
+	tp := hplot.NewTiledPlot(draw.Tiles{Cols: 1, Rows: len(stripCharts), PadY: 0.5 * vg.Centimeter})
+	for irow, sc := range stripCharts {
+		p := tp.Plot(irow, 0)
+		p.X.Label.Text = "time (s)"
+		p.Y.Label.Text = sc.label
+		p.Add(hplot.NewGrid())
+		p.BackgroundColor = color.RGBA{R: 230, G: 247, B: 255, A: 255}
+		var xys plotter.XYs
+		for i := range d.TimeSlices {
+			ts := &d.TimeSlices[i]
+			if y := sc.val(ts, d.TimeWindow); !math.IsNaN(y) {
+				xys = append(xys, struct{ X, Y float64 }{X: ts.Start + d.TimeWindow/2, Y: y})
+			}
+		}
+		if len(xys) == 0 {
+			continue
+		}
+		l, s, err := plotter.NewLinePoints(xys)
+		if err != nil {
+			panic(err)
+		}
+		l.Color = plotutil.Color(irow)
+		s.Color = plotutil.Color(irow)
+		s.Shape = draw.CircleGlyph{}
+		s.GlyphStyle.Radius = 0.05 * vg.Centimeter
+		p.Add(l, s)
+	}
+	return tp
+}
+
+// MakeTimeSliceMaps makes 2D plots of the rate (Hz) per channel and of the
+// distribution of the Z coordinate of the minimal reconstruction points versus time.
+func (d *DQPlot) MakeTimeSliceMaps() *hplot.TiledPlot {
+	tp := hplot.NewTiledPlot(draw.Tiles{Cols: 2, Rows: 1, PadX: 1 * vg.Centimeter})
+	if len(d.TimeSlices) == 0 {
+		return tp
+	}
+	tmax := float64(len(d.TimeSlices)) * d.TimeWindow
+
+	rates := hbook.NewH2D(len(d.TimeSlices), 0, tmax, 240, 0, 240)
+	hz := d.TimeSlices[0].HMinRecZ
+	zs := hbook.NewH2D(len(d.TimeSlices), 0, tmax, hz.Len(), hz.XMin(), hz.XMax())
+	for i := range d.TimeSlices {
+		ts := &d.TimeSlices[i]
+		t := ts.Start + d.TimeWindow/2
+		for ich := 0; ich < ts.HFrequency.Len(); ich++ {
+			x, n := ts.HFrequency.XY(ich)
+			if n > 0 {
+				rates.Fill(t, x, n/d.TimeWindow)
+			}
+		}
+		for iz := 0; iz < ts.HMinRecZ.Len(); iz++ {
+			z, n := ts.HMinRecZ.XY(iz)
+			if n > 0 {
+				zs.Fill(t, z, n)
+			}
+		}
+	}
+
+	p1 := tp.Plot(0, 0)
+	p1.Title.Text = "Rate (Hz) per channel"
+	p1.X.Label.Text = "time (s)"
+	p1.Y.Label.Text = "channel"
+	p1.Y.Tick.Marker = &hplot.FreqTicks{N: 241, Freq: 20}
+	if rates.Entries() > 0 {
+		p1.Add(hplot.NewH2D(rates, nil))
+	}
+
+	p2 := tp.Plot(1, 0)
+	p2.Title.Text = "Minimal reconstruction Z"
+	p2.X.Label.Text = "time (s)"
+	p2.Y.Label.Text = "Z (mm)"
+	if zs.Entries() > 0 {
+		p2.Add(hplot.NewH2D(zs, nil))
+	}
+	return tp
+}
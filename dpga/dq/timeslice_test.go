@@ -0,0 +1,96 @@
+package dq
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+)
+
+func TestTimeSlice(t *testing.T) {
+	const origin = 1000
+	tests := []struct {
+		name   string
+		window float64
+		ts     uint64 // timestamp of the event, after the first one at origin
+		want   int    // index of the time slice, -1 if none
+	}{
+		{"no time slices", 0, origin + 10, -1},
+		{"first slice", 1, origin + clockFreq/2, 0},
+		{"before the first event", 1, origin - 10, 0},
+		{"third slice", 1, origin + 2.5*clockFreq, 2},
+		{"slice boundary", 0.5, origin + clockFreq, 2},
+		{"last slice", 1, origin + (MaxTimeSlices-0.5)*clockFreq, MaxTimeSlices - 1},
+		{"beyond the last slice", 1, origin + MaxTimeSlices*clockFreq, -1},
+	}
+	for _, test := range tests {
+		d := NewDQPlot()
+		d.TimeWindow = test.window
+		d.timeSlice(&event.Event{TimeStamp: origin})
+		ts := d.timeSlice(&event.Event{TimeStamp: test.ts})
+		switch {
+		case test.want < 0:
+			if ts != nil {
+				t.Errorf("%v: time slice starting at %v, want none", test.name, ts.Start)
+			}
+			if test.window > 0 && d.LateEvents != 1 {
+				t.Errorf("%v: %v late events, want 1", test.name, d.LateEvents)
+			}
+		case ts != &d.TimeSlices[test.want]:
+			t.Errorf("%v: wrong time slice (%v slices)", test.name, len(d.TimeSlices))
+		case len(d.TimeSlices) != test.want+1:
+			t.Errorf("%v: %v time slices, want %v", test.name, len(d.TimeSlices), test.want+1)
+		case ts.Start != float64(test.want)*test.window:
+			t.Errorf("%v: start = %v, want %v", test.name, ts.Start, float64(test.want)*test.window)
+		case d.LateEvents != 0:
+			t.Errorf("%v: %v late events, want 0", test.name, d.LateEvents)
+		}
+		if test.window > 0 && d.TimeOrigin != origin {
+			t.Errorf("%v: origin = %v, want %v", test.name, d.TimeOrigin, origin)
+		}
+	}
+}
+
+func TestTimeSlicesROOT(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "dq.root")
+
+	d := NewDQPlot()
+	d.TimeWindow = 2
+	for i, ts := range []uint64{500, 500 + clockFreq, 500 + 5*clockFreq, 500 + 2*MaxTimeSlices*clockFreq} {
+		if s := d.timeSlice(&event.Event{TimeStamp: ts}); s != nil {
+			s.Nevents++
+			s.HFrequency.Fill(float64(i), 1)
+		}
+	}
+	if err := d.WriteROOT(fileName); err != nil {
+		t.Fatalf("could not write dq plots: %v\n", err)
+	}
+	got, err := NewDQPlotFromROOT(fileName)
+	if err != nil {
+		t.Fatalf("could not read dq plots: %v\n", err)
+	}
+	if len(got.TimeSlices) != 3 || got.LateEvents != 1 {
+		t.Fatalf("%v time slices and %v late events, want 3 and 1", len(got.TimeSlices), got.LateEvents)
+	}
+	checkSame(t, got, d)
+}
+
+func TestTimeSliceMaps(t *testing.T) {
+	d := NewDQPlot()
+	d.TimeWindow = 1
+	for i, ts := range []uint64{500, 500 + clockFreq, 500 + 2*clockFreq} {
+		s := d.timeSlice(&event.Event{TimeStamp: ts})
+		s.HFrequency.Fill(float64(i), 1)
+		s.HMinRecZ.Fill(10, 1)
+	}
+	tp := d.MakeTimeSliceMaps()
+	for i, title := range []string{"Rate (Hz) per channel", "Minimal reconstruction Z"} {
+		p := tp.Plot(i, 0)
+		if p.Title.Text != title {
+			t.Errorf("plot %v: title = %q, want %q", i, p.Title.Text, title)
+		}
+	}
+}
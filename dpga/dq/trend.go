@@ -0,0 +1,223 @@
+package dq
+
+import (
+	"image/color"
+	"math"
+	"sort"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// TrendPoint holds the key quantities of the dq plots of a run, followed over
+// several runs to spot gain drifts and failing channels (see NewTrendPoint).
+// Quantities which cannot be computed (e.g. no entries) are NaN.
+type TrendPoint struct {
+	Run         uint32
+	Nevents     uint
+	Rate        []float64 // number of pulses with signal per event, per channel
+	Peak511     []float64 // position (keV) of the 511 keV peak, per channel
+	Noise       []float64 // pedestal noise (ADC counts), per channel (see PedestalNoise)
+	Peak511All  float64   // position (keV) of the 511 keV peak, all channels
+	LORMultMean float64   // mean LOR multiplicity
+	MinRecZMean float64   // mean Z (mm) of the minimal reconstruction points
+}
+
+// Peak511Window is the energy window (keV) in which the 511 keV peak is searched for.
+var Peak511Window = [2]float64{350, 700}
+
+// NewTrendPoint returns the trend quantities of the dq plots d of the run run.
+func NewTrendPoint(run uint32, d *DQPlot) TrendPoint {
+	p := TrendPoint{
+		Run:         run,
+		Nevents:     d.Nevents,
+		Noise:       d.PedestalNoise(),
+		Peak511All:  PeakPosition(d.HEnergyAll, Peak511Window[0], Peak511Window[1]),
+		LORMultMean: mean(d.HLORMult),
+		MinRecZMean: mean(d.HMinRecZ),
+	}
+	for i := 0; i < d.HFrequency.Len(); i++ {
+		rate := math.NaN()
+		if d.Nevents > 0 {
+			rate = d.HFrequency.Value(i) / float64(d.Nevents)
+		}
+		p.Rate = append(p.Rate, rate)
+	}
+	for i := range d.HEnergy {
+		for j := range d.HEnergy[i] {
+			p.Peak511 = append(p.Peak511, PeakPosition(&d.HEnergy[i][j], Peak511Window[0], Peak511Window[1]))
+		}
+	}
+	return p
+}
+
+// PedestalNoise returns the mean RMS of the samples of the pulses without signal, per channel.
+func (d *DQPlot) PedestalNoise() []float64 {
+	noise := make([]float64, d.HNoise.Len())
+	for i := range noise {
+		noise[i] = math.NaN()
+		if n := d.HNoSignal.Value(i); n > 0 {
+			noise[i] = d.HNoise.Value(i) / n
+		}
+	}
+	return noise
+}
+
+// PeakPosition returns the position of the peak of h in [xmin, xmax]: the mean
+// of the bins around the highest bin in the window, within 10% of the window width.
+// Bins are taken at their centers. It returns NaN if there is no entry in the window.
+func PeakPosition(h *hbook.H1D, xmin, xmax float64) float64 {
+	imax := -1
+	for i := 0; i < h.Len(); i++ {
+		x, y := binXY(h, i)
+		if x < xmin || x > xmax {
+			continue
+		}
+		if y > 0 && (imax < 0 || y > h.Value(imax)) {
+			imax = i
+		}
+	}
+	if imax < 0 {
+		return math.NaN()
+	}
+	xpeak, _ := binXY(h, imax)
+	halfWidth := 0.1 * (xmax - xmin)
+	var sumw, sumwx float64
+	for i := 0; i < h.Len(); i++ {
+		x, y := binXY(h, i)
+		if math.Abs(x-xpeak) <= halfWidth {
+			sumw += y
+			sumwx += y * x
+		}
+	}
+	return sumwx / sumw
+}
+
+// binXY returns the center and the content of the bin i of h
+// (H1D.XY returns the lower edge of the bin).
+func binXY(h *hbook.H1D, i int) (float64, float64) {
+	return h.Binning.Bins[i].XMid(), h.Value(i)
+}
+
+// mean returns the mean of h, or NaN if h is empty.
+func mean(h *hbook.H1D) float64 {
+	if h.SumW() == 0 {
+		return math.NaN()
+	}
+	return h.XMean()
+}
+
+// LowRateChannels returns the channels whose rate is below frac times the
+// median rate of the channels (e.g. dead or failing channels).
+func (p *TrendPoint) LowRateChannels(frac float64) []int {
+	var rates []float64
+	for _, r := range p.Rate {
+		if !math.IsNaN(r) {
+			rates = append(rates, r)
+		}
+	}
+	if len(rates) == 0 {
+		return nil
+	}
+	sort.Float64s(rates)
+	median := rates[len(rates)/2]
+	var chans []int
+	for i, r := range p.Rate {
+		if r < frac*median {
+			chans = append(chans, i)
+		}
+	}
+	return chans
+}
+
+// MakeTrendPlot makes the plots of the quantities of the runs which are not
+// given per channel, versus run number.
+func MakeTrendPlot(points []TrendPoint) *hplot.TiledPlot {
+	tp := hplot.NewTiledPlot(draw.Tiles{Cols: 1, Rows: 4, PadY: 1 * vg.Centimeter})
+	trends := []struct {
+		label string
+		val   func(p *TrendPoint) float64
+	}{
+		{"511 keV peak (keV)", func(p *TrendPoint) float64 { return p.Peak511All }},
+		{"Mean LOR mult.", func(p *TrendPoint) float64 { return p.LORMultMean }},
+		{"Mean MAR Z (mm)", func(p *TrendPoint) float64 { return p.MinRecZMean }},
+		{"Mean ped. noise", func(p *TrendPoint) float64 { return MeanOf(p.Noise) }},
+	}
+	for irow, trend := range trends {
+		p := tp.Plot(irow, 0)
+		p.X.Label.Text = "run"
+		p.Y.Label.Text = trend.label
+		p.Add(hplot.NewGrid())
+		p.BackgroundColor = color.RGBA{R: 230, G: 247, B: 255, A: 255}
+		var xys plotter.XYs
+		for i := range points {
+			if y := trend.val(&points[i]); !math.IsNaN(y) {
+				xys = append(xys, struct{ X, Y float64 }{X: float64(points[i].Run), Y: y})
+			}
+		}
+		if len(xys) == 0 {
+			continue
+		}
+		l, s, err := plotter.NewLinePoints(xys)
+		if err != nil {
+			panic(err)
+		}
+		l.Color = plotutil.Color(irow)
+		s.Color = plotutil.Color(irow)
+		s.Shape = draw.CircleGlyph{}
+		p.Add(l, s)
+	}
+	return tp
+}
+
+// MakeChannelTrendPlot makes a 2D plot of a per-channel quantity (e.g. Rate,
+// Peak511 or Noise, as returned by val) versus run index (runs in the order of
+// points) and channel.
+func MakeChannelTrendPlot(points []TrendPoint, title string, val func(p *TrendPoint) []float64) *plot.Plot {
+	p := plot.New()
+	p.Title.Text = title
+	p.X.Label.Text = "run index"
+	p.Y.Label.Text = "channel"
+	noChannels := 0
+	for i := range points {
+		if n := len(val(&points[i])); n > noChannels {
+			noChannels = n
+		}
+	}
+	if len(points) == 0 || noChannels == 0 {
+		return p
+	}
+	h := hbook.NewH2D(len(points), -0.5, float64(len(points))-0.5, noChannels, -0.5, float64(noChannels)-0.5)
+	for i := range points {
+		for ich, v := range val(&points[i]) {
+			if !math.IsNaN(v) {
+				h.Fill(float64(i), float64(ich), v)
+			}
+		}
+	}
+	p.Add(hplot.NewH2D(h, nil))
+	p.X.Tick.Marker = &hplot.FreqTicks{N: len(points) + 1, Freq: 1}
+	p.Y.Tick.Marker = &hplot.FreqTicks{N: noChannels + 1, Freq: 20}
+	return p
+}
+
+// MeanOf returns the mean of the values of vals which are not NaN.
+func MeanOf(vals []float64) float64 {
+	var sum float64
+	n := 0
+	for _, v := range vals {
+		if !math.IsNaN(v) {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	return sum / float64(n)
+}
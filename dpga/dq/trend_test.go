@@ -0,0 +1,127 @@
+package dq
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"go-hep.org/x/hep/hbook"
+)
+
+func TestPeakPosition(t *testing.T) {
+	tests := []struct {
+		name       string
+		fill       map[float64]float64 // x -> weight
+		xmin, xmax float64
+		want       float64
+	}{
+		{"single bin", map[float64]float64{505: 10}, 350, 700, 505},
+		{"symmetric peak", map[float64]float64{495: 5, 505: 10, 515: 5}, 350, 700, 505},
+		{"asymmetric peak", map[float64]float64{505: 10, 515: 10, 525: 0}, 350, 700, 510},
+		{"highest bin outside window", map[float64]float64{205: 100, 505: 10}, 350, 700, 505},
+		{"bins far from the peak ignored", map[float64]float64{375: 3, 505: 10}, 350, 700, 505},
+		{"no entry in window", map[float64]float64{205: 100}, 350, 700, math.NaN()},
+		{"empty", nil, 350, 700, math.NaN()},
+	}
+	for _, test := range tests {
+		h := hbook.NewH1D(100, 0, 1000)
+		for x, w := range test.fill {
+			h.Fill(x, w)
+		}
+		got := PeakPosition(h, test.xmin, test.xmax)
+		switch {
+		case math.IsNaN(test.want):
+			if !math.IsNaN(got) {
+				t.Errorf("%v: PeakPosition = %v, want NaN", test.name, got)
+			}
+		case math.Abs(got-test.want) > 1e-9:
+			t.Errorf("%v: PeakPosition = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPedestalNoise(t *testing.T) {
+	d := NewDQPlot()
+	// channel 0: two pulses without signal, of RMS 2 and 4
+	d.HNoSignal.Fill(0, 1)
+	d.HNoise.Fill(0, 2)
+	d.HNoSignal.Fill(0, 1)
+	d.HNoise.Fill(0, 4)
+	// channel 3: one pulse without signal
+	d.HNoSignal.Fill(3, 1)
+	d.HNoise.Fill(3, 1.5)
+
+	noise := d.PedestalNoise()
+	if len(noise) != d.HNoise.Len() {
+		t.Fatalf("%v channels, want %v", len(noise), d.HNoise.Len())
+	}
+	for i, n := range noise {
+		switch i {
+		case 0:
+			if n != 3 {
+				t.Errorf("noise of channel 0 = %v, want 3", n)
+			}
+		case 3:
+			if n != 1.5 {
+				t.Errorf("noise of channel 3 = %v, want 1.5", n)
+			}
+		default:
+			if !math.IsNaN(n) {
+				t.Errorf("noise of channel %v without pulse = %v, want NaN", i, n)
+			}
+		}
+	}
+}
+
+func TestLowRateChannels(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name string
+		rate []float64
+		frac float64
+		want []int
+	}{
+		{"all equal", []float64{1, 1, 1, 1}, 0.5, nil},
+		{"dead channel", []float64{1, 0, 1.1, 0.9}, 0.5, []int{1}},
+		{"low channels", []float64{1, 0.4, 1, 0.2, 1}, 0.5, []int{1, 3}},
+		{"at the limit", []float64{1, 0.5, 1}, 0.5, nil},
+		{"NaN ignored", []float64{1, nan, 1, 0.1}, 0.5, []int{3}},
+		{"only NaN", []float64{nan, nan}, 0.5, nil},
+		{"no channel", nil, 0.5, nil},
+	}
+	for _, test := range tests {
+		p := TrendPoint{Rate: test.rate}
+		got := p.LowRateChannels(test.frac)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: LowRateChannels = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNewTrendPoint(t *testing.T) {
+	d := testDQPlot(100, 10, 5, 505)
+	p := NewTrendPoint(100, d)
+	if p.Run != 100 || p.Nevents != 10 {
+		t.Errorf("run = %v, Nevents = %v, want 100, 10", p.Run, p.Nevents)
+	}
+	if p.Rate[5] != 1 || p.Rate[6] != 0 {
+		t.Errorf("rates = %v, %v, want 1, 0", p.Rate[5], p.Rate[6])
+	}
+	// the peak is at the center of the bin of 505 keV
+	for _, peak := range []struct {
+		name string
+		x    float64
+		h    *hbook.H1D
+	}{
+		{"all channels", p.Peak511All, d.HEnergyAll},
+		{"channel 5", p.Peak511[5], &d.HEnergy[1][1]},
+	} {
+		halfWidth := (peak.h.XMax() - peak.h.XMin()) / float64(peak.h.Len()) / 2
+		if math.Abs(peak.x-505) > halfWidth {
+			t.Errorf("511 keV peak of %v = %v, want 505 +- %v", peak.name, peak.x, halfWidth)
+		}
+	}
+	if !math.IsNaN(p.Peak511[6]) {
+		t.Errorf("511 keV peak of channel 6 = %v, want NaN", p.Peak511[6])
+	}
+}
@@ -0,0 +1,250 @@
+// Package export writes the pulse and LOR data of DPGA events to Parquet files,
+// so that they can be analysed with Python tools (pandas, pyarrow, ...).
+//
+// Two files are written: one with a row per pulse with signal and one with a
+// row per LOR. They carry the same quantities as the trees of package dpga/trees
+// (see ROOTData and ROOTDataLOR), except the samples. The Run and Evt columns,
+// together with IPulse and the Idx1/Idx2 columns of the LORs, allow joining the
+// two tables. The calibration used to produce the data is recorded in the
+// key-value metadata of the files (see Provenance).
+//
+// Example (python):
+//
+//	import pandas as pd
+//	pulses = pd.read_parquet("run100Pulses.parquet")
+//	lors = pd.read_parquet("run100LORs.parquet")
+package export
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	parquet "github.com/parquet-go/parquet-go"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
+)
+
+// Ext is the extension of the exported files.
+const Ext = ".parquet"
+
+// PulseRow is a row of the pulse table.
+type PulseRow struct {
+	Run                 uint32  `parquet:"Run"`
+	Evt                 uint32  `parquet:"Evt"`
+	T0                  uint32  `parquet:"T0"`
+	TimeStamp           uint64  `parquet:"TimeStamp"`
+	IPulse              int32   `parquet:"IPulse"` // index of the pulse in the event
+	IChanAbs240         uint16  `parquet:"IChanAbs240"`
+	IQuartetAbs60       uint8   `parquet:"IQuartetAbs60"`
+	ILineAbs12          uint8   `parquet:"ILineAbs12"`
+	IHemi               uint8   `parquet:"IHemi"`
+	E                   float64 `parquet:"E"`
+	Ampl                float64 `parquet:"Ampl"`
+	Sat                 bool    `parquet:"Sat"`
+	Charge              float64 `parquet:"Charge"`
+	T10                 float64 `parquet:"T10"`
+	T20                 float64 `parquet:"T20"`
+	T30                 float64 `parquet:"T30"`
+	T80                 float64 `parquet:"T80"`
+	T90                 float64 `parquet:"T90"`
+	Tf20                float64 `parquet:"Tf20"`
+	NoLocMaxRisingFront uint16  `parquet:"NoLocMaxRisingFront"`
+	X                   float64 `parquet:"X"`
+	Y                   float64 `parquet:"Y"`
+	Z                   float64 `parquet:"Z"`
+	Xc                  float64 `parquet:"Xc"`
+	Yc                  float64 `parquet:"Yc"`
+	Zc                  float64 `parquet:"Zc"`
+	RFTime              float64 `parquet:"RFTime"`
+}
+
+// LORRow is a row of the LOR table.
+type LORRow struct {
+	Run        uint32  `parquet:"Run"`
+	Evt        uint32  `parquet:"Evt"`
+	T0         uint32  `parquet:"T0"`
+	TimeStamp  uint64  `parquet:"TimeStamp"`
+	ILOR       int32   `parquet:"ILOR"` // index of the LOR in the event
+	Idx1       int32   `parquet:"Idx1"` // IPulse of the first pulse
+	Idx2       int32   `parquet:"Idx2"` // IPulse of the second pulse
+	IChan1     uint16  `parquet:"IChanAbs240_1"`
+	IChan2     uint16  `parquet:"IChanAbs240_2"`
+	E1         float64 `parquet:"E1"`
+	E2         float64 `parquet:"E2"`
+	TMean      float64 `parquet:"TMean"`
+	TRF        float64 `parquet:"TRF"`
+	Xmar       float64 `parquet:"Xmar"`
+	Ymar       float64 `parquet:"Ymar"`
+	Zmar       float64 `parquet:"Zmar"`
+	Rmar       float64 `parquet:"Rmar"`
+	NormWeight float64 `parquet:"NormWeight"`
+	AttCorr    float64 `parquet:"AttCorr"`
+	RFClass    uint8   `parquet:"RFClass"`
+	RFFreq     float64 `parquet:"RFFreq"`
+	RFAmpl     float64 `parquet:"RFAmpl"`
+}
+
+// Provenance describes the calibration applied to the exported data.
+type Provenance struct {
+	Calib         string // calib used (e.g. A1 for period A, version 1), empty if none
+	Pedestal      bool   // pedestal correction applied
+	TimeDepOffset bool   // time dependent offset correction applied
+	EnergyCalib   bool   // energy calibration applied
+	Input         string // name of the input file
+}
+
+func (p Provenance) options() []parquet.WriterOption {
+	return []parquet.WriterOption{
+		parquet.KeyValueMetadata("calib", p.Calib),
+		parquet.KeyValueMetadata("pedestal", strconv.FormatBool(p.Pedestal)),
+		parquet.KeyValueMetadata("timeDepOffset", strconv.FormatBool(p.TimeDepOffset)),
+		parquet.KeyValueMetadata("energyCalib", strconv.FormatBool(p.EnergyCalib)),
+		parquet.KeyValueMetadata("input", p.Input),
+	}
+}
+
+// FileNames returns the names of the pulse and LOR files corresponding to the
+// binary data file dataFileName (e.g. run100Pulses.parquet and run100LORs.parquet
+// for run100.bin or its compressed version run100.bin.z).
+func FileNames(dataFileName string) (pulses, lors string) {
+	base := strings.TrimSuffix(strings.TrimSuffix(dataFileName, rawz.Ext), ".bin")
+	return base + "Pulses" + Ext, base + "LORs" + Ext
+}
+
+// table is a Parquet file being written.
+type table struct {
+	f *os.File
+	w *parquet.Writer
+}
+
+func newTable(name string, model interface{}, prov Provenance) (*table, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	opts := append([]parquet.WriterOption{parquet.SchemaOf(model)}, prov.options()...)
+	return &table{f: f, w: parquet.NewWriter(f, opts...)}, nil
+}
+
+func (t *table) close() error {
+	err := t.w.Close()
+	if errf := t.f.Close(); err == nil {
+		err = errf
+	}
+	return err
+}
+
+// Writer writes the pulse and LOR tables.
+type Writer struct {
+	pulses *table
+	lors   *table
+	pulse  PulseRow
+	lor    LORRow
+}
+
+// NewWriter creates the pulse and LOR files and returns a writer to them.
+func NewWriter(pulsesFileName, lorsFileName string, prov Provenance) (*Writer, error) {
+	pulses, err := newTable(pulsesFileName, new(PulseRow), prov)
+	if err != nil {
+		return nil, err
+	}
+	lors, err := newTable(lorsFileName, new(LORRow), prov)
+	if err != nil {
+		pulses.close()
+		return nil, err
+	}
+	return &Writer{pulses: pulses, lors: lors}, nil
+}
+
+// Fill writes the pulses with signal and the LORs of e.
+// Pulse features, RF fit and LORs are not computed here: as for the trees, the event
+// must have been processed by pipeline.Features and pipeline.DefaultLORs, so that
+// the exported data do not depend on the trees written along with them.
+func (w *Writer) Fill(run uint32, hdr *rw.Header, e *event.Event) error {
+	_, pulses, _, _, _, _ := e.Multiplicity()
+	for i, p := range pulses {
+		row := &w.pulse
+		*row = PulseRow{
+			Run:                 run,
+			Evt:                 uint32(e.ID),
+			T0:                  hdr.TimeStart,
+			TimeStamp:           e.TimeStamp,
+			IPulse:              int32(i),
+			IChanAbs240:         p.Channel.AbsID240(),
+			IQuartetAbs60:       dpgadetector.FifoID144ToQuartetAbsIdx60(p.Channel.FifoID144(), true),
+			IHemi:               uint8(p.Hemi()),
+			E:                   p.E,
+			Ampl:                p.Ampl,
+			Sat:                 p.HasSatSignal,
+			Charge:              p.Charg,
+			T10:                 p.Time10,
+			T20:                 p.Time20,
+			T30:                 p.Time30,
+			T80:                 p.Time80,
+			T90:                 p.Time90,
+			Tf20:                p.TimeFall20,
+			NoLocMaxRisingFront: uint16(p.NoLocMaxRisingFront),
+			X:                   p.Channel.X,
+			Y:                   p.Channel.Y,
+			Z:                   p.Channel.Z,
+			Xc:                  p.Channel.CrystCenter.X,
+			Yc:                  p.Channel.CrystCenter.Y,
+			Zc:                  p.Channel.CrystCenter.Z,
+			RFTime:              e.RFTime(p),
+		}
+		row.ILineAbs12 = dpgadetector.QuartetAbsIdx60ToLineAbsIdx12(row.IQuartetAbs60)
+		if err := w.pulses.w.Write(row); err != nil {
+			return fmt.Errorf("export: could not write pulse: %v", err)
+		}
+	}
+
+	rfFreq, rfAmpl := 0., 0.
+	if e.RF != nil {
+		rfFreq, rfAmpl = e.RF.Freq, e.RF.Ampl
+	}
+	for i := range e.LORs {
+		lor := &e.LORs[i]
+		row := &w.lor
+		*row = LORRow{
+			Run:        run,
+			Evt:        uint32(e.ID),
+			T0:         hdr.TimeStart,
+			TimeStamp:  e.TimeStamp,
+			ILOR:       int32(i),
+			Idx1:       int32(lor.Idx1),
+			Idx2:       int32(lor.Idx2),
+			IChan1:     lor.Pulses[0].Channel.AbsID240(),
+			IChan2:     lor.Pulses[1].Channel.AbsID240(),
+			E1:         lor.Pulses[0].E,
+			E2:         lor.Pulses[1].E,
+			TMean:      lor.TMean,
+			TRF:        lor.TRF,
+			Xmar:       lor.Xmar,
+			Ymar:       lor.Ymar,
+			Zmar:       lor.Zmar,
+			Rmar:       lor.Rmar,
+			NormWeight: lor.NormWeight,
+			AttCorr:    lor.AttCorr,
+			RFClass:    uint8(lor.RFClass),
+			RFFreq:     rfFreq,
+			RFAmpl:     rfAmpl,
+		}
+		if err := w.lors.w.Write(row); err != nil {
+			return fmt.Errorf("export: could not write LOR: %v", err)
+		}
+	}
+	return nil
+}
+
+// Close writes the footers of the files and closes them.
+func (w *Writer) Close() error {
+	err := w.pulses.close()
+	if errl := w.lors.close(); err == nil {
+		err = errl
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package export
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	parquet "github.com/parquet-go/parquet-go"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/trees"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+)
+
+func TestFileNames(t *testing.T) {
+	tests := []struct {
+		data, pulses, lors string
+	}{
+		{"run100.bin", "run100Pulses.parquet", "run100LORs.parquet"},
+		{"run100.bin.z", "run100Pulses.parquet", "run100LORs.parquet"},
+		{"data/run100.bin.z", "data/run100Pulses.parquet", "data/run100LORs.parquet"},
+		{"run100", "run100Pulses.parquet", "run100LORs.parquet"},
+	}
+	for _, test := range tests {
+		pulses, lors := FileNames(test.data)
+		if pulses != test.pulses || lors != test.lors {
+			t.Errorf("FileNames(%q) = %q, %q, want %q, %q", test.data, pulses, lors, test.pulses, test.lors)
+		}
+	}
+}
+
+// testChans are the channels (absolute Id, 0 -> 239) having signal in the test events.
+var testChans = []uint16{3, 17, 130, 201}
+
+// testEvent returns an event with a pulse in each of the channels testChans,
+// with their features computed, and two LORs.
+func testEvent(id uint) *event.Event {
+	e := event.NewEvent(int(dpgadetector.Det.NoClusters()), 12)
+	e.ID = id
+	e.TimeStamp = 1000 * uint64(id+1)
+	e.Counters = make([]uint32, rw.NumCounters)
+	e.Counters[2] = uint32(e.TimeStamp)
+	pulses := make([]*pulse.Pulse, len(testChans))
+	for i, iChan := range testChans {
+		cluster := &e.Clusters[iChan/4]
+		cluster.ID = uint8(iChan / 4)
+		p := &cluster.Pulses[iChan%4]
+		*p = *pulse.NewPulse(dpgadetector.Det.ChannelFromIdAbs240(iChan))
+		p.HasSignal = true
+		for j := 0; j < 999; j++ {
+			ampl := 0.
+			if j > 300 && j < 400 {
+				ampl = float64(10*(i+1)*(j-300)) * float64(400-j) / 100
+			}
+			p.Samples = append(p.Samples, *pulse.NewSample(ampl, uint16(j), 0.2*float64(j)))
+		}
+		p.TimeStep = 0.2
+		p.E = 511 + float64(i) + float64(id)
+		p.CalcRisingFront(true)
+		p.CalcFallingFront(false)
+		pulses[i] = p
+	}
+	for i, idx := range [][2]int{{0, 2}, {1, 3}} {
+		e.LORs = append(e.LORs, event.LOR{
+			Pulses:     [2]*pulse.Pulse{pulses[idx[0]], pulses[idx[1]]},
+			Idx1:       idx[0],
+			Idx2:       idx[1],
+			TMean:      (pulses[idx[0]].Time30 + pulses[idx[1]].Time30) / 2,
+			TRF:        -1,
+			Xmar:       1 + float64(i),
+			Ymar:       2 + float64(i),
+			Zmar:       3 + float64(i),
+			Rmar:       4 + float64(i),
+			NormWeight: 1,
+			AttCorr:    1.5,
+		})
+	}
+	return e
+}
+
+// export writes the events to parquet files in dir and returns their rows.
+func export(t *testing.T, dir string, events []*event.Event) ([]PulseRow, []LORRow) {
+	pulsesFileName, lorsFileName := FileNames(filepath.Join(dir, "run100.bin"))
+	prov := Provenance{Calib: "A1", Pedestal: true, EnergyCalib: true, Input: "run100.bin"}
+	w, err := NewWriter(pulsesFileName, lorsFileName, prov)
+	if err != nil {
+		t.Fatalf("could not create writer: %v\n", err)
+	}
+	hdr := &rw.Header{TimeStart: 1500000000}
+	for _, e := range events {
+		if err := w.Fill(100, hdr, e); err != nil {
+			t.Fatalf("could not export event %v: %v\n", e.ID, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close writer: %v\n", err)
+	}
+
+	pulses, err := parquet.ReadFile[PulseRow](pulsesFileName)
+	if err != nil {
+		t.Fatalf("could not read pulses: %v\n", err)
+	}
+	lors, err := parquet.ReadFile[LORRow](lorsFileName)
+	if err != nil {
+		t.Fatalf("could not read LORs: %v\n", err)
+	}
+	return pulses, lors
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "export-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v\n", err)
+	}
+	return dir
+}
+
+func TestWriter(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	events := []*event.Event{testEvent(0), testEvent(1)}
+	pulses, lors := export(t, dir, events)
+	if len(pulses) != len(events)*len(testChans) {
+		t.Fatalf("%v pulses, want %v", len(pulses), len(events)*len(testChans))
+	}
+	if len(lors) != len(events)*2 {
+		t.Fatalf("%v LORs, want %v", len(lors), len(events)*2)
+	}
+	for _, row := range pulses {
+		e := events[row.Evt]
+		p := e.LORs[0].Pulses[0]
+		for i := range e.LORs {
+			for _, lp := range e.LORs[i].Pulses {
+				if lp.Channel.AbsID240() == row.IChanAbs240 {
+					p = lp
+				}
+			}
+		}
+		if row.Run != 100 || row.T0 != 1500000000 || row.TimeStamp != e.TimeStamp || testChans[row.IPulse] != row.IChanAbs240 {
+			t.Errorf("pulse row %+v: wrong event fields", row)
+		}
+		if row.E != p.E || row.T30 != p.Time30 || row.Tf20 != p.TimeFall20 || row.Ampl != p.Ampl || row.Zc != p.Channel.CrystCenter.Z {
+			t.Errorf("pulse row %+v: wrong pulse fields", row)
+		}
+	}
+	for _, row := range lors {
+		lor := &events[row.Evt].LORs[row.ILOR]
+		if int(row.Idx1) != lor.Idx1 || int(row.Idx2) != lor.Idx2 ||
+			row.IChan1 != lor.Pulses[0].Channel.AbsID240() || row.IChan2 != lor.Pulses[1].Channel.AbsID240() ||
+			row.TMean != lor.TMean || row.Rmar != lor.Rmar || row.AttCorr != lor.AttCorr {
+			t.Errorf("LOR row %+v: want %+v", row, lor)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(dir, "run100LORs.parquet"))
+	if err != nil {
+		t.Fatalf("could not open LOR file: %v\n", err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		t.Fatalf("could not stat LOR file: %v\n", err)
+	}
+	pf, err := parquet.OpenFile(f, st.Size())
+	if err != nil {
+		t.Fatalf("could not open LOR parquet file: %v\n", err)
+	}
+	for key, want := range map[string]string{"calib": "A1", "pedestal": "true", "timeDepOffset": "false", "input": "run100.bin"} {
+		if got, _ := pf.Lookup(key); got != want {
+			t.Errorf("metadata %v = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// TestTrees checks that the exported data do not depend on the trees filled
+// with the events before they are exported.
+func TestTrees(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	wantPulses, wantLORs := export(t, dir, []*event.Event{testEvent(0), testEvent(1)})
+
+	hdr := &rw.Header{TimeStart: 1500000000}
+	tree := trees.NewTree(filepath.Join(dir, "tree.root"))
+	treeLOR := trees.NewTreeLOR(filepath.Join(dir, "treeLOR.root"))
+	treeMult2 := trees.NewTreeMult2(filepath.Join(dir, "treeMult2.root"))
+	events := []*event.Event{testEvent(0), testEvent(1)}
+	for _, e := range events {
+		tree.Fill(100, hdr, e)
+		treeLOR.Fill(100, hdr, e)
+		treeMult2.Fill(100, hdr, e, e.LORs[0].Pulses[0], e.LORs[0].Pulses[1])
+	}
+	tree.Close()
+	treeLOR.Close()
+	treeMult2.Close()
+	pulses, lors := export(t, dir, events)
+
+	if len(pulses) != len(wantPulses) || len(lors) != len(wantLORs) {
+		t.Fatalf("%v pulses and %v LORs, want %v and %v", len(pulses), len(lors), len(wantPulses), len(wantLORs))
+	}
+	for i := range pulses {
+		if pulses[i] != wantPulses[i] {
+			t.Errorf("pulse %v:\ngot = %+v\nwant= %+v", i, pulses[i], wantPulses[i])
+		}
+	}
+	for i := range lors {
+		if lors[i] != wantLORs[i] {
+			t.Errorf("LOR %v:\ngot = %+v\nwant= %+v", i, lors[i], wantLORs[i])
+		}
+	}
+}
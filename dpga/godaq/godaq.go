@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"runtime"
 	"runtime/pprof"
 	"strconv"
 	"strings"
@@ -25,13 +26,18 @@ import (
 
 	"golang.org/x/net/websocket"
 
-	"gitlab.in2p3.fr/avirm/analysis-go/applyCorrCalib"
+	"gitlab.in2p3.fr/avirm/analysis-go/alarm"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/calib/selectCalib"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dq"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/export"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/trees"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+	"gitlab.in2p3.fr/avirm/analysis-go/pipeline"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
 	"gitlab.in2p3.fr/avirm/analysis-go/utils"
 )
 
@@ -43,7 +49,10 @@ var (
 	pauseRun     = make(chan bool)
 	resumeRun    = make(chan bool)
 	pauseMonBool bool
+	runQuality   = "none" // overall quality of the run with respect to the reference dq plots (see dq.RunQuality)
+	runAlarms    = "none" // number of alarms raised during the run, per severity (see alarm.Engine.Summary)
 	hdrType      = rw.HeaderCAL
+	alarmSev     = alarm.Warning // set with the -alarmsev flag
 	cpuprof      = flag.String("cpuprof", "", "Name of file for CPU profiling")
 	noEvents     = flag.Uint("n", 100000, "Number of events")
 	outfileName  = flag.String("o", "", "Name of the output file. If not specified, setting it automatically using the following syntax: runXXX.bin (where XXX is the run number)")
@@ -59,11 +68,14 @@ var (
 	sleep        = flag.Bool("s", false, "If set, sleep a bit between events")
 	sigthres     = flag.Uint("sigthres", 800, "Value above which a pulse is considered to have signal")
 	notree       = flag.Bool("notree", false, "If set, no root tree is produced")
+	timeWindow   = flag.Float64("timewindow", 0, "Duration (s) of the time slices of the dq plots, shown as strip charts (0: no time slices)")
+	noreport     = flag.Bool("noreport", false, "If set, no html data quality report is produced at the end of the run (see dq.DQPlot.WriteReport)")
+	doparquet    = flag.Bool("parquet", false, "If set, pulses and LORs are exported to Parquet files (see package dpga/export), in addition to the root tree or instead of it with -notree")
 	test         = flag.Bool("test", false,
 		"If set, update runs_test.csv rather than the \"official\" runs.csv file and name by default the output binary file using the following scheme: runXXX_test.bin")
-	//refplots = flag.String("ref", os.Getenv("GOPATH")+"/src/gitlab.in2p3.fr/avirm/analysis-go/dpga/dqref/dq-run37020evtsPedReference.gob",
+	//refplots = flag.String("ref", os.Getenv("GOPATH")+"/src/gitlab.in2p3.fr/avirm/analysis-go/dpga/dqref/dq-run37020evtsPedReference.root",
 	//	"Name of the file containing reference plots. If empty, no reference plots are overlayed")
-	refplots    = flag.String("ref", "", "Name of the file containing reference plots. If empty, no reference plots are overlayed")
+	refplots    = flag.String("ref", "", "Name of the file (ROOT, or legacy gob with the .gob extension) containing reference plots. If empty, no reference plots are overlayed")
 	hvMonDegrad = flag.Uint("hvmondeg", 100, "HV monitoring frequency degradation factor")
 	comment     = flag.String("c", "None", "Comment to be put in runs csv file")
 	distr       = flag.String("distr", "ampl", "Possible values: ampl (default), charge, energy")
@@ -71,8 +83,9 @@ var (
 	noped       = flag.Bool("noped", false, "If specified, no pedestal correction applied")
 	notdo       = flag.Bool("notdo", false, "If specified, no time dependent offset correction applied")
 	noen        = flag.Bool("noen", false, "If specified, no energy calibration applied.")
-	rfcutmean   = flag.Float64("rfcutmean", 7, "Mean used to apply RF selection cut.")
-	rfcutwidth  = flag.Float64("rfcutwidth", 5, "Width used to apply RF selection cut.")
+	compress    = flag.Bool("z", false, "If set, the output binary file is compressed (see package rawz) and named by default runXXX.bin.z")
+	noWorkers   = flag.Int("j", runtime.NumCPU(), "Number of workers building and processing events in parallel")
+	alarmCmd    = flag.String("alarmcmd", "", "Shell command run when an alarm is raised, with the alarm given by the environment variables ALARM_SEVERITY, ALARM_RULE, ALARM_SOURCE, ALARM_MESSAGE and ALARM_TIME (see -alarmsev)")
 )
 
 // XY is a struct used to store a couple of values
@@ -135,13 +148,6 @@ func NewHVexec(execName string, coefDir string) *HVexec {
 	if !utils.Exists(coefDir) {
 		fmt.Printf("could not find directory %v\n", coefDir)
 		return nil
-		if !utils.Exists(coefDir+"/Coef_poly_C001.txt") ||
-			!utils.Exists(coefDir+"/Coef_poly_C002.txt") ||
-			!utils.Exists(coefDir+"/Coef_poly_C003.txt") ||
-			!utils.Exists(coefDir+"/Coef_poly_C004.txt") {
-			fmt.Printf("could not find at least one of the Coef_poly_C00?.txt file\n")
-			return nil
-		}
 	}
 	_, linkName := path.Split(coefDir)
 	if !utils.Exists(linkName) {
@@ -233,7 +239,12 @@ type Data struct {
 	AmplEnergyCorrelation string         `json:"amplenergycorrelation"` // amplitude or energy correlation for events with multiplicity=2
 	HitQuartets           string         `json:"hitquartets"`           // 2D plot displaying quartets that are hit for events with multiplicity=2
 	RFplotALaArnaud       string         `json:"rfplotalaarnaud"`       // 2D RF plot "a la Arnaud"
+	StripCharts           string         `json:"stripcharts"`           // strip charts of the dq time slices
+	TimeSliceMaps         string         `json:"timeslicemaps"`         // rate per channel and minimal reconstruction Z versus time
 	LORMult               string         `json:"lormult"`               // LOR multiplicity
+	Quality               string         `json:"quality"`               // comparison of dq histograms with the reference (see dq.Summary)
+	Alarms                []alarm.Alarm  `json:"alarms"`                // active alarms
+	AlarmHistory          []alarm.Alarm  `json:"alarmhistory"`          // last alarms raised
 }
 
 func TCPConn(p *string) *net.TCPConn {
@@ -252,6 +263,21 @@ func main() {
 	log.SetFlags(log.Llongfile | log.LstdFlags)
 
 	flag.Var(&hdrType, "h", "Type of header: HeaderCAL or HeaderOld")
+	flag.Float64Var(&event.RFClassifier.Freq, "rffreq", event.RFClassifier.Freq, "Nominal frequency (MHz) of the accelerator RF signal.")
+	flag.Float64Var(&event.RFClassifier.PromptMean, "promptmean", event.RFClassifier.PromptMean, "Mean (ns, time since RF rising front) of the prompt-gamma window.")
+	flag.Float64Var(&event.RFClassifier.PromptWidth, "promptwidth", event.RFClassifier.PromptWidth, "Half-width (ns) of the prompt-gamma window.")
+	flag.Float64Var(&event.RFClassifier.MinAmpl, "rfminampl", event.RFClassifier.MinAmpl, "Minimal amplitude of the RF signal for the beam to be considered on.")
+	dq.ThresholdFlags()
+	flag.Float64Var(&alarm.DefaultThresholds.RateZero, "ratezero", alarm.DefaultThresholds.RateZero, "Duration (s) without any pulse with signal in a channel after which an alarm is raised.")
+	flag.UintVar(&alarm.DefaultThresholds.IntegrityWarn, "integritywarn", alarm.DefaultThresholds.IntegrityWarn, "Number of integrity errors above which a warning alarm is raised.")
+	flag.UintVar(&alarm.DefaultThresholds.IntegrityCrit, "integritycrit", alarm.DefaultThresholds.IntegrityCrit, "Number of integrity errors above which a critical alarm is raised.")
+	flag.Float64Var(&alarm.DefaultThresholds.HVWarn, "hvwarn", alarm.DefaultThresholds.HVWarn, "Deviation (V) of a HV value from its value at the start of the run above which a warning alarm is raised.")
+	flag.Float64Var(&alarm.DefaultThresholds.HVCrit, "hvcrit", alarm.DefaultThresholds.HVCrit, "Deviation (V) of a HV value from its value at the start of the run above which a critical alarm is raised.")
+	flag.Float64Var(&alarm.DefaultThresholds.MonBufWarn, "monbufwarn", alarm.DefaultThresholds.MonBufWarn, "Fraction of the monitoring buffer filled above which a warning alarm is raised.")
+	flag.Float64Var(&alarm.DefaultThresholds.MonBufCrit, "monbufcrit", alarm.DefaultThresholds.MonBufCrit, "Fraction of the monitoring buffer filled above which a critical alarm is raised.")
+	flag.Var(&alarmSev, "alarmsev", "Minimal severity of the alarms for which the -alarmcmd command is run: info, warning (default) or critical")
+	flag.Var(&pulse.ExtraVars, "vars", pulse.ExtraVarsUsage())
+	flag.Var(&event.CrystPos, "crystpos", "Position in the crystals used as LOR endpoints: front, center (default) or doi (depth of interaction)")
 	flag.Parse()
 
 	if *cpuprof != "" {
@@ -311,6 +337,9 @@ func main() {
 			*outfileName += "_test"
 		}
 		*outfileName += ".bin"
+		if *compress {
+			*outfileName += rawz.Ext
+		}
 	}
 	filew, err := os.Create(*outfileName)
 	if err != nil {
@@ -319,7 +348,17 @@ func main() {
 	defer filew.Close()
 
 	bufiow := bufio.NewWriter(filew)
-	w := rw.NewWriter(bufiow)
+	var (
+		w  *rw.Writer
+		zw *rawz.Writer
+	)
+	switch *compress {
+	case true:
+		zw = rawz.NewWriter(bufiow, rawz.Stride32)
+		w = rw.NewWriter(zw)
+	case false:
+		w = rw.NewWriter(bufiow)
+	}
 	defer w.Close()
 
 	// Start reading TCP stream
@@ -329,6 +368,15 @@ func main() {
 	if err != nil {
 		log.Fatalf("error writing header: %v\n", err)
 	}
+	// Position of the header in the output file, needed to update it at the end of the run.
+	// When compressed, the header is stored uncompressed so that it can be updated in place.
+	var hdrOffset int64
+	if zw != nil {
+		hdrOffset, err = zw.FlushStored()
+		if err != nil {
+			log.Fatalf("error writing header: %v\n", err)
+		}
+	}
 	hdr.Print()
 
 	// web address handling
@@ -401,12 +449,20 @@ func main() {
 	//bufiow.Flush()
 	timeStop := uint32(time.Now().Unix())
 	noEvents := uint32(iEvent)
-	updateHeader(filew, 16, timeStop)
-	updateHeader(filew, 20, noEvents)
+	updateHeader(filew, hdrOffset+16, timeStop)
+	updateHeader(filew, hdrOffset+20, noEvents)
+
+	// Write index of the binary file, allowing random access to events
+	idx := w.Index()
+	idx.DataFileName = *outfileName
+	err = idx.Write(evtindex.FileName(*outfileName))
+	if err != nil {
+		log.Printf("could not write index file: %v\n", err)
+	}
 
 	// Dump run info in csv. Only relevant when ran on DAQ PC, where the csv file is present.
 	updateRunsCSV(runCSVFileName, currentRunNumber, timeStop, noEvents, *outfileName, hdr)
-	updateHeader(filew, 4, currentRunNumber)
+	updateHeader(filew, hdrOffset+4, currentRunNumber)
 }
 
 func updateHeader(f *os.File, offset int64, val uint32) {
@@ -424,6 +480,8 @@ type RunsCSV struct {
 	StartTime   string
 	StopTime    string
 	Comment     string
+	Quality     string
+	Alarms      string
 }
 
 func getPreviousRunNumber(fileName string) uint32 {
@@ -437,7 +495,7 @@ func getPreviousRunNumber(fileName string) uint32 {
 
 	nLines, err := utils.LineCounter(fileName)
 	if err != nil {
-		log.Fatalf("error reading the number of lines in runs.csv\n", err)
+		log.Fatalf("error reading the number of lines in runs.csv: %v\n", err)
 	}
 
 	// the -2 is because there are two lines of header at the beginning
@@ -446,13 +504,15 @@ func getPreviousRunNumber(fileName string) uint32 {
 		panic(err)
 	}
 	defer rows.Close()
-	var data RunsCSV
+	// only the run number is read, as rows written before the Quality and
+	// Alarms columns were added have less fields than RunsCSV
+	var runNumber uint32
 	rows.Next()
-	err = rows.Scan(&data)
+	err = rows.Scan(&runNumber)
 	if err != nil {
 		log.Fatalf("error reading row: %v\n", err)
 	}
-	return data.RunNumber
+	return runNumber
 }
 
 func updateRunsCSV(csvFileName string, runNumber uint32, timeStop uint32, noEvents uint32, outfileName string, hdr *rw.Header) {
@@ -480,6 +540,8 @@ func updateRunsCSV(csvFileName string, runNumber uint32, timeStop uint32, noEven
 		StartTime:   time.Unix(int64(hdr.TimeStart), 0).Format(time.UnixDate),
 		StopTime:    time.Unix(int64(timeStop), 0).Format(time.UnixDate),
 		Comment:     *comment,
+		Quality:     runQuality,
+		Alarms:      runAlarms,
 	}
 	err = tbl.WriteRow(data)
 	if err != nil {
@@ -599,13 +661,27 @@ func stream(run uint32, r *rw.Reader, w *rw.Writer, iEvent *uint, wg *sync.WaitG
 			doEnergyCalib = true
 		}
 	}
+	// Events are built, corrected and reconstructed in parallel, monitoring is done in order.
+	p := pipeline.New(r, *noWorkers,
+		pipeline.Correct(doPedestal, doTimeDepOffset, doEnergyCalib),
+		pipeline.Features(),
+		pipeline.DefaultLORs())
+	defer p.Close()
 	noEventsForMon := uint64(0)
 	dqplots := dq.NewDQPlot()
+	dqplots.TimeWindow = *timeWindow
 	if *refplots != "" {
-		dqplots.DQPlotRef = dq.NewDQPlotFromGob(*refplots)
+		var err error
+		dqplots.DQPlotRef, err = dq.ReadFile(*refplots)
+		if err != nil {
+			log.Fatalf("could not read reference plots: %v\n", err)
+		}
 	}
 	hvexec := NewHVexec(os.Getenv("HOME")+"/Acquisition/hv/ht-caen", os.Getenv("HOME")+"/Acquisition/hv/Coeff")
-	outrootfileName := strings.Replace(*outfileName, ".bin", "LOR.root", 1)
+	alarms := alarm.NewEngine(alarm.DefaultRules(alarm.DefaultThresholds)...)
+	alarms.Hook = *alarmCmd
+	alarms.HookSeverity = alarmSev
+	outrootfileName := strings.Replace(strings.TrimSuffix(*outfileName, rawz.Ext), ".bin", "LOR.root", 1)
 	var treeLOR *trees.TreeLOR
 	if !*notree {
 		path, _ := os.Getwd()
@@ -616,6 +692,27 @@ func stream(run uint32, r *rw.Reader, w *rw.Writer, iEvent *uint, wg *sync.WaitG
 			treeLOR = trees.NewTreeLOR(os.Getenv("HOME") + "/godaq_rootfiles/" + outrootfileName)
 		}
 	}
+	var exp *export.Writer
+	if *doparquet {
+		pulsesFileName, lorsFileName := export.FileNames(*outfileName)
+		path, _ := os.Getwd()
+		if !strings.Contains(path, "analysis-go") {
+			pulsesFileName = os.Getenv("HOME") + "/godaq_rootfiles/" + pulsesFileName
+			lorsFileName = os.Getenv("HOME") + "/godaq_rootfiles/" + lorsFileName
+		}
+		prov := export.Provenance{
+			Calib:         *calib,
+			Pedestal:      doPedestal,
+			TimeDepOffset: doTimeDepOffset,
+			EnergyCalib:   doEnergyCalib,
+			Input:         *outfileName,
+		}
+		var err error
+		exp, err = export.NewWriter(pulsesFileName, lorsFileName, prov)
+		if err != nil {
+			log.Fatalf("could not create parquet files: %v\n", err)
+		}
+	}
 	var minrec []XYZ
 	minrecXYsvg := ""
 	minrecZsvg := ""
@@ -635,32 +732,36 @@ func stream(run uint32, r *rw.Reader, w *rw.Writer, iEvent *uint, wg *sync.WaitG
 				if *iEvent%*evtFreq == 0 {
 					fmt.Printf("event %v\n", *iEvent)
 				}
-				event, status := r.ReadNextEvent()
+				event, raw, err := p.ReadNextEventRaw()
 				//fmt.Println("counters:", event.Counters)
-				if status == false {
-					panic("error: status is false\n")
+				if event == nil {
+					// end of the stream or reading error
+					panic(err)
+				}
+				if err != nil {
+					dqplots.AddIntegrityError(err)
 				}
 				switch event.IsCorrupted {
 				case false:
 					//event.Print(true, false)
-					w.Event(event)
+					w.Event(raw)
 					noEventsForMon++
+					if exp != nil {
+						if err := exp.Fill(run, r.Header(), event); err != nil {
+							log.Fatalf("error exporting event %v: %v\n", event.ID, err)
+						}
+					}
 					////////////////////////////////////////////////////////////////////////////////////////////
 					// Monitoring
 					if !pauseMonBool {
-						//////////////////////////////////////////////////////
-						// Corrections
-						event = applyCorrCalib.CorrectEvent(event, doPedestal, doTimeDepOffset, doEnergyCalib)
-						//////////////////////////////////////////////////////
 						// 						dqplots.FillHistos(event)
 						// mult, pulsesWithSignal, _ := event.Multiplicity()
 
-						timesRF := event.FindTimesRF()
 						if treeLOR != nil {
-							treeLOR.Fill(run, r.Header(), event, timesRF)
+							treeLOR.Fill(run, r.Header(), event)
 						}
 						// 						fmt.Println(" \nlength middle: ", len(event.LORs))
-						dqplots.FillHistos(event, *rfcutmean, *rfcutwidth)
+						dqplots.FillHistos(event)
 						// 						fmt.Println(" length after: ", len(event.LORs))
 						/*
 							if mult == 2 {
@@ -688,7 +789,7 @@ func stream(run uint32, r *rw.Reader, w *rw.Writer, iEvent *uint, wg *sync.WaitG
 								}
 								if doMinRec {
 									xbeam, ybeam := 0., 0.
-									x, y, z := reconstruction.Minimal(true, ch0, ch1, xbeam, ybeam)
+									x, y, z := reconstruction.Minimal(event.CrystPos, ch0, ch1, pulsesWithSignal[0].E, pulsesWithSignal[1].E, xbeam, ybeam)
 									minrec = append(minrec, XYZ{X: x, Y: y, Z: z})
 									dqplots.HMinRecX.Fill(x, 1)
 									dqplots.HMinRecY.Fill(y, 1)
@@ -749,6 +850,7 @@ func stream(run uint32, r *rw.Reader, w *rw.Writer, iEvent *uint, wg *sync.WaitG
 							chargeLsvg := ""
 							chargeRsvg := ""
 							hvsvg := ""
+							var hv []float64 // HV values read, for alarms
 							if !*monLight {
 								// Make charge (or amplitude) distrib histo plot
 								var whichVar dq.WhichVar
@@ -774,6 +876,7 @@ func stream(run uint32, r *rw.Reader, w *rw.Writer, iEvent *uint, wg *sync.WaitG
 									for iHVCard := 0; iHVCard < 4; iHVCard++ {
 										for iHVChannel := 0; iHVChannel < 16; iHVChannel++ {
 											dqplots.AddHVPoint(iHVCard, iHVChannel, float64(event.ID), hvvals[iHVCard][iHVChannel].HV)
+											hv = append(hv, hvvals[iHVCard][iHVChannel].HV)
 										}
 									}
 								}
@@ -836,6 +939,38 @@ func stream(run uint32, r *rw.Reader, w *rw.Writer, iEvent *uint, wg *sync.WaitG
 								HitQuartetssvg = utils.RenderSVG(pHitQuartets, 9, 9)
 							}
 
+							// Make time slices plots
+							StripChartssvg := ""
+							TimeSliceMapssvg := ""
+							if !*monLight && len(dqplots.TimeSlices) > 0 {
+								StripChartssvg = utils.RenderSVG(dqplots.MakeStripCharts(), 25, 20)
+								TimeSliceMapssvg = utils.RenderSVG(dqplots.MakeTimeSliceMaps(), 30, 12)
+							}
+
+							// Compare dq histograms with reference
+							quality := ""
+							if dqplots.DQPlotRef != nil {
+								quality = dq.Summary(dqplots.Compare(dq.DefaultThresholds))
+							}
+
+							// Evaluate alarms
+							snap := alarm.Snapshot{
+								Time:          stop,
+								ChannelCounts: make([]float64, dqplots.HFrequency.Len()),
+								HV:            hv,
+								MonBufLen:     len(datac),
+								MonBufSize:    datacsize,
+							}
+							for ich := range snap.ChannelCounts {
+								_, snap.ChannelCounts[ich] = dqplots.HFrequency.XY(ich)
+							}
+							for _, n := range dqplots.IntegrityErrors {
+								snap.IntegrityErrors += n
+							}
+							for _, a := range alarms.Evaluate(&snap) {
+								fmt.Printf("%salarm raised: %v%s\n", utils.CLR_R, a, utils.CLR_def)
+							}
+
 							// send to channel
 							if float64(len(datac)) >= 0.6*float64(datacsize) {
 								fmt.Printf("Warning: monitoring buffer filled at more than 60 percent (len(datac) = %v, datacsize = %v)\n", len(datac), datacsize)
@@ -872,7 +1007,12 @@ func stream(run uint32, r *rw.Reader, w *rw.Writer, iEvent *uint, wg *sync.WaitG
 								AmplEnergyCorrelation: Correlationsvg,
 								HitQuartets:           HitQuartetssvg,
 								RFplotALaArnaud:       RFplotALaArnaudsvg,
+								StripCharts:           StripChartssvg,
+								TimeSliceMaps:         TimeSliceMapssvg,
 								LORMult:               LORMultsvg,
+								Quality:               quality,
+								Alarms:                alarms.Active(),
+								AlarmHistory:          alarms.Last(20),
 							}
 							noEventsForMon = 0
 							minrec = nil
@@ -892,9 +1032,59 @@ func stream(run uint32, r *rw.Reader, w *rw.Writer, iEvent *uint, wg *sync.WaitG
 				}
 			case false:
 				fmt.Println("reached specified number of events, stopping.")
+				if dqplots.DQPlotRef != nil {
+					comps := dqplots.Compare(dq.DefaultThresholds)
+					for _, c := range comps {
+						if c.Status != dq.Pass {
+							fmt.Printf("   %v\n", c)
+						}
+					}
+					runQuality = dq.RunQuality(comps).String()
+					fmt.Printf("run quality with respect to reference dq plots = %v\n", runQuality)
+				}
+				runAlarms = alarms.Summary()
+				fmt.Printf("alarms raised during the run: %v\n", runAlarms)
+				for _, a := range alarms.Active() {
+					fmt.Printf("   still active: %v\n", a)
+				}
+				alarmsFileName := alarm.FileName(*outfileName)
+				if path, _ := os.Getwd(); !strings.Contains(path, "analysis-go") {
+					alarmsFileName = os.Getenv("HOME") + "/godaq_rootfiles/" + alarmsFileName
+				}
+				if err := alarms.WriteHistory(alarmsFileName); err != nil {
+					log.Printf("could not write alarm history: %v\n", err)
+				}
+				if !*noreport {
+					reportFileName := dq.ReportFileName(strings.TrimSuffix(*outfileName, rawz.Ext))
+					path, _ := os.Getwd()
+					if !strings.Contains(path, "analysis-go") {
+						reportFileName = os.Getenv("HOME") + "/godaq_rootfiles/" + reportFileName
+					}
+					hdr := *r.Header()
+					hdr.RunNumber = run
+					hdr.TimeStop = uint32(time.Now().Unix())
+					hdr.NoEvents = uint32(*iEvent)
+					info := dq.RunInfo{
+						Header:        &hdr,
+						Input:         *outfileName,
+						Calib:         *calib,
+						Pedestal:      doPedestal,
+						TimeDepOffset: doTimeDepOffset,
+						EnergyCalib:   doEnergyCalib,
+						Comment:       *comment,
+					}
+					if err := dqplots.WriteReport(reportFileName, info); err != nil {
+						log.Printf("could not write data quality report: %v\n", err)
+					}
+				}
 				if treeLOR != nil {
 					treeLOR.Close()
 				}
+				if exp != nil {
+					if err := exp.Close(); err != nil {
+						log.Fatalf("error closing parquet files: %v\n", err)
+					}
+				}
 				return
 			}
 		}
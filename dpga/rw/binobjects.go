@@ -50,6 +50,15 @@ func (ht *HeaderType) Set(value string) error {
 	return nil
 }
 
+// Bits of the History word of the header, set by the programs reprocessing
+// the raw files produced by the DAQ software (e.g. binskim, rwconvert).
+const (
+	HistoryMerged    uint32 = 1 << iota // events of several files concatenated
+	HistorySplit                        // part of the events of a file
+	HistoryFiltered                     // only the events passing a selection
+	HistoryConverted                    // events converted from another format
+)
+
 // Header holds metadata about the run configuration
 type Header struct {
 	HdrType                 HeaderType // type of header
@@ -0,0 +1,379 @@
+// Command binskim concatenates, splits and skims DPGA raw binary files.
+//
+// Events of the input files given as arguments are copied, in order, to the
+// output file without being rebuilt. Only the events passing the selection
+// (if any) are written. If -splitn or -splitt is set, a new output file is
+// started each time the maximal number of events or duration is reached.
+// The NoEvents field of the output headers is set to the number of events
+// written and the History word records what was done (see rw.HistoryMerged,
+// rw.HistorySplit and rw.HistoryFiltered).
+//
+// When several input files are given, the events are renumbered by their
+// position in the concatenated input, so that event IDs increase along the
+// output files and their indices.
+//
+// The TimeStart and TimeStop fields of the first and last output files are the
+// ones of the first and last input files. Those of the other output files are
+// the times of their first and last events, computed from the TimeStart of the
+// input file and the timestamp of the event relative to the first event of the
+// input file.
+//
+// Examples:
+//
+//	binskim -o run100-102.bin run100.bin run101.bin run102.bin
+//	binskim -o run100.bin -splitn 10000 run100.bin.orig
+//	binskim -o run100mult2.bin -mult 2 -quartets 0,1,2,3 run100.bin
+//	binskim -o run100prompt.bin -calib A1 -rfmin 2 -rfmax 12 run100.bin
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/calib/selectCalib"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+	"gitlab.in2p3.fr/avirm/analysis-go/pipeline"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
+)
+
+// clockFreq is the frequency (Hz) of the Thor clock giving the event timestamps.
+const clockFreq = 64e6
+
+// Offsets of the fields of the header updated once the output file is written.
+const (
+	timeStopOffset = 16
+	noEventsOffset = 20
+)
+
+var (
+	outfileName = flag.String("o", "", "Name of the output file. When splitting, output files are named by adding _000, _001, ... before the extension.")
+	splitN      = flag.Uint("splitn", 0, "Maximal number of events per output file (0: no splitting by number of events)")
+	splitT      = flag.Duration("splitt", 0, "Maximal duration, from the event timestamps, per output file (e.g. 5m; 0: no splitting by time)")
+	freq        = flag.Uint("freq", 1000, "Event number printing frequency")
+	compress    = flag.Bool("z", false, "If set, output files are compressed (see package rawz)")
+	sigthres    = flag.Uint("sigthres", 800, "Value above which a pulse is considered to have signal")
+
+	// selection
+	minMult  = flag.Uint("mult", 0, "Minimal multiplicity (number of pulses with signal in clusters with data) of the selected events")
+	rfMin    = flag.Float64("rfmin", 0, "Lower edge (ns, time since RF rising front) of the RF window. The window is applied only if rfmax > rfmin.")
+	rfMax    = flag.Float64("rfmax", 0, "Upper edge (ns, time since RF rising front) of the RF window. Events having at least one pulse with signal in the window are selected.")
+	quartets Quartets
+
+	// corrections applied before the selection
+	calib = flag.String("calib", "", "String indicating which calib to use (e.g. A1 for period A, version 1)")
+	noped = flag.Bool("noped", false, "If specified, no pedestal correction applied")
+	notdo = flag.Bool("notdo", false, "If specified, no time dependent offset correction applied")
+	noen  = flag.Bool("noen", false, "If specified, no energy calibration applied")
+)
+
+// Quartets is the set of quartets (cluster indices, from 0 to 59) given on the command line.
+type Quartets []uint8
+
+func (q *Quartets) String() string {
+	s := make([]string, len(*q))
+	for i, v := range *q {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, ",")
+}
+
+// Set is the method to set the flag value.
+func (q *Quartets) Set(value string) error {
+	*q = (*q)[:0]
+	for _, s := range strings.Split(value, ",") {
+		v, err := strconv.ParseUint(strings.TrimSpace(s), 10, 8)
+		if err != nil || v >= 60 {
+			return fmt.Errorf("invalid quartet %q (possible values: 0 to 59)", s)
+		}
+		*q = append(*q, uint8(v))
+	}
+	return nil
+}
+
+func main() {
+	log.SetFlags(log.Llongfile | log.LstdFlags)
+	flag.Var(&quartets, "quartets", "Comma separated list of quartets (e.g. 0,12,31). Events having at least one pulse with signal in one of them are selected.")
+	flag.Float64Var(&event.RFClassifier.Freq, "rffreq", event.RFClassifier.Freq, "Nominal frequency (MHz) of the accelerator RF signal.")
+	flag.Parse()
+
+	if flag.NArg() == 0 || *outfileName == "" {
+		fmt.Println("usage: binskim -o output.bin [options] input1.bin [input2.bin ...]")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	// Readers. All input files are opened first so that their headers can be checked.
+	readers := make([]*rw.Reader, flag.NArg())
+	for i, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatalf("could not open data file: %v\n", err)
+		}
+		defer f.Close()
+		readers[i], err = rw.NewReader(f, rw.HeaderCAL)
+		if err != nil {
+			log.Fatalf("could not open asm file %v: %v\n", name, err)
+		}
+		readers[i].SigThreshold = *sigthres
+		if hdr0, hdr := readers[0].Header(), readers[i].Header(); hdr.NoSamples != hdr0.NoSamples || hdr.NoASMCards != hdr0.NoASMCards {
+			log.Fatalf("file %v: number of samples or of ASM cards differ from the ones of %v\n", name, flag.Arg(0))
+		}
+	}
+
+	// Output header
+	hdr := *readers[0].Header()
+	hdr.TimeStop = readers[len(readers)-1].Header().TimeStop
+	if len(readers) > 1 {
+		hdr.History |= rw.HistoryMerged
+	}
+	if *splitN > 0 || *splitT > 0 {
+		hdr.History |= rw.HistorySplit
+	}
+
+	// Selection
+	var stages []pipeline.Stage
+	doRF := *rfMax > *rfMin
+	if *minMult > 0 || doRF || len(quartets) > 0 {
+		hdr.History |= rw.HistoryFiltered
+	}
+	if doRF {
+		if *calib != "" {
+			selectCalib.Which(*calib)
+			stages = append(stages, pipeline.Correct(!*noped, !*notdo, !*noen))
+		}
+		stages = append(stages, pipeline.Features())
+	}
+	selected := func(raw *rw.RawEvent, r *rw.Reader) bool {
+		if hdr.History&rw.HistoryFiltered == 0 {
+			return true
+		}
+		e := r.BuildEvent(raw)
+		for _, stage := range stages {
+			e = stage(e)
+		}
+		return selectEvent(e, doRF)
+	}
+
+	noRead, noEvents := skim(readers, flag.Args(), hdr, selected)
+	fmt.Printf("%v events read, %v events written\n", noRead, noEvents)
+}
+
+// skim copies the events of readers passing selected to the output file(s)
+// and returns the numbers of events read and written.
+// names are the names of the input files, used in error messages.
+func skim(readers []*rw.Reader, names []string, hdr rw.Header, selected func(raw *rw.RawEvent, r *rw.Reader) bool) (noRead, noEvents uint) {
+	var (
+		out  *output
+		part int
+	)
+	merged := hdr.History&rw.HistoryMerged != 0
+	for ir, r := range readers {
+		var firstTS uint64 // timestamp of the first event of the input file
+		for first := true; ; first = false {
+			raw, err := r.ReadNextRawEvent()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Fatalf("error reading event in %v: %v\n", names[ir], err)
+			}
+			if noRead%*freq == 0 {
+				fmt.Printf("reading event %v (%v written)\n", noRead, noEvents)
+			}
+			if merged {
+				raw.SetID(uint32(noRead))
+			}
+			noRead++
+			if first {
+				firstTS = raw.TimeStamp()
+			}
+			if !selected(raw, r) {
+				continue
+			}
+			t := float64(r.Header().TimeStart) + float64(raw.TimeStamp()-firstTS)/clockFreq
+			if out != nil && out.full(t) {
+				out.close(uint32(math.Ceil(out.lastTime)))
+				out = nil
+			}
+			if out == nil {
+				name := *outfileName
+				start := hdr.TimeStart
+				if hdr.History&rw.HistorySplit != 0 {
+					name = partFileName(*outfileName, part)
+					if part > 0 {
+						start = uint32(t)
+					}
+					part++
+				}
+				out = newOutput(name, hdr, start)
+			}
+			out.write(raw, t)
+			noEvents++
+		}
+	}
+	if out == nil {
+		// no event selected: write a file with no events
+		out = newOutput(*outfileName, hdr, hdr.TimeStart)
+	}
+	out.close(hdr.TimeStop)
+	return noRead, noEvents
+}
+
+// selectEvent returns true if e passes the selection.
+func selectEvent(e *event.Event, doRF bool) bool {
+	if *minMult > 0 {
+		mult, _, _, _, _, _ := e.Multiplicity()
+		if uint(mult) < *minMult {
+			return false
+		}
+	}
+	if len(quartets) > 0 {
+		found := false
+		for _, q := range quartets {
+			if len(e.Clusters[q].PulsesWithSignal()) > 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if doRF {
+		if e.RF == nil {
+			return false
+		}
+		_, pulses, _, _, _, _ := e.Multiplicity()
+		for _, p := range pulses {
+			if t := e.RFTime(p); t >= *rfMin && t < *rfMax {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// partFileName returns the name of the output file of index i when splitting.
+func partFileName(name string, i int) string {
+	ext := ""
+	if strings.HasSuffix(name, rawz.Ext) {
+		name = strings.TrimSuffix(name, rawz.Ext)
+		ext = rawz.Ext
+	}
+	if strings.HasSuffix(name, ".bin") {
+		name = strings.TrimSuffix(name, ".bin")
+		ext = ".bin" + ext
+	}
+	return fmt.Sprintf("%s_%03d%s", name, i, ext)
+}
+
+// output is an output file being written.
+type output struct {
+	name      string
+	f         *os.File
+	w         *rw.Writer
+	hdr       rw.Header
+	hdrOffset int64 // position of the header in the file
+	noEvents  uint32
+	firstTime float64 // time (s since Jan 01 1970) of the first event
+	lastTime  float64 // time (s since Jan 01 1970) of the last event
+}
+
+// newOutput creates the output file name, with the header hdr starting at start.
+func newOutput(name string, hdr rw.Header, start uint32) *output {
+	f, err := os.Create(name)
+	if err != nil {
+		log.Fatalf("could not create data file: %v\n", err)
+	}
+	out := &output{name: name, f: f, hdr: hdr}
+	out.hdr.NoEvents = 0
+	out.hdr.TimeStart = start
+	bufiow := bufio.NewWriter(f)
+	var zw *rawz.Writer
+	switch *compress {
+	case true:
+		zw = rawz.NewWriter(bufiow, rawz.Stride32)
+		out.w = rw.NewWriter(zw)
+	case false:
+		out.w = rw.NewWriter(bufiow)
+	}
+	if err := out.w.Header(&out.hdr, false); err != nil {
+		log.Fatalf("error writing header: %v\n", err)
+	}
+	if zw != nil {
+		// The header is stored uncompressed so that it can be updated in place.
+		out.hdrOffset, err = zw.FlushStored()
+		if err != nil {
+			log.Fatalf("error writing header: %v\n", err)
+		}
+	}
+	fmt.Printf("writing %v\n", name)
+	return out
+}
+
+// full returns true if an event at time t (s since Jan 01 1970) can not be written
+// to the output file without exceeding the maximal number of events or duration per file.
+func (out *output) full(t float64) bool {
+	if *splitN > 0 && uint(out.noEvents) >= *splitN {
+		return true
+	}
+	if *splitT > 0 && out.noEvents > 0 {
+		dt := time.Duration((t - out.firstTime) * float64(time.Second))
+		if dt >= *splitT {
+			return true
+		}
+	}
+	return false
+}
+
+// write writes raw, which occurred at time t (s since Jan 01 1970).
+func (out *output) write(raw *rw.RawEvent, t float64) {
+	if out.noEvents == 0 {
+		out.firstTime = t
+	}
+	out.lastTime = t
+	if err := out.w.RawEvent(raw); err != nil {
+		log.Fatalf("error writing event: %v\n", err)
+	}
+	out.noEvents++
+}
+
+// close writes the end of the stream, updates the header with the stop time
+// and the number of events and writes the index of the file.
+func (out *output) close(stop uint32) {
+	if err := out.w.Close(); err != nil {
+		log.Fatalf("error closing output file: %v\n", err)
+	}
+	updateHeader(out.f, out.hdrOffset+timeStopOffset, stop)
+	updateHeader(out.f, out.hdrOffset+noEventsOffset, out.noEvents)
+
+	idx := out.w.Index()
+	idx.DataFileName = out.name
+	if err := idx.Write(evtindex.FileName(out.name)); err != nil {
+		log.Printf("could not write index file: %v\n", err)
+	}
+	if err := out.f.Close(); err != nil {
+		log.Fatalf("could not close %v: %v\n", out.name, err)
+	}
+	fmt.Printf("%v events written to %v\n", out.noEvents, out.name)
+}
+
+func updateHeader(f *os.File, offset int64, val uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], val)
+	if _, err := f.WriteAt(buf[:], offset); err != nil {
+		log.Fatalf("could not update header: %v\n", err)
+	}
+}
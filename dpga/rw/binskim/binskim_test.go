@@ -0,0 +1,205 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+)
+
+const testNoSamples = 8
+
+// writeTestFile writes a file with noEvents events of one frame, one second apart,
+// the first one at timestamp ts0.
+func writeTestFile(t *testing.T, name string, timeStart, timeStop uint32, noEvents int, ts0 uint64) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("could not create data file: %v\n", err)
+	}
+	defer f.Close()
+	w := rw.NewWriter(f)
+	hdr := rw.Header{
+		HdrType:   rw.HeaderCAL,
+		RunNumber: 100,
+		TimeStart: timeStart,
+		TimeStop:  timeStop,
+		NoEvents:  uint32(noEvents),
+		NoSamples: testNoSamples + 1,
+	}
+	if err := w.Header(&hdr, false); err != nil {
+		t.Fatalf("error writing header: %v\n", err)
+	}
+	for i := 0; i < noEvents; i++ {
+		ts := ts0 + uint64(i)*clockFreq
+		raw := &rw.RawEvent{
+			ID:       uint32(i),
+			Counters: make([]uint32, rw.NumCounters),
+			Frames: []*rw.Frame{{
+				ID:    uint32(i),
+				Block: rw.Block{Evt: uint32(i), ID: 4, Data: make([]uint32, testNoSamples), SRout: uint32(i)},
+			}},
+		}
+		raw.Counters[2], raw.Counters[3] = uint32(ts), uint32(ts>>32)
+		if err := w.RawEvent(raw); err != nil {
+			t.Fatalf("error writing event: %v\n", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing writer: %v\n", err)
+	}
+}
+
+func openTestFile(t *testing.T, name string) (*os.File, *rw.Reader) {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("could not open data file: %v\n", err)
+	}
+	r, err := rw.NewReader(f, rw.HeaderCAL)
+	if err != nil {
+		t.Fatalf("could not open asm file %v: %v\n", name, err)
+	}
+	return f, r
+}
+
+type testPart struct {
+	timeStart, timeStop uint32
+	ids                 []uint32
+}
+
+func TestSkim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binskim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	run1 := filepath.Join(dir, "run1.bin")
+	run2 := filepath.Join(dir, "run2.bin")
+	writeTestFile(t, run1, 1000, 1010, 5, 1<<20)
+	writeTestFile(t, run2, 2000, 2010, 5, 1<<33)
+
+	odd := func(raw *rw.RawEvent, r *rw.Reader) bool { return raw.Frames[0].Block.SRout%2 == 1 }
+	tests := []struct {
+		name     string
+		inputs   []string
+		splitN   uint
+		splitT   time.Duration
+		selected func(raw *rw.RawEvent, r *rw.Reader) bool
+		history  uint32
+		parts    []testPart
+	}{
+		{
+			name:    "merge",
+			inputs:  []string{run1, run2},
+			history: rw.HistoryMerged,
+			parts:   []testPart{{1000, 2010, []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}}},
+		},
+		{
+			name:    "merge and split by number of events",
+			inputs:  []string{run1, run2},
+			splitN:  4,
+			history: rw.HistoryMerged | rw.HistorySplit,
+			parts: []testPart{
+				{1000, 1003, []uint32{0, 1, 2, 3}},
+				{1004, 2002, []uint32{4, 5, 6, 7}},
+				{2003, 2010, []uint32{8, 9}},
+			},
+		},
+		{
+			name:    "split by time",
+			inputs:  []string{run2},
+			splitT:  2 * time.Second,
+			history: rw.HistorySplit,
+			parts: []testPart{
+				{2000, 2001, []uint32{0, 1}},
+				{2002, 2003, []uint32{2, 3}},
+				{2004, 2010, []uint32{4}},
+			},
+		},
+		{
+			name:     "merge and filter",
+			inputs:   []string{run1, run2},
+			selected: odd,
+			history:  rw.HistoryMerged | rw.HistoryFiltered,
+			parts:    []testPart{{1000, 2010, []uint32{1, 3, 6, 8}}},
+		},
+	}
+	for it, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var readers []*rw.Reader
+			for _, name := range test.inputs {
+				f, r := openTestFile(t, name)
+				defer f.Close()
+				readers = append(readers, r)
+			}
+			hdr := *readers[0].Header()
+			hdr.TimeStop = readers[len(readers)-1].Header().TimeStop
+			hdr.History = test.history
+			*outfileName = filepath.Join(dir, "out"+string(rune('a'+it))+".bin")
+			*splitN, *splitT = test.splitN, test.splitT
+			selected := test.selected
+			if selected == nil {
+				selected = func(raw *rw.RawEvent, r *rw.Reader) bool { return true }
+			}
+			noRead, noEvents := skim(readers, test.inputs, hdr, selected)
+			if want := uint(5 * len(test.inputs)); noRead != want {
+				t.Errorf("got %v events read, want %v\n", noRead, want)
+			}
+
+			n := uint(0)
+			for ip, part := range test.parts {
+				name := *outfileName
+				if len(test.parts) > 1 {
+					name = partFileName(*outfileName, ip)
+				}
+				f, r := openTestFile(t, name)
+				defer f.Close()
+				h := r.Header()
+				if h.TimeStart != part.timeStart || h.TimeStop != part.timeStop {
+					t.Errorf("%v: got times %v-%v, want %v-%v\n", name, h.TimeStart, h.TimeStop, part.timeStart, part.timeStop)
+				}
+				if h.NoEvents != uint32(len(part.ids)) || h.History != test.history {
+					t.Errorf("%v: got NoEvents=%v History=%v, want %v and %v\n", name, h.NoEvents, h.History, len(part.ids), test.history)
+				}
+				idx, err := evtindex.ReadFor(name)
+				if err != nil {
+					t.Fatalf("could not read index: %v\n", err)
+				}
+				if idx.Len() != len(part.ids) {
+					t.Fatalf("%v: got %v index entries, want %v\n", name, idx.Len(), len(part.ids))
+				}
+				for i, id := range part.ids {
+					raw, err := r.ReadNextRawEvent()
+					if err != nil {
+						t.Fatalf("%v: error reading event %v: %v\n", name, i, err)
+					}
+					if raw.ID != id || raw.Frames[0].Block.Evt != id || idx.Entries[i].EventID != uint64(id) {
+						t.Errorf("%v: event %v has ID %v (index: %v), want %v\n", name, i, raw.ID, idx.Entries[i].EventID, id)
+					}
+					n++
+				}
+				if raw, _ := r.ReadNextRawEvent(); raw != nil {
+					t.Errorf("%v: too many events\n", name)
+				}
+			}
+			if noEvents != n {
+				t.Errorf("got %v events written, want %v\n", noEvents, n)
+			}
+		})
+	}
+}
+
+func TestPartFileName(t *testing.T) {
+	for _, test := range []struct{ name, want string }{
+		{"run1.bin", "run1_002.bin"},
+		{"run1.bin.z", "run1_002.bin.z"},
+		{"run1", "run1_002"},
+	} {
+		if got := partFileName(test.name, 2); got != test.want {
+			t.Errorf("partFileName(%q) = %q, want %q\n", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package rw
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+)
+
+const testNoSamples = 16
+
+// testEvent returns an event with the given ID and timestamp whose first
+// quartet has samples.
+func testEvent(id uint, timeStamp uint64) *event.Event {
+	e := event.NewEvent(int(dpgadetector.Det.NoClusters()), int(dpgadetector.Det.NoClustersWoData()))
+	e.ID = id
+	e.Counters = make([]uint32, NumCounters)
+	e.Counters[2] = uint32(timeStamp)
+	e.Counters[3] = uint32(timeStamp >> 32)
+	iHemi, iASM, iDRS, iQuartet := dpgadetector.QuartetAbsIdx72ToRelIdx(0)
+	c := &e.Clusters[0]
+	for i := range c.Pulses {
+		_, iChannelAbs288 := dpgadetector.RelIdxToAbsIdx288(iHemi, iASM, iDRS, iQuartet, uint8(i))
+		p := pulse.NewPulse(dpgadetector.Det.ChannelFromIdAbs288(iChannelAbs288))
+		for j := 0; j < testNoSamples; j++ {
+			ampl := float64(500 + 100*i + 7*j + int(id))
+			p.AddSample(pulse.NewSample(ampl, uint16(j), float64(j)*dpgadetector.Det.SamplingFreq()), nil, 800)
+		}
+		c.Pulses[i] = *p
+	}
+	return e
+}
+
+// writeTestEvents writes events with IDs 0 to len(timeStamps)-1 and the given
+// timestamps and returns the stream and the index of the writer.
+func writeTestEvents(t *testing.T, timeStamps []uint64) ([]byte, *evtindex.Index) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.Header(&Header{HdrType: HeaderOld, Size: testNoSamples + 8}, false); err != nil {
+		t.Fatalf("could not write header: %v\n", err)
+	}
+	for i, ts := range timeStamps {
+		if err := w.Event(testEvent(uint(i), ts)); err != nil {
+			t.Fatalf("error writing event: %v\n", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing writer: %v\n", err)
+	}
+	return buf.Bytes(), w.Index()
+}
+
+func TestIndexSeek(t *testing.T) {
+	// the timestamps are reset after the fourth event
+	timeStamps := []uint64{1000, 2000, 3000, 1 << 40, 500, 600}
+	data, widx := writeTestEvents(t, timeStamps)
+
+	r, err := NewReader(bytes.NewReader(data), HeaderOld)
+	if err != nil {
+		t.Fatalf("could not open stream: %v\n", err)
+	}
+	idx, err := r.BuildIndex()
+	if err != nil {
+		t.Fatalf("error building index: %v\n", err)
+	}
+	if !reflect.DeepEqual(idx.Entries, widx.Entries) {
+		t.Fatalf("indices differ:\ngot= %v\nwant=%v\n", idx.Entries, widx.Entries)
+	}
+	if idx.Len() != len(timeStamps) {
+		t.Fatalf("%v indexed events, want %v\n", idx.Len(), len(timeStamps))
+	}
+	for i, e := range idx.Entries {
+		if e.EventID != uint64(i) || e.Time != timeStamps[i] {
+			t.Errorf("entry %v = %v, want event %v at time %v\n", i, e, i, timeStamps[i])
+		}
+	}
+
+	r, err = NewReader(bytes.NewReader(data), HeaderOld)
+	if err != nil {
+		t.Fatalf("could not open stream: %v\n", err)
+	}
+	if err := r.Seek(1); err != evtindex.ErrNoIndex {
+		t.Errorf("Seek without index: error = %v, want %v\n", err, evtindex.ErrNoIndex)
+	}
+	r.SetIndex(idx)
+	tests := []struct {
+		name string
+		seek func() error
+		want uint // ID of the next event, if err is nil
+		err  error
+	}{
+		{"Seek", func() error { return r.Seek(3) }, 3, nil},
+		{"Seek backwards", func() error { return r.Seek(0) }, 0, nil},
+		{"Seek last", func() error { return r.Seek(5) }, 5, nil},
+		{"Seek beyond the last", func() error { return r.Seek(6) }, 0, evtindex.ErrNotFound},
+		// the timestamps not being sorted, the first event in file order is found
+		{"SeekTime", func() error { return r.SeekTime(1500) }, 1, nil},
+		{"SeekTime after the reset", func() error { return r.SeekTime(550) }, 0, nil},
+		{"SeekTime exact", func() error { return r.SeekTime(1 << 40) }, 3, nil},
+		{"SeekTime beyond the last", func() error { return r.SeekTime(1<<40 + 1) }, 0, evtindex.ErrNotFound},
+	}
+	for _, test := range tests {
+		if err := test.seek(); err != test.err {
+			t.Errorf("%v: error = %v, want %v\n", test.name, err, test.err)
+			continue
+		}
+		if test.err != nil {
+			continue
+		}
+		e, err := r.ReadNextEvent()
+		if err != nil || e == nil {
+			t.Errorf("%v: error reading event: %v\n", test.name, err)
+			continue
+		}
+		if e.ID != test.want || e.TimeStamp != timeStamps[test.want] {
+			t.Errorf("%v: event %v at time %v, want event %v at time %v\n", test.name, e.ID, e.TimeStamp, test.want, timeStamps[test.want])
+		}
+	}
+
+	// the stream is not seekable once wrapped in a bufio.Reader
+	r, err = NewReader(bufio.NewReader(bytes.NewReader(data)), HeaderOld)
+	if err != nil {
+		t.Fatalf("could not open stream: %v\n", err)
+	}
+	r.SetIndex(idx)
+	if err := r.Seek(1); err != evtindex.ErrNotSeekable {
+		t.Errorf("Seek in buffered stream: error = %v, want %v\n", err, evtindex.ErrNotSeekable)
+	}
+}
@@ -8,7 +8,9 @@ import (
 
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
 )
 
 // Reader wraps an io.Reader and reads avirm data files
@@ -22,6 +24,10 @@ type Reader struct {
 	SigThreshold      uint
 	Counters          [NumCounters]uint32
 	Debug             bool
+
+	rs    io.ReadSeeker // underlying stream, if seekable
+	c     *countReader
+	index *evtindex.Index
 }
 
 // NoSamples returns the number of samples
@@ -29,6 +35,11 @@ func (r *Reader) NoSamples() uint16 {
 	return r.noSamples
 }
 
+// SetDebug() sets debug mode
+func (r *Reader) SetDebug() {
+	r.Debug = true
+}
+
 // Err return the reader error
 func (r *Reader) Err() error {
 	return r.err
@@ -40,17 +51,29 @@ func (r *Reader) Header() *Header {
 }
 
 // NewReader returns a new ASM stream in read mode
+//
+// If r implements io.Seeker (e.g. *os.File), it should not be wrapped in a
+// bufio.Reader: the reader then buffers it itself and supports Seek and SeekTime.
 func NewReader(r io.Reader, ht HeaderType) (*Reader, error) {
 	rr := &Reader{
-		r:              r,
 		evtIDPrevFrame: 0,
 		SigThreshold:   800,
 	}
+	r, err := rawz.Open(r)
+	if err != nil {
+		return nil, err
+	}
+	rr.setReader(r)
 	rr.hdr.HdrType = ht
 	rr.readHeader(&rr.hdr)
 	return rr, rr.err
 }
 
+// SetSigThreshold sets the signal SetSigThreshold
+func (r *Reader) SetSigThreshold(val uint) {
+	r.SigThreshold = val
+}
+
 // Read implements io.Reader
 func (r *Reader) Read(data []byte) (int, error) {
 	return r.r.Read(data)
@@ -301,13 +324,31 @@ func MakePulses(f *Frame, sigThreshold uint) (*pulse.Pulse, *pulse.Pulse) {
 	return pulse1, pulse2
 }
 
-func (r *Reader) ReadNextEvent() (*event.Event, bool) {
-	event := event.NewEvent(dpgadetector.Det.NoClusters())
-	event.Counters = make([]uint32, NumCounters)
-	for i := range event.Counters {
-		event.Counters[i] = r.Counters[i]
+// RawEvent holds the frames of an event as read from the stream, before the
+// pulses are made (see ReadNextRawEvent and BuildEvent).
+type RawEvent struct {
+	ID       uint32
+	Counters []uint32
+	Frames   []*Frame
+}
+
+// TimeStamp returns the timestamp of the event, from the 64 MHz clock on Thor.
+func (raw *RawEvent) TimeStamp() uint64 {
+	return timeStamp(raw.Counters)
+}
+
+// SetID sets the ID of the event and of its frames.
+func (raw *RawEvent) SetID(id uint32) {
+	raw.ID = id
+	for _, f := range raw.Frames {
+		f.Block.Evt = id
 	}
-	firstPass := true
+}
+
+// ReadNextRawEvent reads the frames of the next event.
+// At the end of the stream, it returns a nil raw event and io.EOF.
+func (r *Reader) ReadNextRawEvent() (*RawEvent, error) {
+	raw := &RawEvent{}
 	for { // loop over frames
 		var frame *Frame = nil
 		if r.firstFrameOfEvent != nil { // enter this only for first frame of event
@@ -315,7 +356,7 @@ func (r *Reader) ReadNextEvent() (*event.Event, bool) {
 			if r.err != nil {
 				log.Println("error not nil", r.err)
 				if r.err == io.EOF {
-					return nil, false
+					return nil, r.err
 				}
 			}
 			r.firstFrameOfEvent = nil
@@ -324,80 +365,110 @@ func (r *Reader) ReadNextEvent() (*event.Event, bool) {
 			if err != nil && err != io.EOF {
 				log.Fatal("error not nil", err)
 			}
+			if err == io.EOF { // last frame reached
+				if len(raw.Frames) == 0 {
+					return nil, err
+				}
+				r.firstFrameOfEvent = frametemp
+				return raw, nil
+			}
 			frame = frametemp
 		}
 		evtID := frame.Block.Evt
 		//fmt.Println("evtID =", evtID)
-		if firstPass || evtID == r.evtIDPrevFrame { // fill event
-			if firstPass {
-				event.ID = uint(evtID)
-			}
-			firstPass = false
-			fifoID144 := uint16(frame.Block.ID)
+		if len(raw.Frames) > 0 && evtID != r.evtIDPrevFrame { // switched to next event
+			r.firstFrameOfEvent = frame
+			return raw, nil
+		}
+		if len(raw.Frames) == 0 {
+			raw.ID = evtID
+			// Counters are read together with the first frame of the event
+			raw.Counters = make([]uint32, NumCounters)
+			copy(raw.Counters, r.Counters[:])
+		}
+		raw.Frames = append(raw.Frames, frame)
+		r.evtIDPrevFrame = evtID
+	} // end of loop over frames
+}
+
+// BuildEvent makes the pulses from the frames of raw and returns the corresponding event.
+// BuildEvent does not modify the reader, so that events can be built concurrently
+// while the next ones are read.
+func (r *Reader) BuildEvent(raw *RawEvent) *event.Event {
+	event := event.NewEvent(int(dpgadetector.Det.NoClusters()), int(dpgadetector.Det.NoClustersWoData()))
+	event.Counters = raw.Counters
+	event.ID = uint(raw.ID)
+	event.TimeStamp = raw.TimeStamp()
+	for _, frame := range raw.Frames {
+		fifoID144 := uint16(frame.Block.ID)
+
+		////////////////////////////////////////////////////////
+		// determine typeOfFrame
+		switch fifoID144 % 2 {
+		case 0:
+			frame.typeOfFrame = FirstFrameOfCluster
+		case 1:
+			frame.typeOfFrame = SecondFrameOfCluster
+		}
+		////////////////////////////////////////////////////////
+
+		pulse0, pulse1 := MakePulses(frame, r.SigThreshold)
+
+		i := fifoID144 % 12
+		if i == 10 || i == 11 {
+			iChannelWoData := i - 10
+			iChannelWoData += 2 * (fifoID144 / 12)
+			iClusterWoData := iChannelWoData / 2
+			//fmt.Println(fifoID144, iChannelWoData, iClusterWoData)
+			event.ClustersWoData[iClusterWoData].ID = uint8(iClusterWoData)
 
 			////////////////////////////////////////////////////////
-			// determine typeOfFrame
-			switch fifoID144 % 2 {
-			case 0:
-				frame.typeOfFrame = FirstFrameOfCluster
-			case 1:
-				frame.typeOfFrame = SecondFrameOfCluster
+			// Put pulses in event
+			switch frame.typeOfFrame {
+			case FirstFrameOfCluster:
+				event.ClustersWoData[iClusterWoData].Pulses[0] = *pulse0
+				event.ClustersWoData[iClusterWoData].Pulses[1] = *pulse1
+			case SecondFrameOfCluster:
+				event.ClustersWoData[iClusterWoData].Pulses[2] = *pulse0
+				event.ClustersWoData[iClusterWoData].Pulses[3] = *pulse1
 			}
 			////////////////////////////////////////////////////////
+		} else {
+			iCluster := dpgadetector.FifoID144ToQuartetAbsIdx60(fifoID144, true)
+			if iCluster >= 60 {
+				log.Fatalf("error ! iCluster=%v (>= 60)\n", iCluster)
+			}
+			//fmt.Printf("fifoID144=%v, iCluster = %v\n", fifoID144, iCluster)
+			event.Clusters[iCluster].ID = iCluster
 
-			pulse0, pulse1 := MakePulses(frame, r.SigThreshold)
-
-			i := fifoID144 % 12
-			if i == 10 || i == 11 {
-				iChannelWoData := i - 10
-				iChannelWoData += 2 * (fifoID144 / 12)
-				iClusterWoData := iChannelWoData / 2
-				//fmt.Println(fifoID144, iChannelWoData, iClusterWoData)
-				event.ClustersWoData[iClusterWoData].ID = uint8(iClusterWoData)
-
-				////////////////////////////////////////////////////////
-				// Put pulses in event
-				switch frame.typeOfFrame {
-				case FirstFrameOfCluster:
-					event.ClustersWoData[iClusterWoData].Pulses[0] = *pulse0
-					event.ClustersWoData[iClusterWoData].Pulses[1] = *pulse1
-				case SecondFrameOfCluster:
-					event.ClustersWoData[iClusterWoData].Pulses[2] = *pulse0
-					event.ClustersWoData[iClusterWoData].Pulses[3] = *pulse1
-				}
-				////////////////////////////////////////////////////////
-			} else {
-				iCluster := dpgadetector.FifoID144ToQuartetAbsIdx60(fifoID144, true)
-				if iCluster >= 60 {
-					log.Fatalf("error ! iCluster=%v (>= 60)\n", iCluster)
-				}
-				//fmt.Printf("fifoID144=%v, iCluster = %v\n", fifoID144, iCluster)
-				event.Clusters[iCluster].ID = iCluster
-
-				////////////////////////////////////////////////////////
-				// Put pulses in event
-				switch frame.typeOfFrame {
-				case FirstFrameOfCluster:
-					event.Clusters[iCluster].Pulses[0] = *pulse0
-					event.Clusters[iCluster].Pulses[1] = *pulse1
-				case SecondFrameOfCluster:
-					event.Clusters[iCluster].Pulses[2] = *pulse0
-					event.Clusters[iCluster].Pulses[3] = *pulse1
-				}
-				////////////////////////////////////////////////////////
+			////////////////////////////////////////////////////////
+			// Put pulses in event
+			switch frame.typeOfFrame {
+			case FirstFrameOfCluster:
+				event.Clusters[iCluster].Pulses[0] = *pulse0
+				event.Clusters[iCluster].Pulses[1] = *pulse1
+			case SecondFrameOfCluster:
+				event.Clusters[iCluster].Pulses[2] = *pulse0
+				event.Clusters[iCluster].Pulses[3] = *pulse1
 			}
-		} else { // switched to next event
-			r.firstFrameOfEvent = frame
-			return event, true
+			////////////////////////////////////////////////////////
 		}
-		r.evtIDPrevFrame = evtID
-	} // end of loop over frames
-	log.Fatalf("error ! you should never end up here")
-	return nil, false
+	}
+	return event
+}
+
+// ReadNextEvent reads the next event.
+// At the end of the stream, it returns a nil event and io.EOF.
+func (r *Reader) ReadNextEvent() (*event.Event, error) {
+	raw, err := r.ReadNextRawEvent()
+	if raw == nil {
+		return nil, err
+	}
+	return r.BuildEvent(raw), nil
 }
 
 func (r *Reader) ReadNextEventFull() (*event.Event, bool) {
-	event := event.NewEvent(dpgadetector.Det.NoClusters())
+	event := event.NewEvent(int(dpgadetector.Det.NoClusters()), int(dpgadetector.Det.NoClustersWoData()))
 	for iCluster := uint8(0); iCluster < uint8(event.NoClusters()); iCluster++ {
 		frame1, err := r.Frame()
 		if err != nil {
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
 )
 
 func main() {
@@ -35,7 +36,7 @@ func main() {
 		log.Fatalf("could not create data file: %v\n", err)
 	}
 	defer filew.Close()
-	r, err := rw.NewReader(bufio.NewReader(filew), hdrType)
+	r, err := rw.NewReader(filew, hdrType)
 	if err != nil {
 		log.Fatalf("could not open stream: %v\n", err)
 	}
@@ -62,6 +63,19 @@ func main() {
 		log.Fatalf("error writing header: %v\n", err)
 	}
 
+	if *firstEvt > 0 {
+		// Jump directly to the first event if the file is indexed,
+		// otherwise events before firstEvt are skipped in the frame loop
+		idx, err := evtindex.ReadFor(*fileName)
+		if err == nil {
+			r.SetIndex(idx)
+			err = r.Seek(uint64(*firstEvt))
+		}
+		if err != nil {
+			log.Printf("could not seek to event %v (%v), skipping events\n", *firstEvt, err)
+		}
+	}
+
 	nFrames := uint(0)
 	evtIDprev := float64(-1)
 	for {
@@ -0,0 +1,40 @@
+package trees
+
+import (
+	"reflect"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rootio"
+)
+
+// Extra holds the values of the extra pulse variables of a tree entry
+// (see pulse.ExtraVars), written in addition to the default content of the tree.
+// It is used by the trees of the dpga, dpgatca and rct packages.
+type Extra struct {
+	vars []pulse.Var
+	vals []reflect.Value // one [noPulsesMax]float64 array per variable
+}
+
+// NewExtra declares a branch for each variable of pulse.ExtraVars, holding its
+// values for up to noPulsesMax pulses. count is the name of the branch giving
+// the number of pulses, or the number of pulses itself for fixed size branches.
+// An error is returned if a variable has the name of a branch already declared.
+func NewExtra(b rootio.Brancher, count string, noPulsesMax int) (*Extra, error) {
+	x := &Extra{vars: append([]pulse.Var(nil), pulse.ExtraVars...)}
+	typ := reflect.ArrayOf(noPulsesMax, reflect.TypeOf(float64(0)))
+	for _, v := range x.vars {
+		arr := reflect.New(typ)
+		if err := b.Branch(v.Name, arr.Interface(), v.Name+"["+count+"]/D"); err != nil {
+			return nil, err
+		}
+		x.vals = append(x.vals, arr.Elem())
+	}
+	return x, nil
+}
+
+// Fill computes the extra variables of the pulse of index i in the entry.
+func (x *Extra) Fill(i int, p *pulse.Pulse) {
+	for k, v := range x.vars {
+		x.vals[k].Index(i).SetFloat(v.Func(p))
+	}
+}
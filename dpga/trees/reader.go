@@ -0,0 +1,289 @@
+package trees
+
+import (
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rf"
+	"gitlab.in2p3.fr/avirm/analysis-go/rootio"
+)
+
+// TreeReader reads back the files written by Tree.
+type TreeReader struct {
+	data ROOTData
+	tree *rootio.Reader
+}
+
+// NewTreeReader returns a reader of the file rootfileName, written by Tree.
+func NewTreeReader(rootfileName string) (*TreeReader, error) {
+	tree, err := rootio.NewReader(rootfileName, "tree")
+	if err != nil {
+		return nil, err
+	}
+	r := &TreeReader{tree: tree}
+	if err := rootio.Branches(r.tree, &r.data); err != nil {
+		r.tree.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Read reads the events of the tree in order and calls f for each of them
+// (see ROOTData.Event). Reading stops at the first error returned by f.
+func (r *TreeReader) Read(f func(run uint32, e *event.Event) error) error {
+	return r.tree.Read(func(int64) error {
+		return f(r.data.Run, r.data.Event())
+	})
+}
+
+// NoEvents returns the number of events in the tree.
+func (r *TreeReader) NoEvents() int64 {
+	return r.tree.Entries()
+}
+
+func (r *TreeReader) Close() error {
+	return r.tree.Close()
+}
+
+// Event returns the event corresponding to the data of an entry of the tree.
+// Only the pulses with signal, which are the ones stored in the tree, are filled,
+// with their features, together with the LORs and the RF signal.
+func (d *ROOTData) Event() *event.Event {
+	e := newEvent(d.Evt, d.TimeStamp, d.SampleTimes[:], d.PulseRF[:])
+	pulses := make([]*pulse.Pulse, d.NoPulses)
+	for i := range pulses {
+		p := makePulse(e, d.IChanAbs240[i], d.SampleTimes[:], d.Pulse[i][:])
+		p.E = d.E[i]
+		p.Ampl = d.Ampl[i]
+		p.HasSatSignal = d.Sat[i] != 0
+		p.Charg = d.Charge[i]
+		p.Time10 = d.T10[i]
+		p.Time20 = d.T20[i]
+		p.Time30 = d.T30[i]
+		p.Time80 = d.T80[i]
+		p.Time90 = d.T90[i]
+		p.TimeFall20 = d.Tf20[i]
+		p.NoLocMaxRisingFront = int(d.NoLocMaxRisingFront[i])
+		pulses[i] = p
+	}
+	for i := 0; i < int(d.NoLORs); i++ {
+		e.LORs = append(e.LORs, event.LOR{
+			Pulses:     [2]*pulse.Pulse{pulses[d.LORIdx1[i]], pulses[d.LORIdx2[i]]},
+			Idx1:       int(d.LORIdx1[i]),
+			Idx2:       int(d.LORIdx2[i]),
+			TMean:      d.LORTMean[i],
+			TRF:        d.LORTRF[i],
+			Xmar:       d.LORXmar[i],
+			Ymar:       d.LORYmar[i],
+			Zmar:       d.LORZmar[i],
+			Rmar:       d.LORRmar[i],
+			NormWeight: d.LORNormWeight[i],
+			AttCorr:    d.LORAttCorr[i],
+			RFClass:    rf.Class(d.LORRFClass[i]),
+		})
+	}
+	return e
+}
+
+// TreeLORReader reads back the files written by TreeLOR.
+type TreeLORReader struct {
+	data ROOTDataLOR
+	tree *rootio.Reader
+}
+
+// NewTreeLORReader returns a reader of the file rootfileName, written by TreeLOR.
+func NewTreeLORReader(rootfileName string) (*TreeLORReader, error) {
+	tree, err := rootio.NewReader(rootfileName, "tree")
+	if err != nil {
+		return nil, err
+	}
+	r := &TreeLORReader{tree: tree}
+	if err := rootio.Branches(r.tree, &r.data); err != nil {
+		r.tree.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Read reads the events of the tree in order and calls f for each of them
+// (see ROOTDataLOR.Event). Reading stops at the first error returned by f.
+func (r *TreeLORReader) Read(f func(run uint32, e *event.Event) error) error {
+	return r.tree.Read(func(int64) error {
+		return f(r.data.Run, r.data.Event())
+	})
+}
+
+// NoEvents returns the number of events in the tree.
+func (r *TreeLORReader) NoEvents() int64 {
+	return r.tree.Entries()
+}
+
+func (r *TreeLORReader) Close() error {
+	return r.tree.Close()
+}
+
+// Event returns the event corresponding to the data of an entry of the tree.
+// Only the pulses belonging to a LOR, which are the ones stored in the tree,
+// are filled, with their features, together with the LORs and the RF signal.
+func (d *ROOTDataLOR) Event() *event.Event {
+	e := newEvent(d.Evt, d.TimeStamp, d.SampleTimes[:], d.PulseRF[:])
+	pulses := make([]*pulse.Pulse, d.NoPulses)
+	for i := range pulses {
+		p := makePulse(e, d.IChanAbs240[i], d.SampleTimes[:], d.Pulse[i][:])
+		p.E = d.E[i]
+		p.Ampl = d.Ampl[i]
+		p.HasSatSignal = d.Sat[i] != 0
+		p.Charg = d.Charge[i]
+		p.Time10 = d.T10[i]
+		p.Time20 = d.T20[i]
+		p.Time30 = d.T30[i]
+		p.Time80 = d.T80[i]
+		p.Time90 = d.T90[i]
+		p.TimeFall20 = d.Tf20[i]
+		p.NoLocMaxRisingFront = int(d.NoLocMaxRisingFront[i])
+		pulses[i] = p
+	}
+	for i := 0; i < int(d.NoLORs); i++ {
+		e.LORs = append(e.LORs, event.LOR{
+			Pulses:     [2]*pulse.Pulse{pulses[d.LORIdx1[i]], pulses[d.LORIdx2[i]]},
+			Idx1:       int(d.LORIdx1[i]),
+			Idx2:       int(d.LORIdx2[i]),
+			TMean:      d.LORTMean[i],
+			TRF:        d.LORTRF[i],
+			Xmar:       d.LORXmar[i],
+			Ymar:       d.LORYmar[i],
+			Zmar:       d.LORZmar[i],
+			Rmar:       d.LORRmar[i],
+			NormWeight: d.LORNormWeight[i],
+			AttCorr:    d.LORAttCorr[i],
+			RFClass:    rf.Class(d.LORRFClass[i]),
+		})
+	}
+	return e
+}
+
+// TreeMult2Reader reads back the files written by TreeMult2.
+type TreeMult2Reader struct {
+	data ROOTDataMult2
+	tree *rootio.Reader
+}
+
+// NewTreeMult2Reader returns a reader of the file rootfileName, written by TreeMult2.
+func NewTreeMult2Reader(rootfileName string) (*TreeMult2Reader, error) {
+	tree, err := rootio.NewReader(rootfileName, "tree")
+	if err != nil {
+		return nil, err
+	}
+	r := &TreeMult2Reader{tree: tree}
+	if err := rootio.Branches(r.tree, &r.data); err != nil {
+		r.tree.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Read reads the events of the tree in order and calls f for each of them
+// (see ROOTDataMult2.Event). Reading stops at the first error returned by f.
+func (r *TreeMult2Reader) Read(f func(run uint32, e *event.Event) error) error {
+	return r.tree.Read(func(int64) error {
+		return f(r.data.Run, r.data.Event())
+	})
+}
+
+// NoEvents returns the number of events in the tree.
+func (r *TreeMult2Reader) NoEvents() int64 {
+	return r.tree.Entries()
+}
+
+func (r *TreeMult2Reader) Close() error {
+	return r.tree.Close()
+}
+
+// Event returns the event corresponding to the data of an entry of the tree.
+// The two 511 keV pulses, with their features, and the RF signal are filled.
+// The LOR made of the two pulses holds the result of the minimal reconstruction
+// and the RF information of the tree.
+func (d *ROOTDataMult2) Event() *event.Event {
+	e := newEvent(d.Evt, d.TimeStamp, d.SampleTimes[:], d.PulseRF[:])
+	var pulses [2]*pulse.Pulse
+	for i := range pulses {
+		p := makePulse(e, d.IChanAbs240[i], d.SampleTimes[:], d.Pulse[i][:])
+		p.E = d.E[i]
+		p.Ampl = d.Ampl[i]
+		p.HasSatSignal = d.Sat[i] != 0
+		p.Charg = d.Charge[i]
+		p.Time10 = d.T10[i]
+		p.Time20 = d.T20[i]
+		p.Time30 = d.T30[i]
+		p.Time80 = d.T80[i]
+		p.Time90 = d.T90[i]
+		p.TimeFall20 = d.Tf20[i]
+		p.NoLocMaxRisingFront = int(d.NoLocMaxRisingFront[i])
+		pulses[i] = p
+	}
+	e.LORs = append(e.LORs, event.LOR{
+		Pulses:  pulses,
+		Idx1:    0,
+		Idx2:    1,
+		TMean:   (pulses[0].Time30 + pulses[1].Time30) / 2.,
+		TRF:     d.TRF,
+		Xmar:    d.Xmar,
+		Ymar:    d.Ymar,
+		Zmar:    d.Zmar,
+		Rmar:    d.Rmar,
+		RFClass: rf.Class(d.RFClass),
+	})
+	return e
+}
+
+// newEvent returns an event with no pulse, with the sampled RF signal pulseRF if available.
+// The timestamp is stored in the counters too, as in DPGA files.
+func newEvent(evt uint32, timeStamp uint64, sampleTimes, pulseRF []float64) *event.Event {
+	e := event.NewEvent(int(dpgadetector.Det.NoClusters()), int(dpgadetector.Det.NoClustersWoData()))
+	e.ID = uint(evt)
+	e.TimeStamp = timeStamp
+	e.Counters = make([]uint32, rw.NumCounters)
+	e.Counters[2] = uint32(timeStamp)
+	e.Counters[3] = uint32(timeStamp >> 32)
+	for _, amp := range pulseRF {
+		if amp != 0 {
+			e.ClustersWoData[0].Pulses[0].Samples = makeSamples(sampleTimes, pulseRF)
+			e.FitRF()
+			break
+		}
+	}
+	return e
+}
+
+// makePulse fills the pulse of the channel iChanAbs240 of e, with signal and
+// the given amplitudes, and returns it.
+func makePulse(e *event.Event, iChanAbs240 uint16, sampleTimes, amplitudes []float64) *pulse.Pulse {
+	iCluster := uint8(iChanAbs240 / 4)
+	cluster := &e.Clusters[iCluster]
+	cluster.ID = iCluster
+	cluster.Quartet = dpgadetector.Det.QuartetFromIdAbs60(iCluster)
+	e.ClusterIsFilled[iCluster] = true
+
+	p := &cluster.Pulses[iChanAbs240%4]
+	*p = *pulse.NewPulse(dpgadetector.Det.ChannelFromIdAbs240(iChanAbs240))
+	p.HasSignal = true
+	p.Samples = makeSamples(sampleTimes, amplitudes)
+	if len(p.Samples) > 1 {
+		p.TimeStep = p.Samples[1].Time - p.Samples[0].Time
+	}
+	return p
+}
+
+// makeSamples returns the samples of a pulse, as many as the number of samples of the detector.
+func makeSamples(sampleTimes, amplitudes []float64) []pulse.Sample {
+	n := dpgadetector.Det.NoSamples()
+	if n <= 0 || n > len(sampleTimes) {
+		n = len(sampleTimes)
+	}
+	samples := make([]pulse.Sample, n)
+	for j := range samples {
+		samples[j] = *pulse.NewSample(amplitudes[j], uint16(j), sampleTimes[j])
+	}
+	return samples
+}
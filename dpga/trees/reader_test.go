@@ -0,0 +1,228 @@
+package trees
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+)
+
+const testNoEvents = 3
+
+var testHeader = &rw.Header{TimeStart: 1500000000}
+
+// tempFile returns the name of a file in a new temporary directory, and the function removing it.
+func tempFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "trees-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v\n", err)
+	}
+	return filepath.Join(dir, "tree.root"), func() { os.RemoveAll(dir) }
+}
+
+// checkPulse compares the pulse read back from a tree with the pulse written.
+func checkPulse(t *testing.T, name string, got, want *pulse.Pulse) {
+	if got.Channel.AbsID240() != want.Channel.AbsID240() {
+		t.Errorf("%v: channel = %v, want %v", name, got.Channel.AbsID240(), want.Channel.AbsID240())
+		return
+	}
+	if !got.HasSignal {
+		t.Errorf("%v: pulse without signal", name)
+	}
+	if got.E != want.E || got.Ampl != want.Ampl || got.Charg != want.Charg ||
+		got.Time10 != want.Time10 || got.Time20 != want.Time20 || got.Time30 != want.Time30 ||
+		got.Time80 != want.Time80 || got.Time90 != want.Time90 || got.TimeFall20 != want.TimeFall20 ||
+		got.NoLocMaxRisingFront != want.NoLocMaxRisingFront {
+		t.Errorf("%v: features differ:\ngot = %+v\nwant= %+v", name, got, want)
+	}
+	if len(got.Samples) != len(want.Samples) {
+		t.Errorf("%v: %v samples, want %v", name, len(got.Samples), len(want.Samples))
+		return
+	}
+	for j := range got.Samples {
+		if got.Samples[j].Amplitude != want.Samples[j].Amplitude || got.Samples[j].Time != want.Samples[j].Time {
+			t.Errorf("%v: sample %v = (%v, %v), want (%v, %v)", name, j,
+				got.Samples[j].Time, got.Samples[j].Amplitude, want.Samples[j].Time, want.Samples[j].Amplitude)
+			return
+		}
+	}
+}
+
+// checkLOR compares the LOR read back from a tree with the LOR written, apart from the pulse indices.
+func checkLOR(t *testing.T, name string, got, want *event.LOR) {
+	if got.TMean != want.TMean || got.TRF != want.TRF || got.Xmar != want.Xmar || got.Ymar != want.Ymar ||
+		got.Zmar != want.Zmar || got.Rmar != want.Rmar || got.NormWeight != want.NormWeight ||
+		got.AttCorr != want.AttCorr || got.RFClass != want.RFClass {
+		t.Errorf("%v: differ:\ngot = %+v\nwant= %+v", name, got, want)
+	}
+	for k := range got.Pulses {
+		checkPulse(t, fmt.Sprintf("%v, pulse %v", name, k), got.Pulses[k], want.Pulses[k])
+	}
+}
+
+func TestTreeReader(t *testing.T) {
+	fileName, remove := tempFile(t)
+	defer remove()
+
+	tree := NewTree(fileName)
+	for i := uint32(0); i < testNoEvents; i++ {
+		tree.Fill(37020, testHeader, testEvent(i))
+	}
+	tree.Close()
+
+	r, err := NewTreeReader(fileName)
+	if err != nil {
+		t.Fatalf("could not open tree: %v\n", err)
+	}
+	defer r.Close()
+	if n := r.NoEvents(); n != testNoEvents {
+		t.Fatalf("NoEvents = %v, want %v", n, testNoEvents)
+	}
+	noEvents := 0
+	err = r.Read(func(run uint32, e *event.Event) error {
+		want := testEvent(uint32(noEvents))
+		noEvents++
+		if run != 37020 || e.ID != want.ID || e.TimeStamp != want.TimeStamp {
+			t.Errorf("event %v: run=%v id=%v timestamp=%v, want %v %v %v", want.ID, run, e.ID, e.TimeStamp, 37020, want.ID, want.TimeStamp)
+		}
+		_, gotPulses, _, _, _, _ := e.Multiplicity()
+		_, wantPulses, _, _, _, _ := want.Multiplicity()
+		if len(gotPulses) != len(wantPulses) {
+			t.Fatalf("event %v: %v pulses, want %v", want.ID, len(gotPulses), len(wantPulses))
+		}
+		for i := range gotPulses {
+			checkPulse(t, fmt.Sprintf("pulse %v", i), gotPulses[i], wantPulses[i])
+		}
+		if len(e.LORs) != len(want.LORs) {
+			t.Fatalf("event %v: %v LORs, want %v", want.ID, len(e.LORs), len(want.LORs))
+		}
+		for i := range e.LORs {
+			got, want := &e.LORs[i], &want.LORs[i]
+			if got.Idx1 != want.Idx1 || got.Idx2 != want.Idx2 {
+				t.Errorf("LOR %v: indices = (%v, %v), want (%v, %v)", i, got.Idx1, got.Idx2, want.Idx1, want.Idx2)
+			}
+			if got.Pulses[0] != gotPulses[got.Idx1] || got.Pulses[1] != gotPulses[got.Idx2] {
+				t.Errorf("LOR %v: pulses are not the pulses of the event", i)
+			}
+			checkLOR(t, fmt.Sprintf("LOR %v", i), got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error reading tree: %v\n", err)
+	}
+	if noEvents != testNoEvents {
+		t.Fatalf("read %v events, want %v", noEvents, testNoEvents)
+	}
+}
+
+func TestTreeLORReader(t *testing.T) {
+	fileName, remove := tempFile(t)
+	defer remove()
+
+	tree := NewTreeLOR(fileName)
+	for i := uint32(0); i < testNoEvents; i++ {
+		tree.Fill(37020, testHeader, testEvent(i))
+	}
+	tree.Close()
+
+	r, err := NewTreeLORReader(fileName)
+	if err != nil {
+		t.Fatalf("could not open tree: %v\n", err)
+	}
+	defer r.Close()
+	if n := r.NoEvents(); n != testNoEvents {
+		t.Fatalf("NoEvents = %v, want %v", n, testNoEvents)
+	}
+	noEvents := 0
+	err = r.Read(func(run uint32, e *event.Event) error {
+		want := testEvent(uint32(noEvents))
+		noEvents++
+		if run != 37020 || e.ID != want.ID || e.TimeStamp != want.TimeStamp {
+			t.Errorf("event %v: run=%v id=%v timestamp=%v, want %v %v %v", want.ID, run, e.ID, e.TimeStamp, 37020, want.ID, want.TimeStamp)
+		}
+		// all the pulses of the test events belong to a LOR
+		if n, _, _, _, _, _ := e.Multiplicity(); int(n) != len(testChans) {
+			t.Errorf("event %v: %v pulses, want %v", want.ID, n, len(testChans))
+		}
+		if len(e.LORs) != len(want.LORs) {
+			t.Fatalf("event %v: %v LORs, want %v", want.ID, len(e.LORs), len(want.LORs))
+		}
+		for i := range e.LORs {
+			checkLOR(t, fmt.Sprintf("LOR %v", i), &e.LORs[i], &want.LORs[i])
+		}
+		// pulses shared by LORs are stored once
+		if e.LORs[0].Pulses[1] != e.LORs[2].Pulses[0] || e.LORs[1].Pulses[0] != e.LORs[2].Pulses[1] {
+			t.Errorf("event %v: shared pulses are not shared", want.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error reading tree: %v\n", err)
+	}
+	if noEvents != testNoEvents {
+		t.Fatalf("read %v events, want %v", noEvents, testNoEvents)
+	}
+}
+
+func TestTreeMult2Reader(t *testing.T) {
+	fileName, remove := tempFile(t)
+	defer remove()
+
+	tree := NewTreeMult2(fileName)
+	for i := uint32(0); i < testNoEvents; i++ {
+		e := testEvent(i)
+		tree.Fill(37020, testHeader, e, e.LORs[0].Pulses[0], e.LORs[0].Pulses[1])
+	}
+	tree.Close()
+
+	r, err := NewTreeMult2Reader(fileName)
+	if err != nil {
+		t.Fatalf("could not open tree: %v\n", err)
+	}
+	defer r.Close()
+	if n := r.NoEvents(); n != testNoEvents {
+		t.Fatalf("NoEvents = %v, want %v", n, testNoEvents)
+	}
+	noEvents := 0
+	err = r.Read(func(run uint32, e *event.Event) error {
+		want := testEvent(uint32(noEvents))
+		noEvents++
+		if run != 37020 || e.ID != want.ID || e.TimeStamp != want.TimeStamp {
+			t.Errorf("event %v: run=%v id=%v timestamp=%v, want %v %v %v", want.ID, run, e.ID, e.TimeStamp, 37020, want.ID, want.TimeStamp)
+		}
+		if n, _, _, _, _, _ := e.Multiplicity(); n != 2 {
+			t.Errorf("event %v: %v pulses, want 2", want.ID, n)
+		}
+		if len(e.LORs) != 1 {
+			t.Fatalf("event %v: %v LORs, want 1", want.ID, len(e.LORs))
+		}
+		lor := &e.LORs[0]
+		for k := range lor.Pulses {
+			checkPulse(t, fmt.Sprintf("pulse %v", k), lor.Pulses[k], want.LORs[0].Pulses[k])
+		}
+		if tMean := (lor.Pulses[0].Time30 + lor.Pulses[1].Time30) / 2; lor.TMean != tMean {
+			t.Errorf("event %v: TMean = %v, want %v", want.ID, lor.TMean, tMean)
+		}
+		// the pulses are on different hemispheres: the minimal reconstruction is done
+		if rmar := math.Sqrt(lor.Xmar*lor.Xmar + lor.Ymar*lor.Ymar); lor.Rmar != rmar || rmar == 0 {
+			t.Errorf("event %v: Rmar = %v, want %v (non zero)", want.ID, lor.Rmar, rmar)
+		}
+		if lor.TRF != -1 {
+			t.Errorf("event %v: TRF = %v without RF, want -1", want.ID, lor.TRF)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error reading tree: %v\n", err)
+	}
+	if noEvents != testNoEvents {
+		t.Fatalf("read %v events, want %v", noEvents, testNoEvents)
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"fmt"
 	"log"
 
-	"go-hep.org/x/hep/croot"
 	//"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/rootio"
 	"gitlab.in2p3.fr/avirm/analysis-go/utils"
 )
 
@@ -54,144 +54,73 @@ type ROOTData struct {
 	RateLvsL6   float64
 	RateLvsL7   float64
 
-	NoPulses  int32
-	TestSlice [NoPulsesMax]float64
-
-	IChanAbs240         [NoPulsesMax]uint16
-	IQuartetAbs60       [NoPulsesMax]uint8
-	ILineAbs12          [NoPulsesMax]uint8
-	IHemi               [NoPulsesMax]uint8
-	E                   [NoPulsesMax]float64
-	Ampl                [NoPulsesMax]float64
-	Sat                 [NoPulsesMax]uint8
-	Charge              [NoPulsesMax]float64
-	T10                 [NoPulsesMax]float64
-	T20                 [NoPulsesMax]float64
-	T30                 [NoPulsesMax]float64
-	T80                 [NoPulsesMax]float64
-	T90                 [NoPulsesMax]float64
-	Tf20                [NoPulsesMax]float64
-	NoLocMaxRisingFront [NoPulsesMax]uint16
+	NoPulses            int32
+	IChanAbs240         [NoPulsesMax]uint16  `rootio:"count=NoPulses"`
+	IQuartetAbs60       [NoPulsesMax]uint8   `rootio:"count=NoPulses"`
+	ILineAbs12          [NoPulsesMax]uint8   `rootio:"count=NoPulses"`
+	IHemi               [NoPulsesMax]uint8   `rootio:"count=NoPulses"`
+	E                   [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Ampl                [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Sat                 [NoPulsesMax]uint8   `rootio:"count=NoPulses"`
+	Charge              [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	T10                 [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	T20                 [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	T30                 [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	T80                 [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	T90                 [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Tf20                [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	NoLocMaxRisingFront [NoPulsesMax]uint16  `rootio:"count=NoPulses"`
 	SampleTimes         [999]float64
-	Pulse               [NoPulsesMax][999]float64
+	Pulse               [NoPulsesMax][999]float64 `rootio:"count=NoPulses"`
 	PulseRF             [999]float64
-	X                   [NoPulsesMax]float64
-	Y                   [NoPulsesMax]float64
-	Z                   [NoPulsesMax]float64
-	Xc                  [NoPulsesMax]float64
-	Yc                  [NoPulsesMax]float64
-	Zc                  [NoPulsesMax]float64
+	X                   [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Y                   [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Z                   [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Xc                  [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Yc                  [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Zc                  [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	RFTime              [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	RFFreq              float64
+	RFAmpl              float64
 	NoLORs              int32
-	LORIdx1             [NoLORsMax]int32
-	LORIdx2             [NoLORsMax]int32
-	LORTMean            [NoLORsMax]float64
-	LORTRF              [NoLORsMax]float64
-	LORXmar             [NoLORsMax]float64
-	LORYmar             [NoLORsMax]float64
-	LORZmar             [NoLORsMax]float64
-	LORRmar             [NoLORsMax]float64
+	LORIdx1             [NoLORsMax]int32   `rootio:"count=NoLORs"`
+	LORIdx2             [NoLORsMax]int32   `rootio:"count=NoLORs"`
+	LORTMean            [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORTRF              [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORXmar             [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORYmar             [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORZmar             [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORRmar             [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORNormWeight       [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORAttCorr          [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORRFClass          [NoLORsMax]uint8   `rootio:"count=NoLORs"`
 }
 
 type Tree struct {
-	data ROOTData
-	file croot.File
-	tree croot.Tree
+	data  ROOTData
+	tree  *rootio.Tree
+	extra *Extra
 }
 
 func NewTree(outrootfileName string) *Tree {
-	f, err := croot.OpenFile(outrootfileName, "recreate", "ROOT file with event information", 1, 0)
+	tree, err := rootio.NewTree(outrootfileName, "tree", "tree")
+	if err != nil {
+		panic(err)
+	}
+	t := Tree{tree: tree}
+	if err := rootio.Branches(t.tree, &t.data); err != nil {
+		panic(err)
+	}
+	t.extra, err = NewExtra(t.tree, "NoPulses", NoPulsesMax)
 	if err != nil {
 		panic(err)
 	}
-	t := Tree{file: f, tree: croot.NewTree("tree", "tree", 32)}
-	const bufsiz = 32000
-	/*
-		_, err = t.tree.Branch("data", &t.data, bufsiz, 0)
-		if err != nil {
-			panic(err)
-		}
-	*/
-
-	_, err = t.tree.Branch2("Run", &t.data.Run, "Run/i", bufsiz)
-	_, err = t.tree.Branch2("Evt", &t.data.Evt, "Evt/i", bufsiz)
-	_, err = t.tree.Branch2("T0", &t.data.T0, "T0/i", bufsiz)
-	_, err = t.tree.Branch2("TimeStamp", &t.data.TimeStamp, "TimeStamp/l", bufsiz)
-	_, err = t.tree.Branch2("RateBoard1", &t.data.RateBoard1, "RateBoard1/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard2", &t.data.RateBoard2, "RateBoard2/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard3", &t.data.RateBoard3, "RateBoard3/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard4", &t.data.RateBoard4, "RateBoard4/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard5", &t.data.RateBoard5, "RateBoard5/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard6", &t.data.RateBoard6, "RateBoard6/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard7", &t.data.RateBoard7, "RateBoard7/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard8", &t.data.RateBoard8, "RateBoard8/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard9", &t.data.RateBoard9, "RateBoard9/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard10", &t.data.RateBoard10, "RateBoard10/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard11", &t.data.RateBoard11, "RateBoard11/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard12", &t.data.RateBoard12, "RateBoard12/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR1", &t.data.RateLvsR1, "RateLvsR1/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR2", &t.data.RateLvsR2, "RateLvsR2/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR3", &t.data.RateLvsR3, "RateLvsR3/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR4", &t.data.RateLvsR4, "RateLvsR4/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR5", &t.data.RateLvsR5, "RateLvsR5/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR6", &t.data.RateLvsR6, "RateLvsR6/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR7", &t.data.RateLvsR7, "RateLvsR7/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L1", &t.data.RateLvs3L1, "RateLvs3L1/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L2", &t.data.RateLvs3L2, "RateLvs3L2/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L3", &t.data.RateLvs3L3, "RateLvs3L3/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L4", &t.data.RateLvs3L4, "RateLvs3L4/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L5", &t.data.RateLvs3L5, "RateLvs3L5/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L6", &t.data.RateLvs3L6, "RateLvs3L6/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L7", &t.data.RateLvs3L7, "RateLvs3L7/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL1", &t.data.RateLvsL1, "RateLvsL1/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL2", &t.data.RateLvsL2, "RateLvsL2/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL3", &t.data.RateLvsL3, "RateLvsL3/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL4", &t.data.RateLvsL4, "RateLvsL4/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL5", &t.data.RateLvsL5, "RateLvsL5/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL6", &t.data.RateLvsL6, "RateLvsL6/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL7", &t.data.RateLvsL7, "RateLvsL7/D", bufsiz)
-
-	// 	_, err = t.tree.Branch2("TestSize", &t.data.TestSize, "TestSize/L", bufsiz)
-	// 	_, err = t.tree.Branch2("TestSlice", &t.data.TestSlice, "TestSlice[TestSize]/D", bufsiz)
-	_, err = t.tree.Branch2("NoPulses", &t.data.NoPulses, "NoPulses/I", bufsiz)
-	_, err = t.tree.Branch2("IChanAbs240", &t.data.IChanAbs240, "IChanAbs240[NoPulses]/s", bufsiz)
-	_, err = t.tree.Branch2("IQuartetAbs60", &t.data.IQuartetAbs60, "IQuartetAbs60[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("ILineAbs12", &t.data.ILineAbs12, "ILineAbs12[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("IHemi", &t.data.IHemi, "IHemi[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("E", &t.data.E, "E[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Ampl", &t.data.Ampl, "Ampl[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Sat", &t.data.Sat, "Sat[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("Charge", &t.data.Charge, "Charge[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T10", &t.data.T10, "T10[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T20", &t.data.T20, "T20[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T30", &t.data.T30, "T30[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T80", &t.data.T80, "T80[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T90", &t.data.T90, "T90[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Tf20", &t.data.Tf20, "Tf20[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("NoLocMaxRisingFront", &t.data.NoLocMaxRisingFront, "NoLocMaxRisingFront[NoPulses]/s", bufsiz)
-	_, err = t.tree.Branch2("SampleTimes", &t.data.SampleTimes, "SampleTimes[999]/D", bufsiz)
-	_, err = t.tree.Branch2("Pulse", &t.data.Pulse, "Pulse[NoPulses][999]/D", bufsiz)
-	_, err = t.tree.Branch2("PulseRF", &t.data.PulseRF, "PulseRF[999]/D", bufsiz)
-	_, err = t.tree.Branch2("X", &t.data.X, "X[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Y", &t.data.Y, "Y[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Z", &t.data.Z, "Z[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Xc", &t.data.Xc, "Xc[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Yc", &t.data.Yc, "Yc[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Zc", &t.data.Zc, "Zc[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("NoLORs", &t.data.NoLORs, "NoLORs/I", bufsiz)
-	_, err = t.tree.Branch2("LORIdx1", &t.data.LORIdx1, "LORIdx1[NoLORs]/I", bufsiz)
-	_, err = t.tree.Branch2("LORIdx2", &t.data.LORIdx2, "LORIdx2[NoLORs]/I", bufsiz)
-	_, err = t.tree.Branch2("LORTMean", &t.data.LORTMean, "LORTMean[NoLORs]/D", bufsiz)
-	_, err = t.tree.Branch2("LORTRF", &t.data.LORTRF, "LORTRF[NoLORs]/D", bufsiz)
-	_, err = t.tree.Branch2("LORXmar", &t.data.LORXmar, "LORXmar[NoLORs]/D", bufsiz)
-	_, err = t.tree.Branch2("LORYmar", &t.data.LORYmar, "LORYmar[NoLORs]/D", bufsiz)
-	_, err = t.tree.Branch2("LORZmar", &t.data.LORZmar, "LORZmar[NoLORs]/D", bufsiz)
-	_, err = t.tree.Branch2("LORRmar", &t.data.LORRmar, "LORRmar[NoLORs]/D", bufsiz)
-
-	//t.data.Pulse[0] = make([]float64, dpgadetector.Det.NoSamples())
-	//t.data.Pulse[1] = make([]float64, dpgadetector.Det.NoSamples())
 	return &t
 }
 
+// Fill fills the tree with the pulses with signal and the LORs of the event.
+// Pulse features, RF fit and LORs are not computed here: the event must have
+// been processed by pipeline.Features and pipeline.DefaultLORs.
 func (t *Tree) Fill(run uint32, hdr *rw.Header, event *event.Event) {
 	t.data.Run = run
 	t.data.Evt = uint32(event.ID)
@@ -232,16 +161,18 @@ func (t *Tree) Fill(run uint32, hdr *rw.Header, event *event.Event) {
 		t.data.RateLvsL6 = float64(event.Counters[28]) * 64e6 / float64(event.Counters[0])
 		t.data.RateLvsL7 = float64(event.Counters[29]) * 64e6 / float64(event.Counters[0])
 	}
-	///////////////////////////////////////////
-	// TRF calculation
-	//utils.FindIntersections(event.ClustersWoData[11].Pulses[3], min, max)
-	///////////////////////////////////////////
-	noPulses, pulses, _ := event.Multiplicity()
+	// RF
+	t.data.RFFreq, t.data.RFAmpl = 0, 0
+	if event.RF != nil {
+		t.data.RFFreq = event.RF.Freq
+		t.data.RFAmpl = event.RF.Ampl
+	}
+
+	noPulses, pulses, _, _, _, _ := event.Multiplicity()
 	t.data.NoPulses = int32(noPulses)
 	for i := range pulses {
 		pulse := pulses[i]
-		pulse.CalcRisingFront(true)
-		pulse.CalcFallingFront(false)
+		t.extra.Fill(i, pulse)
 		// 		fmt.Println("i=", i)
 		t.data.IChanAbs240[i] = uint16(pulse.Channel.AbsID240())
 		t.data.IQuartetAbs60[i] = dpgadetector.FifoID144ToQuartetAbsIdx60(pulse.Channel.FifoID144(), true)
@@ -258,6 +189,7 @@ func (t *Tree) Fill(run uint32, hdr *rw.Header, event *event.Event) {
 		t.data.T90[i] = pulse.Time90
 		t.data.Tf20[i] = pulse.TimeFall20
 		t.data.NoLocMaxRisingFront[i] = uint16(pulse.NoLocMaxRisingFront)
+		t.data.RFTime[i] = event.RFTime(pulse)
 		for j := range pulse.Samples {
 			t.data.SampleTimes[j] = pulse.Samples[j].Time
 			t.data.Pulse[i][j] = pulse.Samples[j].Amplitude
@@ -273,76 +205,41 @@ func (t *Tree) Fill(run uint32, hdr *rw.Header, event *event.Event) {
 		t.data.Zc[i] = pulse.Channel.CrystCenter.Z
 	}
 
-	// TRF stuff
-	ampSlice := event.ClustersWoData[0].Pulses[0].MakeAmpSlice()
-	var timesRF []float64
-	if len(ampSlice) != 0 { // can compute TRF
-		timesRF = utils.FindIntersections(event.ID, event.ClustersWoData[0].Pulses[0].MakeAmpSlice(), event.ClustersWoData[0].Pulses[0].MakeTimeSlice())
-	}
-
-	event.FindLORs(0, 0, 38., 3*1.2, 0, 1000, true) //511+3*28.3)
 	// 	fmt.Println("no lors: ", len(event.LORs))
 	t.data.NoLORs = int32(len(event.LORs))
-	if t.data.NoLORs < NoLORsMax {
-		for i := range event.LORs {
-			lor := &event.LORs[i]
-			if pulses[lor.Idx1] != lor.Pulses[0] || pulses[lor.Idx2] != lor.Pulses[1] {
-				fmt.Printf("%p %p %p %p\n", pulses[lor.Idx1], lor.Pulses[0], pulses[lor.Idx2], lor.Pulses[1])
-				log.Fatalf("pulses[lor.Idx1] != lor.Pulses[0] ||  pulses[lor.Idx2] != lor.Pulses[1]\n")
-			}
-			t.data.LORIdx1[i] = int32(lor.Idx1)
-			t.data.LORIdx2[i] = int32(lor.Idx2)
-			t.data.LORTMean[i] = lor.TMean
-			t.data.LORXmar[i] = lor.Xmar
-			t.data.LORYmar[i] = lor.Ymar
-			t.data.LORZmar[i] = lor.Zmar
-			t.data.LORRmar[i] = lor.Rmar
-
-			///////////////////////////////////////////
-			// TRF calculation
-			// 	for i := range event.ClustersWoData {
-			// 		cluster := &event.ClustersWoData[i]
-			// 		for j := range cluster.Pulses {
-			// 			pulse := &cluster.Pulses[j]
-			// 			fmt.Println(i, j, len(pulse.Samples))
-			// 		}
-			// 	}
-			if len(timesRF) > 0 {
-				if t.data.LORTMean[i] <= timesRF[0] {
-					//fmt.Println("here ", t.data.LORTMean[i], timesRF[0])
-					t.data.LORTRF[i] = timesRF[0] - 1/24.85e6*1e9 // 24.85 MHz is the HF frequency
-				} else if t.data.LORTMean[i] >= timesRF[len(timesRF)-1] {
-					t.data.LORTRF[i] = timesRF[len(timesRF)-1]
-				} else {
-					for j := range timesRF {
-						if j < len(timesRF)-1 {
-							if t.data.LORTMean[i] > timesRF[j] && t.data.LORTMean[i] < timesRF[j+1] {
-								t.data.LORTRF[i] = timesRF[j]
-								break
-							}
-						} else {
-							fmt.Println(timesRF)
-							log.Fatalf("This should not happen, tMean=%v\n", t.data.LORTMean[i])
-						}
-					}
-				}
-				if t.data.LORTMean[i]-t.data.LORTRF[i] > 1/24.85e6*1e9+3 {
-					fmt.Println(timesRF)
-					fmt.Println(t.data.LORTMean[i], t.data.LORTRF[i])
-					log.Fatalf("ERROR\n")
-				}
-			}
-			///////////////////////////////////////////
+	if t.data.NoLORs > NoLORsMax {
+		t.data.NoLORs = NoLORsMax
+	}
+	for i := range event.LORs {
+		if i >= NoLORsMax {
+			break
+		}
+		lor := &event.LORs[i]
+		if pulses[lor.Idx1] != lor.Pulses[0] || pulses[lor.Idx2] != lor.Pulses[1] {
+			fmt.Printf("%p %p %p %p\n", pulses[lor.Idx1], lor.Pulses[0], pulses[lor.Idx2], lor.Pulses[1])
+			log.Fatalf("pulses[lor.Idx1] != lor.Pulses[0] ||  pulses[lor.Idx2] != lor.Pulses[1]\n")
 		}
+		t.data.LORIdx1[i] = int32(lor.Idx1)
+		t.data.LORIdx2[i] = int32(lor.Idx2)
+		t.data.LORTMean[i] = lor.TMean
+		t.data.LORXmar[i] = lor.Xmar
+		t.data.LORYmar[i] = lor.Ymar
+		t.data.LORZmar[i] = lor.Zmar
+		t.data.LORRmar[i] = lor.Rmar
+		t.data.LORNormWeight[i] = lor.NormWeight
+		t.data.LORAttCorr[i] = lor.AttCorr
+		t.data.LORTRF[i] = lor.TRF
+		t.data.LORRFClass[i] = uint8(lor.RFClass)
 	}
 
-	_, err := t.tree.Fill()
+	err := t.tree.Fill()
 	if err != nil {
 		panic(err)
 	}
 }
 
 func (t *Tree) Close() {
-	t.file.Write("", 0, 0)
-	t.file.Close("")
+	if err := t.tree.Close(); err != nil {
+		panic(err)
+	}
 }
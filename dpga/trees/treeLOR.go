@@ -1,12 +1,12 @@
 package trees
 
 import (
-	"go-hep.org/x/hep/croot"
 	//"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rootio"
 	"gitlab.in2p3.fr/avirm/analysis-go/utils"
 )
 
@@ -53,138 +53,68 @@ type ROOTDataLOR struct {
 
 	NoPulses int32
 
-	IChanAbs240         [NoPulsesInLORMax]uint16
-	IQuartetAbs60       [NoPulsesInLORMax]uint8
-	ILineAbs12          [NoPulsesInLORMax]uint8
-	IHemi               [NoPulsesInLORMax]uint8
-	E                   [NoPulsesInLORMax]float64
-	Ampl                [NoPulsesInLORMax]float64
-	Sat                 [NoPulsesInLORMax]uint8
-	Charge              [NoPulsesInLORMax]float64
-	T10                 [NoPulsesInLORMax]float64
-	T20                 [NoPulsesInLORMax]float64
-	T30                 [NoPulsesInLORMax]float64
-	T80                 [NoPulsesInLORMax]float64
-	T90                 [NoPulsesInLORMax]float64
-	Tf20                [NoPulsesInLORMax]float64
-	NoLocMaxRisingFront [NoPulsesInLORMax]uint16
+	IChanAbs240         [NoPulsesInLORMax]uint16  `rootio:"count=NoPulses"`
+	IQuartetAbs60       [NoPulsesInLORMax]uint8   `rootio:"count=NoPulses"`
+	ILineAbs12          [NoPulsesInLORMax]uint8   `rootio:"count=NoPulses"`
+	IHemi               [NoPulsesInLORMax]uint8   `rootio:"count=NoPulses"`
+	E                   [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	Ampl                [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	Sat                 [NoPulsesInLORMax]uint8   `rootio:"count=NoPulses"`
+	Charge              [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	T10                 [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	T20                 [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	T30                 [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	T80                 [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	T90                 [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	Tf20                [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	NoLocMaxRisingFront [NoPulsesInLORMax]uint16  `rootio:"count=NoPulses"`
 	SampleTimes         [999]float64
-	Pulse               [NoPulsesInLORMax][999]float64
+	Pulse               [NoPulsesInLORMax][999]float64 `rootio:"count=NoPulses"`
 	PulseRF             [999]float64
-	X                   [NoPulsesInLORMax]float64
-	Y                   [NoPulsesInLORMax]float64
-	Z                   [NoPulsesInLORMax]float64
-	Xc                  [NoPulsesInLORMax]float64
-	Yc                  [NoPulsesInLORMax]float64
-	Zc                  [NoPulsesInLORMax]float64
+	X                   [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	Y                   [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	Z                   [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	Xc                  [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	Yc                  [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	Zc                  [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
+	RFTime              [NoPulsesInLORMax]float64 `rootio:"count=NoPulses"`
 	TRF                 float64
+	RFFreq              float64
+	RFAmpl              float64
 	NoLORs              int32
 	NoLORsMax           int32
-	LORIdx1             [NoLORsMax]int32
-	LORIdx2             [NoLORsMax]int32
-	LORTMean            [NoLORsMax]float64
-	LORTRF              [NoLORsMax]float64
-	LORXmar             [NoLORsMax]float64
-	LORYmar             [NoLORsMax]float64
-	LORZmar             [NoLORsMax]float64
-	LORRmar             [NoLORsMax]float64
+	LORIdx1             [NoLORsMax]int32   `rootio:"count=NoLORs"`
+	LORIdx2             [NoLORsMax]int32   `rootio:"count=NoLORs"`
+	LORTMean            [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORTRF              [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORXmar             [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORYmar             [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORZmar             [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORRmar             [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORNormWeight       [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORAttCorr          [NoLORsMax]float64 `rootio:"count=NoLORs"`
+	LORRFClass          [NoLORsMax]uint8   `rootio:"count=NoLORs"`
 }
 
 type TreeLOR struct {
-	data ROOTDataLOR
-	file croot.File
-	tree croot.Tree
+	data  ROOTDataLOR
+	tree  *rootio.Tree
+	extra *Extra
 }
 
 func NewTreeLOR(outrootfileName string) *TreeLOR {
-	f, err := croot.OpenFile(outrootfileName, "recreate", "ROOT file with event information", 1, 0)
+	tree, err := rootio.NewTree(outrootfileName, "tree", "tree")
+	if err != nil {
+		panic(err)
+	}
+	t := TreeLOR{tree: tree}
+	if err := rootio.Branches(t.tree, &t.data); err != nil {
+		panic(err)
+	}
+	t.extra, err = NewExtra(t.tree, "NoPulses", NoPulsesInLORMax)
 	if err != nil {
 		panic(err)
 	}
-	t := TreeLOR{file: f, tree: croot.NewTree("tree", "tree", 32)}
-	const bufsiz = 32000
-	/*
-		_, err = t.tree.Branch("data", &t.data, bufsiz, 0)
-		if err != nil {
-			panic(err)
-		}
-	*/
-
-	_, err = t.tree.Branch2("Run", &t.data.Run, "Run/i", bufsiz)
-	_, err = t.tree.Branch2("Evt", &t.data.Evt, "Evt/i", bufsiz)
-	_, err = t.tree.Branch2("T0", &t.data.T0, "T0/i", bufsiz)
-	_, err = t.tree.Branch2("TimeStamp", &t.data.TimeStamp, "TimeStamp/l", bufsiz)
-	_, err = t.tree.Branch2("RateBoard1", &t.data.RateBoard1, "RateBoard1/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard2", &t.data.RateBoard2, "RateBoard2/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard3", &t.data.RateBoard3, "RateBoard3/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard4", &t.data.RateBoard4, "RateBoard4/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard5", &t.data.RateBoard5, "RateBoard5/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard6", &t.data.RateBoard6, "RateBoard6/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard7", &t.data.RateBoard7, "RateBoard7/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard8", &t.data.RateBoard8, "RateBoard8/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard9", &t.data.RateBoard9, "RateBoard9/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard10", &t.data.RateBoard10, "RateBoard10/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard11", &t.data.RateBoard11, "RateBoard11/D", bufsiz)
-	_, err = t.tree.Branch2("RateBoard12", &t.data.RateBoard12, "RateBoard12/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR1", &t.data.RateLvsR1, "RateLvsR1/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR2", &t.data.RateLvsR2, "RateLvsR2/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR3", &t.data.RateLvsR3, "RateLvsR3/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR4", &t.data.RateLvsR4, "RateLvsR4/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR5", &t.data.RateLvsR5, "RateLvsR5/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR6", &t.data.RateLvsR6, "RateLvsR6/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsR7", &t.data.RateLvsR7, "RateLvsR7/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L1", &t.data.RateLvs3L1, "RateLvs3L1/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L2", &t.data.RateLvs3L2, "RateLvs3L2/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L3", &t.data.RateLvs3L3, "RateLvs3L3/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L4", &t.data.RateLvs3L4, "RateLvs3L4/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L5", &t.data.RateLvs3L5, "RateLvs3L5/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L6", &t.data.RateLvs3L6, "RateLvs3L6/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvs3L7", &t.data.RateLvs3L7, "RateLvs3L7/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL1", &t.data.RateLvsL1, "RateLvsL1/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL2", &t.data.RateLvsL2, "RateLvsL2/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL3", &t.data.RateLvsL3, "RateLvsL3/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL4", &t.data.RateLvsL4, "RateLvsL4/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL5", &t.data.RateLvsL5, "RateLvsL5/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL6", &t.data.RateLvsL6, "RateLvsL6/D", bufsiz)
-	_, err = t.tree.Branch2("RateLvsL7", &t.data.RateLvsL7, "RateLvsL7/D", bufsiz)
-
-	_, err = t.tree.Branch2("NoPulses", &t.data.NoPulses, "NoPulses/I", bufsiz)
-	_, err = t.tree.Branch2("IChanAbs240", &t.data.IChanAbs240, "IChanAbs240[NoPulses]/s", bufsiz)
-	_, err = t.tree.Branch2("IQuartetAbs60", &t.data.IQuartetAbs60, "IQuartetAbs60[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("ILineAbs12", &t.data.ILineAbs12, "ILineAbs12[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("IHemi", &t.data.IHemi, "IHemi[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("E", &t.data.E, "E[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Ampl", &t.data.Ampl, "Ampl[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Sat", &t.data.Sat, "Sat[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("Charge", &t.data.Charge, "Charge[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T10", &t.data.T10, "T10[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T20", &t.data.T20, "T20[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T30", &t.data.T30, "T30[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T80", &t.data.T80, "T80[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T90", &t.data.T90, "T90[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Tf20", &t.data.Tf20, "Tf20[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("NoLocMaxRisingFront", &t.data.NoLocMaxRisingFront, "NoLocMaxRisingFront[NoPulses]/s", bufsiz)
-	_, err = t.tree.Branch2("SampleTimes", &t.data.SampleTimes, "SampleTimes[999]/D", bufsiz)
-	_, err = t.tree.Branch2("Pulse", &t.data.Pulse, "Pulse[NoPulses][999]/D", bufsiz)
-	_, err = t.tree.Branch2("PulseRF", &t.data.PulseRF, "PulseRF[999]/D", bufsiz)
-	_, err = t.tree.Branch2("X", &t.data.X, "X[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Y", &t.data.Y, "Y[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Z", &t.data.Z, "Z[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Xc", &t.data.Xc, "Xc[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Yc", &t.data.Yc, "Yc[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Zc", &t.data.Zc, "Zc[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("TRF", &t.data.TRF, "TRF/D", bufsiz)
-	_, err = t.tree.Branch2("NoLORs", &t.data.NoLORs, "NoLORs/I", bufsiz)
-	_, err = t.tree.Branch2("NoLORsMax", &t.data.NoLORsMax, "NoLORsMax/I", bufsiz)
-	_, err = t.tree.Branch2("LORIdx1", &t.data.LORIdx1, "LORIdx1[NoLORs]/I", bufsiz)
-	_, err = t.tree.Branch2("LORIdx2", &t.data.LORIdx2, "LORIdx2[NoLORs]/I", bufsiz)
-	_, err = t.tree.Branch2("LORTMean", &t.data.LORTMean, "LORTMean[NoLORs]/D", bufsiz)
-	_, err = t.tree.Branch2("LORTRF", &t.data.LORTRF, "LORTRF[NoLORs]/D", bufsiz)
-	_, err = t.tree.Branch2("LORXmar", &t.data.LORXmar, "LORXmar[NoLORs]/D", bufsiz)
-	_, err = t.tree.Branch2("LORYmar", &t.data.LORYmar, "LORYmar[NoLORs]/D", bufsiz)
-	_, err = t.tree.Branch2("LORZmar", &t.data.LORZmar, "LORZmar[NoLORs]/D", bufsiz)
-	_, err = t.tree.Branch2("LORRmar", &t.data.LORRmar, "LORRmar[NoLORs]/D", bufsiz)
-
 	return &t
 }
 
@@ -197,7 +127,10 @@ func AlreadyIn(pulses []*pulse.Pulse, p *pulse.Pulse) (bool, int) {
 	return false, -1
 }
 
-func (t *TreeLOR) Fill(run uint32, hdr *rw.Header, event *event.Event, timesRF []float64) {
+// Fill fills the tree with the LORs of the event and their pulses.
+// Pulse features, RF fit and LORs are not computed here: the event must have
+// been processed by pipeline.Features and pipeline.DefaultLORs.
+func (t *TreeLOR) Fill(run uint32, hdr *rw.Header, event *event.Event) {
 	t.data.Run = run
 	t.data.Evt = uint32(event.ID)
 	t.data.TimeStamp = uint64(event.Counters[3])<<32 | uint64(event.Counters[2])
@@ -238,21 +171,15 @@ func (t *TreeLOR) Fill(run uint32, hdr *rw.Header, event *event.Event, timesRF [
 		t.data.RateLvsL7 = float64(event.Counters[29]) * 64e6 / float64(event.Counters[0])
 	}
 
-	// TRF stuff
-	// 	ampSlice := event.ClustersWoData[0].Pulses[0].MakeAmpSlice()
-	// 	var timesRF []float64
-	// 	if len(ampSlice) != 0 { // can compute TRF
-	// 		timesRF = utils.FindIntersections(event.ID, ampSlice, event.ClustersWoData[0].Pulses[0].MakeTimeSlice())
-	// 	}
-	//
-
-	if len(timesRF) == 0 {
-		timesRF = event.FindTimesRF()
-	} else {
-		t.data.TRF = timesRF[0]
+	// RF
+	t.data.TRF, t.data.RFFreq, t.data.RFAmpl = -1, 0, 0
+	if event.RF != nil {
+		// time of the first RF rising front in the sampling window
+		t.data.TRF = event.RF.RefTime(event.RF.Period())
+		t.data.RFFreq = event.RF.Freq
+		t.data.RFAmpl = event.RF.Ampl
 	}
 
-	event.FindLORs(0, 0, 25., 3*1.2, 0, 1000, true) //511+3*28.3)
 	// 	fmt.Println("no lors: ", len(event.LORs))
 	t.data.NoLORs = int32(len(event.LORs))
 	t.data.NoLORsMax = int32(NoLORsMax)
@@ -287,15 +214,16 @@ func (t *TreeLOR) Fill(run uint32, hdr *rw.Header, event *event.Event, timesRF [
 		t.data.LORYmar[i] = lor.Ymar
 		t.data.LORZmar[i] = lor.Zmar
 		t.data.LORRmar[i] = lor.Rmar
-		lor.CalcTRF(timesRF)
+		t.data.LORNormWeight[i] = lor.NormWeight
+		t.data.LORAttCorr[i] = lor.AttCorr
 		t.data.LORTRF[i] = lor.TRF
+		t.data.LORRFClass[i] = uint8(lor.RFClass)
 	}
 
 	t.data.NoPulses = int32(len(pulsesInLOR))
 	for i := range pulsesInLOR {
 		pulse := pulsesInLOR[i]
-		pulse.CalcRisingFront(true)
-		pulse.CalcFallingFront(false)
+		t.extra.Fill(i, pulse)
 		// 		fmt.Println("i=", i)
 		t.data.IChanAbs240[i] = uint16(pulse.Channel.AbsID240())
 		t.data.IQuartetAbs60[i] = dpgadetector.FifoID144ToQuartetAbsIdx60(pulse.Channel.FifoID144(), true)
@@ -312,6 +240,7 @@ func (t *TreeLOR) Fill(run uint32, hdr *rw.Header, event *event.Event, timesRF [
 		t.data.T90[i] = pulse.Time90
 		t.data.Tf20[i] = pulse.TimeFall20
 		t.data.NoLocMaxRisingFront[i] = uint16(pulse.NoLocMaxRisingFront)
+		t.data.RFTime[i] = event.RFTime(pulse)
 		for j := range pulse.Samples {
 			t.data.SampleTimes[j] = pulse.Samples[j].Time
 			t.data.Pulse[i][j] = pulse.Samples[j].Amplitude
@@ -327,13 +256,14 @@ func (t *TreeLOR) Fill(run uint32, hdr *rw.Header, event *event.Event, timesRF [
 		t.data.Zc[i] = pulse.Channel.CrystCenter.Z
 	}
 
-	_, err := t.tree.Fill()
+	err := t.tree.Fill()
 	if err != nil {
 		panic(err)
 	}
 }
 
 func (t *TreeLOR) Close() {
-	t.file.Write("", 0, 0)
-	t.file.Close("")
+	if err := t.tree.Close(); err != nil {
+		panic(err)
+	}
 }
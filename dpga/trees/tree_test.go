@@ -0,0 +1,165 @@
+package trees
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rootio"
+)
+
+// testChans are the channels (absolute Id, 0 -> 239) having signal in the test events.
+var testChans = []uint16{3, 17, 130, 201}
+
+// testLORs are the indices of the pulses of the LORs of the test events. Pulses
+// are shared by LORs, which are not in the order of their pulses.
+var testLORs = [][2]int{{0, 2}, {1, 3}, {2, 1}}
+
+// testEvent returns an event with a pulse in each of the channels testChans and
+// the LORs testLORs.
+func testEvent(id uint32) *event.Event {
+	var times [999]float64
+	for j := range times {
+		times[j] = 0.2 * float64(j)
+	}
+	e := newEvent(id, 1000*uint64(id+1), times[:], make([]float64, len(times)))
+	pulses := make([]*pulse.Pulse, len(testChans))
+	for i, iChan := range testChans {
+		ampls := make([]float64, len(times))
+		for j := range ampls {
+			ampls[j] = float64(100*i + j + int(id))
+		}
+		p := makePulse(e, iChan, times[:], ampls)
+		p.SRout = uint16(10*id) + uint16(i)
+		p.E = 511 + float64(i)
+		p.Ampl = 100 + float64(i)
+		p.Charg = 20 + float64(i)
+		p.Time10 = 10 + float64(i)
+		p.Time20 = 20 + float64(i)
+		p.Time30 = 30 + float64(i)
+		p.Time80 = 80 + float64(i)
+		p.Time90 = 90 + float64(i)
+		p.TimeFall20 = 120 + float64(i)
+		p.NoLocMaxRisingFront = i
+		pulses[i] = p
+	}
+	for i, idx := range testLORs {
+		e.LORs = append(e.LORs, event.LOR{
+			Pulses:     [2]*pulse.Pulse{pulses[idx[0]], pulses[idx[1]]},
+			Idx1:       idx[0],
+			Idx2:       idx[1],
+			TMean:      float64(i) + 0.5,
+			TRF:        5 + float64(i),
+			Xmar:       1 + float64(i),
+			Ymar:       2 + float64(i),
+			Zmar:       3 + float64(i),
+			Rmar:       4 + float64(i),
+			NormWeight: 1,
+			AttCorr:    1.5,
+		})
+	}
+	return e
+}
+
+func TestTreeRoundTrip(t *testing.T) {
+	defer func(vars pulse.VarList) { pulse.ExtraVars = vars }(pulse.ExtraVars)
+	pulse.ExtraVars = pulse.VarList{pulse.Vars["FirstCapa"]}
+
+	dir, err := ioutil.TempDir("", "trees-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "tree.root")
+
+	const noEvents = 3
+	hdr := &rw.Header{TimeStart: 1500000000}
+	tree := NewTree(fileName)
+	for i := uint32(0); i < noEvents; i++ {
+		tree.Fill(37020, hdr, testEvent(i))
+	}
+	tree.Close()
+
+	r, err := rootio.NewReader(fileName, "tree")
+	if err != nil {
+		t.Fatalf("could not open tree: %v\n", err)
+	}
+	defer r.Close()
+	var data ROOTData
+	var firstCapa [NoPulsesMax]float64
+	if err := rootio.Branches(r, &data); err != nil {
+		t.Fatalf("could not declare branches: %v\n", err)
+	}
+	if err := r.Branch("FirstCapa", &firstCapa, "FirstCapa[NoPulses]/D"); err != nil {
+		t.Fatalf("could not declare extra branch: %v\n", err)
+	}
+	if n := r.Entries(); n != noEvents {
+		t.Fatalf("entries = %v, want %v", n, noEvents)
+	}
+	err = r.Read(func(entry int64) error {
+		e := testEvent(uint32(entry))
+		if data.Run != 37020 || data.Evt != uint32(entry) || data.T0 != hdr.TimeStart || data.TimeStamp != e.TimeStamp {
+			t.Errorf("entry %v: run=%v evt=%v t0=%v timestamp=%v", entry, data.Run, data.Evt, data.T0, data.TimeStamp)
+		}
+		if int(data.NoPulses) != len(testChans) {
+			t.Fatalf("entry %v: NoPulses = %v, want %v", entry, data.NoPulses, len(testChans))
+		}
+		_, pulses, _, _, _, _ := e.Multiplicity()
+		for i, p := range pulses {
+			if data.IChanAbs240[i] != testChans[i] {
+				t.Errorf("entry %v, pulse %v: IChanAbs240 = %v, want %v", entry, i, data.IChanAbs240[i], testChans[i])
+			}
+			if data.E[i] != p.E || data.T30[i] != p.Time30 || data.Tf20[i] != p.TimeFall20 {
+				t.Errorf("entry %v, pulse %v: E=%v T30=%v Tf20=%v, want %v %v %v", entry, i,
+					data.E[i], data.T30[i], data.Tf20[i], p.E, p.Time30, p.TimeFall20)
+			}
+			if data.Pulse[i][5] != p.Samples[5].Amplitude {
+				t.Errorf("entry %v, pulse %v: sample 5 = %v, want %v", entry, i, data.Pulse[i][5], p.Samples[5].Amplitude)
+			}
+			if firstCapa[i] != float64(p.SRout) {
+				t.Errorf("entry %v, pulse %v: FirstCapa = %v, want %v", entry, i, firstCapa[i], p.SRout)
+			}
+		}
+		if int(data.NoLORs) != len(e.LORs) {
+			t.Fatalf("entry %v: NoLORs = %v, want %v", entry, data.NoLORs, len(e.LORs))
+		}
+		for i, lor := range e.LORs {
+			if int(data.LORIdx1[i]) != lor.Idx1 || int(data.LORIdx2[i]) != lor.Idx2 || data.LORRmar[i] != lor.Rmar {
+				t.Errorf("entry %v, LOR %v: idx=(%v,%v) Rmar=%v, want (%v,%v) %v", entry, i,
+					data.LORIdx1[i], data.LORIdx2[i], data.LORRmar[i], lor.Idx1, lor.Idx2, lor.Rmar)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error reading tree: %v\n", err)
+	}
+}
+
+func TestExtraDuplicate(t *testing.T) {
+	defer func(vars pulse.VarList) { pulse.ExtraVars = vars }(pulse.ExtraVars)
+	pulse.ExtraVars = pulse.VarList{{Name: "E", Func: func(p *pulse.Pulse) float64 { return p.E }}}
+
+	dir, err := ioutil.TempDir("", "trees-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree, err := rootio.NewTree(filepath.Join(dir, "tree.root"), "tree", "tree")
+	if err != nil {
+		t.Fatalf("could not create tree: %v\n", err)
+	}
+	defer tree.Close()
+	var data ROOTData
+	if err := rootio.Branches(tree, &data); err != nil {
+		t.Fatalf("could not declare branches: %v\n", err)
+	}
+	if _, err := NewExtra(tree, "NoPulses", NoPulsesMax); err == nil {
+		t.Fatalf("extra variable E declared twice: no error")
+	}
+}
@@ -1,16 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 
-	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rw"
-	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rwi"
-	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rwvme"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
 	"go-hep.org/x/hep/csvutil"
 )
 
@@ -19,10 +16,12 @@ var (
 	outFile  = flag.String("o", "", "Name of the output csv file")
 	noEvents = flag.Uint("n", 100000, "Number of events")
 	evtFreq  = flag.Uint("ef", 100, "Event printing frequency")
-	vme      = flag.Bool("vme", false, "If set, uses VME reader")
+	vme      = flag.Bool("vme", false, "If set, uses VME reader (same as -format=vme)")
+	format   rwi.Format
 )
 
 func main() {
+	flag.Var(&format, "format", rwi.FormatUsage)
 	flag.Parse()
 
 	// Reader
@@ -36,11 +35,12 @@ func main() {
 	if err != nil {
 		log.Fatalf("could not open stream: %v\n", err)
 	}*/
-	var r rwi.Reader
-	if !*vme {
-		r, _ = rw.NewReader(bufio.NewReader(f))
-	} else {
-		r, _ = rwvme.NewReader(bufio.NewReader(f), rwvme.HeaderCAL)
+	if *vme {
+		format = rwi.FormatVME
+	}
+	r, err := rwi.NewReader(f, format)
+	if err != nil {
+		log.Fatalf("could not open stream: %v\n", err)
 	}
 
 	// csv file containing output of pedestal analysis
@@ -72,9 +72,9 @@ func main() {
 		if iEvent%*evtFreq == 0 {
 			fmt.Printf("event %v\n", iEvent)
 		}
-		event, status := r.ReadNextEvent()
-		if status == false {
-			panic("error: status is false\n")
+		event, err := r.ReadNextEvent()
+		if event == nil {
+			panic(err)
 		}
 		// 		event.Print(false, false)
 		// 		fmt.Println(event.HasSignal())
@@ -84,7 +84,7 @@ func main() {
 		}
 		amps := event.AmpsPerChannel()
 		stamps := strings.Fields(strings.Trim(fmt.Sprint(amps), "[]"))
-		err := tbl.Writer.Write(stamps)
+		err = tbl.Writer.Write(stamps)
 		if err != nil {
 			log.Fatalf("error writing row: %v\n", err)
 		}
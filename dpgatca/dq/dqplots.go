@@ -11,6 +11,8 @@ import (
 
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/reconstruction"
+	"gitlab.in2p3.fr/avirm/analysis-go/rf"
 	"gitlab.in2p3.fr/avirm/analysis-go/utils"
 	"go-hep.org/x/hep/hbook"
 	"go-hep.org/x/hep/hplot"
@@ -42,11 +44,18 @@ type DQPlot struct {
 	HitQuartets         *hbook.H2D
 	HEnergyVsDeltaTggRF *hbook.H2D
 
+	// Corruptions found by the reader in recovery mode (see rw.Reader.SetRecover)
+	HCorruption    *hbook.H1D // number of corrupted byte ranges per corruption code
+	NoBytesSkipped float64    // total number of bytes skipped
+
 	HV [4][16]plotter.XYs // first index refers to HV board (there are 4 boards), second index refers to channels (there are 16 channels per board)
 
 	SRout [2][6][3]hbook.H1D // first index: ASM board, second index: DRS
 
 	DQPlotRef *DQPlot
+
+	refEdge   *reconstruction.DistalEdge // fit of the distal edge of refEdgeOf (see refDistalEdge)
+	refEdgeOf *DQPlot
 }
 
 func NewDQPlot() *DQPlot {
@@ -72,6 +81,7 @@ func NewDQPlot() *DQPlot {
 		EnergyCorrelation:   hbook.NewH2D(50, 0, 1000, 50, 0, 1000),
 		HitQuartets:         hbook.NewH2D(30, 0, 30, 30, 30, 60),
 		HEnergyVsDeltaTggRF: hbook.NewH2D(50, 0, 40, 50, 0, 1050),
+		HCorruption:         hbook.NewH1D(5, 0.5, 5.5),
 	}
 	for i := uint8(0); i < NoClusters; i++ {
 		dqp.HCharge[i] = make([]hbook.H1D, N)
@@ -117,7 +127,7 @@ func NewDQPlotFromGob(fileName string) *DQPlot {
 	return dqplot
 }
 
-func (d *DQPlot) FillHistos(event *event.Event, RFcutMean, RFcutWidth float64) {
+func (d *DQPlot) FillHistos(event *event.Event) {
 	d.Nevents++
 
 	var mult uint8 = 0
@@ -183,10 +193,11 @@ func (d *DQPlot) FillHistos(event *event.Event, RFcutMean, RFcutWidth float64) {
 		d.HEnergyVsDeltaTggRF.Fill(timeDiff, lor.Pulses[0].E, 1)
 		d.HEnergyVsDeltaTggRF.Fill(timeDiff, lor.Pulses[1].E, 1)
 
-		if math.Abs(timeDiff-RFcutMean) > RFcutWidth {
-			d.HMinRecX.Fill(lor.Xmar, 1)
-			d.HMinRecY.Fill(lor.Ymar, 1)
-			d.HMinRecZ.Fill(lor.Zmar, 1)
+		// prompt gammas are excluded from the activity profiles
+		if lor.RFClass != rf.Prompt {
+			d.HMinRecX.Fill(lor.Xmar, lor.Weight())
+			d.HMinRecY.Fill(lor.Ymar, lor.Weight())
+			d.HMinRecZ.Fill(lor.Zmar, lor.Weight())
 		}
 	}
 
@@ -197,6 +208,13 @@ func (d *DQPlot) FillHistos(event *event.Event, RFcutMean, RFcutWidth float64) {
 	}
 }
 
+// AddCorruption adds a range of noBytes bytes skipped by the reader because of
+// a corruption of type code (see rw.CorruptionCode).
+func (d *DQPlot) AddCorruption(code int, noBytes int64) {
+	d.HCorruption.Fill(float64(code), 1)
+	d.NoBytesSkipped += float64(noBytes)
+}
+
 // AddHVPoint adds a point to the HV curve.
 // abscissa is whatever you think is more relevant in your case.
 func (d *DQPlot) AddHVPoint(idCard int, idChannel int, abscissa float64, val float64) {
@@ -322,13 +340,10 @@ func (d *DQPlot) MakeMinRecXYDistrs() *hplot.TiledPlot {
 }
 
 func (d *DQPlot) MakeMinRecZDistr() *plot.Plot {
-	p, err := plot.New()
+	p := plot.New()
 
 	p.X.Min = -10
 	p.X.Max = 10
-	if err != nil {
-		panic(err)
-	}
 	p.X.Label.Text = "Z (mm)"
 	p.Y.Label.Text = "No entries"
 	p.X.Tick.Marker = &hplot.FreqTicks{N: 124, Freq: 6}
@@ -342,6 +357,81 @@ func (d *DQPlot) MakeMinRecZDistr() *plot.Plot {
 	return p
 }
 
+// MinRecZProfile returns the distribution of the Z coordinate of the minimal
+// reconstruction points as a reconstruction.Profile.
+func (d *DQPlot) MinRecZProfile() reconstruction.Profile {
+	var p reconstruction.Profile
+	for i := range d.HMinRecZ.Binning.Bins {
+		bin := &d.HMinRecZ.Binning.Bins[i]
+		p.Z = append(p.Z, bin.XMid())
+		p.N = append(p.N, bin.SumW())
+	}
+	return p
+}
+
+// FitRange fits the distal fall-off of the minimal reconstruction Z distribution.
+// If d.DQPlotRef is not nil, the reference distribution is fitted as well and
+// the range shift with respect to the reference is returned.
+// Otherwise, the returned shift and its uncertainty are NaN.
+func (d *DQPlot) FitRange(dir reconstruction.BeamDir) (edge reconstruction.DistalEdge, shift, shiftErr float64) {
+	edge = reconstruction.FitDistalEdge(d.MinRecZProfile(), dir)
+	shift, shiftErr = math.NaN(), math.NaN()
+	if d.DQPlotRef != nil {
+		shift, shiftErr = reconstruction.RangeShift(edge, d.refDistalEdge(dir), dir)
+	}
+	return
+}
+
+// refDistalEdge returns the fit of the distal fall-off of the reference
+// distribution. As the reference does not change during a run, it is only
+// fitted again if d.DQPlotRef or the beam direction changed.
+func (d *DQPlot) refDistalEdge(dir reconstruction.BeamDir) reconstruction.DistalEdge {
+	if d.refEdge == nil || d.refEdgeOf != d.DQPlotRef || d.refEdge.Dir != dir {
+		edge := reconstruction.FitDistalEdge(d.DQPlotRef.MinRecZProfile(), dir)
+		d.refEdge = &edge
+		d.refEdgeOf = d.DQPlotRef
+	}
+	return *d.refEdge
+}
+
+// MakeRangePlot makes the minimal reconstruction Z distribution overlaid
+// with the fit of its distal fall-off (and with the fit of the reference
+// distribution if d.DQPlotRef is not nil).
+func (d *DQPlot) MakeRangePlot(dir reconstruction.BeamDir) *plot.Plot {
+	p := plot.New()
+	p.X.Label.Text = "Z (mm)"
+	p.Y.Label.Text = "No entries"
+	p.X.Tick.Marker = &hplot.FreqTicks{N: 124, Freq: 6}
+	p.BackgroundColor = color.RGBA{R: 230, G: 247, B: 255, A: 255}
+
+	hplotZ := hplot.NewH1D(d.HMinRecZ)
+	hplotZ.FillColor = color.RGBA{R: 255, G: 204, B: 153, A: 255}
+	p.Add(hplotZ)
+	p.Add(hplot.NewGrid())
+
+	edge, shift, shiftErr := d.FitRange(dir)
+	if math.IsNaN(edge.R50) {
+		p.Title.Text = "R50: fit failed"
+		return p
+	}
+	f := plotter.NewFunction(edge.Func())
+	f.Color = color.RGBA{R: 255, A: 255}
+	f.Width = vg.Points(2)
+	f.Samples = 500
+	p.Add(f)
+	p.Title.Text = fmt.Sprintf("R50 = %.2f +- %.2f mm", edge.R50, edge.R50Err)
+	if d.DQPlotRef != nil {
+		edgeRef := d.refDistalEdge(dir)
+		fref := plotter.NewFunction(edgeRef.Func())
+		fref.Color = color.RGBA{B: 255, A: 255}
+		fref.Dashes = []vg.Length{vg.Points(5), vg.Points(5)}
+		fref.Samples = 500
+		p.Add(fref)
+		p.Title.Text += fmt.Sprintf(", shift = %.2f +- %.2f mm", shift, shiftErr)
+	}
+	return p
+}
+
 type WhichVar byte
 
 const (
@@ -572,10 +662,7 @@ func (d *DQPlot) MakeHVTiledPlot() *hplot.TiledPlot {
 }
 
 func (d *DQPlot) MakeAmplCorrelationPlot() *plot.Plot {
-	pCorrelation, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pCorrelation := plot.New()
 	pCorrelation.X.Label.Text = "Amplitude pulse 0 (ADC counts)"
 	pCorrelation.Y.Label.Text = "Amplitude pulse 1 (ADC counts)"
 	pCorrelation.X.Tick.Marker = &hplot.FreqTicks{N: 11, Freq: 2}
@@ -590,10 +677,7 @@ func (d *DQPlot) MakeAmplCorrelationPlot() *plot.Plot {
 }
 
 func (d *DQPlot) MakeEnergyCorrelationPlot() *plot.Plot {
-	pCorrelation, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pCorrelation := plot.New()
 	pCorrelation.X.Label.Text = "Energy pulse 0 (keV)"
 	pCorrelation.Y.Label.Text = "Energy pulse 1 (keV)"
 	pCorrelation.X.Tick.Marker = &hplot.FreqTicks{N: 11, Freq: 2}
@@ -609,10 +693,7 @@ func (d *DQPlot) MakeEnergyCorrelationPlot() *plot.Plot {
 }
 
 func (d *DQPlot) MakeRFPlotALaArnaud() *plot.Plot {
-	pRF, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pRF := plot.New()
 	pRF.X.Label.Text = "tgg - trf (ns)"
 	pRF.Y.Label.Text = "Energy (keV)"
 	pRF.X.Tick.Marker = &hplot.FreqTicks{N: 11, Freq: 2}
@@ -628,10 +709,7 @@ func (d *DQPlot) MakeRFPlotALaArnaud() *plot.Plot {
 }
 
 func (d *DQPlot) MakeHitQuartetsPlot() *plot.Plot {
-	pHitQuartets, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pHitQuartets := plot.New()
 	pHitQuartets.X.Label.Text = "Quartet Id (right hemisphere)"
 	pHitQuartets.Y.Label.Text = "Quartet Id (left hemisphere)"
 	pHitQuartets.X.Tick.Marker = &hplot.FreqTicks{N: 31, Freq: 2}
@@ -673,6 +751,20 @@ func (d *DQPlot) MakeLORMultPlot() *hplot.Plot {
 	return p
 }
 
+func (d *DQPlot) MakeCorruptionPlot() *hplot.Plot {
+	p := hplot.New()
+	p.Title.Text = fmt.Sprintf("Corrupted data (%v bytes skipped)", d.NoBytesSkipped)
+	p.X.Label.Text = "Code (1: start of frame, 2: magic, 3: no samples, 4: trailer, 5: truncated)"
+	p.Y.Label.Text = "No entries"
+	p.X.Tick.Marker = &hplot.FreqTicks{N: 6, Freq: 1}
+	hp := hplot.NewH1D(d.HCorruption)
+	hp.FillColor = color.RGBA{R: 255, G: 102, B: 102, A: 255}
+	p.Add(hp)
+	p.Add(hplot.NewGrid())
+	p.BackgroundColor = color.RGBA{R: 230, G: 247, B: 255, A: 255}
+	return p
+}
+
 func (d *DQPlot) MakeEnergyPlot() *hplot.Plot {
 	p := hplot.New()
 	p.X.Label.Text = "Energy (keV)"
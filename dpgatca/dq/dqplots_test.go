@@ -0,0 +1,31 @@
+package dq
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/reconstruction"
+)
+
+func TestMinRecZProfile(t *testing.T) {
+	d := NewDQPlot()
+	zs := []float64{-90, -12.3, -12.2, 0, 0.1, 45, 97}
+	for _, z := range zs {
+		d.HMinRecZ.Fill(z, 1)
+	}
+	h := d.HMinRecZ
+	want := reconstruction.NewProfile(zs, h.Len(), h.XMin(), h.XMax())
+	got := d.MinRecZProfile()
+	if len(got.Z) != len(want.Z) {
+		t.Fatalf("%v bins, want %v", len(got.Z), len(want.Z))
+	}
+	for i := range want.Z {
+		if math.Abs(got.Z[i]-want.Z[i]) > 1e-9 {
+			t.Errorf("bin %v: z = %v, want the bin center %v", i, got.Z[i], want.Z[i])
+		}
+	}
+	if !reflect.DeepEqual(got.N, want.N) {
+		t.Errorf("contents = %v, want %v", got.N, want.N)
+	}
+}
@@ -7,12 +7,13 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"runtime/pprof"
 	"strconv"
+	"time"
 
-	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/udp"
+	"gonum.org/v1/plot/vg"
 )
 
 var (
@@ -20,18 +21,11 @@ var (
 	port       = flag.String("p", "6000", "Port number")
 	frameFreq  = flag.Uint("ff", 1000, "Frame printing frequency")
 	nFramesTot = flag.Uint("n", 300000, "Number of frames to process")
+	pcapName   = flag.String("pcap", "", "Name of the pcap file in which packets are written. If empty, packets are not written.")
+	lossPlot   = flag.String("lossplot", "", "Name of the file in which the number of lost packets versus time is plotted (e.g. loss.png). If empty, no plot is made.")
+	binWidth   = flag.Duration("binwidth", time.Second, "Time bin width of the lost packets plot")
 )
 
-func UDPConn(p *string) *net.UDPConn {
-	fmt.Println("addr", *ip+":"+*p)
-	locAddr, err := net.ResolveUDPAddr("udp", *ip+":"+*p)
-	conn, err := net.ListenUDP("udp", locAddr)
-	if err != nil {
-		return nil
-	}
-	return conn
-}
-
 func main() {
 	log.SetFlags(log.Llongfile | log.LstdFlags)
 	flag.Parse()
@@ -43,70 +37,54 @@ func main() {
 	pprof.StartCPUProfile(f)
 	defer pprof.StopCPUProfile()
 
-	conn := UDPConn(port)
-	for i := 0; conn == nil; i++ {
-		newportu, err := strconv.ParseUint(*port, 10, 64)
-		if err != nil {
-			panic(err)
+	rcv, err := udp.Listen(*ip + ":" + *port)
+	for i := 0; err != nil; i++ {
+		newportu, perr := strconv.ParseUint(*port, 10, 64)
+		if perr != nil {
+			panic(perr)
 		}
 		newportu += 1
 		newport := strconv.FormatUint(newportu, 10)
 		fmt.Printf("Port %v not responding, trying %v\n", *port, newport)
 		*port = newport
-		conn = UDPConn(port)
+		rcv, err = udp.Listen(*ip + ":" + *port)
 		if i >= 5 {
 			log.Fatalf("Cannot find port to connect to server")
 		}
 	}
-	// 	conn.SetReadBuffer(200)
-	conn.SetReadBuffer(216320) // not sure what is the unit of the argument
-	//conn.Write([]byte("Hello from client"))
+	defer rcv.Close()
+	rcv.Monitor = udp.NewMonitor(*binWidth)
 
-	nframes := uint(0)
-	AMCFrameCounterPrev := uint32(0)
-	buf := make([]byte, 8238)
-	for nframes < *nFramesTot {
-		if nframes%*frameFreq == 0 {
-			fmt.Printf("reading frame %v\n", nframes)
+	if *pcapName != "" {
+		fpcap, err := os.Create(*pcapName)
+		if err != nil {
+			log.Fatalf("could not create pcap file: %v\n", err)
 		}
-		///////////////////////////////////////////////////////
-		// Option 1 : fastest
-		/*conn.Read(buf)
-		AMCFrameCounters0 := binary.BigEndian.Uint16(buf[2:4])
-		AMCFrameCounters1 := binary.BigEndian.Uint16(buf[4:6])
-		AMCFrameCounter := (uint32(AMCFrameCounters0) << 16) + uint32(AMCFrameCounters1)
-		if nframes > 0 {
-			if AMCFrameCounter != AMCFrameCounterPrev+1 {
-				fmt.Printf("AMCFrameCounter != AMCFrameCounterPrev+1\n")
-			}
+		defer fpcap.Close()
+		rcv.Pcap, err = udp.NewPcapWriter(fpcap)
+		if err != nil {
+			log.Fatalf("could not write pcap file: %v\n", err)
 		}
-		AMCFrameCounterPrev = AMCFrameCounter*/
-		///////////////////////////////////////////////////////
+	}
 
-		//////////////////////////////////////////////////////
-		// Option 2 : a bit more refined
-		//n, _, err := conn.ReadFromUDP(buf)
-		n, err := conn.Read(buf)
-		frame := rw.NewFrame(n)
-		//fmt.Println("payload =", n)
-		frame.FillHeader(buf)
-		err = frame.IntegrityHeader()
-		if err != nil {
-			panic(err)
+	start := time.Now()
+	nframes := uint(0)
+	for nframes < *nFramesTot {
+		if nframes%*frameFreq == 0 {
+			fmt.Printf("reading frame %v (%.0f frames/s, %v lost)\n", nframes, float64(nframes)/time.Since(start).Seconds(), rcv.Monitor.AMC.Lost)
 		}
-		frame.FillData(buf)
-		err = frame.IntegrityData()
+		_, err := rcv.ReadPacket()
 		if err != nil {
-			panic(err)
+			log.Fatalf("error reading packet: %v\n", err)
 		}
-		if nframes > 0 {
-			if frame.AMCFrameCounter != AMCFrameCounterPrev+1 {
-				fmt.Printf("frame.AMCFrameCounter != AMCFrameCounterPrev+1\n")
-			}
-		}
-		AMCFrameCounterPrev = frame.AMCFrameCounter
-		//////////////////////////////////////////////////////
-
 		nframes++
 	}
+
+	rcv.Monitor.Print()
+	if *lossPlot != "" {
+		p := rcv.Monitor.MakeLossPlot()
+		if err := p.Save(15*vg.Centimeter, 10*vg.Centimeter, *lossPlot); err != nil {
+			log.Fatalf("error saving lost packets plot: %v\n", err)
+		}
+	}
 }
@@ -25,10 +25,15 @@ import (
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/dq"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rw"
-	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rwi"
-	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rwvme"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/trees"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtbuilder"
+	"gitlab.in2p3.fr/avirm/analysis-go/pipeline"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/reconstruction"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
+	"gitlab.in2p3.fr/avirm/analysis-go/tcaframe"
+	"gitlab.in2p3.fr/avirm/analysis-go/udp"
 	"gitlab.in2p3.fr/avirm/analysis-go/utils"
 )
 
@@ -40,6 +45,7 @@ var (
 	pauseRun     = make(chan bool)
 	resumeRun    = make(chan bool)
 	pauseMonBool bool
+	rcv          *udp.Receiver // set with the -udp flag
 	cpuprof      = flag.String("cpuprof", "", "Name of file for CPU profiling")
 	noEvents     = flag.Uint("n", 100000, "Number of events")
 	inFileName   = flag.String("i", "", "Name of input file (if non empty, use it rather than input stream from DAQ")
@@ -59,7 +65,7 @@ var (
 	notree       = flag.Bool("notree", false, "If set, no root tree is produced")
 	test         = flag.Bool("test", false,
 		"If set, update runs_test.csv rather than the \"official\" runs.csv file and name by default the output binary file using the following scheme: runXXX_test.bin")
-	//refplots = flag.String("ref", os.Getenv("GOPATH")+"/src/gitlab.in2p3.fr/avirm/analysis-go/dpga/dqref/dq-run37020evtsPedReference.gob",
+	//refplots = flag.String("ref", os.Getenv("GOPATH")+"/src/gitlab.in2p3.fr/avirm/analysis-go/dpga/dqref/dq-run37020evtsPedReference.root",
 	//	"Name of the file containing reference plots. If empty, no reference plots are overlayed")
 	refplots    = flag.String("ref", "", "Name of the file containing reference plots. If empty, no reference plots are overlayed")
 	hvMonDegrad = flag.Uint("hvmondeg", 100, "HV monitoring frequency degradation factor")
@@ -69,9 +75,21 @@ var (
 	noped       = flag.Bool("noped", false, "If specified, no pedestal correction applied")
 	notdo       = flag.Bool("notdo", false, "If specified, no time dependent offset correction applied")
 	noen        = flag.Bool("noen", false, "If specified, no energy calibration applied.")
-	rfcutmean   = flag.Float64("rfcutmean", 7, "Mean used to apply RF selection cut.")
-	rfcutwidth  = flag.Float64("rfcutwidth", 5, "Width used to apply RF selection cut.")
-	vme         = flag.Bool("vme", false, "If set, uses VME reader")
+	mumap       = flag.String("mumap", "", "Name of the file containing the attenuation map. If set, LORs are corrected for attenuation")
+	phantomR    = flag.Float64("phantomr", 50, "Radius (mm) of the cylindrical phantom (see -phantom)")
+	phantomL    = flag.Float64("phantoml", 200, "Length (mm) of the cylindrical phantom (see -phantom)")
+	beamdir     = flag.Int("beamdir", 1, "Direction of the beam along the Z axis (1 or -1), used for the range verification plot")
+	vme         = flag.Bool("vme", false, "If set, uses VME reader (same as -format=vme)")
+	recoverMode = flag.Bool("recover", false, "If set, corrupted frames are skipped and reported instead of stopping the run (not available with -vme)")
+	udpAddr     = flag.String("udp", "", "Local address (e.g. :60000) on which frames are received over UDP. If set, frames are read from the socket rather than from the input file")
+	pcapName    = flag.String("pcap", "", "Name of the pcap file in which the UDP packets are written (relevant only with -udp)")
+	crcMode     tcaframe.CRCMode // set with the -crc flag
+	evtWindow   = flag.Uint64("window", 0, "Maximal difference between the keys (see -evtkey) of frames grouped into the same event")
+	evtTimeout  = flag.Int("timeout", 16, "Number of frames read after which an incomplete event is closed")
+	evtResync   = flag.Uint64("resync", 0, "Backwards jump of the keys (see -evtkey) above which event building is resynchronized, e.g. after a counter reset or wrap (0: default of the key, see evtbuilder.DefaultResync)")
+	format      rwi.Format                // set with the -format flag
+	phantom     reconstruction.Phantom    // set with the -phantom flag
+	evtKey      = evtbuilder.KeyTimeStamp // set with the -evtkey flag
 )
 
 // XY is a struct used to store a couple of values
@@ -120,6 +138,8 @@ type Data struct {
 	RFplotALaArnaud       string         `json:"rfplotalaarnaud"`       // 2D RF plot "a la Arnaud"
 	SRoutTiled            string         `json:"srouttiled"`            // SRout distributions for the 36 DRS's
 	LORMult               string         `json:"lormult"`               // LOR multiplicity
+	RangeFit              string         `json:"rangefit"`              // fit of the distal edge of the minimal reconstruction Z distribution
+	Corruption            string         `json:"corruption"`            // corrupted data skipped by the reader
 }
 
 func (d *Data) Print() {
@@ -146,6 +166,16 @@ func TCPConn(p *string) *net.TCPConn {
 func main() {
 	log.SetFlags(log.Llongfile | log.LstdFlags)
 
+	flag.Var(&format, "format", rwi.FormatUsage)
+	flag.Var(&evtKey, "evtkey", evtbuilder.KeyUsage)
+	flag.Var(&phantom, "phantom", "If set (possible values: water, pmma) and -mumap is not set, LORs are corrected for attenuation in a cylindrical phantom")
+	flag.Var(&crcMode, "crc", "How frames with a wrong CRC are handled: flag (default, frames are kept), drop or ignore (CRC not checked) (not available with -vme)")
+	flag.Float64Var(&event.RFClassifier.Freq, "rffreq", event.RFClassifier.Freq, "Nominal frequency (MHz) of the accelerator RF signal.")
+	flag.Float64Var(&event.RFClassifier.PromptMean, "promptmean", event.RFClassifier.PromptMean, "Mean (ns, time since RF rising front) of the prompt-gamma window.")
+	flag.Float64Var(&event.RFClassifier.PromptWidth, "promptwidth", event.RFClassifier.PromptWidth, "Half-width (ns) of the prompt-gamma window.")
+	flag.Float64Var(&event.RFClassifier.MinAmpl, "rfminampl", event.RFClassifier.MinAmpl, "Minimal amplitude of the RF signal for the beam to be considered on.")
+	flag.Var(&event.CrystPos, "crystpos", "Position in the crystals used as LOR endpoints: front, center (default) or doi (depth of interaction)")
+	flag.Var(&pulse.ExtraVars, "vars", pulse.ExtraVarsUsage())
 	flag.Parse()
 
 	if *cpuprof != "" {
@@ -181,17 +211,40 @@ func main() {
 	*/
 
 	// Reader
-	f, err := os.Open(*inFileName)
-	if err != nil {
-		log.Fatalf("could not open data file: %v\n", err)
-	}
-	defer f.Close()
-
-	var r rwi.Reader
-	if !*vme {
-		r, _ = rw.NewReader(bufio.NewReader(f))
+	var (
+		r   rwi.Reader
+		err error
+	)
+	if *udpAddr != "" {
+		r = udpReader()
+		defer rcv.Close()
 	} else {
-		r, _ = rwvme.NewReader(bufio.NewReader(f), rwvme.HeaderCAL)
+		f, err := os.Open(*inFileName)
+		if err != nil {
+			log.Fatalf("could not open data file: %v\n", err)
+		}
+		defer f.Close()
+
+		if *vme {
+			format = rwi.FormatVME
+		}
+		r, err = rwi.NewReader(f, format)
+		if err != nil {
+			log.Fatalf("could not open stream: %v\n", err)
+		}
+	}
+	if rr, ok := r.(*rw.Reader); ok {
+		if *recoverMode {
+			rr.SetRecover()
+		}
+		rr.CRCMode = crcMode
+		rr.EventBuilder.Key = evtKey
+		rr.EventBuilder.Window = *evtWindow
+		rr.EventBuilder.Timeout = *evtTimeout
+		rr.EventBuilder.Resync = evtbuilder.DefaultResync[evtKey]
+		if *evtResync != 0 {
+			rr.EventBuilder.Resync = *evtResync
+		}
 	}
 	// 	r, err := rw.NewReader(bufio.NewReader(f))
 	// 	if err != nil {
@@ -345,11 +398,23 @@ func stream(run uint32, r rwi.Reader, iEvent *uint, wg *sync.WaitGroup) {
 			doEnergyCalib = true
 		}
 	}
+	attMap := phantom.MuMap(*phantomR, *phantomL)
+	if *mumap != "" {
+		attMap = reconstruction.ReadMuMap(*mumap)
+	}
+	// LORs are found with the same parameters as in dpga/analysis
+	findLORs := pipeline.DefaultLORs()
+	attCorrection := pipeline.AttCorrection(attMap)
 	noEventsForMon := uint64(0)
 	dqplots := dq.NewDQPlot()
 	if *refplots != "" {
 		dqplots.DQPlotRef = dq.NewDQPlotFromGob(*refplots)
 	}
+	var report *rw.CorruptionReport
+	if rr, ok := r.(*rw.Reader); ok && rr.Recover {
+		report = &rr.Report
+	}
+	noCorruptions := 0
 	outRootFileName := strings.Replace(*inFileName, ".bin", ".root", 1)
 	var tree *trees.Tree
 	if !*notree {
@@ -387,8 +452,18 @@ func stream(run uint32, r rwi.Reader, iEvent *uint, wg *sync.WaitGroup) {
 					// 					panic(err)
 					fmt.Println(err)
 				}
+				if report != nil {
+					for _, c := range report.Corruptions[noCorruptions:] {
+						dqplots.AddCorruption(int(c.Code), c.Length)
+					}
+					noCorruptions = len(report.Corruptions)
+				}
 				if event == nil && err != nil { // EOF
 					fmt.Printf("Reached EOF for iEvent = %v\n", *iEvent)
+					writeCorruptionReport(report, outRootFileName)
+					printCRCFailures(r)
+					printEventBuilding(r)
+					printUDPLosses()
 					return
 				}
 
@@ -407,6 +482,7 @@ func stream(run uint32, r rwi.Reader, iEvent *uint, wg *sync.WaitGroup) {
 					//////////////////////////////////////////////////////
 					// Corrections
 					event = applyCorrCalib.CorrectEvent(event, doPedestal, doTimeDepOffset, doEnergyCalib)
+					event = attCorrection(findLORs(event))
 					//////////////////////////////////////////////////////
 					// 						dqplots.FillHistos(event)
 					// mult, pulsesWithSignal, _ := event.Multiplicity()
@@ -415,7 +491,7 @@ func stream(run uint32, r rwi.Reader, iEvent *uint, wg *sync.WaitGroup) {
 						tree.Fill(run, event)
 					}
 					// 						fmt.Println(" \nlength middle: ", len(event.LORs))
-					dqplots.FillHistos(event, *rfcutmean, *rfcutwidth)
+					dqplots.FillHistos(event)
 					// 						fmt.Println(" length after: ", len(event.LORs))
 					if *iEvent%*monFreq == 0 {
 						// Webserver data
@@ -489,6 +565,20 @@ func stream(run uint32, r rwi.Reader, iEvent *uint, wg *sync.WaitGroup) {
 						pLORMult := dqplots.MakeLORMultPlot()
 						LORMultsvg := utils.RenderSVG(pLORMult, 10, 7.5)
 
+						// Make range verification plot
+						RangeFitsvg := ""
+						if *iEvent > 0 && dqplots.HMinRecZ.Entries() > 0 {
+							pRangeFit := dqplots.MakeRangePlot(reconstruction.BeamDir(*beamdir))
+							RangeFitsvg = utils.RenderSVG(pRangeFit, 12, 7.5)
+						}
+
+						// Make corruption plot
+						Corruptionsvg := ""
+						if dqplots.HCorruption.Entries() > 0 {
+							pCorruption := dqplots.MakeCorruptionPlot()
+							Corruptionsvg = utils.RenderSVG(pCorruption, 12, 7.5)
+						}
+
 						// Make ampl correlation plot
 						pAmplCorrelation := dqplots.MakeAmplCorrelationPlot()
 						AmplCorrelationsvg := ""
@@ -559,6 +649,8 @@ func stream(run uint32, r rwi.Reader, iEvent *uint, wg *sync.WaitGroup) {
 							HitQuartets:           HitQuartetssvg,
 							RFplotALaArnaud:       RFplotALaArnaudsvg,
 							LORMult:               LORMultsvg,
+							RangeFit:              RangeFitsvg,
+							Corruption:            Corruptionsvg,
 							SRoutTiled:            SRoutsvg,
 						}
 						//dataToMonitor.Print()
@@ -579,12 +671,77 @@ func stream(run uint32, r rwi.Reader, iEvent *uint, wg *sync.WaitGroup) {
 				if tree != nil {
 					tree.Close()
 				}
+				writeCorruptionReport(report, outRootFileName)
+				printCRCFailures(r)
+				printEventBuilding(r)
+				printUDPLosses()
 				return
 			}
 		}
 	} // event loop
 }
 
+// writeCorruptionReport prints the corruption report of the reader and writes it
+// next to the output root file, as part of the run metadata.
+// Nothing is done if report is nil (recovery mode not set).
+func writeCorruptionReport(report *rw.CorruptionReport, outRootFileName string) {
+	if report == nil {
+		return
+	}
+	report.Print()
+	report.WriteCSV(strings.Replace(outRootFileName, ".root", "_corruption.csv", 1), *inFileName)
+}
+
+// printCRCFailures prints the number of frames with a wrong CRC per front-end.
+// Nothing is done for the VME reader.
+func printCRCFailures(r rwi.Reader) {
+	if rr, ok := r.(*rw.Reader); ok {
+		rr.PrintCRCFailures()
+	}
+}
+
+// printEventBuilding prints the event building statistics (incomplete events, late frames).
+// Nothing is done for readers of other formats.
+func printEventBuilding(r rwi.Reader) {
+	if rr, ok := r.(*rw.Reader); ok {
+		rr.EventBuilder.Stats.Print()
+	}
+}
+
+// udpReader returns a reader of the frames received on the UDP socket set with -udp.
+// Packets are written to the pcap file set with -pcap, if any.
+func udpReader() rwi.Reader {
+	var err error
+	rcv, err = udp.Listen(*udpAddr)
+	if err != nil {
+		log.Fatalf("could not listen to UDP address %v: %v\n", *udpAddr, err)
+	}
+	if *pcapName != "" {
+		fpcap, err := os.Create(*pcapName)
+		if err != nil {
+			log.Fatalf("could not create pcap file: %v\n", err)
+		}
+		rcv.Pcap, err = udp.NewPcapWriter(bufio.NewWriter(fpcap))
+		if err != nil {
+			log.Fatalf("could not write pcap file: %v\n", err)
+		}
+	}
+	fmt.Printf("Waiting for frames on UDP address %v\n", *udpAddr)
+	r, err := rw.NewStreamReader(rcv)
+	if err != nil {
+		log.Fatalf("could not open stream: %v\n", err)
+	}
+	return r
+}
+
+// printUDPLosses prints the number of lost and reordered UDP packets.
+// Nothing is done if frames are not received over UDP.
+func printUDPLosses() {
+	if rcv != nil {
+		rcv.Monitor.Print()
+	}
+}
+
 func dataHandler(ws *websocket.Conn) {
 	for data := range datac {
 		/////////////////////////////////////////////////
@@ -1,3 +1,9 @@
+//go:build ignore
+
+// The event building under development below is commented out, the file
+// is not built.
+
+package main
 
 // Remains to be done:
 //   - manage runs.csv and runs_test.csv
@@ -0,0 +1,163 @@
+package rw
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"go-hep.org/x/hep/csvutil"
+)
+
+// CorruptionCode identifies the reason why a range of bytes was skipped by the reader
+// in recovery mode (see Reader.SetRecover).
+type CorruptionCode uint8
+
+const (
+	CorruptStartOfFrame CorruptionCode = iota + 1 // missing 0x1230 start of frame
+	CorruptMagic                                  // missing 0xCAFE or 0xDECA magic in frame header
+	CorruptNoSamples                              // number of samples in frame header out of range
+	CorruptTrailer                                // missing CRC or end of frame magic in frame trailer
+	CorruptTruncated                              // end of the stream reached while reading the frame
+
+	NoCorruptionCodes = int(CorruptTruncated)
+)
+
+func (c CorruptionCode) String() string {
+	switch c {
+	case CorruptStartOfFrame:
+		return "StartOfFrame"
+	case CorruptMagic:
+		return "Magic"
+	case CorruptNoSamples:
+		return "NoSamples"
+	case CorruptTrailer:
+		return "Trailer"
+	case CorruptTruncated:
+		return "Truncated"
+	default:
+		return fmt.Sprintf("CorruptionCode(%d)", uint8(c))
+	}
+}
+
+// Corruption is a range of bytes skipped by the reader.
+type Corruption struct {
+	Offset int64          // position in the stream of the first skipped byte
+	Length int64          // number of skipped bytes
+	Code   CorruptionCode // reason why the bytes were skipped
+}
+
+// CorruptionReport summarizes the corruptions found in a stream.
+type CorruptionReport struct {
+	Corruptions    []Corruption
+	NoFrames       int64 // number of frames successfully read
+	NoBytesSkipped int64 // total number of skipped bytes
+}
+
+func (c *CorruptionReport) add(offset, length int64, code CorruptionCode) {
+	c.Corruptions = append(c.Corruptions, Corruption{Offset: offset, Length: length, Code: code})
+	c.NoBytesSkipped += length
+}
+
+// Counts returns the number of corruptions for each code (index is code - 1).
+func (c *CorruptionReport) Counts() [NoCorruptionCodes]int {
+	var counts [NoCorruptionCodes]int
+	for _, corr := range c.Corruptions {
+		if corr.Code >= 1 && int(corr.Code) <= NoCorruptionCodes {
+			counts[corr.Code-1]++
+		}
+	}
+	return counts
+}
+
+// Print prints a summary of the report.
+func (c *CorruptionReport) Print() {
+	fmt.Println("Corruption report:")
+	fmt.Printf("  -> frames read = %v\n", c.NoFrames)
+	fmt.Printf("  -> corrupted ranges = %v (%v bytes skipped)\n", len(c.Corruptions), c.NoBytesSkipped)
+	counts := c.Counts()
+	for i, n := range counts {
+		if n > 0 {
+			fmt.Printf("     o %v: %v\n", CorruptionCode(i+1), n)
+		}
+	}
+}
+
+// WriteCSV writes the list of corruptions to a csv file.
+func (c *CorruptionReport) WriteCSV(fileName, inFileName string) {
+	tbl, err := csvutil.Create(fileName)
+	if err != nil {
+		log.Fatalf("could not create %s: %v\n", fileName, err)
+	}
+	defer tbl.Close()
+	tbl.Writer.Comma = ' '
+
+	err = tbl.WriteHeader(fmt.Sprintf("# Corruption report (creation date: %v, input file: %v, frames read: %v, bytes skipped: %v)\n",
+		time.Now(), inFileName, c.NoFrames, c.NoBytesSkipped))
+	err = tbl.WriteHeader("# offset length code")
+
+	for _, corr := range c.Corruptions {
+		err = tbl.WriteRow(corr)
+		if err != nil {
+			log.Fatalf("error writing row: %v\n", err)
+		}
+	}
+
+	err = tbl.Close()
+	if err != nil {
+		log.Fatalf("error closing table: %v\n", err)
+	}
+}
+
+// scanReader wraps the underlying io.Reader of a Reader.
+// It keeps track of the position in the stream and allows bytes
+// that were already read to be pushed back, which is needed to
+// resynchronise on the next frame after a corruption.
+type scanReader struct {
+	r        io.Reader
+	pending  []byte // bytes pushed back, read before those of r
+	offset   int64  // position in the stream of the next byte to be read
+	record   bool   // if true, bytes read are appended to recorded
+	recorded []byte
+}
+
+func (s *scanReader) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if len(s.pending) > 0 {
+		n = copy(p, s.pending)
+		s.pending = s.pending[n:]
+	} else {
+		n, err = s.r.Read(p)
+	}
+	s.offset += int64(n)
+	if s.record {
+		s.recorded = append(s.recorded, p[:n]...)
+	}
+	return n, err
+}
+
+// unread pushes back b, so that it is read again by the next calls to Read.
+// While recording, b must be the last bytes read, which are removed from the recorded bytes.
+func (s *scanReader) unread(b []byte) {
+	pending := make([]byte, 0, len(b)+len(s.pending))
+	pending = append(pending, b...)
+	s.pending = append(pending, s.pending...)
+	s.offset -= int64(len(b))
+	if s.record {
+		s.recorded = s.recorded[:len(s.recorded)-len(b)]
+	}
+}
+
+// startRecording starts recording the bytes read.
+func (s *scanReader) startRecording() {
+	s.record = true
+	s.recorded = s.recorded[:0]
+}
+
+// stopRecording stops recording and returns the recorded bytes.
+// The returned slice is only valid until the next call to startRecording.
+func (s *scanReader) stopRecording() []byte {
+	s.record = false
+	return s.recorded
+}
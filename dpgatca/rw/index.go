@@ -0,0 +1,215 @@
+package rw
+
+import (
+	"bufio"
+	"io"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/evtbuilder"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+)
+
+// noFramesPerEvent is the number of frames per event expected by the event builder of ReadNextEvent.
+const noFramesPerEvent = 2
+
+// newEventBuilder returns the default event builder of readers, grouping
+// frames with the same ASM timestamp, noFramesPerEvent frames per event.
+// Events may have more frames (e.g. files converted from the VME format,
+// with one frame per quartet having data), hence Extend is set.
+func newEventBuilder() *evtbuilder.Builder {
+	b := evtbuilder.New(evtbuilder.KeyTimeStamp, noFramesPerEvent)
+	b.Extend = true
+	return b
+}
+
+// timeStampAsm returns the 64 bits ASM timestamp of the frame.
+func (f *FrameHeader) timeStampAsm() uint64 {
+	return (uint64(f.TimeStampAsmMsb) << 48) | (uint64(f.TimeStampAsmOsb) << 32) | (uint64(f.TimeStampAsmUsb) << 16) | uint64(f.TimeStampAsmLsb)
+}
+
+// noFrameAsm returns the 64 bits ASM frame counter of the frame.
+func (f *FrameHeader) noFrameAsm() uint64 {
+	return (uint64(f.NoFrameAsmMsb) << 48) | (uint64(f.NoFrameAsmOsb) << 32) | (uint64(f.NoFrameAsmUsb) << 16) | uint64(f.NoFrameAsmLsb)
+}
+
+// cptTriggerAsm returns the ASM trigger counter of the frame.
+func (f *FrameHeader) cptTriggerAsm() uint32 {
+	return (uint32(f.CptTriggerAsmMsb) << 16) | uint32(f.CptTriggerAsmLsb)
+}
+
+// eventKey returns the key of the frame used to group frames into events.
+func (f *FrameHeader) eventKey(k evtbuilder.Key) uint64 {
+	if k == evtbuilder.KeyTrigger {
+		return uint64(f.cptTriggerAsm())
+	}
+	return f.timeStampAsm()
+}
+
+// indexedFrame is what eventIndexer keeps of a frame.
+type indexedFrame struct {
+	timeStamp uint64
+	offset    int64
+}
+
+// eventIndexer builds the index of a stream frame by frame. Frames are grouped
+// into events by an event builder set up as the one of the reader, so that
+// events are numbered as by ReadNextEvent. Since frames of consecutive events
+// can be interleaved, an event is indexed at its first frame in the stream.
+type eventIndexer struct {
+	index evtindex.Index
+	b     *evtbuilder.Builder
+	id    uint64 // ID of the next event
+}
+
+// newEventIndexer returns an indexer grouping frames as b does.
+func newEventIndexer(b *evtbuilder.Builder) *eventIndexer {
+	bb := evtbuilder.New(b.Key, b.NoFrames)
+	bb.Window = b.Window
+	bb.Timeout = b.Timeout
+	bb.Resync = b.Resync
+	bb.Extend = b.Extend
+	return &eventIndexer{b: bb}
+}
+
+func (ei *eventIndexer) add(f *FrameHeader, offset int64) {
+	ei.b.Add(f.eventKey(ei.b.Key), indexedFrame{timeStamp: f.timeStampAsm(), offset: offset})
+	for e := ei.b.Next(); e != nil; e = ei.b.Next() {
+		ei.addEvent(e)
+	}
+}
+
+// flush indexes the events still open, at the end of the stream.
+func (ei *eventIndexer) flush() {
+	for e := ei.b.Flush(); e != nil; e = ei.b.Flush() {
+		ei.addEvent(e)
+	}
+}
+
+func (ei *eventIndexer) addEvent(e *evtbuilder.Event) {
+	first := e.Frames[0].(indexedFrame)
+	for _, f := range e.Frames[1:] {
+		if f := f.(indexedFrame); f.offset < first.offset {
+			first = f
+		}
+	}
+	ei.index.Add(ei.id, first.timeStamp, first.offset)
+	ei.id++
+}
+
+// countWriter wraps an io.Writer and counts the bytes written to it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// setReader sets the underlying io.Reader of the reader.
+// If it implements io.Seeker (e.g. *os.File), the reader keeps it
+// to be able to seek and buffers it itself.
+func (r *Reader) setReader(rr io.Reader) {
+	var off int64
+	r.rs = nil
+	if rs, ok := rr.(io.ReadSeeker); ok {
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err == nil {
+			r.rs = rs
+			off = pos
+			rr = bufio.NewReader(rs)
+		}
+	}
+	r.s = &scanReader{r: rr, offset: off}
+	r.r = r.s
+}
+
+// Offset returns the position in the stream of the next byte to be read.
+func (r *Reader) Offset() int64 {
+	return r.s.offset
+}
+
+// SetIndex sets the index used by Seek and SeekTime.
+func (r *Reader) SetIndex(idx *evtindex.Index) {
+	r.index = idx
+}
+
+// Seek positions the reader so that the next call to ReadNextEvent returns
+// the event with ID eventID (or the first following one in the index).
+// The index must have been set beforehand with SetIndex.
+//
+// Frames of the events preceding eventID that are interleaved with those of
+// eventID in the stream are returned as incomplete events.
+func (r *Reader) Seek(eventID uint64) error {
+	if r.index == nil {
+		return evtindex.ErrNoIndex
+	}
+	e, err := r.index.Find(eventID)
+	if err != nil {
+		return err
+	}
+	return r.seekEntry(e)
+}
+
+// SeekTime positions the reader so that the next call to ReadNextEvent returns
+// the first event with an ASM timestamp larger or equal to t.
+// The index must have been set beforehand with SetIndex.
+func (r *Reader) SeekTime(t uint64) error {
+	if r.index == nil {
+		return evtindex.ErrNoIndex
+	}
+	e, err := r.index.FindTime(t)
+	if err != nil {
+		return err
+	}
+	return r.seekEntry(e)
+}
+
+func (r *Reader) seekEntry(e evtindex.Entry) error {
+	if r.rs == nil {
+		return evtindex.ErrNotSeekable
+	}
+	_, err := r.rs.Seek(e.Offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.setReader(r.rs)
+	r.err = nil
+	r.firstFrameOfEvent = nil
+	r.IDPrevFrame = 0
+	r.EventBuilder.Reset()
+	// Event IDs are not stored in the stream but counted by ReadNextEvent
+	r.ID = uint(e.EventID)
+	return nil
+}
+
+// BuildIndex reads the frames from the current position to the end of the stream
+// and returns the index of the events found.
+// Frames are grouped into events as in ReadNextEvent (see Reader.EventBuilder),
+// and event IDs are counted from 0.
+func (r *Reader) BuildIndex() (*evtindex.Index, error) {
+	ei := newEventIndexer(r.EventBuilder)
+	for {
+		offset := r.Offset()
+		f := r.Frame()
+		if f == nil {
+			break
+		}
+		ei.add(&f.Header, offset)
+	}
+	ei.flush()
+	if r.err != nil && r.err != io.EOF {
+		return &ei.index, r.err
+	}
+	return &ei.index, nil
+}
+
+// Index returns the index of the events written so far, with frames grouped
+// into events as by the default event builder of Reader.ReadNextEvent.
+// Events whose frames are not all written yet are indexed as incomplete events,
+// so that Index should only be called once all frames are written.
+func (w *Writer) Index() *evtindex.Index {
+	w.indexer.flush()
+	return &w.indexer.index
+}
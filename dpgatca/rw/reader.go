@@ -1,6 +1,7 @@
 package rw
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -9,11 +10,19 @@ import (
 
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtbuilder"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
+	"gitlab.in2p3.fr/avirm/analysis-go/tcaframe"
 )
 
 type ReadMode byte
 
+// maxNoSamples is the maximal number of samples per channel in a frame.
+// In recovery mode, frames with a larger number of samples are considered as corrupted.
+const maxNoSamples = 1024
+
 const (
 	Default ReadMode = iota
 	UDPHalfDRS
@@ -31,19 +40,75 @@ type Reader struct {
 
 	IDPrevFrame       uint32
 	firstFrameOfEvent *Frame
+
+	// ID is the ID of the next event returned by ReadNextEvent: events are
+	// numbered from 0 in reading order (IDs are not stored in the stream).
+	ID uint
+
+	// EventBuilder groups frames into events (by default, frames with the
+	// same ASM timestamp, 2 frames per event).
+	EventBuilder *evtbuilder.Builder
+
+	// Recover indicates whether corrupted frames are skipped (true)
+	// or make the reader panic (false, default), see SetRecover.
+	Recover bool
+	// Report lists the corruptions found in recovery mode.
+	Report CorruptionReport
+
+	// CRCMode defines how frames with a wrong CRC are handled (CRCFlag by default).
+	CRCMode tcaframe.CRCMode
+	// CRCFailures counts frames with a wrong CRC per front-end.
+	CRCFailures tcaframe.CRCFailures
+
+	s     *scanReader
+	rs    io.ReadSeeker // underlying stream, if seekable
+	index *evtindex.Index
 }
 
 // NewReader returns a new ASM stream in read mode
+//
+// If r implements io.Seeker (e.g. *os.File), it should not be wrapped in a
+// bufio.Reader: the reader then buffers it itself and supports Seek and SeekTime.
 func NewReader(r io.Reader) (*Reader, error) {
-	rr := &Reader{
-		r:                r,
+	rr := newReader()
+	r, err := rawz.Open(r)
+	if err != nil {
+		return nil, err
+	}
+	rr.setReader(r)
+	rr.readFileHeader(&rr.FileHeader)
+	return rr, rr.err
+}
+
+// NewStreamReader returns a new ASM stream in read mode for a stream of frames
+// without file header, e.g. the frames received over UDP (see package udp).
+// FileHeader.NoSamples is set from the header of the first frame, so that
+// NewStreamReader blocks until the first frame is available.
+func NewStreamReader(r io.Reader) (*Reader, error) {
+	rr := newReader()
+	br := bufio.NewReader(r)
+	buf, err := br.Peek(frameHeaderNoSamplesOffset + 2)
+	if err != nil {
+		return nil, err
+	}
+	rr.FileHeader.NoSamples = binary.BigEndian.Uint16(buf[frameHeaderNoSamplesOffset:])
+	// events are returned as soon as they are complete, without waiting for the next one
+	rr.EventBuilder.Extend = false
+	rr.setReader(br)
+	return rr, nil
+}
+
+// frameHeaderNoSamplesOffset is the position of the NoSamples word in the frame header.
+const frameHeaderNoSamplesOffset = 72
+
+func newReader() *Reader {
+	return &Reader{
 		IDPrevFrame:      0,
 		SigThreshold:     800,
 		ReadMode:         Default,
 		UDPHalfDRSBuffer: make([]byte, 8270), //8238),
+		EventBuilder:     newEventBuilder(),
 	}
-	rr.readFileHeader(&rr.FileHeader)
-	return rr, rr.err
 }
 
 // SetDebug() sets debug mode
@@ -51,6 +116,15 @@ func (r *Reader) SetDebug() {
 	r.Debug = true
 }
 
+// SetRecover sets recovery mode.
+// In this mode, when the integrity of a frame is not verified, the reader scans
+// the stream forward for the next frame header (0x1230 start of frame followed by
+// valid 0xCAFE and 0xDECA magics) instead of panicking. The skipped byte ranges
+// are recorded in r.Report.
+func (r *Reader) SetRecover() {
+	r.Recover = true
+}
+
 // SetSigThreshold sets the signal SetSigThreshold
 func (r *Reader) SetSigThreshold(val uint) {
 	r.SigThreshold = val
@@ -98,7 +172,7 @@ func (r *Reader) readU16(v *uint16, byteOrder binary.ByteOrder) {
 		return
 	}
 	var buf [2]byte
-	_, r.err = r.r.Read(buf[:])
+	_, r.err = io.ReadFull(r.r, buf[:])
 	if r.err != nil {
 		return
 	}
@@ -213,26 +287,94 @@ func (r *Reader) readFrameData(data *HalfDRSData) {
 	}
 }
 
-func (r *Reader) readFrameTrailer(f *FrameTrailer) {
+// readFrameTrailer reads the trailer of the frame whose header holds the
+// front-end word feIdK30 (FrameHeader.FEIdK30).
+func (r *Reader) readFrameTrailer(f *FrameTrailer, feIdK30 uint16) {
 	r.readU16(&f.Crc, binary.BigEndian)
+	r.readU16(&f.EoF, binary.BigEndian)
 	// Temporary fix, until we understand where these additionnal 16 bits come from
-	// 	fmt.Printf("CRC = %x\n", f.Crc)
-	if f.Crc != ctrl0xCRC {
-		//fmt.Printf("CRC = %x (should be %x)\n", f.CRC, ctrl0xCRC)
-		r.readU16(&f.Crc, binary.BigEndian)
-		// 		fmt.Printf("new CRC = %x\n", f.Crc)
+	// The additionnal word precedes the Crc word. Since the Crc word is not a fixed
+	// magic anymore, it is detected by checking that the end of frame word holds the
+	// 0xfb magic and the front-end Id of the header (see tcaframe.IsEoF).
+	if r.err != nil || tcaframe.IsEoF(f.EoF, feIdK30) {
+		return
+	}
+	if !tcaframe.HasEoFMagic(f.EoF) {
+		// 		fmt.Printf("extra word = %x\n", f.Crc)
+		f.Crc = f.EoF
+		r.readU16(&f.EoF, binary.BigEndian)
+		return
+	}
+	// The second word has the 0xfb magic but not the front-end Id of the header:
+	// it is either a Crc whose low byte is 0xfb following an additionnal word, or
+	// the end of frame word of a firmware not writing the front-end Id there.
+	// The next word tells which one.
+	var next uint16
+	r.readU16(&next, binary.BigEndian)
+	switch {
+	case r.err == nil && tcaframe.IsEoF(next, feIdK30):
+		f.Crc, f.EoF = f.EoF, next
+	case r.err == nil:
+		r.unreadU16(next)
+	case r.err == io.EOF:
+		r.err = nil
 	}
 	// End of temporary fix
-	r.readU16(&f.EoF, binary.BigEndian)
 }
 
+// unreadU16 pushes back the big endian word v, so that it is read again by the next read.
+func (r *Reader) unreadU16(v uint16) {
+	r.s.unread([]byte{byte(v >> 8), byte(v)})
+}
+
+// Frame reads the next frame.
+// The CRC of the frame is checked according to r.CRCMode.
 func (r *Reader) Frame() *Frame {
+	for {
+		f := r.frame()
+		if f == nil || r.err != nil || r.checkCRC(f) {
+			return f
+		}
+	}
+}
+
+// checkCRC checks the CRC of f according to r.CRCMode, stores the result in f.CRCStatus
+// and counts failures per front-end.
+// It returns false if the frame should be dropped.
+func (r *Reader) checkCRC(f *Frame) bool {
+	if r.CRCMode == tcaframe.CRCIgnore {
+		f.CRCStatus = tcaframe.CRCNotChecked
+		return true
+	}
+	f.CRCStatus = f.CheckCRC()
+	if f.CRCStatus != tcaframe.CRCFailed {
+		return true
+	}
+	r.CRCFailures.Add(f.Header.FEId)
+	if r.Debug {
+		fmt.Printf("rw: wrong CRC for frame of front-end %v (got %x, expected %x)\n", f.Header.FEId, f.Trailer.Crc, f.ComputeCRC())
+	}
+	return r.CRCMode != tcaframe.CRCDrop
+}
+
+// PrintCRCFailures prints the number of frames with a wrong CRC per front-end.
+func (r *Reader) PrintCRCFailures() {
+	if r.CRCMode == tcaframe.CRCIgnore {
+		return
+	}
+	r.CRCFailures.Print()
+}
+
+func (r *Reader) frame() *Frame {
 	f := &Frame{}
 	if r.Debug {
 		fmt.Printf("\nrw: start reading frame\n")
 	}
 	switch r.ReadMode {
 	case Default:
+		if r.Recover {
+			return r.frameRecover()
+		}
 		r.readFrameHeader(&f.Header)
 		if r.err == io.EOF {
 			return nil
@@ -248,7 +390,7 @@ func (r *Reader) Frame() *Frame {
 		// 		fmt.Println("Channels = ", f.Data.Data[0].Channel, f.Data.Data[1].Channel, f.Data.Data[2].Channel, f.Data.Data[3].Channel)
 		f.QuartetAbsIdx60 = dpgadetector.FEIdAndChanIdToQuartetAbsIdx60(f.Header.FEId, f.Data.Data[0].Channel, false)
 		f.QuartetAbsIdx72 = dpgadetector.FEIdAndChanIdToQuartetAbsIdx72(f.Header.FEId, f.Data.Data[0].Channel)
-		r.readFrameTrailer(&f.Trailer)
+		r.readFrameTrailer(&f.Trailer, f.Header.FEIdK30)
 		r.err = f.Trailer.Integrity()
 		if r.err != nil {
 			f.Trailer.Print()
@@ -286,6 +428,100 @@ func (r *Reader) Frame() *Frame {
 	return f
 }
 
+// frameRecover reads the next frame in recovery mode (see SetRecover).
+// It returns nil when the end of the stream is reached.
+func (r *Reader) frameRecover() *Frame {
+	for {
+		start := r.s.offset
+		r.s.startRecording()
+		f := &Frame{}
+		code := r.readFrame(f)
+		rec := r.s.stopRecording()
+		switch {
+		case code == 0:
+			r.Report.NoFrames++
+			return f
+		case len(rec) == 0 && r.err != nil:
+			r.err = io.EOF
+			return nil
+		case code == CorruptTruncated:
+			// The end of the stream was reached while reading the frame. The
+			// frame may be corrupted (e.g. a wrong number of samples) and
+			// followed by valid ones, which are looked for in the bytes read.
+			r.err = nil
+		}
+		if r.Debug {
+			fmt.Printf("rw: corrupted frame at offset %v (%v), resynchronising\n", start, code)
+		}
+		// Scan again from the byte following the start of the corrupted frame
+		r.s.unread(rec[1:])
+		skipped := 1 + r.resync()
+		r.Report.add(start, skipped, code)
+		if r.err != nil {
+			r.err = io.EOF
+			return nil
+		}
+	}
+}
+
+// readFrame reads a frame and checks its integrity.
+// It returns 0 if the frame is valid and the corruption code otherwise.
+func (r *Reader) readFrame(f *Frame) CorruptionCode {
+	r.readFrameHeader(&f.Header)
+	if r.err != nil {
+		return CorruptTruncated
+	}
+	switch {
+	case f.Header.StartOfFrame != ctrlStartOfFrame:
+		return CorruptStartOfFrame
+	case f.Header.Cafe != ctrl0xCafe || f.Header.Deca != ctrl0xDeca:
+		return CorruptMagic
+	case f.Header.NoSamples == 0 || f.Header.NoSamples > maxNoSamples:
+		return CorruptNoSamples
+	}
+	f.SetDataSliceLen(int(f.Header.NoSamples))
+	r.readFrameData(&f.Data)
+	r.readFrameTrailer(&f.Trailer, f.Header.FEIdK30)
+	if r.err != nil {
+		return CorruptTruncated
+	}
+	if f.Trailer.Integrity() != nil {
+		return CorruptTrailer
+	}
+	f.QuartetAbsIdx60 = dpgadetector.FEIdAndChanIdToQuartetAbsIdx60(f.Header.FEId, f.Data.Data[0].Channel, false)
+	f.QuartetAbsIdx72 = dpgadetector.FEIdAndChanIdToQuartetAbsIdx72(f.Header.FEId, f.Data.Data[0].Channel)
+	return 0
+}
+
+// resync skips bytes until the next candidate frame header, that is a 0x1230 start of
+// frame followed by the 0xCAFE and 0xDECA magics at their expected positions.
+// The candidate header is pushed back so that it is read again by readFrame.
+// It returns the number of skipped bytes.
+func (r *Reader) resync() int64 {
+	const n = 24 // number of bytes from the start of frame to the end of the 0xDECA magic
+	var win []byte
+	var skipped int64
+	var b [1]byte
+	for {
+		for len(win) < n {
+			_, err := io.ReadFull(r.r, b[:])
+			if err != nil {
+				r.err = err
+				return skipped + int64(len(win))
+			}
+			win = append(win, b[0])
+		}
+		if binary.BigEndian.Uint16(win[0:2]) == ctrlStartOfFrame &&
+			binary.BigEndian.Uint16(win[20:22]) == ctrl0xCafe &&
+			binary.BigEndian.Uint16(win[22:24]) == ctrl0xDeca {
+			r.s.unread(win)
+			return skipped
+		}
+		win = win[1:]
+		skipped++
+	}
+}
+
 func MakePulse(c *ChanData, quartetAbsIdx72 uint8, sigThreshold uint) *pulse.Pulse {
 	iHemi, iASM, iDRS, iQuartet := dpgadetector.QuartetAbsIdx72ToRelIdx(quartetAbsIdx72)
 	_, iChannelAbs288 := dpgadetector.RelIdxToAbsIdx288(iHemi, iASM, iDRS, iQuartet, uint8(c.Channel)%4)
@@ -317,7 +553,7 @@ func MakePulses(f *Frame, sigThreshold uint) []*pulse.Pulse {
 // for tca
 /*
 func (r *Reader) ReadNextEvent() (*event.Event, error) {
-	event := event.NewEvent(dpgadetector.Det.NoClusters())
+	event := event.NewEvent(int(dpgadetector.Det.NoClusters()), int(dpgadetector.Det.NoClustersWoData()))
 	firstPass := true
 	i := 0
 	for {
@@ -406,7 +642,25 @@ func (r *Reader) ReadNextEvent() (*event.Event, error) {
 	return nil, nil
 }
 */
-var ID uint
+
+// eventKey returns the key of the frame used to group frames into events.
+func (r *Reader) eventKey(f *Frame) uint64 {
+	return f.Header.eventKey(r.EventBuilder.Key)
+}
+
+// nextFrames returns the frames of the next event built by r.EventBuilder.
+func (r *Reader) nextFrames() *evtbuilder.Event {
+	for {
+		if e := r.EventBuilder.Next(); e != nil {
+			return e
+		}
+		frame := r.Frame()
+		if frame == nil { // EOF, empty the open events
+			return r.EventBuilder.Flush()
+		}
+		r.EventBuilder.Add(r.eventKey(frame), frame)
+	}
+}
 
 // for rct
 func (r *Reader) ReadNextEvent() (*event.Event, error) {
@@ -418,16 +672,22 @@ func (r *Reader) ReadNextEvent() (*event.Event, error) {
 	// 	}
 	/////////////////////////////////////////////////////////
 
-	event := event.NewEvent(dpgadetector.Det.NoClusters())
-	event.ID = ID
-	event.NoFrames = 2
+	evtFrames := r.nextFrames()
+	if evtFrames == nil {
+		return nil, r.err
+	}
+
+	event := event.NewEvent(int(dpgadetector.Det.NoClusters()), int(dpgadetector.Det.NoClustersWoData()))
+	event.ID = r.ID
+	event.NoFrames = uint8(len(evtFrames.Frames))
 
 	var SRout1, SRout2 uint16 // for debug
 
-	for i := 0; i < 2; i++ {
-		frame := r.Frame()
+	for i, f := range evtFrames.Frames {
+		frame := f.(*Frame)
 // 		frame.Print()
 		pulses := MakePulses(frame, r.SigThreshold)
+		event.TimeStamp = frame.Header.timeStampAsm()
 		if i == 0 {
 			SRout1 = pulses[0].SRout
 		} else {
@@ -441,6 +701,9 @@ func (r *Reader) ReadNextEvent() (*event.Event, error) {
 			// 				fmt.Printf("iCluster = %v\n", iCluster)
 			event.Clusters[iCluster].ID = iCluster
 			event.Clusters[iCluster].Quartet = dpgadetector.Det.QuartetFromIdAbs60(iCluster)
+			event.Clusters[iCluster].CptTriggerAsm = frame.Header.cptTriggerAsm()
+			event.Clusters[iCluster].NoFrameAsm = frame.Header.noFrameAsm()
+			event.Clusters[iCluster].TimeStampAsm = frame.Header.timeStampAsm()
 			// 			fmt.Printf("Quartet in reader %p\n", event.Clusters[iCluster].Quartet)
 			////////////////////////////////////////////////////////
 			// Put pulses in event
@@ -454,6 +717,9 @@ func (r *Reader) ReadNextEvent() (*event.Event, error) {
 			iClusterWoData := frame.QuartetAbsIdx72 / 6
 			// 				fmt.Printf("iClusterWoData = %v\n", iClusterWoData)
 			event.ClustersWoData[iClusterWoData].ID = uint8(iClusterWoData)
+			event.ClustersWoData[iClusterWoData].CptTriggerAsm = frame.Header.cptTriggerAsm()
+			event.ClustersWoData[iClusterWoData].NoFrameAsm = frame.Header.noFrameAsm()
+			event.ClustersWoData[iClusterWoData].TimeStampAsm = frame.Header.timeStampAsm()
 			////////////////////////////////////////////////////////
 			// Put pulses in event
 			event.ClustersWoData[iClusterWoData].Pulses[0] = *pulses[0]
@@ -467,11 +733,13 @@ func (r *Reader) ReadNextEvent() (*event.Event, error) {
 
 	var err error
 
-	if SRout1 != SRout2 {
+	if !evtFrames.Complete {
+		err = evtbuilder.ErrIncomplete
+	} else if SRout1 != SRout2 {
 		fmt.Printf("SRout1 (%v) != SRout2 (%v)\n", SRout1, SRout2)
 		err = errors.New("SRout1 != SRout2")
 	}
-	ID += 1
+	r.ID++
 	return event, err
 }
 
@@ -2,9 +2,16 @@ package rw
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
 	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+	"gitlab.in2p3.fr/avirm/analysis-go/tcaframe"
 )
 
 //var rhdr *Header
@@ -44,7 +51,7 @@ func TestRW(t *testing.T) {
 	// 	defer w.Close()
 
 	for {
-		frame, _ := r.Frame()
+		frame := r.Frame()
 		frame.Header.Print()
 		frame.Data.Print()
 	}
@@ -74,6 +81,533 @@ func TestRW(t *testing.T) {
 	*/
 }
 
+func newTestFrame(noSamples int, counter uint16) *Frame {
+	f := &Frame{}
+	h := &f.Header
+	h.StartOfFrame = ctrlStartOfFrame
+	h.FEIdK30 = 0x11
+	h.Cafe = ctrl0xCafe
+	h.Deca = ctrl0xDeca
+	h.Bobo = 0xb0b0
+	h.CptTriggerThorLsb = counter
+	h.NoSamples = uint16(noSamples)
+	f.SetDataSliceLen(noSamples)
+	for i := range f.Data.Data {
+		data := &f.Data.Data[i]
+		data.FirstChanWord = uint16(i) | 0xfd00
+		data.SecondChanWord = 0x100 + counter
+		for j := range data.Amplitudes {
+			data.Amplitudes[j] = uint16(500 + 7*i + j + int(counter))
+		}
+	}
+	f.Trailer.EoF = 0x11fb
+	return f
+}
+
+func writeTestFrames(t *testing.T, noFrames, noSamples int) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	err := w.FileHeader(&FileHeader{ModeFile: 1, FEId: 0x11, NoSamples: uint16(noSamples), Time: 1234})
+	if err != nil {
+		t.Fatalf("error writing file header: %v\n", err)
+	}
+	for i := 0; i < noFrames; i++ {
+		if err := w.Frame(newTestFrame(noSamples, uint16(i))); err != nil {
+			t.Fatalf("error writing frame: %v\n", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing writer: %v\n", err)
+	}
+	return buf.Bytes()
+}
+
+func readTestFrames(t *testing.T, data []byte, mode tcaframe.CRCMode) (*Reader, []*Frame) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	r.CRCMode = mode
+	var frames []*Frame
+	for {
+		frame := r.Frame()
+		if frame == nil {
+			break
+		}
+		frames = append(frames, frame)
+	}
+	return r, frames
+}
+
+func TestCRCRoundTrip(t *testing.T) {
+	const (
+		noFrames    = 3
+		noSamples   = 16
+		fileHdrSize = 24
+		frameSize   = 2*37 + 4*(4+2*noSamples) + 4
+	)
+	data := writeTestFrames(t, noFrames, noSamples)
+	if len(data) != fileHdrSize+noFrames*frameSize {
+		t.Fatalf("wrong stream size: got %v, want %v\n", len(data), fileHdrSize+noFrames*frameSize)
+	}
+
+	r, frames := readTestFrames(t, data, tcaframe.CRCFlag)
+	if len(frames) != noFrames {
+		t.Fatalf("wrong number of frames: got %v, want %v\n", len(frames), noFrames)
+	}
+	for i, frame := range frames {
+		if frame.CRCStatus != tcaframe.CRCOK {
+			t.Errorf("frame %v: CRC status = %v, want %v\n", i, frame.CRCStatus, tcaframe.CRCOK)
+		}
+		want := newTestFrame(noSamples, uint16(i))
+		for j := range want.Data.Data {
+			if !reflect.DeepEqual(frame.Data.Data[j].Amplitudes, want.Data.Data[j].Amplitudes) {
+				t.Errorf("frame %v: amplitudes of channel %v differ\n", i, j)
+			}
+		}
+	}
+
+	// Corrupt one sample of the second frame
+	corrupted := append([]byte(nil), data...)
+	corrupted[fileHdrSize+frameSize+2*37+10] ^= 0x01
+
+	r, frames = readTestFrames(t, corrupted, tcaframe.CRCFlag)
+	if len(frames) != noFrames {
+		t.Fatalf("wrong number of frames in flag mode: got %v, want %v\n", len(frames), noFrames)
+	}
+	if frames[1].CRCStatus != tcaframe.CRCFailed {
+		t.Errorf("CRC status = %v, want %v\n", frames[1].CRCStatus, tcaframe.CRCFailed)
+	}
+	if r.CRCFailures[0x11] != 1 {
+		t.Errorf("CRC failures = %v, want 1\n", r.CRCFailures[0x11])
+	}
+
+	r, frames = readTestFrames(t, corrupted, tcaframe.CRCDrop)
+	if len(frames) != noFrames-1 {
+		t.Fatalf("wrong number of frames in drop mode: got %v, want %v\n", len(frames), noFrames-1)
+	}
+	if r.CRCFailures[0x11] != 1 {
+		t.Errorf("CRC failures = %v, want 1\n", r.CRCFailures[0x11])
+	}
+
+	_, frames = readTestFrames(t, corrupted, tcaframe.CRCIgnore)
+	if len(frames) != noFrames || frames[1].CRCStatus != tcaframe.CRCNotChecked {
+		t.Errorf("CRC should not be checked in ignore mode\n")
+	}
+
+	// Firmwares not computing the CRC write the fixed 0x9876 word,
+	// sometimes preceded by an additionnal 16 bits word
+	var legacy []byte
+	legacy = append(legacy, data[:fileHdrSize+frameSize-4]...)
+	legacy = append(legacy, 0x98, 0x76)
+	legacy = append(legacy, data[fileHdrSize+frameSize-2:fileHdrSize+2*frameSize-4]...)
+	legacy = append(legacy, 0x00, 0x00, 0x98, 0x76)
+	legacy = append(legacy, data[fileHdrSize+2*frameSize-2:]...)
+
+	_, frames = readTestFrames(t, legacy, tcaframe.CRCFlag)
+	if len(frames) != noFrames {
+		t.Fatalf("wrong number of frames in legacy stream: got %v, want %v\n", len(frames), noFrames)
+	}
+	for i, want := range []tcaframe.CRCStatus{tcaframe.CRCLegacy, tcaframe.CRCLegacy, tcaframe.CRCOK} {
+		if frames[i].CRCStatus != want {
+			t.Errorf("frame %v: CRC status = %v, want %v\n", i, frames[i].CRCStatus, want)
+		}
+	}
+}
+
+func TestTrailerExtraWord(t *testing.T) {
+	const (
+		noSamples   = 16
+		fileHdrSize = 24
+		frameSize   = 2*37 + 4*(4+2*noSamples) + 4
+	)
+	// Look for a frame whose CRC has the 0xfb end of frame magic in its low byte
+	counter := uint16(0)
+	for {
+		crc := newTestFrame(noSamples, counter).ComputeCRC()
+		if tcaframe.HasEoFMagic(crc) && !tcaframe.IsEoF(crc, 0x11) {
+			break
+		}
+		counter++
+	}
+
+	write := func(eof uint16) []byte {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		if err := w.FileHeader(&FileHeader{ModeFile: 1, FEId: 0x11, NoSamples: noSamples, Time: 1234}); err != nil {
+			t.Fatalf("error writing file header: %v\n", err)
+		}
+		for i := uint16(0); i < 2; i++ {
+			f := newTestFrame(noSamples, counter+i)
+			f.Trailer.EoF = eof
+			if err := w.Frame(f); err != nil {
+				t.Fatalf("error writing frame: %v\n", err)
+			}
+		}
+		w.Close()
+		return buf.Bytes()
+	}
+	check := func(data []byte, name string) {
+		_, frames := readTestFrames(t, data, tcaframe.CRCFlag)
+		if len(frames) != 2 {
+			t.Fatalf("%v: wrong number of frames: got %v, want 2\n", name, len(frames))
+		}
+		for i, f := range frames {
+			if f.CRCStatus != tcaframe.CRCOK || f.Header.CptTriggerThorLsb != counter+uint16(i) {
+				t.Errorf("%v: frame %v: CRC status = %v, counter = %v\n", name, i, f.CRCStatus, f.Header.CptTriggerThorLsb)
+			}
+		}
+	}
+
+	// Additionnal word before a Crc word looking like an end of frame word
+	data := write(0x11fb)
+	var extra []byte
+	extra = append(extra, data[:fileHdrSize+frameSize-4]...)
+	extra = append(extra, 0xab, 0xcd)
+	extra = append(extra, data[fileHdrSize+frameSize-4:]...)
+	check(extra, "extra word")
+
+	// End of frame words without the front-end Id, for the frame followed
+	// by another frame and for the last frame of the stream
+	check(write(0x00fb), "no front-end Id")
+}
+
+func TestRecover(t *testing.T) {
+	const (
+		noFrames    = 4
+		noSamples   = 16
+		fileHdrSize = 24
+		frameSize   = 2*37 + 4*(4+2*noSamples) + 4
+	)
+	data := writeTestFrames(t, noFrames, noSamples)
+	frame := func(i int) []byte {
+		return data[fileHdrSize+i*frameSize : fileHdrSize+(i+1)*frameSize]
+	}
+	stream := func(parts ...[]byte) []byte {
+		s := append([]byte(nil), data[:fileHdrSize]...)
+		for _, p := range parts {
+			s = append(s, p...)
+		}
+		return s
+	}
+	garbage := []byte{0xff, 0x12, 0x00, 0xab, 0x12, 0x30, 0x07}
+	withWord := func(i, offset int, v uint16) []byte {
+		f := append([]byte(nil), frame(i)...)
+		f[offset], f[offset+1] = byte(v>>8), byte(v)
+		return f
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		counters    []uint16 // counters of the frames read
+		corruptions []Corruption
+	}{
+		{
+			name:     "no corruption",
+			data:     data,
+			counters: []uint16{0, 1, 2, 3},
+		},
+		{
+			name:        "garbage between frames",
+			data:        stream(frame(0), frame(1), garbage, frame(2), frame(3)),
+			counters:    []uint16{0, 1, 2, 3},
+			corruptions: []Corruption{{fileHdrSize + 2*frameSize, int64(len(garbage)), CorruptStartOfFrame}},
+		},
+		{
+			name:        "garbage at the start",
+			data:        stream(garbage, frame(0), frame(1), frame(2), frame(3)),
+			counters:    []uint16{0, 1, 2, 3},
+			corruptions: []Corruption{{fileHdrSize, int64(len(garbage)), CorruptStartOfFrame}},
+		},
+		{
+			name:        "garbage at the end",
+			data:        stream(frame(0), frame(1), frame(2), frame(3), garbage),
+			counters:    []uint16{0, 1, 2, 3},
+			corruptions: []Corruption{{fileHdrSize + 4*frameSize, int64(len(garbage)), CorruptTruncated}},
+		},
+		{
+			name:        "wrong magic",
+			data:        stream(frame(0), withWord(1, 20, 0), frame(2), frame(3)),
+			counters:    []uint16{0, 2, 3},
+			corruptions: []Corruption{{fileHdrSize + frameSize, frameSize, CorruptMagic}},
+		},
+		{
+			name:        "wrong number of samples",
+			data:        stream(frame(0), withWord(1, frameHeaderNoSamplesOffset, maxNoSamples+1), frame(2), frame(3)),
+			counters:    []uint16{0, 2, 3},
+			corruptions: []Corruption{{fileHdrSize + frameSize, frameSize, CorruptNoSamples}},
+		},
+		{
+			// the frame claims more samples than there are bytes left in the stream
+			name:        "truncated by the end of the stream in the middle",
+			data:        stream(frame(0), withWord(1, frameHeaderNoSamplesOffset, maxNoSamples), frame(2), frame(3)),
+			counters:    []uint16{0, 2, 3},
+			corruptions: []Corruption{{fileHdrSize + frameSize, frameSize, CorruptTruncated}},
+		},
+		{
+			name:        "truncated frame followed by frames",
+			data:        stream(frame(0), frame(1)[:100], frame(2), frame(3)),
+			counters:    []uint16{0, 2, 3},
+			corruptions: []Corruption{{fileHdrSize + frameSize, 100, CorruptTrailer}},
+		},
+		{
+			name:        "truncated last frame",
+			data:        stream(frame(0), frame(1), frame(2), frame(3)[:frameSize-30]),
+			counters:    []uint16{0, 1, 2},
+			corruptions: []Corruption{{fileHdrSize + 3*frameSize, frameSize - 30, CorruptTruncated}},
+		},
+		{
+			name:     "several corruptions",
+			data:     stream(garbage, frame(0), frame(1)[:100], frame(2), garbage, frame(3)[:frameSize-30]),
+			counters: []uint16{0, 2},
+			corruptions: []Corruption{
+				{fileHdrSize, int64(len(garbage)), CorruptStartOfFrame},
+				{fileHdrSize + int64(len(garbage)) + frameSize, 100, CorruptTrailer},
+				{fileHdrSize + int64(len(garbage)) + frameSize + 100 + frameSize, int64(len(garbage)), CorruptStartOfFrame},
+				{fileHdrSize + 2*int64(len(garbage)) + 2*frameSize + 100, frameSize - 30, CorruptTruncated},
+			},
+		},
+	}
+	for _, test := range tests {
+		r, err := NewReader(bytes.NewReader(test.data))
+		if err != nil {
+			t.Fatalf("%v: could not open asm stream: %v\n", test.name, err)
+		}
+		r.SetRecover()
+		var counters []uint16
+		for {
+			f := r.Frame()
+			if f == nil {
+				break
+			}
+			counters = append(counters, f.Header.CptTriggerThorLsb)
+		}
+		if r.Err() != io.EOF {
+			t.Errorf("%v: error = %v, want %v\n", test.name, r.Err(), io.EOF)
+		}
+		if !reflect.DeepEqual(counters, test.counters) {
+			t.Errorf("%v: frames read = %v, want %v\n", test.name, counters, test.counters)
+		}
+		if !reflect.DeepEqual(r.Report.Corruptions, test.corruptions) {
+			t.Errorf("%v: corruptions = %v, want %v\n", test.name, r.Report.Corruptions, test.corruptions)
+		}
+		var skipped int64
+		for _, c := range test.corruptions {
+			skipped += c.Length
+		}
+		if r.Report.NoFrames != int64(len(test.counters)) || r.Report.NoBytesSkipped != skipped {
+			t.Errorf("%v: %v frames read and %v bytes skipped, want %v and %v\n",
+				test.name, r.Report.NoFrames, r.Report.NoBytesSkipped, len(test.counters), skipped)
+		}
+	}
+}
+
+// failWriter is an io.Writer whose writes fail.
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errWrite
+}
+
+var errWrite = errors.New("write error")
+
+func TestCloseError(t *testing.T) {
+	tests := []struct {
+		name string
+		w    *Writer
+	}{
+		{"buffered", NewWriter(bufio.NewWriter(failWriter{}))},
+		{"compressed", NewCompressedWriter(bufio.NewWriter(failWriter{}))},
+	}
+	for _, test := range tests {
+		// the frame is kept in the buffers until the writer is closed
+		if err := test.w.Frame(newTestFrame(16, 0)); err != nil {
+			t.Fatalf("%v: error writing frame: %v\n", test.name, err)
+		}
+		if err := test.w.Close(); err != errWrite {
+			t.Errorf("%v: Close error = %v, want %v\n", test.name, err, errWrite)
+		}
+	}
+}
+
+func TestIndexSeek(t *testing.T) {
+	const (
+		noFrames  = 10
+		noSamples = 16
+	)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	err := w.FileHeader(&FileHeader{ModeFile: 1, FEId: 0x11, NoSamples: noSamples, Time: 1234})
+	if err != nil {
+		t.Fatalf("error writing file header: %v\n", err)
+	}
+	for i := 0; i < noFrames; i++ {
+		f := newTestFrame(noSamples, uint16(i))
+		f.Header.TimeStampAsmLsb = uint16(100 * (i / noFramesPerEvent))
+		if err := w.Frame(f); err != nil {
+			t.Fatalf("error writing frame: %v\n", err)
+		}
+	}
+	w.Close()
+	data := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	idx, err := r.BuildIndex()
+	if err != nil {
+		t.Fatalf("error building index: %v\n", err)
+	}
+	if !reflect.DeepEqual(idx.Entries, w.Index().Entries) {
+		t.Fatalf("indices differ:\ngot= %v\nwant=%v\n", idx.Entries, w.Index().Entries)
+	}
+	if idx.Len() != noFrames/noFramesPerEvent {
+		t.Fatalf("wrong number of indexed events: got %v, want %v\n", idx.Len(), noFrames/noFramesPerEvent)
+	}
+
+	r, err = NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	r.SetIndex(idx)
+	if err := r.Seek(3); err != nil {
+		t.Fatalf("error seeking event: %v\n", err)
+	}
+	if f := r.Frame(); f.Header.CptTriggerThorLsb != 6 || r.ID != 3 {
+		t.Errorf("wrong frame after Seek: counter = %v, event ID = %v\n", f.Header.CptTriggerThorLsb, r.ID)
+	}
+	if err := r.SeekTime(150); err != nil {
+		t.Fatalf("error seeking time: %v\n", err)
+	}
+	if f := r.Frame(); f.Header.CptTriggerThorLsb != 4 {
+		t.Errorf("wrong frame after SeekTime: counter = %v\n", f.Header.CptTriggerThorLsb)
+	}
+}
+
+func TestIndexEventBuilding(t *testing.T) {
+	const (
+		noSamples   = 16
+		fileHdrSize = 24
+		frameSize   = 2*37 + 4*(4+2*noSamples) + 4
+	)
+	// Frames of consecutive events are interleaved, and the timestamp
+	// is reset after the frames of timestamp 1<<48 + 400.
+	timeStamps := []uint64{100, 200, 100, 200, 300, 300, 1<<48 + 400, 1<<48 + 400, 10, 10}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	err := w.FileHeader(&FileHeader{ModeFile: 1, FEId: 0x11, NoSamples: noSamples, Time: 1234})
+	if err != nil {
+		t.Fatalf("error writing file header: %v\n", err)
+	}
+	for i, ts := range timeStamps {
+		f := newTestFrame(noSamples, uint16(i))
+		f.Header.TimeStampAsmMsb = uint16(ts >> 48)
+		f.Header.TimeStampAsmLsb = uint16(ts)
+		if err := w.Frame(f); err != nil {
+			t.Fatalf("error writing frame: %v\n", err)
+		}
+	}
+	w.Close()
+	data := buf.Bytes()
+
+	r1, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	idx, err := r1.BuildIndex()
+	if err != nil {
+		t.Fatalf("error building index: %v\n", err)
+	}
+	want := []evtindex.Entry{
+		{EventID: 0, Time: 100, Offset: fileHdrSize},
+		{EventID: 1, Time: 200, Offset: fileHdrSize + frameSize},
+		{EventID: 2, Time: 300, Offset: fileHdrSize + 4*frameSize},
+		{EventID: 3, Time: 1<<48 + 400, Offset: fileHdrSize + 6*frameSize},
+		{EventID: 4, Time: 10, Offset: fileHdrSize + 8*frameSize},
+	}
+	if !reflect.DeepEqual(idx.Entries, want) {
+		t.Fatalf("wrong index:\ngot= %v\nwant=%v\n", idx.Entries, want)
+	}
+	if !reflect.DeepEqual(w.Index().Entries, want) {
+		t.Fatalf("wrong writer index:\ngot= %v\nwant=%v\n", w.Index().Entries, want)
+	}
+
+	// Event IDs are kept per reader
+	r1, err = NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	r2, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	r1.SetIndex(idx)
+	r2.SetIndex(idx)
+	if err := r1.Seek(1); err != nil {
+		t.Fatalf("error seeking event: %v\n", err)
+	}
+	if err := r2.Seek(4); err != nil {
+		t.Fatalf("error seeking event: %v\n", err)
+	}
+	if r1.ID != 1 || r2.ID != 4 {
+		t.Errorf("wrong event IDs after Seek: got %v and %v, want 1 and 4\n", r1.ID, r2.ID)
+	}
+	if f := r2.Frame(); f.Header.CptTriggerThorLsb != 8 {
+		t.Errorf("wrong frame after Seek: counter = %v, want 8\n", f.Header.CptTriggerThorLsb)
+	}
+}
+
+func TestCompressedRW(t *testing.T) {
+	const (
+		noFrames  = 10
+		noSamples = 16
+	)
+	var buf bytes.Buffer
+	w := NewCompressedWriter(&buf)
+	err := w.FileHeader(&FileHeader{ModeFile: 1, FEId: 0x11, NoSamples: noSamples, Time: 1234})
+	if err != nil {
+		t.Fatalf("error writing file header: %v\n", err)
+	}
+	for i := 0; i < noFrames; i++ {
+		f := newTestFrame(noSamples, uint16(i))
+		f.Header.TimeStampAsmLsb = uint16(100 * (i / noFramesPerEvent))
+		if err := w.Frame(f); err != nil {
+			t.Fatalf("error writing frame: %v\n", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing writer: %v\n", err)
+	}
+	data := buf.Bytes()
+	if plain := writeTestFrames(t, noFrames, noSamples); len(data) >= len(plain) {
+		t.Errorf("compressed stream not smaller than plain stream: %v >= %v bytes\n", len(data), len(plain))
+	}
+
+	_, frames := readTestFrames(t, data, tcaframe.CRCFlag)
+	if len(frames) != noFrames {
+		t.Fatalf("wrong number of frames: got %v, want %v\n", len(frames), noFrames)
+	}
+	for i, f := range frames {
+		if f.CRCStatus != tcaframe.CRCOK || f.Header.CptTriggerThorLsb != uint16(i) {
+			t.Errorf("frame %v: CRC status = %v, counter = %v\n", i, f.CRCStatus, f.Header.CptTriggerThorLsb)
+		}
+	}
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	r.SetIndex(w.Index())
+	if err := r.Seek(3); err != nil {
+		t.Fatalf("error seeking event: %v\n", err)
+	}
+	if f := r.Frame(); f.Header.CptTriggerThorLsb != 6 {
+		t.Errorf("wrong frame after Seek: counter = %v\n", f.Header.CptTriggerThorLsb)
+	}
+}
+
 /*
 func TestWIntegrity(t *testing.T) {
 	fmt.Println("starting TestWIntegrity")
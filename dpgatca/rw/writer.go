@@ -4,6 +4,12 @@ import (
 	"bufio"
 	"encoding/binary"
 	"io"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
+	"gitlab.in2p3.fr/avirm/analysis-go/tcaframe"
 )
 
 // Writer wraps an io.Writer and writes an ASM stream.
@@ -12,11 +18,21 @@ type Writer struct {
 	err error
 	//hdr          *Header
 	//frameCounter uint32
+
+	c       *countWriter
+	indexer *eventIndexer
 }
 
 // NewWriter returns a new ASM stream in write mode.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{w: w}
+	c := &countWriter{w: w}
+	return &Writer{w: c, c: c, indexer: newEventIndexer(newEventBuilder())}
+}
+
+// NewCompressedWriter returns a new ASM stream in write mode, written to w
+// in the compressed container of package rawz (one sample per 16 bits word).
+func NewCompressedWriter(w io.Writer) *Writer {
+	return NewWriter(rawz.NewWriter(w, rawz.Stride16))
 }
 
 // Write implements io.Writer.
@@ -30,8 +46,15 @@ func (w *Writer) Write(data []byte) (int, error) {
 // Close does not close the underlying io.Writer.
 func (w *Writer) Close() error {
 	//w.writeU32(lastFrame)
-	if ww, ok := w.w.(*bufio.Writer); ok {
-		ww.Flush()
+	var err error
+	switch ww := w.c.w.(type) {
+	case *bufio.Writer:
+		err = ww.Flush()
+	case *rawz.Writer:
+		err = ww.Close()
+	}
+	if w.err == nil {
+		w.err = err
 	}
 	if w.err != nil && w.err != io.EOF {
 		return w.err
@@ -39,14 +62,42 @@ func (w *Writer) Close() error {
 	return nil
 }
 
+// FileHeader writes the file header to the ASM stream.
+func (w *Writer) FileHeader(f *FileHeader) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = binary.Write(w.w, binary.LittleEndian, f.ModeFile)
+	w.writeU16LE(f.FEId)
+	w.writeU16LE(f.NoSamples)
+	for i := 0; i < 2; i++ {
+		if w.err != nil {
+			break
+		}
+		w.err = binary.Write(w.w, binary.LittleEndian, f.Time)
+	}
+	return w.err
+}
+
 // Frame writes a Frame to the ASM stream.
-// func (w *Writer) Frame(f *Frame) error {
-// 	if w.err != nil {
-// 		return w.err
-// 	}
-// 	w.writeFrame(f)
-// 	return w.err
-// }
+//
+// The Crc word of the frame trailer is computed from the frame header and
+// sample data (see Frame.ComputeCRC), so that frames can be checked when read back.
+func (w *Writer) Frame(f *Frame) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.indexer.add(&f.Header, w.c.n)
+	buf := f.headerAndDataBytes()
+	f.Trailer.Crc = tcaframe.CRC(buf)
+	if (f.Trailer.EoF & 0xff) != ctrl0xfb {
+		f.Trailer.EoF = f.Trailer.EoF&0xff00 | ctrl0xfb
+	}
+	_, w.err = w.w.Write(buf)
+	w.writeU16(f.Trailer.Crc)
+	w.writeU16(f.Trailer.EoF)
+	return w.err
+}
 
 func (w *Writer) write(v interface{}) {
 	if w.err != nil {
@@ -77,6 +128,83 @@ func (w *Writer) WriteU16(v uint16) {
 	w.writeU16(v)
 }
 
+func (w *Writer) writeU16LE(v uint16) {
+	if w.err != nil {
+		return
+	}
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], v)
+	_, w.err = w.w.Write(buf[:])
+}
+
+// Event writes the frames of the event e, one frame per cluster whose pulses
+// have samples, so that events read from another format can be written in the microTCA format.
+// The frame headers are filled from the counters of the clusters (CptTriggerAsm,
+// NoFrameAsm and TimeStampAsm) and the Thor trigger counter is set to the event ID.
+func (w *Writer) Event(e *event.Event) error {
+	for i := range e.Clusters {
+		w.clusterFrame(&e.Clusters[i], uint32(e.ID))
+	}
+	for i := range e.ClustersWoData {
+		w.clusterFrame(&e.ClustersWoData[i], uint32(e.ID))
+	}
+	return w.err
+}
+
+// clusterFrame writes the frame of cluster c, if its pulses have samples.
+func (w *Writer) clusterFrame(c *pulse.Cluster, evtID uint32) {
+	if c.Pulses[0].NoSamples() == 0 || c.Pulses[0].Channel == nil {
+		return
+	}
+	quartetAbsIdx72 := uint8(c.Pulses[0].Channel.AbsID288() / 4)
+	w.Frame(MakeFrame(c, quartetAbsIdx72, evtID))
+}
+
+// MakeFrame returns the frame of the quartet of absolute Id quartetAbsIdx72 (0 -> 71)
+// holding the samples of the pulses of cluster c.
+func MakeFrame(c *pulse.Cluster, quartetAbsIdx72 uint8, evtID uint32) *Frame {
+	FEId, ChanId := dpgadetector.QuartetAbsIdx72ToFEIdAndChanId(quartetAbsIdx72)
+	noSamples := int(c.NoSamples())
+
+	f := &Frame{QuartetAbsIdx72: quartetAbsIdx72}
+	h := &f.Header
+	h.StartOfFrame = ctrlStartOfFrame
+	h.FEIdK30 = FEId
+	h.Cafe = ctrl0xCafe
+	h.Deca = ctrl0xDeca
+	h.Bobo = ctrl0xB0b0
+	h.NoFrameAsmMsb = uint16(c.NoFrameAsm >> 48)
+	h.NoFrameAsmOsb = uint16(c.NoFrameAsm >> 32)
+	h.NoFrameAsmUsb = uint16(c.NoFrameAsm >> 16)
+	h.NoFrameAsmLsb = uint16(c.NoFrameAsm)
+	h.TimeStampAsmMsb = uint16(c.TimeStampAsm >> 48)
+	h.TimeStampAsmOsb = uint16(c.TimeStampAsm >> 32)
+	h.TimeStampAsmUsb = uint16(c.TimeStampAsm >> 16)
+	h.TimeStampAsmLsb = uint16(c.TimeStampAsm)
+	h.CptTriggerThorMsb = uint16(evtID >> 16)
+	h.CptTriggerThorLsb = uint16(evtID)
+	h.CptTriggerAsmMsb = uint16(c.CptTriggerAsm >> 16)
+	h.CptTriggerAsmLsb = uint16(c.CptTriggerAsm)
+	h.NoSamples = uint16(noSamples)
+	h.FEId = FEId
+	h.CptTriggerThor = evtID
+
+	f.SetDataSliceLen(noSamples)
+	for i := range f.Data.Data {
+		p := &c.Pulses[i]
+		data := &f.Data.Data[i]
+		data.Channel = ChanId + uint16(i)
+		data.SRout = p.SRout & 0x3ff
+		data.FirstChanWord = ctrl0xfd<<8 | data.Channel
+		data.SecondChanWord = data.SRout
+		for j := range p.Samples {
+			data.Amplitudes[j] = uint16(p.Samples[j].Amplitude)
+		}
+	}
+	f.Trailer.EoF = FEId<<8 | ctrl0xfb
+	return f
+}
+
 // func (w *Writer) writeFrame(f *Frame) {
 // 	if w.err != nil {
 // 		return
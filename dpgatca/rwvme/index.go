@@ -0,0 +1,141 @@
+package rwvme
+
+import (
+	"bufio"
+	"io"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+)
+
+// countReader wraps an io.Reader and counts the bytes read from it,
+// which gives the offset in the stream of the next frame.
+type countReader struct {
+	r io.Reader
+	n int64 // position in the stream of the next byte to be read
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countWriter wraps an io.Writer and counts the bytes written to it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// setReader sets the underlying io.Reader of the reader.
+// If it implements io.Seeker (e.g. *os.File), the reader keeps it
+// to be able to seek and buffers it itself.
+func (r *Reader) setReader(rr io.Reader) {
+	var off int64
+	r.rs = nil
+	if rs, ok := rr.(io.ReadSeeker); ok {
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err == nil {
+			r.rs = rs
+			off = pos
+			rr = bufio.NewReader(rs)
+		}
+	}
+	r.c = &countReader{r: rr, n: off}
+	r.r = r.c
+}
+
+// Offset returns the position in the stream of the next byte to be read.
+func (r *Reader) Offset() int64 {
+	return r.c.n
+}
+
+// SetIndex sets the index used by Seek and SeekTime.
+func (r *Reader) SetIndex(idx *evtindex.Index) {
+	r.index = idx
+}
+
+// Seek positions the reader so that the next call to ReadNextEvent returns
+// the first event with an ID larger or equal to eventID.
+// The index must have been set beforehand with SetIndex.
+func (r *Reader) Seek(eventID uint64) error {
+	if r.index == nil {
+		return evtindex.ErrNoIndex
+	}
+	e, err := r.index.Find(eventID)
+	if err != nil {
+		return err
+	}
+	return r.seekOffset(e.Offset)
+}
+
+// SeekTime positions the reader so that the next call to ReadNextEvent returns
+// the first event with a timestamp larger or equal to t.
+// The index must have been set beforehand with SetIndex.
+func (r *Reader) SeekTime(t uint64) error {
+	if r.index == nil {
+		return evtindex.ErrNoIndex
+	}
+	e, err := r.index.FindTime(t)
+	if err != nil {
+		return err
+	}
+	return r.seekOffset(e.Offset)
+}
+
+func (r *Reader) seekOffset(offset int64) error {
+	if r.rs == nil {
+		return evtindex.ErrNotSeekable
+	}
+	_, err := r.rs.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.setReader(r.rs)
+	r.err = nil
+	r.firstFrameOfEvent = nil
+	r.evtIDPrevFrame = 0
+	return nil
+}
+
+// BuildIndex reads the frames from the current position to the end of the stream
+// and returns the index of the events found.
+// An event starts with the first word of its first frame, or with the FirstEventWord
+// preceding it when present, in which case its timestamp is taken from the counters.
+func (r *Reader) BuildIndex() (*evtindex.Index, error) {
+	idx := evtindex.New("")
+	var evtIDPrev uint32
+	for first := true; ; first = false {
+		offset := r.Offset()
+		f, err := r.Frame()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return idx, err
+		}
+		if first || f.FirstOfEvent || f.Block.Evt != evtIDPrev {
+			idx.Add(uint64(f.Block.Evt), timeStamp(r.Counters[:]), offset)
+		}
+		evtIDPrev = f.Block.Evt
+	}
+	return idx, nil
+}
+
+// timeStamp returns the event timestamp contained in the counters.
+func timeStamp(counters []uint32) uint64 {
+	if len(counters) < 4 {
+		return 0
+	}
+	return uint64(counters[3])<<32 | uint64(counters[2])
+}
+
+// Index returns the index of the events written so far (see Event and EventFull).
+func (w *Writer) Index() *evtindex.Index {
+	return &w.index
+}
@@ -8,7 +8,9 @@ import (
 
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
 )
 
 // Reader wraps an io.Reader and reads avirm data files
@@ -22,6 +24,10 @@ type Reader struct {
 	SigThreshold      uint
 	Counters          [NumCounters]uint32
 	Debug             bool
+
+	rs    io.ReadSeeker // underlying stream, if seekable
+	c     *countReader
+	index *evtindex.Index
 }
 
 // NoSamples returns the number of samples
@@ -45,12 +51,19 @@ func (r *Reader) Header() *Header {
 }
 
 // NewReader returns a new ASM stream in read mode
+//
+// If r implements io.Seeker (e.g. *os.File), it should not be wrapped in a
+// bufio.Reader: the reader then buffers it itself and supports Seek and SeekTime.
 func NewReader(r io.Reader, ht HeaderType) (*Reader, error) {
 	rr := &Reader{
-		r:              r,
 		evtIDPrevFrame: 0,
 		SigThreshold:   800,
 	}
+	r, err := rawz.Open(r)
+	if err != nil {
+		return nil, err
+	}
+	rr.setReader(r)
 	rr.hdr.HdrType = ht
 	rr.readHeader(&rr.hdr)
 	return rr, rr.err
@@ -312,11 +325,7 @@ func MakePulses(f *Frame, sigThreshold uint) (*pulse.Pulse, *pulse.Pulse) {
 }
 
 func (r *Reader) ReadNextEvent() (*event.Event, error) {
-	event := event.NewEvent(dpgadetector.Det.NoClusters())
-	event.Counters = make([]uint32, NumCounters)
-	for i := range event.Counters {
-		event.Counters[i] = r.Counters[i]
-	}
+	event := event.NewEvent(int(dpgadetector.Det.NoClusters()), int(dpgadetector.Det.NoClustersWoData()))
 	firstPass := true
 	for { // loop over frames
 		var frame *Frame = nil
@@ -341,6 +350,11 @@ func (r *Reader) ReadNextEvent() (*event.Event, error) {
 		if firstPass || evtID == r.evtIDPrevFrame { // fill event
 			if firstPass {
 				event.ID = uint(evtID)
+				// counters are read with the first frame of the event
+				event.Counters = make([]uint32, NumCounters)
+				for i := range event.Counters {
+					event.Counters[i] = r.Counters[i]
+				}
 			}
 			firstPass = false
 			fifoID144 := uint16(frame.Block.ID)
@@ -402,12 +416,10 @@ func (r *Reader) ReadNextEvent() (*event.Event, error) {
 		}
 		r.evtIDPrevFrame = evtID
 	} // end of loop over frames
-	log.Fatalf("error ! you should never end up here")
-	return nil, nil
 }
 
 func (r *Reader) ReadNextEventFull() (*event.Event, bool) {
-	event := event.NewEvent(dpgadetector.Det.NoClusters())
+	event := event.NewEvent(int(dpgadetector.Det.NoClusters()), int(dpgadetector.Det.NoClustersWoData()))
 	for iCluster := uint8(0); iCluster < uint8(event.NoClusters()); iCluster++ {
 		frame1, err := r.Frame()
 		if err != nil {
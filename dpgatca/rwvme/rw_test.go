@@ -53,7 +53,7 @@ func TestRW(t *testing.T) {
 	nevents := 0
 	for {
 		fmt.Printf("reading event %v\n", nevents)
-		event, status := r.ReadNextEvent()
+		event, err := r.ReadNextEvent()
 		if int(event.ID) != nevents {
 			t.Fatalf("event.ID != nevents (event.ID=%v; nevents=%v)\n", event.ID, nevents)
 		}
@@ -61,8 +61,8 @@ func TestRW(t *testing.T) {
 		revents = append(revents, *event)
 		w.Event(event)
 		if r.Err() != io.EOF {
-			if status == false {
-				t.Fatalf("error: status is false\n")
+			if err != nil {
+				t.Fatalf("error reading event: %v\n", err)
 			}
 		} else {
 			break
@@ -91,11 +91,11 @@ func TestWIntegrity(t *testing.T) {
 	var wevents []event.Event
 
 	for {
-		event, status := r.ReadNextEvent()
+		event, err := r.ReadNextEvent()
 		wevents = append(wevents, *event)
 		if r.Err() != io.EOF {
-			if status == false {
-				t.Fatalf("error: status is false\n")
+			if err != nil {
+				t.Fatalf("error reading event: %v\n", err)
 			}
 		} else {
 			break
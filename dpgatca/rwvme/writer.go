@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
 )
 
 // Writer wraps an io.Writer and writes an ASM stream.
@@ -17,11 +19,21 @@ type Writer struct {
 	err          error
 	hdr          *Header
 	frameCounter uint32
+
+	c     *countWriter
+	index evtindex.Index
 }
 
 // NewWriter returns a new ASM stream in write mode.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{w: w}
+	c := &countWriter{w: w}
+	return &Writer{w: c, c: c}
+}
+
+// NewCompressedWriter returns a new ASM stream in write mode, written to w
+// in the compressed container of package rawz (two 12 bits samples per 32 bits word).
+func NewCompressedWriter(w io.Writer) *Writer {
+	return NewWriter(rawz.NewWriter(w, rawz.Stride32))
 }
 
 // Write implements io.Writer.
@@ -35,8 +47,15 @@ func (w *Writer) Write(data []byte) (int, error) {
 // Close does not close the underlying io.Writer.
 func (w *Writer) Close() error {
 	w.writeU32(lastFrame)
-	if ww, ok := w.w.(*bufio.Writer); ok {
-		ww.Flush()
+	var err error
+	switch ww := w.c.w.(type) {
+	case *bufio.Writer:
+		err = ww.Flush()
+	case *rawz.Writer:
+		err = ww.Close()
+	}
+	if w.err == nil {
+		w.err = err
 	}
 	if w.err != nil && w.err != io.EOF {
 		return w.err
@@ -178,7 +197,13 @@ func (w *Writer) MakeFrame(evtID uint32, pulse0 *pulse.Pulse, pulse1 *pulse.Puls
 	return frame
 }
 
-func (w *Writer) Event(event *event.Event) {
+// Event writes the counters and the frames of the quartets of the event having samples.
+// It returns the first error encountered while writing.
+func (w *Writer) Event(event *event.Event) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.index.Add(uint64(event.ID), timeStamp(event.Counters), w.c.n)
 	w.writeU32(FirstEventWord)
 	for _, v := range event.Counters {
 		w.writeU32(v)
@@ -210,10 +235,16 @@ func (w *Writer) Event(event *event.Event) {
 			}
 		}
 	}
-
+	return w.err
 }
 
-func (w *Writer) EventFull(event *event.Event) {
+// EventFull writes the counters and the frames of all the clusters of the event.
+// It returns the first error encountered while writing.
+func (w *Writer) EventFull(event *event.Event) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.index.Add(uint64(event.ID), timeStamp(event.Counters), w.c.n)
 	w.writeU32(FirstEventWord)
 	for _, v := range event.Counters {
 		w.writeU32(v)
@@ -269,4 +300,5 @@ func (w *Writer) EventFull(event *event.Event) {
 		w.Frame(&frame2)
 		iframe += 2
 	}
+	return w.err
 }
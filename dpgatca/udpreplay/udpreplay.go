@@ -0,0 +1,60 @@
+// Package udpreplay sends the UDP packets of a capture file (written by
+// fastudpreader -pcap, godaq -pcap or tcpdump) to godaq, in order to debug
+// acquisition problems offline.
+//
+// Example (godaq listening on port 60000 of the local host, at twice the original pace):
+//
+//	godaq -udp :60000 &
+//	udpreplay -i run.pcap -p 60000 -speed 2
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/udp"
+)
+
+var (
+	fileName = flag.String("i", "", "Name of the pcap capture file")
+	ip       = flag.String("ip", "127.0.0.1", "IP address to which packets are sent")
+	port     = flag.String("p", "60000", "Port number to which packets are sent")
+	speed    = flag.Float64("speed", 1, "Replay speed with respect to the capture (1: original pace, 10: 10 times faster, 0: as fast as possible)")
+)
+
+func main() {
+	log.SetFlags(log.Llongfile | log.LstdFlags)
+	flag.Parse()
+
+	f, err := os.Open(*fileName)
+	if err != nil {
+		log.Fatalf("could not open capture file: %v\n", err)
+	}
+	defer f.Close()
+
+	r, err := udp.NewPcapReader(f)
+	if err != nil {
+		log.Fatalf("could not read capture file: %v\n", err)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", *ip+":"+*port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	n, err := udp.Replay(r, conn, *speed)
+	if err != nil {
+		log.Fatalf("error replaying capture after %v packets: %v\n", n, err)
+	}
+	fmt.Printf("%v packets sent in %v\n", n, time.Since(start))
+}
@@ -149,10 +149,7 @@ func (d *Data) PlotAmplitudeCorrelationWithinCluster() {
 			data = append(data, mydata)
 		}
 	}
-	p, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	p := plot.New()
 	p.Title.Text = "Correlation of amplitudes for clusters with 2 pulses"
 	p.X.Label.Text = "amplitude 1"
 	p.Y.Label.Text = "amplitude 2"
@@ -10,6 +10,7 @@ import (
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
 	"gitlab.in2p3.fr/avirm/analysis-go/reconstruction"
+	"gitlab.in2p3.fr/avirm/analysis-go/rf"
 	"gitlab.in2p3.fr/avirm/analysis-go/utils"
 )
 
@@ -18,13 +19,33 @@ type LOR struct {
 	Idx1   int
 	Idx2   int
 	TMean  float64
-	TRF    float64
+	TRF    float64 // time of the last RF rising front preceding TMean (-1 if no RF)
 	Xmar   float64
 	Ymar   float64
 	Zmar   float64
 	Rmar   float64
+
+	// NormWeight is the normalization weight of the LOR
+	// (see dpgadetector.Detector.NormWeight)
+	NormWeight float64
+
+	// AttCorr is the attenuation correction factor of the LOR.
+	// It is equal to 1 if no attenuation map is used (see CalcAttCorr).
+	AttCorr float64
+
+	// RFClass is the class of the LOR with respect to the RF (see rf.Classifier)
+	RFClass rf.Class
 }
 
+// CrystPos is the position in the crystals used as LOR endpoints
+// (see reconstruction.CrystPos). The depth of interaction mode is opt-in
+// (e.g. with the -crystpos flag of the programs).
+var CrystPos = reconstruction.CrystCenter
+
+// RFClassifier is the classifier used to fit the RF signal of events
+// and to classify LORs with respect to the RF.
+var RFClassifier = rf.NewClassifier()
+
 func NewLOR(pulse1, pulse2 *pulse.Pulse, idx1, idx2 int, Xmar, Ymar, Zmar, Rmar float64) *LOR {
 	l := &LOR{}
 	l.Pulses[0] = pulse1
@@ -37,41 +58,46 @@ func NewLOR(pulse1, pulse2 *pulse.Pulse, idx1, idx2 int, Xmar, Ymar, Zmar, Rmar
 	l.Ymar = Ymar
 	l.Zmar = Zmar
 	l.Rmar = Rmar
+	l.NormWeight = dpgadetector.Det.NormWeight(pulse1.Channel, pulse2.Channel)
+	l.AttCorr = 1
 	return l
 }
 
+// Weight returns the weight with which the LOR should be counted,
+// that is the product of its normalization weight and of its
+// attenuation correction factor.
+func (l *LOR) Weight() float64 {
+	return l.NormWeight * l.AttCorr
+}
+
 func (l *LOR) Copy() *LOR {
-	return NewLOR(l.Pulses[0], l.Pulses[1], l.Idx1, l.Idx2, l.Xmar, l.Ymar, l.Zmar, l.Rmar)
+	c := NewLOR(l.Pulses[0], l.Pulses[1], l.Idx1, l.Idx2, l.Xmar, l.Ymar, l.Zmar, l.Rmar)
+	c.TRF = l.TRF
+	c.RFClass = l.RFClass
+	c.AttCorr = l.AttCorr
+	return c
 }
 
-func (l *LOR) CalcTRF(timesRF []float64) {
-	if len(timesRF) == 0 {
-		//log.Printf("len(timesRF) == 0 -> setting TRF to 0.\n")
-		return
+// CalcAttCorr computes the attenuation correction factor of the LOR with the
+// attenuation map m (see reconstruction.MuMap.AttCorrection).
+// If m is nil, the factor is set to 1.
+func (l *LOR) CalcAttCorr(m *reconstruction.MuMap) {
+	l.AttCorr = 1
+	if m != nil {
+		l.AttCorr = m.AttCorrection(CrystPos, l.Pulses[0].Channel, l.Pulses[1].Channel, l.Pulses[0].E, l.Pulses[1].E)
 	}
-	if l.TMean <= timesRF[0] {
-		//fmt.Println("here ", t.data.LORTMean[i], timesRF[0])
-		l.TRF = timesRF[0] - 1/24.85e6*1e9 // 24.85 MHz is the HF frequency
-	} else if l.TMean >= timesRF[len(timesRF)-1] {
-		l.TRF = timesRF[len(timesRF)-1]
-	} else {
-		for j := range timesRF {
-			if j < len(timesRF)-1 {
-				if l.TMean > timesRF[j] && l.TMean < timesRF[j+1] {
-					l.TRF = timesRF[j]
-					break
-				}
-			} else {
-				fmt.Println(timesRF)
-				log.Printf("This should not happen, tMean=%v\n", l.TMean)
-			}
-		}
-	}
-	if l.TMean-l.TRF > 1/24.85e6*1e9+3 {
-		fmt.Println(timesRF)
-		fmt.Println(l.TMean, l.TRF)
-		log.Printf("ERROR\n")
+}
+
+// CalcRF computes the RF reference time and the RF class of the LOR
+// given the RF signal s of the event.
+// If s is nil, TRF is set to -1 and the class to rf.Unknown.
+func (l *LOR) CalcRF(s *rf.Sine) {
+	l.RFClass = RFClassifier.Classify(s, l.TMean)
+	if s == nil {
+		l.TRF = -1
+		return
 	}
+	l.TRF = s.RefTime(l.TMean)
 }
 
 type Event struct {
@@ -85,6 +111,10 @@ type Event struct {
 	Counters              []uint32
 	LORs                  []LOR
 	HasSig                bool
+	RF                    *rf.Sine // fitted RF signal (nil if not fitted or not available)
+	IsCorrupted           bool     // true if frames of the event were found corrupted while reading
+
+	rfFitted bool // true once FitRF was called, even if no RF signal was found
 }
 
 func NewEvent(noClusters int, noClustersWoData int) *Event {
@@ -108,6 +138,12 @@ func (e *Event) Copy() *Event {
 	newevent.TimeStamp = e.TimeStamp
 	newevent.NoFrames = e.NoFrames
 	newevent.HasSig = e.HasSig
+	newevent.IsCorrupted = e.IsCorrupted
+	newevent.rfFitted = e.rfFitted
+	if e.RF != nil {
+		sine := *e.RF
+		newevent.RF = &sine
+	}
 	newevent.Counters = make([]uint32, len(e.Counters))
 	for i := range e.Counters {
 		newevent.Counters[i] = e.Counters[i]
@@ -404,7 +440,7 @@ func (e *Event) FindLORs(xbeam, ybeam, RmarMax, DeltaTMax, Emin, Emax float64, e
 			// do MAR
 			ch0 := pulseRight.Channel
 			ch1 := pulseLeft.Channel
-			x, y, z := reconstruction.Minimal(true, ch0, ch1, xbeam, ybeam)
+			x, y, z := reconstruction.Minimal(CrystPos, ch0, ch1, pulseRight.E, pulseLeft.E, xbeam, ybeam)
 			r := math.Sqrt(x*x + y*y)
 			// 			fmt.Println("times: ", pulseRight.Time30, pulseLeft.Time30)
 			if r < RmarMax &&
@@ -413,6 +449,10 @@ func (e *Event) FindLORs(xbeam, ybeam, RmarMax, DeltaTMax, Emin, Emax float64, e
 				pulseRight.E > Emin && pulseRight.E < Emax &&
 				pulseLeft.E > Emin && pulseLeft.E < Emax {
 				l := NewLOR(pulseRight, pulseLeft, i, j, x, y, z, r)
+				if !e.rfFitted {
+					e.FitRF()
+				}
+				l.CalcRF(e.RF)
 				e.LORs = append(e.LORs, *l)
 			}
 		}
@@ -437,21 +477,31 @@ func (e *Event) FindLORsLose(xbeam, ybeam float64) []LOR {
 	return lors
 }
 
-func (e *Event) FindTimesRF() []float64 {
-	// 	for i := range event.ClustersWoData {
-	// 		cluster := &event.ClustersWoData[i]
-	// 		for j := range cluster.Pulses {
-	// 			pulse := &cluster.Pulses[j]
-	// 			fmt.Println(i, j, len(pulse.Samples))
-	// 		}
-	// 	}
+// FitRF fits the RF signal, recorded on the first channel of the first cluster
+// without data, with RFClassifier and stores the result in e.RF.
+// e.RF is left to nil if the RF signal was not recorded, and the fit is
+// then not attempted again by FindLORs.
+func (e *Event) FitRF() *rf.Sine {
+	e.RF = nil
+	e.rfFitted = true
+	if len(e.ClustersWoData) == 0 || len(e.ClustersWoData[0].Pulses) == 0 {
+		return nil
+	}
 	pulse := e.ClustersWoData[0].Pulses[0]
-	ampSlice := pulse.MakeAmpSlice()
-	var timesRF []float64
-	if len(ampSlice) != 0 { // can compute TRF
-		timesRF = utils.FindIntersections(e.ID, ampSlice, pulse.MakeTimeSlice())
+	if len(pulse.Samples) == 0 {
+		return nil
+	}
+	e.RF = RFClassifier.Fit(pulse.MakeTimeSlice(), pulse.MakeAmpSlice())
+	return e.RF
+}
+
+// RFTime returns the time elapsed since the last RF rising front for a pulse
+// of this event, or -1 if the RF signal is not available.
+func (e *Event) RFTime(p *pulse.Pulse) float64 {
+	if e.RF == nil {
+		return -1
 	}
-	return timesRF
+	return e.RF.TimeSinceRef(p.Time30)
 }
 
 func (e *Event) AmpsPerChannel() []float64 {
@@ -526,15 +576,15 @@ func (e *Event) IntegrityFirstASMBoard() error {
 	////////////////////////////////////////////////////////////////////////////////
 	// Check that we always have the two half DRSs from a DRS
 	if e.ClusterIsFilled[0] != e.ClusterIsFilled[1] {
-		fmt.Println("%s  -> e.ClusterIsFilled[0] != e.ClusterIsFilled[1]%s ", utils.CLR_R, utils.CLR_def)
+		fmt.Printf("%s  -> e.ClusterIsFilled[0] != e.ClusterIsFilled[1]%s\n", utils.CLR_R, utils.CLR_def)
 		err = errors.New(" -> Event integrity test failed ==> Investigate !!")
 	}
 	if e.ClusterIsFilled[2] != e.ClusterIsFilled[3] {
-		fmt.Println("%s  -> e.ClusterIsFilled[2] != e.ClusterIsFilled[3]%s ", utils.CLR_R, utils.CLR_def)
+		fmt.Printf("%s  -> e.ClusterIsFilled[2] != e.ClusterIsFilled[3]%s\n", utils.CLR_R, utils.CLR_def)
 		err = errors.New(" -> Event integrity test failed ==> Investigate !!")
 	}
 	if e.ClusterIsFilled[4] != e.ClusterWoDataIsFilled[0] {
-		fmt.Println("%s  -> e.ClusterIsFilled[4] != e.ClusterWoDataIsFilled[0]%s ", utils.CLR_R, utils.CLR_def)
+		fmt.Printf("%s  -> e.ClusterIsFilled[4] != e.ClusterWoDataIsFilled[0]%s\n", utils.CLR_R, utils.CLR_def)
 		err = errors.New(" -> Event integrity test failed ==> Investigate !!")
 	}
 	////////////////////////////////////////////////////////////////////////////////
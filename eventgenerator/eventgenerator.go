@@ -116,17 +116,14 @@ func (ec *EventColl) XY(i int) (x, y float64) {
 }
 
 func (ec *EventColl) PlotTimeVsEvtIndex(name string) {
-	p, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	p := plot.New()
 
 	p.Title.Text = "Time vs event index"
 	p.X.Label.Text = "event index"
 	p.Y.Label.Text = "Time (s)"
 	p.Add(plotter.NewGrid())
 
-	err = plotutil.AddLinePoints(p, "", ec)
+	err := plotutil.AddLinePoints(p, "", ec)
 
 	if err != nil {
 		panic(err)
@@ -143,18 +140,12 @@ func (ec *EventColl) PlotRelTimeHist(name string) {
 		hRelTime.Fill(ec.Events[i].Time-ec.Events[i-1].Time, 1)
 	}
 
-	p, err := hplot.New()
-	if err != nil {
-		log.Fatalf("error: %v\n", err)
-	}
+	p := hplot.New()
 	p.Title.Text = "Histogram"
 	p.X.Label.Text = "relative time (s)"
 	p.Y.Label.Text = "A. U. "
 
-	h, err := hplot.NewH1D(hRelTime)
-	if err != nil {
-		log.Fatal(err)
-	}
+	h := hplot.NewH1D(hRelTime)
 	h.Infos.Style = hplot.HInfoSummary
 	p.Add(h)
 
@@ -310,10 +301,7 @@ func mVsr(dt float64) {
 		mVsrRes.X = append(mVsrRes.X, r)
 		mVsrRes.Y = append(mVsrRes.Y, m)
 	}
-	p, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	p := plot.New()
 	p.Title.Text = "measured rate vs true rate"
 	p.X.Label.Text = "r"
 	p.Y.Label.Text = "m"
@@ -326,7 +314,7 @@ func mVsr(dt float64) {
 	line[1].X = mVsrRes.X[len(mVsrRes.X)-1]
 	line[1].Y = 1 / dt
 
-	err = plotutil.AddLinePoints(p, "", mVsrRes, "Asymptotic value", line)
+	err := plotutil.AddLinePoints(p, "", mVsrRes, "Asymptotic value", line)
 
 	if err != nil {
 		panic(err)
@@ -373,10 +361,7 @@ func mVsrDPGAMixture(dt, ti, tf float64) {
 
 	////////////////////////////////////////////
 	// plot m vs r
-	p0, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	p0 := plot.New()
 	p0.Title.Text = "measured rates vs total true rate"
 	p0.X.Label.Text = "rTot"
 	p0.Y.Label.Text = "m"
@@ -389,7 +374,7 @@ func mVsrDPGAMixture(dt, ti, tf float64) {
 	line[1].X = mVsrRes[0].X[len(mVsrRes[0].X)-1]
 	line[1].Y = 1 / dt
 
-	err = plotutil.AddLinePoints(p0, "Tot", &mVsrRes[0], "LYSO", &mVsrRes[1], "Na22_16kBq", &mVsrRes[2], "Na22_2MBq", &mVsrRes[3], "Asymptotic value", line)
+	err := plotutil.AddLinePoints(p0, "Tot", &mVsrRes[0], "LYSO", &mVsrRes[1], "Na22_16kBq", &mVsrRes[2], "Na22_2MBq", &mVsrRes[3], "Asymptotic value", line)
 
 	if err != nil {
 		panic(err)
@@ -402,10 +387,7 @@ func mVsrDPGAMixture(dt, ti, tf float64) {
 
 	////////////////////////////////////////////
 	// plot ratios
-	p1, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	p1 := plot.New()
 	p1.Title.Text = "measured rates/total measured rate vs total true rate"
 	p1.X.Label.Text = "rTot"
 	p1.Y.Label.Text = "measured rate/total measured rate"
@@ -1,2 +1,7 @@
-//Package event describes the data and event structure.
-package event
\ No newline at end of file
+//go:build ignore
+
+// Package event describes the data and event structure.
+//
+// It is a backup of the former test bench event package, written against
+// APIs which have since changed, and is not built anymore.
+package event
@@ -0,0 +1,276 @@
+// Package evtbuilder groups the frames sent by several front-ends into events.
+//
+// Frames are matched using a key, which is either the ASM timestamp or the ASM
+// trigger counter of the frame (see Key). A frame belongs to an open event if
+// its key differs from the key of the event (the key of its first frame) by at
+// most Window. Since frames sent over UDP can arrive out of order, several events
+// are kept open at the same time. An event is closed when it has the expected
+// number of frames, or when Timeout frames have been added since it was opened,
+// in which case it is incomplete. Closed events are returned in key order.
+//
+// If Extend is set, events having the expected number of frames are only closed
+// once a frame of a later event was added, so that events with more frames
+// than expected (e.g. in files converted from the VME format, see package rwi)
+// are not cut. Extend suits files, in which the frames of an event are stored
+// together, but delays events by one frame in live streams.
+//
+// A frame whose key is lower than the key of the last returned event is late and
+// dropped, unless its key is below the highest key seen by more than Resync, which
+// happens when the counter used as key wraps or is reset (e.g. restart of the ASM
+// boards): the builder is then resynchronized. The events opened before still
+// receive their late frames and are returned before those of the new counter values.
+package evtbuilder
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrIncomplete is returned by readers for events closed before all their frames arrived.
+var ErrIncomplete = errors.New("evtbuilder: incomplete event")
+
+// Key defines which quantity of the frames is used to group them into events.
+type Key byte
+
+const (
+	KeyTimeStamp Key = iota // ASM timestamp
+	KeyTrigger              // ASM trigger counter
+)
+
+// KeyUsage is the usage string of flags setting a Key.
+const KeyUsage = "Quantity used to group frames into events: timestamp (ASM timestamp) or trigger (ASM trigger counter)"
+
+func (k *Key) String() string {
+	switch *k {
+	case KeyTimeStamp:
+		return "timestamp"
+	case KeyTrigger:
+		return "trigger"
+	default:
+		return fmt.Sprintf("Key(%v)", *k)
+	}
+}
+
+// Set is the method to set the flag value.
+func (k *Key) Set(value string) error {
+	switch value {
+	case "timestamp":
+		*k = KeyTimeStamp
+	case "trigger":
+		*k = KeyTrigger
+	default:
+		return fmt.Errorf("invalid event building key %q", value)
+	}
+	return nil
+}
+
+// Event is a group of frames with matching keys.
+type Event struct {
+	Key      uint64        // key of the first frame of the event
+	Frames   []interface{} // frames, in arrival order
+	Complete bool          // true if the event has the expected number of frames
+
+	opened uint64 // number of frames added to the builder when the event was opened
+}
+
+// Stats are the event building statistics.
+type Stats struct {
+	Frames     uint64 // number of frames added
+	Events     uint64 // number of events returned
+	Incomplete uint64 // number of incomplete events returned
+	LateFrames uint64 // number of frames dropped because their event was already returned
+	Resyncs    uint64 // number of resynchronizations after a backwards jump of the key
+}
+
+// Print prints the event building statistics.
+func (s *Stats) Print() {
+	fmt.Printf("Event building: %v frames, %v events (%v incomplete), %v late frames dropped, %v resynchronizations\n",
+		s.Frames, s.Events, s.Incomplete, s.LateFrames, s.Resyncs)
+}
+
+// Builder builds events from frames.
+type Builder struct {
+	Key      Key    // quantity used as key (used by readers to compute the key of their frames)
+	NoFrames int    // expected number of frames per event
+	Window   uint64 // maximal difference between the keys of frames of the same event
+	Timeout  int    // number of frames added after which an open event is closed
+	Resync   uint64 // backwards jump of the key above which the builder is resynchronized (0: never)
+	Extend   bool   // if true, complete events are closed once a frame of a later event was added
+	Stats    Stats
+
+	cur       epoch
+	prev      *epoch   // events opened before the last resynchronization, returned before those of cur
+	resyncKey uint64   // key of the frame which triggered the last resynchronization
+	flushed   []*Event // events closed by a second resynchronization, returned first
+}
+
+// epoch holds the open events between two resynchronizations.
+type epoch struct {
+	open    []*Event // open events, sorted by key
+	last    uint64   // key of the last returned event
+	started bool     // true once an event has been returned
+}
+
+// top returns the highest key of the events of ep, open or returned,
+// and false if no frame was added to ep yet.
+func (ep *epoch) top() (uint64, bool) {
+	if n := len(ep.open); n > 0 {
+		return ep.open[n-1].Key, true
+	}
+	return ep.last, ep.started
+}
+
+// DefaultResync is the default value of Builder.Resync for each key:
+// late frames have keys close to the key of the last returned event, while a
+// counter reset or wrap makes the key jump backwards by much more.
+var DefaultResync = map[Key]uint64{
+	KeyTimeStamp: 1 << 32,
+	KeyTrigger:   1 << 16,
+}
+
+// New returns a builder making events of noFrames frames, with a window of 0
+// (all frames of an event have the same key), a timeout of 8 events and
+// the default resynchronization threshold of key (see DefaultResync).
+func New(key Key, noFrames int) *Builder {
+	return &Builder{
+		Key:      key,
+		NoFrames: noFrames,
+		Timeout:  8 * noFrames,
+		Resync:   DefaultResync[key],
+	}
+}
+
+// Add adds a frame with the given key.
+// It returns false if the frame is late, i.e. if it belongs to an event that
+// was already returned by Next, in which case it is dropped.
+//
+// After a resynchronization, frames whose key is above the key which triggered
+// it by more than Resync are added to the events opened before it.
+func (b *Builder) Add(key uint64, frame interface{}) bool {
+	b.Stats.Frames++
+	ep := &b.cur
+	top, ok := b.cur.top()
+	switch {
+	case b.prev != nil && key > b.resyncKey && key-b.resyncKey > b.Resync:
+		ep = b.prev
+	case ok && b.Resync > 0 && key < top && top-key > b.Resync:
+		b.resync(key)
+	}
+	if ep.started && key <= ep.last+b.Window {
+		b.Stats.LateFrames++
+		return false
+	}
+	i := sort.Search(len(ep.open), func(i int) bool { return ep.open[i].Key+b.Window >= key })
+	if i < len(ep.open) && ep.open[i].Key <= key+b.Window {
+		ep.open[i].Frames = append(ep.open[i].Frames, frame)
+		return true
+	}
+	e := &Event{Key: key, Frames: []interface{}{frame}, opened: b.Stats.Frames}
+	ep.open = append(ep.open, nil)
+	copy(ep.open[i+1:], ep.open[i:])
+	ep.open[i] = e
+	return true
+}
+
+// resync starts a new epoch after a backwards jump of the key to key.
+// The events still open are kept to receive their late frames and are returned
+// before the events of the new epoch.
+func (b *Builder) resync(key uint64) {
+	if b.prev != nil {
+		b.flushed = append(b.flushed, b.prev.open...)
+	}
+	prev := b.cur
+	b.prev = &prev
+	b.cur = epoch{}
+	b.resyncKey = key
+	b.Stats.Resyncs++
+}
+
+// closed returns true if e, the open event of ep with the lowest key, can be returned.
+func (b *Builder) closed(ep *epoch, e *Event) bool {
+	if b.Extend && len(e.Frames) >= b.NoFrames {
+		return len(ep.open) > 1 || ep == b.prev
+	}
+	return len(e.Frames) >= b.NoFrames || b.Stats.Frames-e.opened >= uint64(b.Timeout)
+}
+
+// epoch returns the epoch whose events are returned first, or nil if there is no open event.
+func (b *Builder) epoch() *epoch {
+	if b.prev != nil && len(b.prev.open) == 0 {
+		b.prev = nil
+	}
+	switch {
+	case b.prev != nil:
+		return b.prev
+	case len(b.cur.open) > 0:
+		return &b.cur
+	default:
+		return nil
+	}
+}
+
+// Next returns the next closed event, or nil if the event with the lowest key is still open.
+func (b *Builder) Next() *Event {
+	if len(b.flushed) > 0 {
+		return b.popFlushed()
+	}
+	ep := b.epoch()
+	if ep == nil || !b.closed(ep, ep.open[0]) {
+		return nil
+	}
+	return b.pop(ep)
+}
+
+// Flush returns the event with the lowest key, even if it is still open, or nil if there is none.
+// It is used at the end of the stream to get the remaining events.
+func (b *Builder) Flush() *Event {
+	if len(b.flushed) > 0 {
+		return b.popFlushed()
+	}
+	ep := b.epoch()
+	if ep == nil {
+		return nil
+	}
+	return b.pop(ep)
+}
+
+func (b *Builder) pop(ep *epoch) *Event {
+	e := ep.open[0]
+	ep.open = ep.open[1:]
+	ep.last = e.Key
+	ep.started = true
+	return b.ret(e)
+}
+
+func (b *Builder) popFlushed() *Event {
+	e := b.flushed[0]
+	b.flushed = b.flushed[1:]
+	return b.ret(e)
+}
+
+func (b *Builder) ret(e *Event) *Event {
+	e.Complete = len(e.Frames) >= b.NoFrames
+	b.Stats.Events++
+	if !e.Complete {
+		b.Stats.Incomplete++
+	}
+	return e
+}
+
+// Len returns the number of open events.
+func (b *Builder) Len() int {
+	n := len(b.flushed) + len(b.cur.open)
+	if b.prev != nil {
+		n += len(b.prev.open)
+	}
+	return n
+}
+
+// Reset drops the open events, e.g. after seeking in the stream.
+// Statistics are kept.
+func (b *Builder) Reset() {
+	b.cur = epoch{}
+	b.prev = nil
+	b.flushed = nil
+}
@@ -0,0 +1,107 @@
+package evtbuilder
+
+import (
+	"reflect"
+	"testing"
+)
+
+// build adds the frames with the given keys (the frames are their indices)
+// and returns the keys and frames of the events, in returned order.
+func build(b *Builder, keys []uint64) ([]uint64, [][]interface{}) {
+	var (
+		evtKeys []uint64
+		frames  [][]interface{}
+	)
+	get := func(e *Event) {
+		evtKeys = append(evtKeys, e.Key)
+		frames = append(frames, e.Frames)
+	}
+	for i, key := range keys {
+		b.Add(key, i)
+		for e := b.Next(); e != nil; e = b.Next() {
+			get(e)
+		}
+	}
+	for e := b.Flush(); e != nil; e = b.Flush() {
+		get(e)
+	}
+	return evtKeys, frames
+}
+
+func TestBuilder(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        Key
+		extend     bool
+		keys       []uint64
+		wantKeys   []uint64
+		wantFrames [][]interface{}
+		wantStats  Stats
+	}{
+		{
+			name:       "interleaved",
+			key:        KeyTrigger,
+			keys:       []uint64{1, 2, 1, 2, 3, 3},
+			wantKeys:   []uint64{1, 2, 3},
+			wantFrames: [][]interface{}{{0, 2}, {1, 3}, {4, 5}},
+			wantStats:  Stats{Frames: 6, Events: 3},
+		},
+		{
+			name:       "late frame",
+			key:        KeyTrigger,
+			keys:       []uint64{1, 1, 2, 2, 1, 3, 3},
+			wantKeys:   []uint64{1, 2, 3},
+			wantFrames: [][]interface{}{{0, 1}, {2, 3}, {5, 6}},
+			wantStats:  Stats{Frames: 7, Events: 3, LateFrames: 1},
+		},
+		{
+			name:       "counter wrap",
+			key:        KeyTrigger,
+			keys:       []uint64{0xfffffffe, 0xfffffffe, 0xffffffff, 0, 0xffffffff, 0, 1, 1},
+			wantKeys:   []uint64{0xfffffffe, 0xffffffff, 0, 1},
+			wantFrames: [][]interface{}{{0, 1}, {2, 4}, {3, 5}, {6, 7}},
+			wantStats:  Stats{Frames: 8, Events: 4, Resyncs: 1},
+		},
+		{
+			name:       "timestamp reset",
+			key:        KeyTimeStamp,
+			keys:       []uint64{1 << 40, 1 << 40, 1<<40 + 100, 20, 20, 1<<40 + 100},
+			wantKeys:   []uint64{1 << 40, 1<<40 + 100, 20},
+			wantFrames: [][]interface{}{{0, 1}, {2, 5}, {3, 4}},
+			wantStats:  Stats{Frames: 6, Events: 3, Resyncs: 1},
+		},
+		{
+			name:       "extended events",
+			key:        KeyTimeStamp,
+			extend:     true,
+			keys:       []uint64{10, 10, 10, 10, 20, 20, 20, 30, 40, 30, 50},
+			wantKeys:   []uint64{10, 20, 30, 40, 50},
+			wantFrames: [][]interface{}{{0, 1, 2, 3}, {4, 5, 6}, {7, 9}, {8}, {10}},
+			wantStats:  Stats{Frames: 11, Events: 5, Incomplete: 2},
+		},
+		{
+			name:       "events cut without extend",
+			key:        KeyTimeStamp,
+			keys:       []uint64{10, 10, 10, 10, 20, 20},
+			wantKeys:   []uint64{10, 20},
+			wantFrames: [][]interface{}{{0, 1}, {4, 5}},
+			wantStats:  Stats{Frames: 6, Events: 2, LateFrames: 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New(test.key, 2)
+			b.Extend = test.extend
+			keys, frames := build(b, test.keys)
+			if !reflect.DeepEqual(keys, test.wantKeys) {
+				t.Errorf("event keys = %v, want %v", keys, test.wantKeys)
+			}
+			if !reflect.DeepEqual(frames, test.wantFrames) {
+				t.Errorf("event frames = %v, want %v", frames, test.wantFrames)
+			}
+			if b.Stats != test.wantStats {
+				t.Errorf("stats = %+v, want %+v", b.Stats, test.wantStats)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Package evtindex implements the index of raw binary data files.
+// The index is stored in a sidecar file next to the data file (see FileName)
+// and gives, for each event, its ID, its timestamp and the byte offset in the
+// data file at which it starts, so that readers can jump directly to an event
+// or to a time slice instead of decoding the file sequentially from the start.
+package evtindex
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+	"sort"
+)
+
+// Ext is the extension added to the name of a data file to get the name of its index.
+const Ext = ".idx"
+
+var (
+	// ErrNotFound is returned when no event in the index matches the requested event ID or time.
+	ErrNotFound = errors.New("evtindex: event not found")
+	// ErrNoIndex is returned by readers asked to seek before an index was set.
+	ErrNoIndex = errors.New("evtindex: no index")
+	// ErrNotSeekable is returned by readers asked to seek in a stream that
+	// does not implement io.Seeker (e.g. TCP stream or bufio.Reader).
+	ErrNotSeekable = errors.New("evtindex: stream is not seekable")
+)
+
+// Entry describes one event of a data file.
+type Entry struct {
+	EventID uint64 // event ID (as returned in event.Event.ID)
+	Time    uint64 // event timestamp, in the clock units of the data format
+	Offset  int64  // byte offset of the first byte of the event in the data file
+}
+
+// Index is the list of the events of a data file, in file order.
+type Index struct {
+	DataFileName string // name of the indexed data file
+	Entries      []Entry
+}
+
+// FileName returns the name of the index file associated with the data file dataFileName.
+func FileName(dataFileName string) string {
+	return dataFileName + Ext
+}
+
+// New returns an empty index for the data file dataFileName.
+func New(dataFileName string) *Index {
+	return &Index{DataFileName: dataFileName}
+}
+
+// Add appends an entry to the index.
+func (idx *Index) Add(eventID, time uint64, offset int64) {
+	idx.Entries = append(idx.Entries, Entry{EventID: eventID, Time: time, Offset: offset})
+}
+
+// Len returns the number of events in the index.
+func (idx *Index) Len() int {
+	return len(idx.Entries)
+}
+
+// Find returns the entry of the first event whose ID is larger or equal to eventID.
+// Event IDs are expected to increase along the file, which allows for a binary search.
+// If they do not (e.g. after a counter reset), the first event with the requested ID is
+// looked for sequentially.
+func (idx *Index) Find(eventID uint64) (Entry, error) {
+	if idx.sorted(func(e *Entry) uint64 { return e.EventID }) {
+		i := sort.Search(len(idx.Entries), func(i int) bool { return idx.Entries[i].EventID >= eventID })
+		if i == len(idx.Entries) {
+			return Entry{}, ErrNotFound
+		}
+		return idx.Entries[i], nil
+	}
+	for _, e := range idx.Entries {
+		if e.EventID == eventID {
+			return e, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+// FindTime returns the entry of the first event whose timestamp is larger or equal to t.
+func (idx *Index) FindTime(t uint64) (Entry, error) {
+	if idx.sorted(func(e *Entry) uint64 { return e.Time }) {
+		i := sort.Search(len(idx.Entries), func(i int) bool { return idx.Entries[i].Time >= t })
+		if i == len(idx.Entries) {
+			return Entry{}, ErrNotFound
+		}
+		return idx.Entries[i], nil
+	}
+	for _, e := range idx.Entries {
+		if e.Time >= t {
+			return e, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+// sorted returns true if the quantity returned by val does not decrease along the index.
+func (idx *Index) sorted(val func(e *Entry) uint64) bool {
+	for i := 1; i < len(idx.Entries); i++ {
+		if val(&idx.Entries[i]) < val(&idx.Entries[i-1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Write writes the index to the file fileName.
+func (idx *Index) Write(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = gob.NewEncoder(f).Encode(idx)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Read reads the index stored in the file fileName.
+func Read(fileName string) (*Index, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx := &Index{}
+	err = gob.NewDecoder(f).Decode(idx)
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// ReadFor reads the index associated with the data file dataFileName (see FileName).
+func ReadFor(dataFileName string) (*Index, error) {
+	return Read(FileName(dataFileName))
+}
@@ -0,0 +1,105 @@
+package evtindex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testIndex returns an index whose entries have the given event IDs and times,
+// the offset of entry i being 100*i.
+func testIndex(ids, times []uint64) *Index {
+	idx := New("run.bin")
+	for i := range ids {
+		idx.Add(ids[i], times[i], int64(100*i))
+	}
+	return idx
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name   string
+		ids    []uint64
+		id     uint64
+		offset int64 // offset of the entry found, -1 if none
+	}{
+		{"first", []uint64{0, 1, 2, 3}, 0, 0},
+		{"middle", []uint64{0, 1, 2, 3}, 2, 200},
+		{"last", []uint64{0, 1, 2, 3}, 3, 300},
+		{"beyond the last", []uint64{0, 1, 2, 3}, 4, -1},
+		{"missing ID", []uint64{0, 2, 4, 6}, 3, 200},
+		{"first ID larger", []uint64{5, 6, 7}, 2, 0},
+		{"repeated ID", []uint64{0, 1, 1, 2}, 1, 100},
+		{"empty", nil, 0, -1},
+		// after a counter reset, the first event with the ID is returned
+		{"unsorted", []uint64{10, 11, 12, 0, 1, 11}, 11, 100},
+		{"unsorted after reset", []uint64{10, 11, 12, 0, 1, 2}, 1, 400},
+		{"unsorted missing ID", []uint64{10, 11, 12, 0, 1, 2}, 5, -1},
+	}
+	for _, test := range tests {
+		idx := testIndex(test.ids, make([]uint64, len(test.ids)))
+		e, err := idx.Find(test.id)
+		switch {
+		case test.offset < 0 && err != ErrNotFound:
+			t.Errorf("%v: Find(%v) = %v, %v, want %v", test.name, test.id, e, err, ErrNotFound)
+		case test.offset >= 0 && (err != nil || e.Offset != test.offset):
+			t.Errorf("%v: Find(%v) = %v, %v, want offset %v", test.name, test.id, e, err, test.offset)
+		}
+	}
+}
+
+func TestFindTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		times  []uint64
+		time   uint64
+		offset int64 // offset of the entry found, -1 if none
+	}{
+		{"exact", []uint64{10, 20, 30, 40}, 20, 100},
+		{"between", []uint64{10, 20, 30, 40}, 25, 200},
+		{"before the first", []uint64{10, 20, 30, 40}, 0, 0},
+		{"beyond the last", []uint64{10, 20, 30, 40}, 41, -1},
+		{"same times", []uint64{10, 20, 20, 40}, 15, 100},
+		{"empty", nil, 0, -1},
+		// after a timestamp reset, the first event in file order is returned
+		{"unsorted", []uint64{100, 200, 300, 10, 20}, 150, 100},
+		{"unsorted after reset", []uint64{100, 200, 300, 10, 20}, 5, 0},
+		{"unsorted beyond the last", []uint64{100, 200, 300, 10, 20}, 400, -1},
+	}
+	for _, test := range tests {
+		idx := testIndex(make([]uint64, len(test.times)), test.times)
+		e, err := idx.FindTime(test.time)
+		switch {
+		case test.offset < 0 && err != ErrNotFound:
+			t.Errorf("%v: FindTime(%v) = %v, %v, want %v", test.name, test.time, e, err, ErrNotFound)
+		case test.offset >= 0 && (err != nil || e.Offset != test.offset):
+			t.Errorf("%v: FindTime(%v) = %v, %v, want offset %v", test.name, test.time, e, err, test.offset)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evtindex-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	dataFileName := filepath.Join(dir, "run.bin")
+
+	idx := testIndex([]uint64{0, 1, 2}, []uint64{10, 20, 30})
+	if err := idx.Write(FileName(dataFileName)); err != nil {
+		t.Fatalf("could not write index: %v\n", err)
+	}
+	got, err := ReadFor(dataFileName)
+	if err != nil {
+		t.Fatalf("could not read index: %v\n", err)
+	}
+	if !reflect.DeepEqual(got, idx) {
+		t.Errorf("index read back = %v, want %v", got, idx)
+	}
+	if _, err := ReadFor(filepath.Join(dir, "other.bin")); err == nil {
+		t.Errorf("missing index read without error")
+	}
+}
@@ -0,0 +1,48 @@
+module gitlab.in2p3.fr/avirm/analysis-go
+
+go 1.26.0
+
+require (
+	github.com/gonum/blas v0.0.0-20181208220705-f22b278b28ac
+	github.com/gonum/floats v0.0.0-20181209220543-c233463c7e82
+	github.com/gonum/internal v0.0.0-20181124074243-f884aa714029
+	github.com/gonum/lapack v0.0.0-20181123203213-e4cdc5a0bff9
+	github.com/gonum/mathext v0.0.0-20181121095525-8a4bf007ea55
+	github.com/gonum/matrix v0.0.0-20181209220409-c518dec07be9
+	github.com/gonum/stat v0.0.0-20181125101827-41a0da705a5b
+	github.com/parquet-go/parquet-go v0.32.0
+	github.com/toqueteos/webbrowser v1.2.1
+	go-hep.org/x/hep v0.37.1
+	golang.org/x/net v0.60.0
+	gonum.org/v1/gonum v0.17.0
+	gonum.org/v1/plot v0.17.0
+)
+
+require (
+	codeberg.org/go-fonts/liberation v0.5.0 // indirect
+	codeberg.org/go-latex/latex v0.2.0 // indirect
+	codeberg.org/go-mmap/mmap v0.8.0 // indirect
+	codeberg.org/go-pdf/fpdf v0.11.1 // indirect
+	codeberg.org/gonuts/binary v0.3.2 // indirect
+	git.sr.ht/~sbinet/gg v0.7.0 // indirect
+	github.com/ajstarks/svgo v0.0.0-20211024235047-1546f124cd8b // indirect
+	github.com/andybalholm/brotli v1.1.1 // indirect
+	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/hashicorp/go-uuid v1.0.3 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/parquet-go/bitpack v1.0.0 // indirect
+	github.com/parquet-go/jsonlite v1.0.0 // indirect
+	github.com/pierrec/lz4/v4 v4.1.22 // indirect
+	github.com/pierrec/xxHash v0.1.5 // indirect
+	github.com/twpayne/go-geom v1.6.1 // indirect
+	github.com/ulikunitz/xz v0.5.12 // indirect
+	golang.org/x/image v0.30.0 // indirect
+	golang.org/x/mod v0.41.0 // indirect
+	golang.org/x/sync v0.23.0 // indirect
+	golang.org/x/sys v0.48.0 // indirect
+	golang.org/x/text v0.42.0 // indirect
+	golang.org/x/tools v0.49.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,188 @@
+// Package pipeline implements a parallel event processing pipeline.
+//
+// The raw data of the events is read sequentially from a rwi.Reader, while event
+// building (when supported by the reader, see rwi.Builder) and processing stages
+// (calibration, pulse feature extraction, LOR finding, ...) run on a pool of workers.
+// Events are returned by ReadNextEvent in the order in which they were read, so that
+// trees and DQ plots can be filled sequentially as with a plain reader:
+//
+//	p := pipeline.New(r, runtime.NumCPU(), pipeline.Correct(true, true, true), pipeline.Features(), pipeline.DefaultLORs())
+//	defer p.Close()
+//	for event, _ := p.ReadNextEvent(); event != nil; event, _ = p.ReadNextEvent() {
+//		dqplots.FillHistos(event)
+//	}
+//
+// Stages must only read global state (e.g. calibration constants of dpgadetector.Det);
+// anything accumulating over events (e.g. event.PushPedestalSamples) has to be done
+// on the events returned by ReadNextEvent.
+package pipeline
+
+import (
+	"sync"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
+)
+
+// Stage is a processing step applied to each event by the workers.
+// It returns the processed event, which can be a new event (e.g. a corrected copy),
+// or nil to drop the event.
+type Stage func(e *event.Event) *event.Event
+
+// result is the output of a worker for one event.
+type result struct {
+	e   *event.Event
+	raw *event.Event // event as built, before the stages
+	err error
+}
+
+// job is the input of a worker for one event.
+// The result is sent on out, which is read by ReadNextEvent in reading order.
+type job struct {
+	raw interface{}
+	out chan result
+}
+
+// Pipeline reads events from a rwi.Reader and processes them concurrently.
+type Pipeline struct {
+	b      rwi.Builder
+	stages []Stage
+
+	jobs  chan job
+	order chan chan result // outputs of the jobs, in reading order
+	quit  chan struct{}
+	done  chan struct{}  // closed when the reading stops
+	wg    sync.WaitGroup // workers
+	once  sync.Once
+	err   error // error which stopped the reading (io.EOF at the end of the stream)
+
+	// DroppedErrs holds the errors returned by the reader (e.g. failed
+	// integrity tests) for the events dropped by a stage, in reading order.
+	// It is filled by ReadNextEvent, and must only be read by its caller.
+	DroppedErrs []error
+}
+
+// New returns a pipeline reading events from r and applying the stages, in the
+// given order, on noWorkers workers. The pipeline starts reading right away.
+// The number of events read in advance is limited to a few times noWorkers.
+func New(r rwi.Reader, noWorkers int, stages ...Stage) *Pipeline {
+	if noWorkers < 1 {
+		noWorkers = 1
+	}
+	p := &Pipeline{
+		b:      rwi.NewBuilder(r),
+		stages: stages,
+		jobs:   make(chan job, noWorkers),
+		order:  make(chan chan result, 4*noWorkers),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	p.wg.Add(noWorkers)
+	go p.read()
+	for i := 0; i < noWorkers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// read reads the raw events sequentially and dispatches them to the workers.
+func (p *Pipeline) read() {
+	defer close(p.done)
+	defer close(p.order)
+	defer close(p.jobs)
+	for {
+		raw, err := p.b.ReadRaw()
+		if raw == nil {
+			p.err = err
+			return
+		}
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+		j := job{raw: raw, out: make(chan result, 1)}
+		select {
+		case p.order <- j.out:
+		case <-p.quit:
+			return
+		}
+		select {
+		case p.jobs <- j:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// work builds and processes events until the reading stops or the pipeline is closed.
+func (p *Pipeline) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case j, ok := <-p.jobs:
+			if !ok {
+				return
+			}
+			j.out <- p.process(j.raw)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// process builds the event from raw data and applies the stages.
+func (p *Pipeline) process(data interface{}) result {
+	raw, err := p.b.Build(data)
+	e := raw
+	for _, stage := range p.stages {
+		if e == nil {
+			break
+		}
+		e = stage(e)
+	}
+	return result{e, raw, err}
+}
+
+// ReadNextEvent returns the next processed event, in reading order.
+// Events dropped by a stage are skipped, their errors are appended to DroppedErrs.
+// At the end of the stream, it returns a nil event and io.EOF (or the reading error).
+// It must not be called after Close.
+func (p *Pipeline) ReadNextEvent() (*event.Event, error) {
+	e, _, err := p.ReadNextEventRaw()
+	return e, err
+}
+
+// ReadNextEventRaw is like ReadNextEvent, but also returns the event as built
+// by the reader, before the stages (e.g. to write the raw data to a file).
+// Stages working in place (e.g. Features) modify the raw event too, while
+// Correct works on a copy when corrections are applied.
+func (p *Pipeline) ReadNextEventRaw() (e, raw *event.Event, err error) {
+	for out := range p.order {
+		res := <-out
+		if res.e == nil {
+			if res.err != nil {
+				p.DroppedErrs = append(p.DroppedErrs, res.err)
+			}
+			continue
+		}
+		return res.e, res.raw, res.err
+	}
+	return nil, nil, p.err
+}
+
+// Close stops the pipeline and waits for the workers to stop.
+// A read pending on the underlying reader (e.g. waiting for data on a socket)
+// is not interrupted: Close does not wait for it, and the reading stops as soon
+// as it returns. Use Wait before using the underlying reader again.
+func (p *Pipeline) Close() {
+	p.once.Do(func() {
+		close(p.quit)
+		p.wg.Wait()
+	})
+}
+
+// Wait waits for the reading to stop, at the end of the stream or after Close.
+func (p *Pipeline) Wait() {
+	<-p.done
+}
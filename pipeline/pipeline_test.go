@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+)
+
+var errIntegrity = errors.New("integrity test failed")
+
+// testReader returns noEvents events with increasing IDs, then io.EOF.
+// Events whose ID is a multiple of 5 are returned with errIntegrity.
+// If block is not nil, reading the event of ID blockAt waits for block to be closed.
+type testReader struct {
+	id       uint
+	noEvents uint
+	blockAt  uint
+	block    chan struct{}
+}
+
+func (r *testReader) ReadNextEvent() (*event.Event, error) {
+	if r.id >= r.noEvents {
+		return nil, io.EOF
+	}
+	if r.block != nil && r.id == r.blockAt {
+		<-r.block
+	}
+	e := event.NewEvent(1, 0)
+	e.ID = r.id
+	r.id++
+	if e.ID%5 == 0 {
+		return e, errIntegrity
+	}
+	return e, nil
+}
+
+func (r *testReader) SetSigThreshold(val uint) {}
+func (r *testReader) NoSamples() uint16        { return 0 }
+func (r *testReader) SetDebug()                {}
+
+// shuffle is a stage sleeping for a random duration, so that workers finish out of order.
+func shuffle(e *event.Event) *event.Event {
+	time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+	return e
+}
+
+func TestOrder(t *testing.T) {
+	const noEvents = 200
+	p := New(&testReader{noEvents: noEvents}, 8, shuffle)
+	defer p.Close()
+	n := uint(0)
+	for e, err := p.ReadNextEvent(); e != nil; e, err = p.ReadNextEvent() {
+		if e.ID != n {
+			t.Fatalf("event %v returned at position %v\n", e.ID, n)
+		}
+		if (err == errIntegrity) != (e.ID%5 == 0) {
+			t.Errorf("event %v returned with error %v\n", e.ID, err)
+		}
+		n++
+	}
+	if n != noEvents {
+		t.Errorf("got %v events, want %v\n", n, noEvents)
+	}
+	if _, err := p.ReadNextEvent(); err != io.EOF {
+		t.Errorf("got error %v at the end of the stream, want io.EOF\n", err)
+	}
+}
+
+func TestDroppedErrors(t *testing.T) {
+	const noEvents = 100
+	odd := Select(func(e *event.Event) bool { return e.ID%2 == 1 })
+	p := New(&testReader{noEvents: noEvents}, 4, shuffle, odd)
+	defer p.Close()
+	noErrs := 0
+	for e, err := p.ReadNextEvent(); e != nil; e, err = p.ReadNextEvent() {
+		if e.ID%2 != 1 {
+			t.Errorf("event %v was not dropped\n", e.ID)
+		}
+		if err != nil {
+			noErrs++
+		}
+	}
+	// errors of events 5, 15, ..., 95 are returned with the events,
+	// those of events 0, 10, ..., 90 are in DroppedErrs.
+	if noErrs != noEvents/10 {
+		t.Errorf("got %v errors with the events, want %v\n", noErrs, noEvents/10)
+	}
+	if len(p.DroppedErrs) != noEvents/10 {
+		t.Errorf("got %v errors of dropped events, want %v\n", len(p.DroppedErrs), noEvents/10)
+	}
+	for _, err := range p.DroppedErrs {
+		if err != errIntegrity {
+			t.Errorf("wrong error of dropped event: %v\n", err)
+		}
+	}
+}
+
+func TestRawEvent(t *testing.T) {
+	copyStage := func(e *event.Event) *event.Event { return e.Copy() }
+	p := New(&testReader{noEvents: 10}, 2, copyStage)
+	defer p.Close()
+	for e, raw, _ := p.ReadNextEventRaw(); e != nil; e, raw, _ = p.ReadNextEventRaw() {
+		if raw == nil || raw == e || raw.ID != e.ID {
+			t.Fatalf("wrong raw event for event %v\n", e.ID)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	// Close before the end of the stream
+	r := &testReader{noEvents: 1000}
+	p := New(r, 4, shuffle)
+	for i := 0; i < 10; i++ {
+		if e, _ := p.ReadNextEvent(); e == nil || e.ID != uint(i) {
+			t.Fatalf("wrong event %v\n", i)
+		}
+	}
+	p.Close()
+	p.Wait()
+	if r.id >= r.noEvents {
+		t.Errorf("whole stream read before Close\n")
+	}
+	p.Close() // closing twice is allowed
+
+	// Close while the reading is blocked, e.g. waiting for data on a socket
+	block := make(chan struct{})
+	r = &testReader{noEvents: 1000, blockAt: 3, block: block}
+	p = New(r, 4)
+	for i := 0; i < 3; i++ {
+		if e, _ := p.ReadNextEvent(); e == nil {
+			t.Fatalf("missing event %v\n", i)
+		}
+	}
+	closed := make(chan struct{})
+	go func() {
+		p.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close blocked by the pending read\n")
+	}
+	close(block)
+	p.Wait()
+	if r.id != 4 {
+		t.Errorf("reading went on after Close: %v events read\n", r.id)
+	}
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"gitlab.in2p3.fr/avirm/analysis-go/applyCorrCalib"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/reconstruction"
+)
+
+// Correct returns a stage applying the corrections and calibrations (see applyCorrCalib.CorrectEvent).
+func Correct(doPedestal bool, doTimeDepOffset bool, doEnergyCalib bool) Stage {
+	return func(e *event.Event) *event.Event {
+		return applyCorrCalib.CorrectEvent(e, doPedestal, doTimeDepOffset, doEnergyCalib)
+	}
+}
+
+// Features returns a stage computing the features of the pulses with signal
+// (amplitude, rising and falling front times) and fitting the RF signal of the event.
+// It should be run after the corrections.
+func Features() Stage {
+	return func(e *event.Event) *event.Event {
+		for i := range e.Clusters {
+			cluster := &e.Clusters[i]
+			for j := range cluster.Pulses {
+				pulse := &cluster.Pulses[j]
+				if pulse.HasSignal {
+					pulse.CalcRisingFront(true)
+					pulse.CalcFallingFront(false)
+				}
+			}
+		}
+		e.FitRF()
+		return e
+	}
+}
+
+// LORs returns a stage finding the LORs of the event (see event.FindLORs).
+// It should be run after the corrections.
+func LORs(xbeam, ybeam, RmarMax, DeltaTMax, Emin, Emax float64, earlyTimePulses bool) Stage {
+	return func(e *event.Event) *event.Event {
+		e.FindLORs(xbeam, ybeam, RmarMax, DeltaTMax, Emin, Emax, earlyTimePulses)
+		return e
+	}
+}
+
+// AttCorrection returns a stage computing the attenuation correction factors of
+// the LORs of the event with the attenuation map m (see event.LOR.CalcAttCorr).
+// It should be run after the LORs stage.
+func AttCorrection(m *reconstruction.MuMap) Stage {
+	return func(e *event.Event) *event.Event {
+		for i := range e.LORs {
+			e.LORs[i].CalcAttCorr(m)
+		}
+		return e
+	}
+}
+
+// DefaultLORs returns the LORs stage with the parameters used for the trees,
+// the DQ plots and the exported data of the DPGA (dpga/trees, dpga/dq, dpga/export),
+// so that all of them are filled with the same LORs.
+func DefaultLORs() Stage {
+	return LORs(0, 0, 25., 3*1.2, 0, 1000, true) //511+3*28.3)
+}
+
+// Select returns a stage dropping the events for which keep returns false.
+func Select(keep func(e *event.Event) bool) Stage {
+	return func(e *event.Event) *event.Event {
+		if !keep(e) {
+			return nil
+		}
+		return e
+	}
+}
@@ -127,7 +127,7 @@ func (p *Pulse) Print(detailed bool) {
 				}
 			}
 		}
-		fmt.Println("\n")
+		fmt.Print("\n\n")
 	}
 }
 
@@ -476,6 +476,20 @@ func (p *Pulse) AverageAmp() float64 {
 	return p.AvAmp
 }
 
+// RMS computes the root mean square deviation of the amplitudes of the samples
+// of this pulse from their average, i.e. the noise for pulses without signal
+func (p *Pulse) RMS() float64 {
+	if len(p.Samples) == 0 {
+		return 0
+	}
+	mean := p.AverageAmp()
+	var sum2 float64
+	for _, samp := range p.Samples {
+		sum2 += (samp.Amplitude - mean) * (samp.Amplitude - mean)
+	}
+	return math.Sqrt(sum2 / float64(len(p.Samples)))
+}
+
 // Correlation computes the correlation between two pulses
 func (p *Pulse) Correlation(pu *Pulse) float64 {
 	amplitudes1 := p.MakeAmpSlice()
@@ -493,6 +507,11 @@ type Cluster struct {
 	NoFrameAsm    uint64
 	TimeStampAsm  uint64
 	Quartet       *detector.Quartet
+
+	// CountersFifo1 and CountersFifo2 are the counters read in the frames of
+	// the two fifos of the cluster (test bench only)
+	CountersFifo1 []uint32
+	CountersFifo2 []uint32
 }
 
 // NewClusterFromID constructs a new cluster from ID only
@@ -530,6 +549,16 @@ func (c *Cluster) NoSamples() uint16 {
 	return noSamples
 }
 
+// CounterFifo1 returns the i-th counter of the first fifo of the cluster
+func (c *Cluster) CounterFifo1(i int) uint32 {
+	return c.CountersFifo1[i]
+}
+
+// CounterFifo2 returns the i-th counter of the second fifo of the cluster
+func (c *Cluster) CounterFifo2(i int) uint32 {
+	return c.CountersFifo2[i]
+}
+
 // SRout returns the srout (common to all pulses in the cluster)
 func (c *Cluster) SetSRout() uint16 {
 	srout := c.Pulses[0].SRout
@@ -673,10 +702,7 @@ const (
 
 // PlotPulses plots the four pulses of the cluster in one canvas
 func (c *Cluster) PlotPulses(evtID uint, x XaxisType, yrange YRange, xrange XRange) string {
-	p, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	p := plot.New()
 
 	p.Title.Text = "Pulse for event " + strconv.Itoa(int(evtID)) + " cluster " + strconv.Itoa(int(c.ID))
 	switch x {
@@ -701,7 +727,7 @@ func (c *Cluster) PlotPulses(evtID uint, x XaxisType, yrange YRange, xrange XRan
 			TextsAndXYs = append(TextsAndXYs, c.Pulses[i].MakeXY(x))
 		}
 	}
-	err = plotutil.AddLinePoints(p, TextsAndXYs...)
+	err := plotutil.AddLinePoints(p, TextsAndXYs...)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,122 @@
+package pulse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Var is a pulse variable which is not part of the default content of the
+// output trees and can be requested from the command line (see ExtraVars).
+type Var struct {
+	Name string
+	Doc  string
+	Func func(p *Pulse) float64
+}
+
+// CFDFraction is the fraction of the amplitude used by the CFD variable.
+var CFDFraction = 0.5
+
+// PSDTailDelay is the delay (ns) after the maximum of the pulse from which the
+// tail of the pulse starts, for the PSD variable.
+var PSDTailDelay = 20.
+
+// Vars holds the pulse variables which can be requested as extra variables.
+var Vars = map[string]Var{
+	"CFD": {
+		Name: "CFD",
+		Doc:  "time (ns) of the digital constant fraction discriminator, at CFDFraction of the amplitude",
+		Func: func(p *Pulse) float64 {
+			t, _, _ := p.T(true, CFDFraction, 0)
+			return t
+		},
+	},
+	"PSD": {
+		Name: "PSD",
+		Doc:  "pulse shape discrimination ratio: charge in the tail (starting PSDTailDelay ns after the maximum) over total charge",
+		Func: psdRatio,
+	},
+	"AvAmp": {
+		Name: "AvAmp",
+		Doc:  "average amplitude of the samples",
+		Func: (*Pulse).AverageAmp,
+	},
+	"AmplIndex": {
+		Name: "AmplIndex",
+		Doc:  "index of the sample with the highest amplitude",
+		Func: func(p *Pulse) float64 {
+			i, _ := p.Amplitude()
+			return float64(i)
+		},
+	},
+	"FirstCapa": {
+		Name: "FirstCapa",
+		Doc:  "number of the first capacitor of the pulse (SRout)",
+		Func: func(p *Pulse) float64 { return float64(p.SRout) },
+	},
+}
+
+func psdRatio(p *Pulse) float64 {
+	if len(p.Samples) == 0 {
+		return 0
+	}
+	p.Amplitude()
+	tTail := p.Samples[p.AmplIndex].Time + PSDTailDelay
+	var total, tail float64
+	for _, s := range p.Samples {
+		total += s.Amplitude
+		if s.Time >= tTail {
+			tail += s.Amplitude
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return tail / total
+}
+
+// VarList is a list of pulse variables, set from a comma separated list of names.
+type VarList []Var
+
+func (l *VarList) String() string {
+	names := make([]string, len(*l))
+	for i, v := range *l {
+		names[i] = v.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// Set is the method to set the flag value.
+func (l *VarList) Set(value string) error {
+	*l = (*l)[:0]
+	for _, name := range strings.Split(value, ",") {
+		v, ok := Vars[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("unknown pulse variable %q (possible values: %v)", name, strings.Join(VarNames(), ", "))
+		}
+		*l = append(*l, v)
+	}
+	return nil
+}
+
+// VarNames returns the sorted names of the variables in Vars.
+func VarNames() []string {
+	var names []string
+	for name := range Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExtraVars is the list of the extra variables written for each pulse to the
+// output trees, in addition to their default content. It is typically set from
+// the command line:
+//
+//	flag.Var(&pulse.ExtraVars, "vars", pulse.ExtraVarsUsage())
+var ExtraVars VarList
+
+// ExtraVarsUsage returns the usage message of the flag setting ExtraVars.
+func ExtraVarsUsage() string {
+	return "Comma separated list of extra pulse variables written to the output trees (possible values: " + strings.Join(VarNames(), ", ") + ")"
+}
@@ -0,0 +1,496 @@
+// Package rawz implements the compressed container of raw binary data files.
+//
+// A compressed file starts with the 4 bytes magic "AVZ\x01" and is made of
+// independent chunks of at most ChunkSize bytes of the original stream. Each
+// chunk is made of a header (see chunkHeaderSize) followed by the chunk data,
+// either stored as is or compressed as follows:
+//   - samples are delta-encoded: the stream is seen as 16 bits big endian words and
+//     each word is replaced by its difference with the word stride words before
+//     (stride is 1 when samples are 16 bits words, as in rct files, and 2 when two
+//     samples are packed into a 32 bits word, as in dpga files);
+//   - the high and low bytes of the words are separated into two planes, so that
+//     the (mostly 0 or 0xff) high bytes of the small differences are contiguous;
+//   - the result is compressed with DEFLATE at its fastest level.
+//
+// Since chunks are independent, a Reader reading from an io.ReadSeeker can seek
+// to any position of the original stream by decompressing a single chunk, so that
+// the offsets stored in event indices (see package evtindex) remain valid.
+//
+// Readers of the binary formats (dpga/rw, dpgatca/rw, dpgatca/rwvme, rct/rw) call
+// Open on their input, so that compressed files are decompressed transparently.
+package rawz
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic is the first bytes of compressed files.
+const Magic = "AVZ\x01"
+
+// Ext is the extension conventionally added to the name of compressed files (e.g. run123.bin.z).
+const Ext = ".z"
+
+// Delta-encoding strides, in 16 bits words.
+const (
+	Stride16 = 1 // one 16 bits sample per 16 bits word
+	Stride32 = 2 // two 12 bits samples per 32 bits word
+)
+
+// ChunkSize is the default maximal number of bytes of the original stream per chunk.
+const ChunkSize = 1 << 20
+
+// codecs of the chunk data
+const (
+	codecStored  = 0
+	codecDeflate = 1
+)
+
+// chunkHeaderSize is the size of the chunk header: size of the original data (uint32),
+// size of the chunk data (uint32), codec (byte), delta-encoding stride (byte), 2 unused bytes.
+const chunkHeaderSize = 12
+
+var (
+	// ErrFormat is returned when reading a stream which is not a valid compressed stream.
+	ErrFormat = errors.New("rawz: invalid compressed stream")
+	// ErrNotSeekable is returned by Reader.Seek when the underlying stream does not implement io.Seeker.
+	ErrNotSeekable = errors.New("rawz: stream is not seekable")
+)
+
+// Writer compresses the data written to it and writes the compressed container to an io.Writer.
+type Writer struct {
+	// ChunkSize is the maximal number of bytes of the original stream per chunk.
+	ChunkSize int
+
+	w      io.Writer
+	err    error
+	stride int
+	n      int64  // number of bytes written to w
+	buf    []byte // pending data of the current chunk
+	zbuf   bytes.Buffer
+	fw     *flate.Writer
+}
+
+// NewWriter returns a writer compressing data with the given delta-encoding stride
+// (Stride16 or Stride32) and writing them to w.
+func NewWriter(w io.Writer, stride int) *Writer {
+	zw := &Writer{
+		ChunkSize: ChunkSize,
+		w:         w,
+		stride:    stride,
+	}
+	zw.write([]byte(Magic))
+	return zw
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(p)
+	w.n += int64(n)
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		k := w.ChunkSize - len(w.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		if len(w.buf) >= w.ChunkSize {
+			w.Flush()
+		}
+	}
+	return n, w.err
+}
+
+// Flush compresses and writes the pending data as a chunk.
+func (w *Writer) Flush() error {
+	if w.err != nil || len(w.buf) == 0 {
+		return w.err
+	}
+	encodeDelta(w.buf, w.stride)
+	planes := splitPlanes(w.buf)
+	w.zbuf.Reset()
+	if w.fw == nil {
+		w.fw, w.err = flate.NewWriter(&w.zbuf, flate.BestSpeed)
+		if w.err != nil {
+			return w.err
+		}
+	} else {
+		w.fw.Reset(&w.zbuf)
+	}
+	if _, w.err = w.fw.Write(planes); w.err != nil {
+		return w.err
+	}
+	w.err = w.fw.Close()
+	w.writeChunk(codecDeflate, len(planes), w.zbuf.Bytes())
+	return w.err
+}
+
+// FlushStored writes the pending data as a chunk stored without compression and
+// returns the number of bytes written to the underlying io.Writer before the first
+// byte of these data, i.e. their position in a file created for the writer.
+// It is used to be able to update data in place once written, e.g. the header of
+// a run file (number of events, stop time) at the end of the run.
+func (w *Writer) FlushStored() (int64, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	pos := w.n + chunkHeaderSize
+	w.writeChunk(codecStored, len(w.buf), w.buf)
+	return pos, w.err
+}
+
+func (w *Writer) writeChunk(codec byte, rawLen int, data []byte) {
+	var hdr [chunkHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(rawLen))
+	binary.BigEndian.PutUint32(hdr[4:8], uint32(len(data)))
+	hdr[8] = codec
+	hdr[9] = byte(w.stride)
+	w.write(hdr[:])
+	w.write(data)
+	w.buf = w.buf[:0]
+}
+
+// Close writes the pending data and flushes the underlying io.Writer if it is a *bufio.Writer.
+// Close does not close the underlying io.Writer.
+func (w *Writer) Close() error {
+	w.Flush()
+	if ww, ok := w.w.(*bufio.Writer); ok && w.err == nil {
+		w.err = ww.Flush()
+	}
+	return w.err
+}
+
+// chunk describes a chunk of the compressed stream.
+type chunk struct {
+	off  int64 // position in the original stream of the first byte of the chunk
+	pos  int64 // position in the compressed stream of the chunk header
+	size int64 // size of the original data
+	len  int64 // size of the chunk data
+}
+
+// Reader decompresses a compressed stream.
+type Reader struct {
+	r  io.Reader
+	rs io.ReadSeeker // underlying stream, if seekable
+
+	chunks []chunk // chunks read so far (or skipped while seeking)
+	cur    int     // index of the current chunk in chunks (-1 before the first chunk)
+	data   []byte  // original data of the current chunk
+	base   int64   // position in the original stream of data[0]
+	i      int     // position of the next byte to read in data
+	pos    int64   // position in the underlying stream
+	start  int64   // position in the underlying stream of the first chunk
+	end    bool    // true when the end of the stream was reached
+	err    error
+	hdr    [chunkHeaderSize]byte
+}
+
+// NewReader returns a reader decompressing the compressed stream read from r.
+// The magic bytes are read from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	zr := &Reader{r: r, cur: -1}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err == nil {
+			zr.rs = rs
+			zr.pos = pos
+		}
+	}
+	var magic [len(Magic)]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if string(magic[:]) != Magic {
+		return nil, ErrFormat
+	}
+	zr.pos += int64(len(Magic))
+	zr.start = zr.pos
+	return zr, nil
+}
+
+// Open returns r itself, or a Reader decompressing it if r is a compressed stream.
+// If r does not implement io.Seeker, the returned reader wraps r in a bufio.Reader.
+func Open(r io.Reader) (io.Reader, error) {
+	if _, ok := r.(*Reader); ok {
+		return r, nil
+	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		if pos, err := rs.Seek(0, io.SeekCurrent); err == nil {
+			var magic [len(Magic)]byte
+			n, _ := io.ReadFull(rs, magic[:])
+			if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+				return nil, err
+			}
+			if n == len(magic) && string(magic[:]) == Magic {
+				return NewReader(rs)
+			}
+			return rs, nil
+		}
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	if magic, err := br.Peek(len(Magic)); err == nil && string(magic) == Magic {
+		return NewReader(br)
+	}
+	return br, nil
+}
+
+// readChunk reads the chunk starting at the current position of the underlying stream,
+// which is the chunk of index i in r.chunks.
+func (r *Reader) readChunk(i int) error {
+	c, err := r.readChunkHeader(i)
+	if err != nil {
+		return err
+	}
+	codec, stride := r.hdr[8], r.hdr[9]
+	buf := make([]byte, c.len)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	r.pos += c.len
+	switch codec {
+	case codecStored:
+		r.data = buf
+	case codecDeflate:
+		planes := make([]byte, c.size)
+		fr := flate.NewReader(bytes.NewReader(buf))
+		if _, err := io.ReadFull(fr, planes); err != nil {
+			return fmt.Errorf("rawz: could not decompress chunk %v: %v", i, err)
+		}
+		r.data = joinPlanes(planes)
+		decodeDelta(r.data, int(stride))
+	default:
+		return fmt.Errorf("rawz: unknown codec %v in chunk %v", codec, i)
+	}
+	r.cur = i
+	r.base = c.off
+	r.i = 0
+	return nil
+}
+
+// readChunkHeader reads the header of the chunk of index i at the current position
+// of the underlying stream and adds the chunk to r.chunks if it is not known yet.
+// It returns io.EOF if there is no more chunk.
+func (r *Reader) readChunkHeader(i int) (chunk, error) {
+	if _, err := io.ReadFull(r.r, r.hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrFormat
+		}
+		if err == io.EOF {
+			r.end = true
+		}
+		return chunk{}, err
+	}
+	c := chunk{
+		pos:  r.pos,
+		size: int64(binary.BigEndian.Uint32(r.hdr[0:4])),
+		len:  int64(binary.BigEndian.Uint32(r.hdr[4:8])),
+	}
+	if i > 0 {
+		prev := r.chunks[i-1]
+		c.off = prev.off + prev.size
+	}
+	r.pos += chunkHeaderSize
+	if i == len(r.chunks) {
+		r.chunks = append(r.chunks, c)
+	}
+	return c, nil
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	for r.i >= len(r.data) {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.readChunk(r.cur + 1)
+	}
+	n := copy(p, r.data[r.i:])
+	r.i += n
+	return n, nil
+}
+
+// Seek implements io.Seeker. Offsets are positions in the original (uncompressed) stream.
+// Seeking is possible only if the underlying stream implements io.Seeker.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if r.rs == nil {
+		return 0, ErrNotSeekable
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset()
+	case io.SeekEnd:
+		size, err := r.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, errors.New("rawz: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("rawz: negative position")
+	}
+	if offset == r.offset() {
+		return offset, nil
+	}
+
+	// Find the chunk containing offset, skipping the chunks not read yet if needed
+	i := 0
+	for {
+		for i < len(r.chunks) && offset >= r.chunks[i].off+r.chunks[i].size {
+			i++
+		}
+		if i < len(r.chunks) || r.end {
+			break
+		}
+		if err := r.skipChunk(len(r.chunks)); err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	r.err = nil
+	if i == len(r.chunks) { // at or beyond the end of the stream
+		r.data = nil
+		r.base = offset
+		r.i = 0
+		r.cur = len(r.chunks) - 1
+		return offset, r.setPos(r.endPos())
+	}
+	if err := r.setPos(r.chunks[i].pos); err != nil {
+		return 0, err
+	}
+	if err := r.readChunk(i); err != nil {
+		return 0, err
+	}
+	r.i = int(offset - r.chunks[i].off)
+	return offset, nil
+}
+
+// offset returns the position in the original stream of the next byte to read.
+func (r *Reader) offset() int64 {
+	return r.base + int64(r.i)
+}
+
+// size returns the size of the original stream.
+func (r *Reader) size() (int64, error) {
+	for !r.end {
+		if err := r.skipChunk(len(r.chunks)); err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	if len(r.chunks) == 0 {
+		return 0, nil
+	}
+	last := r.chunks[len(r.chunks)-1]
+	return last.off + last.size, nil
+}
+
+// skipChunk reads the header of the chunk of index i, which follows the last known
+// chunk, and moves to the next chunk without decompressing it.
+func (r *Reader) skipChunk(i int) error {
+	if err := r.setPos(r.endPos()); err != nil {
+		return err
+	}
+	c, err := r.readChunkHeader(i)
+	if err != nil {
+		return err
+	}
+	return r.setPos(c.pos + chunkHeaderSize + c.len)
+}
+
+// endPos returns the position in the underlying stream following the last known chunk.
+func (r *Reader) endPos() int64 {
+	if len(r.chunks) == 0 {
+		return r.start
+	}
+	last := r.chunks[len(r.chunks)-1]
+	return last.pos + chunkHeaderSize + last.len
+}
+
+func (r *Reader) setPos(pos int64) error {
+	if r.pos == pos {
+		return nil
+	}
+	if _, err := r.rs.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	r.pos = pos
+	return nil
+}
+
+// encodeDelta replaces each 16 bits word of buf by its difference with the word stride words before.
+func encodeDelta(buf []byte, stride int) {
+	if stride <= 0 {
+		return
+	}
+	s := 2 * stride
+	for i := len(buf)&^1 - 2; i >= s; i -= 2 {
+		w := binary.BigEndian.Uint16(buf[i:])
+		prev := binary.BigEndian.Uint16(buf[i-s:])
+		binary.BigEndian.PutUint16(buf[i:], w-prev)
+	}
+}
+
+// decodeDelta is the inverse of encodeDelta.
+func decodeDelta(buf []byte, stride int) {
+	if stride <= 0 {
+		return
+	}
+	s := 2 * stride
+	for i := s; i+2 <= len(buf); i += 2 {
+		d := binary.BigEndian.Uint16(buf[i:])
+		prev := binary.BigEndian.Uint16(buf[i-s:])
+		binary.BigEndian.PutUint16(buf[i:], d+prev)
+	}
+}
+
+// splitPlanes returns the high bytes of the 16 bits words of buf followed by their low bytes
+// (and the last byte of buf if its length is odd).
+func splitPlanes(buf []byte) []byte {
+	n := len(buf) / 2
+	out := make([]byte, len(buf))
+	for i := 0; i < n; i++ {
+		out[i] = buf[2*i]
+		out[n+i] = buf[2*i+1]
+	}
+	if len(buf)%2 != 0 {
+		out[len(buf)-1] = buf[len(buf)-1]
+	}
+	return out
+}
+
+// joinPlanes is the inverse of splitPlanes.
+func joinPlanes(planes []byte) []byte {
+	n := len(planes) / 2
+	out := make([]byte, len(planes))
+	for i := 0; i < n; i++ {
+		out[2*i] = planes[i]
+		out[2*i+1] = planes[n+i]
+	}
+	if len(planes)%2 != 0 {
+		out[len(planes)-1] = planes[len(planes)-1]
+	}
+	return out
+}
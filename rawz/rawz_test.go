@@ -0,0 +1,199 @@
+package rawz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// testData returns n bytes of slowly varying 16 bits samples with some noise.
+func testData(n int) []byte {
+	rnd := rand.New(rand.NewSource(1))
+	buf := make([]byte, n)
+	v := uint16(2000)
+	for i := 0; i+2 <= n; i += 2 {
+		v += uint16(rnd.Intn(21) - 10)
+		binary.BigEndian.PutUint16(buf[i:], v)
+	}
+	if n%2 != 0 {
+		buf[n-1] = 0x5a
+	}
+	return buf
+}
+
+// compress returns data compressed with chunks of chunkSize bytes,
+// written in pieces of various sizes.
+func compress(t *testing.T, data []byte, stride, chunkSize int) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, stride)
+	w.ChunkSize = chunkSize
+	for p, k := data, 1; len(p) > 0; k = 2*k + 1 {
+		if k > len(p) {
+			k = len(p)
+		}
+		if _, err := w.Write(p[:k]); err != nil {
+			t.Fatalf("error writing: %v\n", err)
+		}
+		p = p[k:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing writer: %v\n", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 99, 1000, 1001} {
+		for _, stride := range []int{Stride16, Stride32} {
+			for _, chunkSize := range []int{7, 64, 101, ChunkSize} {
+				data := testData(n)
+				z := compress(t, data, stride, chunkSize)
+
+				// seekable and non seekable streams
+				for _, in := range []io.Reader{bytes.NewReader(z), struct{ io.Reader }{bytes.NewReader(z)}} {
+					r, err := Open(in)
+					if err != nil {
+						t.Fatalf("error opening stream: %v\n", err)
+					}
+					if _, ok := r.(*Reader); !ok {
+						t.Fatalf("compressed stream not detected\n")
+					}
+					got, err := ioutil.ReadAll(r)
+					if err != nil {
+						t.Fatalf("n=%v stride=%v chunk=%v: error reading: %v\n", n, stride, chunkSize, err)
+					}
+					if !bytes.Equal(got, data) {
+						t.Errorf("n=%v stride=%v chunk=%v: data differ\n", n, stride, chunkSize)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestOpenUncompressed(t *testing.T) {
+	data := testData(100)
+	for _, in := range []io.Reader{bytes.NewReader(data), struct{ io.Reader }{bytes.NewReader(data)}} {
+		r, err := Open(in)
+		if err != nil {
+			t.Fatalf("error opening stream: %v\n", err)
+		}
+		if _, ok := r.(*Reader); ok {
+			t.Fatalf("uncompressed stream opened as compressed\n")
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("data differ (err=%v)\n", err)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	const chunkSize = 64
+	data := testData(1001)
+	z := compress(t, data, Stride32, chunkSize)
+
+	type seek struct {
+		offset int64
+		whence int
+		want   int64 // expected position
+	}
+	tests := []struct {
+		name  string
+		seeks []seek
+	}{
+		// the chunks are skipped without being decompressed
+		{"forward", []seek{{130, io.SeekStart, 130}, {500, io.SeekStart, 500}}},
+		{"chunk boundaries", []seek{{chunkSize, io.SeekStart, chunkSize}, {3*chunkSize - 1, io.SeekStart, 3*chunkSize - 1}}},
+		{"backward", []seek{{900, io.SeekStart, 900}, {10, io.SeekStart, 10}, {-5, io.SeekCurrent, 5}}},
+		{"current", []seek{{100, io.SeekCurrent, 100}, {60, io.SeekCurrent, 160}}},
+		{"end", []seek{{0, io.SeekEnd, 1001}, {-1, io.SeekEnd, 1000}, {-200, io.SeekEnd, 801}}},
+		{"end then start", []seek{{-10, io.SeekEnd, 991}, {0, io.SeekStart, 0}}},
+		{"beyond end", []seek{{2000, io.SeekStart, 2000}, {100, io.SeekStart, 100}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(z))
+			if err != nil {
+				t.Fatalf("error creating reader: %v\n", err)
+			}
+			for _, s := range test.seeks {
+				pos, err := r.Seek(s.offset, s.whence)
+				if err != nil {
+					t.Fatalf("error seeking to %v (whence=%v): %v\n", s.offset, s.whence, err)
+				}
+				if pos != s.want {
+					t.Fatalf("got position %v, want %v\n", pos, s.want)
+				}
+				// read across the next chunk boundary, then come back to pos
+				buf := make([]byte, chunkSize+3)
+				n, err := io.ReadFull(r, buf)
+				want := data[min(pos, int64(len(data))):]
+				if len(want) > len(buf) {
+					want = want[:len(buf)]
+				}
+				if !bytes.Equal(buf[:n], want) {
+					t.Errorf("wrong data read at position %v\n", pos)
+				}
+				if len(want) < len(buf) && err != io.ErrUnexpectedEOF && err != io.EOF {
+					t.Errorf("got error %v reading at the end of the stream\n", err)
+				}
+				if _, err := r.Seek(pos, io.SeekStart); err != nil {
+					t.Fatalf("error seeking back to %v: %v\n", pos, err)
+				}
+			}
+		})
+	}
+
+	r, err := NewReader(struct{ io.Reader }{bytes.NewReader(z)})
+	if err != nil {
+		t.Fatalf("error creating reader: %v\n", err)
+	}
+	if _, err := r.Seek(10, io.SeekStart); err != ErrNotSeekable {
+		t.Errorf("got error %v seeking a non seekable stream, want %v\n", err, ErrNotSeekable)
+	}
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestFlushStored(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Stride16)
+	w.ChunkSize = 16
+	head := []byte("header00")
+	w.Write(head)
+	pos, err := w.FlushStored()
+	if err != nil {
+		t.Fatalf("error flushing stored chunk: %v\n", err)
+	}
+	data := testData(100)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing writer: %v\n", err)
+	}
+
+	// update the header in place, as done at the end of a run
+	z := buf.Bytes()
+	copy(z[pos:], "header42")
+
+	r, err := NewReader(bytes.NewReader(z))
+	if err != nil {
+		t.Fatalf("error creating reader: %v\n", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading: %v\n", err)
+	}
+	want := append([]byte("header42"), data...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("data differ\n")
+	}
+}
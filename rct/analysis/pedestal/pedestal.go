@@ -1,15 +1,14 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 
-	"gitlab.in2p3.fr/avirm/analysis-go/rct/rw"
 	"gitlab.in2p3.fr/avirm/analysis-go/rct/trees"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
 )
 
 var (
@@ -17,9 +16,11 @@ var (
 	noEvents     = flag.Uint("n", 100000, "Number of events")
 	evtFreq      = flag.Uint("ef", 100, "Event printing frequency")
 	treeFileName = flag.String("ot", "", "Name of the TFile containing the output tree")
+	format       rwi.Format
 )
 
 func main() {
+	flag.Var(&format, "format", rwi.FormatUsage)
 	flag.Parse()
 
 	// Reader
@@ -33,8 +34,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("could not open stream: %v\n", err)
 	}*/
-	var r *rw.Reader
-	r, _ = rw.NewReader(bufio.NewReader(f))
+	r, err := rwi.NewReader(f, format)
+	if err != nil {
+		log.Fatalf("could not open stream: %v\n", err)
+	}
 	r.SetSigThreshold(1)
 
 	outRootFileName := strings.Replace(*inFile, ".bin", ".root", 1)
@@ -5,12 +5,12 @@ import (
 	"encoding/gob"
 	"fmt"
 	"image/color"
-	"math"
 	"os"
 	"strconv"
 
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/rf"
 	"gitlab.in2p3.fr/avirm/analysis-go/utils"
 	"go-hep.org/x/hep/hbook"
 	"go-hep.org/x/hep/hplot"
@@ -84,7 +84,7 @@ func NewDQPlot() *DQPlot {
 	return dqp
 }
 
-func (d *DQPlot) FillHistos(event *event.Event, RFcutMean, RFcutWidth float64) {
+func (d *DQPlot) FillHistos(event *event.Event) {
 	d.Nevents++
 
 	var mult uint8 = 0
@@ -181,10 +181,11 @@ func (d *DQPlot) FillHistos(event *event.Event, RFcutMean, RFcutWidth float64) {
 		d.HEnergyVsDeltaTggRF.Fill(timeDiff, lor.Pulses[0].E, 1)
 		d.HEnergyVsDeltaTggRF.Fill(timeDiff, lor.Pulses[1].E, 1)
 
-		if math.Abs(timeDiff-RFcutMean) > RFcutWidth {
-			d.HMinRecX.Fill(lor.Xmar, 1)
-			d.HMinRecY.Fill(lor.Ymar, 1)
-			d.HMinRecZ.Fill(lor.Zmar, 1)
+		// prompt gammas are excluded from the activity profiles
+		if lor.RFClass != rf.Prompt {
+			d.HMinRecX.Fill(lor.Xmar, lor.Weight())
+			d.HMinRecY.Fill(lor.Ymar, lor.Weight())
+			d.HMinRecZ.Fill(lor.Zmar, lor.Weight())
 		}
 	}
 
@@ -333,13 +334,10 @@ func (d *DQPlot) MakeMinRecXYDistrs() *hplot.TiledPlot {
 }
 
 func (d *DQPlot) MakeMinRecZDistr() *plot.Plot {
-	p, err := plot.New()
+	p := plot.New()
 
 	p.X.Min = -10
 	p.X.Max = 10
-	if err != nil {
-		panic(err)
-	}
 	p.X.Label.Text = "Z (mm)"
 	p.Y.Label.Text = "No entries"
 	p.X.Tick.Marker = &hplot.FreqTicks{N: 124, Freq: 6}
@@ -423,10 +421,7 @@ func (d *DQPlot) MakeChargeAmplTiledPlot(whichV WhichVar) *hplot.TiledPlot {
 }
 
 func (d *DQPlot) MakeAmplCorrelationPlot() *plot.Plot {
-	pCorrelation, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pCorrelation := plot.New()
 	pCorrelation.X.Label.Text = "Amplitude pulse 0 (ADC counts)"
 	pCorrelation.Y.Label.Text = "Amplitude pulse 1 (ADC counts)"
 	pCorrelation.X.Tick.Marker = &hplot.FreqTicks{N: 11, Freq: 2}
@@ -441,10 +436,7 @@ func (d *DQPlot) MakeAmplCorrelationPlot() *plot.Plot {
 }
 
 func (d *DQPlot) MakeEnergyCorrelationPlot() *plot.Plot {
-	pCorrelation, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pCorrelation := plot.New()
 	pCorrelation.X.Label.Text = "Energy pulse 0 (keV)"
 	pCorrelation.Y.Label.Text = "Energy pulse 1 (keV)"
 	pCorrelation.X.Tick.Marker = &hplot.FreqTicks{N: 11, Freq: 2}
@@ -460,10 +452,7 @@ func (d *DQPlot) MakeEnergyCorrelationPlot() *plot.Plot {
 }
 
 func (d *DQPlot) MakeRFPlotALaArnaud() *plot.Plot {
-	pRF, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pRF := plot.New()
 	pRF.X.Label.Text = "tgg - trf (ns)"
 	pRF.Y.Label.Text = "Energy (keV)"
 	pRF.X.Tick.Marker = &hplot.FreqTicks{N: 11, Freq: 2}
@@ -479,10 +468,7 @@ func (d *DQPlot) MakeRFPlotALaArnaud() *plot.Plot {
 }
 
 func (d *DQPlot) MakeHitQuartetsPlot() *plot.Plot {
-	pHitQuartets, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	pHitQuartets := plot.New()
 	pHitQuartets.X.Label.Text = "Quartet Id (right hemisphere)"
 	pHitQuartets.Y.Label.Text = "Quartet Id (left hemisphere)"
 	pHitQuartets.X.Tick.Marker = &hplot.FreqTicks{N: 31, Freq: 2}
@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"runtime"
 	"runtime/pprof"
 	"strconv"
 	"strings"
@@ -19,12 +20,17 @@ import (
 
 	"golang.org/x/net/websocket"
 
-	"gitlab.in2p3.fr/avirm/analysis-go/applyCorrCalib"
+	"gitlab.in2p3.fr/avirm/analysis-go/alarm"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/calib/selectCalib"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtbuilder"
+	"gitlab.in2p3.fr/avirm/analysis-go/pipeline"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
 	"gitlab.in2p3.fr/avirm/analysis-go/rct/dq"
 	"gitlab.in2p3.fr/avirm/analysis-go/rct/rw"
 	"gitlab.in2p3.fr/avirm/analysis-go/rct/trees"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
+	"gitlab.in2p3.fr/avirm/analysis-go/tcaframe"
 	"gitlab.in2p3.fr/avirm/analysis-go/utils"
 )
 
@@ -62,12 +68,19 @@ var (
 	noped                     = flag.Bool("noped", false, "If specified, no pedestal correction applied")
 	notdo                     = flag.Bool("notdo", false, "If specified, no time dependent offset correction applied")
 	noen                      = flag.Bool("noen", false, "If specified, no energy calibration applied.")
-	rfcutmean                 = flag.Float64("rfcutmean", 7, "Mean used to apply RF selection cut.")
-	rfcutwidth                = flag.Float64("rfcutwidth", 5, "Width used to apply RF selection cut.")
 	nopanic                   = flag.Bool("nopanic", false, "If set, the program won't panic when errors are not nil")
 	printWarningMonBufferSize = flag.Bool("nowarning", false, "If set, the program won't print the warning related to the size of the monitoring buffer")
 	xaxis                     = flag.String("xaxis", "SampleIdx", "Sets what is represented on xaxis on pulse plots (possible values: SampleIdx, SampleTime, CapaId")
 	skip                      = flag.Uint("skip", 0, "Set number of events to skip")
+	noWorkers                 = flag.Int("j", runtime.NumCPU(), "Number of workers building and processing events in parallel")
+	crcMode                   tcaframe.CRCMode // set with the -crc flag
+	evtWindow                 = flag.Uint64("window", 0, "Maximal difference between the keys (see -evtkey) of frames grouped into the same event")
+	evtTimeout                = flag.Int("timeout", 48, "Number of frames read after which an incomplete event is closed")
+	evtResync                 = flag.Uint64("resync", 0, "Backwards jump of the keys (see -evtkey) above which event building is resynchronized, e.g. after a counter reset or wrap (0: default of the key, see evtbuilder.DefaultResync)")
+	format                    rwi.Format              // set with the -format flag
+	evtKey                    = evtbuilder.KeyTrigger // set with the -evtkey flag
+	alarmCmd                  = flag.String("alarmcmd", "", "Shell command run when an alarm is raised, with the alarm given by the environment variables ALARM_SEVERITY, ALARM_RULE, ALARM_SOURCE, ALARM_MESSAGE and ALARM_TIME (see -alarmsev)")
+	alarmSev                  = alarm.Warning // set with the -alarmsev flag
 )
 
 // XY is a struct used to store a couple of values
@@ -116,6 +129,8 @@ type Data struct {
 	LORMult               string         `json:"lormult"`               // LOR multiplicity
 	SRout                 [6]uint16      `json:"srout"`                 // SRout of quartets
 	Xaxis                 string         `json:"xaxis"`                 // xaxis type (see input flags)
+	Alarms                []alarm.Alarm  `json:"alarms"`                // active alarms
+	AlarmHistory          []alarm.Alarm  `json:"alarmhistory"`          // last alarms raised
 }
 
 func (d *Data) Print() {
@@ -142,6 +157,20 @@ func TCPConn(p *string) *net.TCPConn {
 func main() {
 	log.SetFlags(log.Llongfile | log.LstdFlags)
 
+	flag.Var(&format, "format", rwi.FormatUsage)
+	flag.Var(&evtKey, "evtkey", evtbuilder.KeyUsage)
+	flag.Var(&crcMode, "crc", "How frames with a wrong CRC are handled: flag (default, frames are kept), drop or ignore (CRC not checked)")
+	flag.Float64Var(&event.RFClassifier.Freq, "rffreq", event.RFClassifier.Freq, "Nominal frequency (MHz) of the accelerator RF signal.")
+	flag.Float64Var(&event.RFClassifier.PromptMean, "promptmean", event.RFClassifier.PromptMean, "Mean (ns, time since RF rising front) of the prompt-gamma window.")
+	flag.Float64Var(&event.RFClassifier.PromptWidth, "promptwidth", event.RFClassifier.PromptWidth, "Half-width (ns) of the prompt-gamma window.")
+	flag.Float64Var(&event.RFClassifier.MinAmpl, "rfminampl", event.RFClassifier.MinAmpl, "Minimal amplitude of the RF signal for the beam to be considered on.")
+	flag.Float64Var(&alarm.DefaultThresholds.RateZero, "ratezero", alarm.DefaultThresholds.RateZero, "Duration (s) without any pulse with signal in a channel after which an alarm is raised.")
+	flag.UintVar(&alarm.DefaultThresholds.IntegrityWarn, "integritywarn", alarm.DefaultThresholds.IntegrityWarn, "Number of integrity errors above which a warning alarm is raised.")
+	flag.UintVar(&alarm.DefaultThresholds.IntegrityCrit, "integritycrit", alarm.DefaultThresholds.IntegrityCrit, "Number of integrity errors above which a critical alarm is raised.")
+	flag.Float64Var(&alarm.DefaultThresholds.MonBufWarn, "monbufwarn", alarm.DefaultThresholds.MonBufWarn, "Fraction of the monitoring buffer filled above which a warning alarm is raised.")
+	flag.Float64Var(&alarm.DefaultThresholds.MonBufCrit, "monbufcrit", alarm.DefaultThresholds.MonBufCrit, "Fraction of the monitoring buffer filled above which a critical alarm is raised.")
+	flag.Var(&alarmSev, "alarmsev", "Minimal severity of the alarms for which the -alarmcmd command is run: info, warning (default) or critical")
+	flag.Var(&pulse.ExtraVars, "vars", pulse.ExtraVarsUsage())
 	flag.Parse()
 
 	if *cpuprof != "" {
@@ -183,14 +212,30 @@ func main() {
 	}
 	defer f.Close()
 
-	var r *rw.Reader
-	r, _ = rw.NewReader(bufio.NewReader(f))
-	// 	r, err := rw.NewReader(bufio.NewReader(f))
-	// 	if err != nil {
-	// 		log.Fatalf("could not open stream: %v\n", err)
-	// 	}
+	r, err := rwi.NewReader(f, format)
+	if err != nil {
+		log.Fatalf("could not open stream: %v\n", err)
+	}
 	r.SetSigThreshold(*sigthres)
-	r.NoPanic = *nopanic
+	if rr, ok := r.(*rw.Reader); ok {
+		rr.NoPanic = *nopanic
+		rr.CRCMode = crcMode
+		rr.EventBuilder.Key = evtKey
+		rr.EventBuilder.Window = *evtWindow
+		rr.EventBuilder.Timeout = *evtTimeout
+		rr.EventBuilder.Resync = evtbuilder.DefaultResync[evtKey]
+		if *evtResync != 0 {
+			rr.EventBuilder.Resync = *evtResync
+		}
+	}
+	if *skip > 0 {
+		// Jump directly to the first event if the file is indexed,
+		// otherwise events before skip are skipped in the event loop
+		err = rwi.SeekFile(r, *inFileName, uint64(*skip))
+		if err != nil {
+			log.Printf("could not seek to event %v (%v), skipping events\n", *skip, err)
+		}
+	}
 
 	// Start reading TCP stream
 	// 	hdr := r.Header()
@@ -326,7 +371,7 @@ func GetMonData(sampFreq int, pulse pulse.Pulse) []XY {
 	return data
 }
 
-func stream(run uint32, r *rw.Reader, iEvent *uint, wg *sync.WaitGroup) {
+func stream(run uint32, r rwi.Reader, iEvent *uint, wg *sync.WaitGroup) {
 	defer wg.Done()
 	doPedestal := false
 	doTimeDepOffset := false
@@ -343,8 +388,15 @@ func stream(run uint32, r *rw.Reader, iEvent *uint, wg *sync.WaitGroup) {
 			doEnergyCalib = true
 		}
 	}
+	// Events are built and corrected in parallel, monitoring is done in order.
+	p := pipeline.New(r, *noWorkers, pipeline.Correct(doPedestal, doTimeDepOffset, doEnergyCalib))
+	defer p.Close()
 	noEventsForMon := uint64(0)
 	dqplots := dq.NewDQPlot()
+	alarms := alarm.NewEngine(alarm.DefaultRules(alarm.DefaultThresholds)...)
+	alarms.Hook = *alarmCmd
+	alarms.HookSeverity = alarmSev
+	noIntegrityErrors := uint(0) // events returned with an error (failed integrity tests, incomplete)
 	outRootFileName := strings.Replace(*inFileName, ".bin", ".root", 1)
 	var tree *trees.Tree
 	if !*notree {
@@ -377,7 +429,7 @@ func stream(run uint32, r *rw.Reader, iEvent *uint, wg *sync.WaitGroup) {
 		default:
 			switch *iEvent < *noEvents {
 			case true:
-				event, err := r.ReadNextEvent()
+				event, err := p.ReadNextEvent()
 				if *iEvent%*evtFreq == 0 {
 					fmt.Printf("iEvent=%v; event.ID=%v\n", *iEvent, event.ID)
 				}
@@ -387,8 +439,14 @@ func stream(run uint32, r *rw.Reader, iEvent *uint, wg *sync.WaitGroup) {
 				}
 				if event == nil && err != nil { // EOF
 					fmt.Printf("Reached EOF for iEvent = %v\n", *iEvent)
+					printCRCFailures(r)
+					printEventBuilding(r)
+					printAlarms(alarms)
 					return
 				}
+				if err != nil {
+					noIntegrityErrors++
+				}
 
 				if event.ID < *skip {
 					continue
@@ -399,10 +457,6 @@ func stream(run uint32, r *rw.Reader, iEvent *uint, wg *sync.WaitGroup) {
 				////////////////////////////////////////////////////////////////////////////////////////////
 				// Monitoring
 				if !pauseMonBool {
-					//////////////////////////////////////////////////////
-					// Corrections
-					event = applyCorrCalib.CorrectEvent(event, doPedestal, doTimeDepOffset, doEnergyCalib)
-					//////////////////////////////////////////////////////
 					// 						dqplots.FillHistos(event)
 					// mult, pulsesWithSignal, _ := event.Multiplicity()
 
@@ -410,7 +464,7 @@ func stream(run uint32, r *rw.Reader, iEvent *uint, wg *sync.WaitGroup) {
 						tree.Fill(run, event)
 					}
 					// 						fmt.Println(" \nlength middle: ", len(event.LORs))
-					dqplots.FillHistos(event, *rfcutmean, *rfcutwidth)
+					dqplots.FillHistos(event)
 					// 						fmt.Println(" length after: ", len(event.LORs))
 					if (*iEvent+1)%*monFreq == 0 {
 						// Webserver data
@@ -521,6 +575,21 @@ func stream(run uint32, r *rw.Reader, iEvent *uint, wg *sync.WaitGroup) {
 							SRoutsvg = utils.RenderSVG(pSRout, 30, 8)
 						}
 
+						// Evaluate alarms
+						snap := alarm.Snapshot{
+							Time:            stop,
+							ChannelCounts:   make([]float64, dqplots.HFrequency.Len()),
+							IntegrityErrors: noIntegrityErrors,
+							MonBufLen:       len(datac),
+							MonBufSize:      datacsize,
+						}
+						for ich := range snap.ChannelCounts {
+							_, snap.ChannelCounts[ich] = dqplots.HFrequency.XY(ich)
+						}
+						for _, a := range alarms.Evaluate(&snap) {
+							fmt.Printf("%salarm raised: %v%s\n", utils.CLR_R, a, utils.CLR_def)
+						}
+
 						// send to channel
 						if !*printWarningMonBufferSize && float64(len(datac)) >= 0.6*float64(datacsize) {
 							fmt.Printf("Warning: monitoring buffer filled at more than 60 percent (len(datac) = %v, datacsize = %v)\n", len(datac), datacsize)
@@ -571,7 +640,9 @@ func stream(run uint32, r *rw.Reader, iEvent *uint, wg *sync.WaitGroup) {
 								event.Clusters[4].SRout,
 								event.ClustersWoData[0].SRout,
 							},
-							Xaxis: *xaxis,
+							Xaxis:        *xaxis,
+							Alarms:       alarms.Active(),
+							AlarmHistory: alarms.Last(20),
 						}
 						//dataToMonitor.Print()
 						datac <- dataToMonitor
@@ -592,12 +663,39 @@ func stream(run uint32, r *rw.Reader, iEvent *uint, wg *sync.WaitGroup) {
 				if tree != nil {
 					tree.Close()
 				}
+				printCRCFailures(r)
+				printEventBuilding(r)
+				printAlarms(alarms)
 				return
 			}
 		}
 	} // event loop
 }
 
+// printAlarms prints the number of alarms raised during the run and the alarms still active.
+func printAlarms(alarms *alarm.Engine) {
+	fmt.Printf("alarms raised during the run: %v\n", alarms.Summary())
+	for _, a := range alarms.Active() {
+		fmt.Printf("   still active: %v\n", a)
+	}
+}
+
+// printCRCFailures prints the number of frames with a wrong CRC per front-end.
+// Nothing is done for readers of other formats.
+func printCRCFailures(r rwi.Reader) {
+	if rr, ok := r.(*rw.Reader); ok {
+		rr.PrintCRCFailures()
+	}
+}
+
+// printEventBuilding prints the event building statistics (incomplete events, late frames).
+// Nothing is done for readers of other formats.
+func printEventBuilding(r rwi.Reader) {
+	if rr, ok := r.(*rw.Reader); ok {
+		rr.EventBuilder.Stats.Print()
+	}
+}
+
 func dataHandler(ws *websocket.Conn) {
 	for data := range datac {
 		/////////////////////////////////////////////////
@@ -1,8 +1,11 @@
 package rw
 
 import (
+	"encoding/binary"
 	"fmt"
 	"reflect"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/tcaframe"
 )
 
 const (
@@ -137,10 +140,9 @@ type FrameTrailer struct {
 	EoF uint16
 }
 
+// Integrity checks the end of frame magic.
+// The Crc word is checked separately, see Frame.CheckCRC.
 func (f *FrameTrailer) Integrity() error {
-	if f.Crc != ctrl0xCRC {
-		return fmt.Errorf("asm: missing %x magic\n", ctrl0xCRC)
-	}
 	if (f.EoF & 0xff) != ctrl0xfb {
 		return fmt.Errorf("asm: missing %x magic\n", ctrl0xfb)
 	}
@@ -208,7 +210,8 @@ type Frame struct {
 	QuartetAbsIdx72 uint8 // one-to-one correspondance between frames and quartets in xTCA
 
 	// Error handling
-	Err ErrorCode
+	Err       ErrorCode
+	CRCStatus tcaframe.CRCStatus // set by the reader, see Reader.CRCMode
 
 	// UDP Payload size in octects
 	UDPPayloadSize int
@@ -231,6 +234,58 @@ func (f *Frame) Print() {
 	f.Trailer.Print()
 }
 
+// headerAndDataBytes returns the frame header and sample data as they appear in the stream.
+func (f *Frame) headerAndDataBytes() []byte {
+	h := &f.Header
+	buf := make([]byte, 0, 2*37+len(f.Data.Data)*(4+2*len(f.Data.Data[0].Amplitudes)))
+	be := func(v uint16) {
+		buf = append(buf, byte(v>>8), byte(v))
+	}
+	le := func(v uint16) {
+		buf = append(buf, byte(v), byte(v>>8))
+	}
+	be(h.StartOfFrame)
+	be(h.NbFrameAmcMsb)
+	be(h.NbFrameAmcLsb)
+	le(h.FEIdK30)
+	for _, v := range []uint16{
+		h.Mode, h.TriggerType,
+		h.NoFrameAsmMsb, h.NoFrameAsmOsb, h.NoFrameAsmUsb, h.NoFrameAsmLsb,
+		h.Cafe, h.Deca,
+		h.UndefinedMsb, h.UndefinedOsb, h.UndefinedUsb, h.UndefinedLsb,
+		h.TimeStampAsmMsb, h.TimeStampAsmOsb, h.TimeStampAsmUsb, h.TimeStampAsmLsb,
+		h.TimeStampTrigThorAsmMsb, h.TimeStampTrigThorAsmOsb, h.TimeStampTrigThorAsmUsb, h.TimeStampTrigThorAsmLsb,
+		h.ThorTT, h.PatternMsb, h.PatternOsb, h.PatternLsb, h.Bobo,
+		h.ThorTrigTimeStampMsb, h.ThorTrigTimeStampOsb, h.ThorTrigTimeStampLsb,
+		h.CptTriggerThorMsb, h.CptTriggerThorLsb, h.CptTriggerAsmMsb, h.CptTriggerAsmLsb,
+		h.NoSamples,
+	} {
+		be(v)
+	}
+	for i := range f.Data.Data {
+		data := &f.Data.Data[i]
+		le(data.FirstChanWord)
+		be(data.SecondChanWord)
+		for _, ampl := range data.Amplitudes {
+			var b [2]byte
+			binary.BigEndian.PutUint16(b[:], ampl)
+			buf = append(buf, b[:]...)
+		}
+	}
+	return buf
+}
+
+// ComputeCRC returns the CRC of the frame header and sample data.
+func (f *Frame) ComputeCRC() uint16 {
+	return tcaframe.CRC(f.headerAndDataBytes())
+}
+
+// CheckCRC compares the CRC stored in the frame trailer with the one computed
+// from the frame header and sample data, and returns the result.
+func (f *Frame) CheckCRC() tcaframe.CRCStatus {
+	return tcaframe.CheckCRC(f.Trailer.Crc, f.ComputeCRC())
+}
+
 /*func NewFrame(udppayloadsize int) *Frame {
 	f := &Frame{}
 	f.UDPPayloadSize = udppayloadsize
@@ -0,0 +1,173 @@
+package rw
+
+import (
+	"bufio"
+	"io"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+)
+
+// countReader wraps an io.Reader and counts the bytes read from it,
+// which gives the offset in the stream of the next frame.
+type countReader struct {
+	r       io.Reader
+	n       int64  // position in the stream of the next byte to be read
+	pending []byte // bytes pushed back, read before those of r
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if len(c.pending) > 0 {
+		n = copy(p, c.pending)
+		c.pending = c.pending[n:]
+	} else {
+		n, err = c.r.Read(p)
+	}
+	c.n += int64(n)
+	return n, err
+}
+
+// unread pushes back b, so that it is read again by the next calls to Read.
+func (c *countReader) unread(b []byte) {
+	c.pending = append(append([]byte(nil), b...), c.pending...)
+	c.n -= int64(len(b))
+}
+
+// countWriter wraps an io.Writer and counts the bytes written to it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// cptTriggerAsm returns the ASM trigger counter of the frame, used as event ID.
+func (f *FrameHeader) cptTriggerAsm() uint32 {
+	return (uint32(f.CptTriggerAsmMsb) << 16) | uint32(f.CptTriggerAsmLsb)
+}
+
+// timeStampAsm returns the 64 bits ASM timestamp of the frame.
+func (f *FrameHeader) timeStampAsm() uint64 {
+	return (uint64(f.TimeStampAsmMsb) << 48) | (uint64(f.TimeStampAsmOsb) << 32) | (uint64(f.TimeStampAsmUsb) << 16) | uint64(f.TimeStampAsmLsb)
+}
+
+// eventIndexer builds the index of a stream frame by frame.
+// Since frames of consecutive events can be interleaved (see ReadNextEvent),
+// an event is indexed at its first frame only.
+type eventIndexer struct {
+	index   evtindex.Index
+	indexed map[uint32]bool // key: CptTriggerAsm
+}
+
+func (ei *eventIndexer) add(f *FrameHeader, offset int64) {
+	if ei.indexed == nil {
+		ei.indexed = make(map[uint32]bool)
+	}
+	cpt := f.cptTriggerAsm()
+	if ei.indexed[cpt] {
+		return
+	}
+	ei.indexed[cpt] = true
+	ei.index.Add(uint64(cpt), f.timeStampAsm(), offset)
+}
+
+// setReader sets the underlying io.Reader of the reader.
+// If it implements io.Seeker (e.g. *os.File), the reader keeps it
+// to be able to seek and buffers it itself.
+func (r *Reader) setReader(rr io.Reader) {
+	var off int64
+	r.rs = nil
+	if rs, ok := rr.(io.ReadSeeker); ok {
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err == nil {
+			r.rs = rs
+			off = pos
+			rr = bufio.NewReader(rs)
+		}
+	}
+	r.c = &countReader{r: rr, n: off}
+	r.r = r.c
+}
+
+// Offset returns the position in the stream of the next byte to be read.
+func (r *Reader) Offset() int64 {
+	return r.c.n
+}
+
+// SetIndex sets the index used by Seek and SeekTime.
+func (r *Reader) SetIndex(idx *evtindex.Index) {
+	r.index = idx
+}
+
+// Seek positions the reader so that the next call to ReadNextEvent returns
+// the event with ID (i.e. CptTriggerAsm) eventID, or the first following one in the index.
+// The index must have been set beforehand with SetIndex.
+//
+// Frames of the events preceding eventID that are interleaved with those of
+// eventID in the stream are returned as incomplete events.
+func (r *Reader) Seek(eventID uint64) error {
+	if r.index == nil {
+		return evtindex.ErrNoIndex
+	}
+	e, err := r.index.Find(eventID)
+	if err != nil {
+		return err
+	}
+	return r.seekOffset(e.Offset)
+}
+
+// SeekTime positions the reader so that the next call to ReadNextEvent returns
+// the first event with an ASM timestamp larger or equal to t.
+// The index must have been set beforehand with SetIndex.
+func (r *Reader) SeekTime(t uint64) error {
+	if r.index == nil {
+		return evtindex.ErrNoIndex
+	}
+	e, err := r.index.FindTime(t)
+	if err != nil {
+		return err
+	}
+	return r.seekOffset(e.Offset)
+}
+
+func (r *Reader) seekOffset(offset int64) error {
+	if r.rs == nil {
+		return evtindex.ErrNotSeekable
+	}
+	_, err := r.rs.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.setReader(r.rs)
+	r.err = nil
+	r.EventBuilder.Reset()
+	return nil
+}
+
+// BuildIndex reads the frames from the current position to the end of the stream
+// and returns the index of the events found.
+func (r *Reader) BuildIndex() (*evtindex.Index, error) {
+	var ei eventIndexer
+	for {
+		offset := r.Offset()
+		f := r.Frame()
+		if f == nil {
+			break
+		}
+		ei.add(&f.Header, offset)
+	}
+	if r.err != nil && r.err != io.EOF {
+		return &ei.index, r.err
+	}
+	return &ei.index, nil
+}
+
+// Index returns the index of the events written so far.
+func (w *Writer) Index() *evtindex.Index {
+	return &w.indexer.index
+}
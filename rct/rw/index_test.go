@@ -0,0 +1,101 @@
+package rw
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+)
+
+func TestIndexSeek(t *testing.T) {
+	const (
+		noSamples   = 16
+		fileHdrSize = 24
+		frameSize   = 2*37 + 4*(4+2*noSamples) + 4
+	)
+	// Frames of consecutive events are interleaved. The timestamp of the
+	// frames is 100 times their counter (see newTestCluster).
+	data := writeTestClusters(t, noSamples, 0, 0, 1, 0, 1, 1, 3, 3)
+	want := []evtindex.Entry{
+		{EventID: 0, Time: 0, Offset: fileHdrSize},
+		{EventID: 1, Time: 100, Offset: fileHdrSize + 2*frameSize},
+		{EventID: 3, Time: 300, Offset: fileHdrSize + 6*frameSize},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.FileHeader(&FileHeader{ModeFile: 1, FEId: 0x10, NoSamples: noSamples, Time: 1234}); err != nil {
+		t.Fatalf("error writing file header: %v\n", err)
+	}
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	for f := r.Frame(); f != nil; f = r.Frame() {
+		if err := w.Frame(f); err != nil {
+			t.Fatalf("error writing frame: %v\n", err)
+		}
+	}
+	if !reflect.DeepEqual(w.Index().Entries, want) {
+		t.Fatalf("wrong writer index:\ngot= %v\nwant=%v\n", w.Index().Entries, want)
+	}
+
+	r, err = NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	idx, err := r.BuildIndex()
+	if err != nil {
+		t.Fatalf("error building index: %v\n", err)
+	}
+	if !reflect.DeepEqual(idx.Entries, want) {
+		t.Fatalf("wrong index:\ngot= %v\nwant=%v\n", idx.Entries, want)
+	}
+
+	r, err = NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	if err := r.Seek(1); err != evtindex.ErrNoIndex {
+		t.Errorf("Seek without index: error = %v, want %v\n", err, evtindex.ErrNoIndex)
+	}
+	r.SetIndex(idx)
+	tests := []struct {
+		name    string
+		seek    func() error
+		counter uint32 // counter of the next frame, if err is nil
+		err     error
+	}{
+		{"Seek", func() error { return r.Seek(1) }, 1, nil},
+		{"Seek backwards", func() error { return r.Seek(0) }, 0, nil},
+		{"Seek missing event", func() error { return r.Seek(2) }, 3, nil},
+		{"Seek beyond the last", func() error { return r.Seek(4) }, 0, evtindex.ErrNotFound},
+		{"SeekTime", func() error { return r.SeekTime(50) }, 1, nil},
+		{"SeekTime exact", func() error { return r.SeekTime(300) }, 3, nil},
+		{"SeekTime beyond the last", func() error { return r.SeekTime(301) }, 0, evtindex.ErrNotFound},
+	}
+	for _, test := range tests {
+		if err := test.seek(); err != test.err {
+			t.Errorf("%v: error = %v, want %v\n", test.name, err, test.err)
+			continue
+		}
+		if test.err != nil {
+			continue
+		}
+		if f := r.Frame(); f == nil || f.Header.cptTriggerAsm() != test.counter {
+			t.Errorf("%v: wrong frame after seeking (%v), want counter %v\n", test.name, f, test.counter)
+		}
+	}
+
+	// the stream is not seekable once wrapped in a bufio.Reader
+	r, err = NewReader(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("could not open asm stream: %v\n", err)
+	}
+	r.SetIndex(idx)
+	if err := r.Seek(1); err != evtindex.ErrNotSeekable {
+		t.Errorf("Seek in buffered stream: error = %v, want %v\n", err, evtindex.ErrNotSeekable)
+	}
+}
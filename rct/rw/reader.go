@@ -8,7 +8,11 @@ import (
 
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtbuilder"
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
+	"gitlab.in2p3.fr/avirm/analysis-go/tcaframe"
 )
 
 type ReadMode byte
@@ -27,21 +31,37 @@ type Reader struct {
 	Debug              bool
 	ReadMode           ReadMode
 	UDPHalfDRSBuffer   []byte              // relevant only when reading from UDP with packet = half DRS
-	framesMap          map[uint32][]*Frame // key: CptTriggerAsm ; value: slice of pointers to frames for corresponding CptTriggerAsm
-	framesMapKeys      []uint32            // vector of keys (book-keeping of keys in sorted way)
+	// EventBuilder groups frames into events (by default, frames with the same
+	// CptTriggerAsm, 6 frames per event).
+	EventBuilder *evtbuilder.Builder
 	NoPanic bool
+
+	// CRCMode defines how frames with a wrong CRC are handled (CRCFlag by default).
+	CRCMode tcaframe.CRCMode
+	// CRCFailures counts frames with a wrong CRC per front-end.
+	CRCFailures tcaframe.CRCFailures
+
+	rs    io.ReadSeeker // underlying stream, if seekable
+	c     *countReader
+	index *evtindex.Index
 }
 
 // NewReader returns a new ASM stream in read mode
+//
+// If r implements io.Seeker (e.g. *os.File), it should not be wrapped in a
+// bufio.Reader: the reader then buffers it itself and supports Seek and SeekTime.
 func NewReader(r io.Reader) (*Reader, error) {
 	rr := &Reader{
-		r:                r,
 		SigThreshold:     800,
 		ReadMode:         Default,
 		UDPHalfDRSBuffer: make([]byte, 8270), //8238),
 	}
-	rr.framesMap = make(map[uint32][]*Frame)
-	rr.framesMapKeys = make([]uint32, 0)
+	rr.EventBuilder = evtbuilder.New(evtbuilder.KeyTrigger, noFramesPerEvent)
+	r, err := rawz.Open(r)
+	if err != nil {
+		return nil, err
+	}
+	rr.setReader(r)
 	rr.readFileHeader(&rr.FileHeader)
 	rr.NoPanic = false
 	return rr, rr.err
@@ -219,20 +239,85 @@ func (r *Reader) readFrameData(data *HalfDRSData) {
 	}
 }
 
-func (r *Reader) readFrameTrailer(f *FrameTrailer) {
+// readFrameTrailer reads the trailer of the frame whose header holds the
+// front-end word feIdK30 (FrameHeader.FEIdK30).
+func (r *Reader) readFrameTrailer(f *FrameTrailer, feIdK30 uint16) {
 	r.readU16(&f.Crc, binary.BigEndian)
+	r.readU16(&f.EoF, binary.BigEndian)
 	// Temporary fix, until we understand where these additionnal 16 bits come from
-	// 	fmt.Printf("CRC = %x\n", f.Crc)
-	if f.Crc != ctrl0xCRC {
-		//fmt.Printf("CRC = %x (should be %x)\n", f.CRC, ctrl0xCRC)
-		r.readU16(&f.Crc, binary.BigEndian)
-		// 		fmt.Printf("new CRC = %x\n", f.Crc)
+	// The additionnal word precedes the Crc word. Since the Crc word is not a fixed
+	// magic anymore, it is detected by checking that the end of frame word holds the
+	// 0xfb magic and the front-end Id of the header (see tcaframe.IsEoF).
+	if r.err != nil || tcaframe.IsEoF(f.EoF, feIdK30) {
+		return
+	}
+	if !tcaframe.HasEoFMagic(f.EoF) {
+		// 		fmt.Printf("extra word = %x\n", f.Crc)
+		f.Crc = f.EoF
+		r.readU16(&f.EoF, binary.BigEndian)
+		return
+	}
+	// The second word has the 0xfb magic but not the front-end Id of the header:
+	// it is either a Crc whose low byte is 0xfb following an additionnal word, or
+	// the end of frame word of a firmware not writing the front-end Id there.
+	// The next word tells which one.
+	var next uint16
+	r.readU16(&next, binary.BigEndian)
+	switch {
+	case r.err == nil && tcaframe.IsEoF(next, feIdK30):
+		f.Crc, f.EoF = f.EoF, next
+	case r.err == nil:
+		r.unreadU16(next)
+	case r.err == io.EOF:
+		r.err = nil
 	}
 	// End of temporary fix
-	r.readU16(&f.EoF, binary.BigEndian)
 }
 
+// unreadU16 pushes back the big endian word v, so that it is read again by the next read.
+func (r *Reader) unreadU16(v uint16) {
+	r.c.unread([]byte{byte(v >> 8), byte(v)})
+}
+
+// Frame reads the next frame.
+// The CRC of the frame is checked according to r.CRCMode.
 func (r *Reader) Frame() *Frame {
+	for {
+		f := r.frame()
+		if f == nil || r.err != nil || r.checkCRC(f) {
+			return f
+		}
+	}
+}
+
+// checkCRC checks the CRC of f according to r.CRCMode, stores the result in f.CRCStatus
+// and counts failures per front-end.
+// It returns false if the frame should be dropped.
+func (r *Reader) checkCRC(f *Frame) bool {
+	if r.CRCMode == tcaframe.CRCIgnore {
+		f.CRCStatus = tcaframe.CRCNotChecked
+		return true
+	}
+	f.CRCStatus = f.CheckCRC()
+	if f.CRCStatus != tcaframe.CRCFailed {
+		return true
+	}
+	r.CRCFailures.Add(f.Header.FEId)
+	if r.Debug {
+		fmt.Printf("rw: wrong CRC for frame of front-end %v (got %x, expected %x)\n", f.Header.FEId, f.Trailer.Crc, f.ComputeCRC())
+	}
+	return r.CRCMode != tcaframe.CRCDrop
+}
+
+// PrintCRCFailures prints the number of frames with a wrong CRC per front-end.
+func (r *Reader) PrintCRCFailures() {
+	if r.CRCMode == tcaframe.CRCIgnore {
+		return
+	}
+	r.CRCFailures.Print()
+}
+
+func (r *Reader) frame() *Frame {
 	f := &Frame{}
 	if r.Debug {
 		fmt.Printf("\nrw: start reading frame\n")
@@ -259,7 +344,7 @@ func (r *Reader) Frame() *Frame {
 		f.Header.FEId = 0x10
 		f.QuartetAbsIdx60 = dpgadetector.FEIdAndChanIdToQuartetAbsIdx60(f.Header.FEId, f.Data.Data[0].Channel, false)
 		f.QuartetAbsIdx72 = dpgadetector.FEIdAndChanIdToQuartetAbsIdx72(f.Header.FEId, f.Data.Data[0].Channel)
-		r.readFrameTrailer(&f.Trailer)
+		r.readFrameTrailer(&f.Trailer, f.Header.FEIdK30)
 		r.err = f.Trailer.Integrity()
 		if r.err != nil {
 			f.Trailer.Print()
@@ -384,38 +469,48 @@ func (r *Reader) ReadNextEvent() (*event.Event, error) {
 */
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Smart implementation (frame are grouped into events using their CptTriggerAsm value)
-func alreadyInVec(valTest uint32, vec *[]uint32) bool {
-	for _, valIn := range *vec {
-		if valTest == valIn {
-			return true
-		}
+// Smart implementation (frames are grouped into events by r.EventBuilder, using their CptTriggerAsm
+// or TimeStampAsm value, so that frames arriving out of order are put in the right event)
+
+// noFramesPerEvent is the number of frames of a complete event (5 clusters and 1 cluster without data).
+const noFramesPerEvent = 6
+
+// eventKey returns the key of the frame used to group frames into events.
+func (r *Reader) eventKey(f *Frame) uint64 {
+	if r.EventBuilder.Key == evtbuilder.KeyTimeStamp {
+		return f.Header.TimeStampAsm
 	}
-	return false
+	return uint64(f.Header.CptTriggerAsm)
 }
-func (r *Reader) ReadNextEvent() (*event.Event, error) {
-	// Fill frame stack
-	stackSize := 8
-	for len(r.framesMap) < stackSize {
-		frame := r.Frame()
-		// 		fmt.Println(frame.Header.CptTriggerAsm)
-		if r.framesMap[frame.Header.CptTriggerAsm] == nil {
-			r.framesMap[frame.Header.CptTriggerAsm] = make([]*Frame, 0)
-			// 			fmt.Println(" -> slice in map is nil")
+
+// nextFrames returns the frames of the next event built by r.EventBuilder.
+func (r *Reader) nextFrames() *evtbuilder.Event {
+	for {
+		if e := r.EventBuilder.Next(); e != nil {
+			return e
 		}
-		r.framesMap[frame.Header.CptTriggerAsm] = append(r.framesMap[frame.Header.CptTriggerAsm], frame)
-		if !alreadyInVec(frame.Header.CptTriggerAsm, &r.framesMapKeys) {
-			r.framesMapKeys = append(r.framesMapKeys, frame.Header.CptTriggerAsm)
+		frame := r.Frame()
+		if frame == nil { // EOF, empty the open events
+			return r.EventBuilder.Flush()
 		}
+		r.EventBuilder.Add(r.eventKey(frame), frame)
 	}
+}
 
-	// Make event for CptTriggerAsm = r.framesMapKeys[0]
+func (r *Reader) ReadNextEvent() (*event.Event, error) {
+	evtFrames := r.nextFrames()
+	if evtFrames == nil {
+		return nil, r.err
+	}
+
+	// Make event for the frames of evtFrames
 	event := event.NewEvent(5, 1)
-	event.NoFrames = uint8(len(r.framesMap[r.framesMapKeys[0]]))
-	event.ID = uint(r.framesMapKeys[0])
+	event.NoFrames = uint8(len(evtFrames.Frames))
+	event.ID = uint(evtFrames.Frames[0].(*Frame).Header.CptTriggerAsm)
 	// 	fmt.Println("event.ID=", event.ID)
 	timeStamp := uint64(0)
-	for _, framePtr := range r.framesMap[r.framesMapKeys[0]] {
+	for _, f := range evtFrames.Frames {
+		framePtr := f.(*Frame)
 		pulses := MakePulses(framePtr, r.SigThreshold)
 		if framePtr.QuartetAbsIdx72 >= 6 {
 			panic("framePtr.QuartetAbsIdx72 >= 6")
@@ -460,21 +555,23 @@ func (r *Reader) ReadNextEvent() (*event.Event, error) {
 			event.ClustersWoData[iClusterWoData].SetSRout()
 		}
 	}
-	err := event.IntegrityFirstASMBoard()
+	var err error
+	if evtFrames.Complete {
+		err = event.IntegrityFirstASMBoard()
+	} else {
+		err = evtbuilder.ErrIncomplete
+	}
 	event.TimeStamp = timeStamp
 
 	if false && err != nil {
 		fmt.Println(" ** Error is not nil: Printing debugging info")
-		fmt.Println("   o r.framesMapKeys: ", len(r.framesMapKeys), r.framesMapKeys)
-		fmt.Println("   o r.framesMap: ", len(r.framesMap), r.framesMap)
-		for _, framePtr := range r.framesMap[r.framesMapKeys[0]] {
+		fmt.Println("   o open events: ", r.EventBuilder.Len())
+		for _, f := range evtFrames.Frames {
+			framePtr := f.(*Frame)
 			fmt.Println("   o NoFrameAsm, QuartetAbsIdx60, QuartetAbsIdx72:", framePtr.Header.NoFrameAsm, framePtr.QuartetAbsIdx60, framePtr.QuartetAbsIdx72)
 		}
 	}
 
-	// Remove event from stack
-	delete(r.framesMap, r.framesMapKeys[0])
-	r.framesMapKeys = r.framesMapKeys[1:]
 	return event, err
 }
 
@@ -2,9 +2,14 @@ package rw
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"os"
+	"reflect"
 	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/tcaframe"
 )
 
 //var rhdr *Header
@@ -44,7 +49,7 @@ func TestRW(t *testing.T) {
 	// 	defer w.Close()
 
 	for {
-		frame, _ := r.Frame()
+		frame := r.Frame()
 		frame.Header.Print()
 		frame.Data.Print()
 	}
@@ -74,6 +79,109 @@ func TestRW(t *testing.T) {
 	*/
 }
 
+// newTestCluster returns a cluster whose pulses have noSamples samples
+// and whose amplitudes, SRout and counters depend on counter.
+func newTestCluster(noSamples int, counter uint16) *pulse.Cluster {
+	c := &pulse.Cluster{CptTriggerAsm: uint32(counter), TimeStampAsm: 100 * uint64(counter)}
+	for i := range c.Pulses {
+		p := &c.Pulses[i]
+		p.SRout = 0x100 + counter
+		p.Samples = make([]pulse.Sample, noSamples)
+		for j := range p.Samples {
+			p.Samples[j].Amplitude = float64(500 + 7*i + j + int(counter))
+		}
+	}
+	return c
+}
+
+// writeTestClusters writes the frames of the clusters returned by
+// newTestCluster for the given counters, in the quartet 0.
+func writeTestClusters(t *testing.T, noSamples int, counters ...uint16) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	err := w.FileHeader(&FileHeader{ModeFile: 1, FEId: 0x10, NoSamples: uint16(noSamples), Time: 1234})
+	if err != nil {
+		t.Fatalf("error writing file header: %v\n", err)
+	}
+	for _, counter := range counters {
+		if err := w.Frame(MakeFrame(newTestCluster(noSamples, counter), 0, uint32(counter))); err != nil {
+			t.Fatalf("error writing frame: %v\n", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing writer: %v\n", err)
+	}
+	return buf.Bytes()
+}
+
+func TestCRCRoundTrip(t *testing.T) {
+	const (
+		noSamples   = 16
+		fileHdrSize = 24
+		frameSize   = 2*37 + 4*(4+2*noSamples) + 4
+	)
+	read := func(data []byte, mode tcaframe.CRCMode) (*Reader, []*Frame) {
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("could not open asm stream: %v\n", err)
+		}
+		r.CRCMode = mode
+		var frames []*Frame
+		for f := r.Frame(); f != nil; f = r.Frame() {
+			frames = append(frames, f)
+		}
+		return r, frames
+	}
+
+	data := writeTestClusters(t, noSamples, 0, 1, 2)
+	_, frames := read(data, tcaframe.CRCFlag)
+	if len(frames) != 3 {
+		t.Fatalf("wrong number of frames: got %v, want 3\n", len(frames))
+	}
+	for i, frame := range frames {
+		if frame.CRCStatus != tcaframe.CRCOK {
+			t.Errorf("frame %v: CRC status = %v, want %v\n", i, frame.CRCStatus, tcaframe.CRCOK)
+		}
+		want := MakeFrame(newTestCluster(noSamples, uint16(i)), 0, uint32(i))
+		for j := range want.Data.Data {
+			if !reflect.DeepEqual(frame.Data.Data[j].Amplitudes, want.Data.Data[j].Amplitudes) {
+				t.Errorf("frame %v: amplitudes of channel %v differ\n", i, j)
+			}
+		}
+	}
+
+	// Corrupt one sample of the second frame
+	corrupted := append([]byte(nil), data...)
+	corrupted[fileHdrSize+frameSize+2*37+10] ^= 0x01
+	r, frames := read(corrupted, tcaframe.CRCFlag)
+	if len(frames) != 3 || frames[1].CRCStatus != tcaframe.CRCFailed || r.CRCFailures[0x10] != 1 {
+		t.Errorf("corrupted frame not flagged\n")
+	}
+	r, frames = read(corrupted, tcaframe.CRCDrop)
+	if len(frames) != 2 || r.CRCFailures[0x10] != 1 {
+		t.Errorf("corrupted frame not dropped\n")
+	}
+
+	// Additionnal word before a Crc word looking like an end of frame word
+	counter := uint16(0)
+	for {
+		f := MakeFrame(newTestCluster(noSamples, counter), 0, uint32(counter))
+		if crc := f.ComputeCRC(); tcaframe.HasEoFMagic(crc) && !tcaframe.IsEoF(crc, f.Header.FEIdK30) {
+			break
+		}
+		counter++
+	}
+	data = writeTestClusters(t, noSamples, counter, counter+1)
+	var extra []byte
+	extra = append(extra, data[:fileHdrSize+frameSize-4]...)
+	extra = append(extra, 0xab, 0xcd)
+	extra = append(extra, data[fileHdrSize+frameSize-4:]...)
+	_, frames = read(extra, tcaframe.CRCFlag)
+	if len(frames) != 2 || frames[0].CRCStatus != tcaframe.CRCOK || frames[1].CRCStatus != tcaframe.CRCOK {
+		t.Errorf("frames with additionnal trailer word not read back\n")
+	}
+}
+
 /*
 func TestWIntegrity(t *testing.T) {
 	fmt.Println("starting TestWIntegrity")
@@ -2,8 +2,16 @@ package rw
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"io"
+	"log"
+
+	tcarw "gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
+	"gitlab.in2p3.fr/avirm/analysis-go/tcaframe"
 )
 
 // Writer wraps an io.Writer and writes an ASM stream.
@@ -12,11 +20,21 @@ type Writer struct {
 	err error
 	//hdr          *Header
 	//frameCounter uint32
+
+	c       *countWriter
+	indexer eventIndexer
 }
 
 // NewWriter returns a new ASM stream in write mode.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{w: w}
+	c := &countWriter{w: w}
+	return &Writer{w: c, c: c}
+}
+
+// NewCompressedWriter returns a new ASM stream in write mode, written to w
+// in the compressed container of package rawz (one sample per 16 bits word).
+func NewCompressedWriter(w io.Writer) *Writer {
+	return NewWriter(rawz.NewWriter(w, rawz.Stride16))
 }
 
 // Write implements io.Writer.
@@ -30,8 +48,15 @@ func (w *Writer) Write(data []byte) (int, error) {
 // Close does not close the underlying io.Writer.
 func (w *Writer) Close() error {
 	//w.writeU32(lastFrame)
-	if ww, ok := w.w.(*bufio.Writer); ok {
-		ww.Flush()
+	var err error
+	switch ww := w.c.w.(type) {
+	case *bufio.Writer:
+		err = ww.Flush()
+	case *rawz.Writer:
+		err = ww.Close()
+	}
+	if w.err == nil {
+		w.err = err
 	}
 	if w.err != nil && w.err != io.EOF {
 		return w.err
@@ -39,14 +64,42 @@ func (w *Writer) Close() error {
 	return nil
 }
 
+// FileHeader writes the file header to the ASM stream.
+func (w *Writer) FileHeader(f *FileHeader) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = binary.Write(w.w, binary.LittleEndian, f.ModeFile)
+	w.writeU16LE(f.FEId)
+	w.writeU16LE(f.NoSamples)
+	for i := 0; i < 2; i++ {
+		if w.err != nil {
+			break
+		}
+		w.err = binary.Write(w.w, binary.LittleEndian, f.Time)
+	}
+	return w.err
+}
+
 // Frame writes a Frame to the ASM stream.
-// func (w *Writer) Frame(f *Frame) error {
-// 	if w.err != nil {
-// 		return w.err
-// 	}
-// 	w.writeFrame(f)
-// 	return w.err
-// }
+//
+// The Crc word of the frame trailer is computed from the frame header and
+// sample data (see Frame.ComputeCRC), so that frames can be checked when read back.
+func (w *Writer) Frame(f *Frame) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.indexer.add(&f.Header, w.c.n)
+	buf := f.headerAndDataBytes()
+	f.Trailer.Crc = tcaframe.CRC(buf)
+	if (f.Trailer.EoF & 0xff) != ctrl0xfb {
+		f.Trailer.EoF = f.Trailer.EoF&0xff00 | ctrl0xfb
+	}
+	_, w.err = w.w.Write(buf)
+	w.writeU16(f.Trailer.Crc)
+	w.writeU16(f.Trailer.EoF)
+	return w.err
+}
 
 func (w *Writer) write(v interface{}) {
 	if w.err != nil {
@@ -77,6 +130,63 @@ func (w *Writer) WriteU16(v uint16) {
 	w.writeU16(v)
 }
 
+func (w *Writer) writeU16LE(v uint16) {
+	if w.err != nil {
+		return
+	}
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], v)
+	_, w.err = w.w.Write(buf[:])
+}
+
+// Event writes the frames of the event e, one frame per cluster whose pulses
+// have samples, so that events read from another format can be written in the RCT format.
+// The frame headers are filled from the counters of the clusters (CptTriggerAsm,
+// NoFrameAsm and TimeStampAsm) and the Thor trigger counter is set to the event ID.
+// Only the quartets of the first ASM board (absolute Id 0 -> 5) are written,
+// the other ones are skipped since the RCT only has one board.
+func (w *Writer) Event(e *event.Event) error {
+	for i := range e.Clusters {
+		w.clusterFrame(&e.Clusters[i], uint32(e.ID))
+	}
+	for i := range e.ClustersWoData {
+		w.clusterFrame(&e.ClustersWoData[i], uint32(e.ID))
+	}
+	return w.err
+}
+
+// clusterFrame writes the frame of cluster c, if its pulses have samples.
+func (w *Writer) clusterFrame(c *pulse.Cluster, evtID uint32) {
+	if c.Pulses[0].NoSamples() == 0 || c.Pulses[0].Channel == nil {
+		return
+	}
+	quartetAbsIdx72 := uint8(c.Pulses[0].Channel.AbsID288() / 4)
+	if quartetAbsIdx72 >= 6 {
+		return
+	}
+	w.Frame(MakeFrame(c, quartetAbsIdx72, evtID))
+}
+
+// MakeFrame returns the frame of the quartet of absolute Id quartetAbsIdx72 (0 -> 71)
+// holding the samples of the pulses of cluster c.
+// RCT frames have the layout of microTCA frames: the frame is made by MakeFrame of
+// package dpgatca/rw and decoded as an RCT frame, which also sets the counters of
+// the header (NoFrameAsm, TimeStampAsm and CptTriggerAsm) and the Crc word.
+func MakeFrame(c *pulse.Cluster, quartetAbsIdx72 uint8, evtID uint32) *Frame {
+	var buf bytes.Buffer
+	if err := tcarw.NewWriter(&buf).Frame(tcarw.MakeFrame(c, quartetAbsIdx72, evtID)); err != nil {
+		log.Fatalf("rw: could not make frame: %v\n", err)
+	}
+	r := &Reader{NoPanic: true}
+	r.setReader(&buf)
+	f := r.frame()
+	if r.err != nil {
+		log.Fatalf("rw: could not make frame: %v\n", r.err)
+	}
+	f.QuartetAbsIdx72 = quartetAbsIdx72
+	return f
+}
+
 // func (w *Writer) writeFrame(f *Frame) {
 // 	if w.err != nil {
 // 		return
@@ -1,13 +1,12 @@
 package trees
 
 import (
-	"fmt"
-
-	"github.com/go-hep/croot"
 	//"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
 	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
+	dpgatrees "gitlab.in2p3.fr/avirm/analysis-go/dpga/trees"
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rootio"
 	"gitlab.in2p3.fr/avirm/analysis-go/utils"
 )
 
@@ -20,82 +19,49 @@ type ROOTData struct {
 	T0  uint32
 
 	NoPulses            int32
-	IChanAbs288         [NoPulsesMax]uint16
-	IQuartetAbs72       [NoPulsesMax]uint8
-	ILineAbs12          [NoPulsesMax]uint8
-	IHemi               [NoPulsesMax]uint8
-	E                   [NoPulsesMax]float64
-	Ampl                [NoPulsesMax]float64
-	Sat                 [NoPulsesMax]uint8
-	Charge              [NoPulsesMax]float64
-	T10                 [NoPulsesMax]float64
-	T20                 [NoPulsesMax]float64
-	T30                 [NoPulsesMax]float64
-	T80                 [NoPulsesMax]float64
-	T90                 [NoPulsesMax]float64
-	Tf20                [NoPulsesMax]float64
-	NoLocMaxRisingFront [NoPulsesMax]uint16
-	SRout               [NoPulsesMax]uint16
+	IChanAbs288         [NoPulsesMax]uint16  `rootio:"count=NoPulses"`
+	IQuartetAbs72       [NoPulsesMax]uint8   `rootio:"count=NoPulses"`
+	ILineAbs12          [NoPulsesMax]uint8   `rootio:"count=NoPulses"`
+	IHemi               [NoPulsesMax]uint8   `rootio:"count=NoPulses"`
+	E                   [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Ampl                [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Sat                 [NoPulsesMax]uint8   `rootio:"count=NoPulses"`
+	Charge              [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	T10                 [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	T20                 [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	T30                 [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	T80                 [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	T90                 [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	Tf20                [NoPulsesMax]float64 `rootio:"count=NoPulses"`
+	NoLocMaxRisingFront [NoPulsesMax]uint16  `rootio:"count=NoPulses"`
+	SRout               [NoPulsesMax]uint16  `rootio:"count=NoPulses"`
 
 	NoSamples     int32
-	SampleTimes   [NoSamplesMax]float64
-	SampleIndices [NoSamplesMax]uint16
-	Pulse         [NoPulsesMax][NoSamplesMax]float64
-	CapaId        [NoPulsesMax][NoSamplesMax]uint16
+	SampleTimes   [NoSamplesMax]float64              `rootio:"count=NoSamples"`
+	SampleIndices [NoSamplesMax]uint16               `rootio:"count=NoSamples"`
+	Pulse         [NoPulsesMax][NoSamplesMax]float64 `rootio:"count=NoPulses"`
+	CapaId        [NoPulsesMax][NoSamplesMax]uint16  `rootio:"count=NoPulses"`
 }
 
 type Tree struct {
-	data ROOTData
-	file croot.File
-	tree croot.Tree
+	data  ROOTData
+	tree  *rootio.Tree
+	extra *dpgatrees.Extra
 }
 
 func NewTree(outrootfileName string) *Tree {
-	f, err := croot.OpenFile(outrootfileName, "recreate", "ROOT file with event information", 1, 0)
+	tree, err := rootio.NewTree(outrootfileName, "tree", "tree")
+	if err != nil {
+		panic(err)
+	}
+	t := Tree{tree: tree}
+	if err := rootio.Branches(t.tree, &t.data); err != nil {
+		panic(err)
+	}
+	t.extra, err = dpgatrees.NewExtra(t.tree, "NoPulses", NoPulsesMax)
 	if err != nil {
 		panic(err)
 	}
-	t := Tree{file: f, tree: croot.NewTree("tree", "tree", 32)}
-	const bufsiz = 32000
-	/*
-		_, err = t.tree.Branch("data", &t.data, bufsiz, 0)
-		if err != nil {
-			panic(err)
-		}
-	*/
-
-	_, err = t.tree.Branch2("Run", &t.data.Run, "Run/i", bufsiz)
-	_, err = t.tree.Branch2("Evt", &t.data.Evt, "Evt/i", bufsiz)
-	_, err = t.tree.Branch2("T0", &t.data.T0, "T0/i", bufsiz)
-
-	_, err = t.tree.Branch2("NoPulses", &t.data.NoPulses, "NoPulses/I", bufsiz)
-	_, err = t.tree.Branch2("IChanAbs288", &t.data.IChanAbs288, "IChanAbs288[NoPulses]/s", bufsiz)
-	_, err = t.tree.Branch2("IQuartetAbs72", &t.data.IQuartetAbs72, "IQuartetAbs72[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("ILineAbs12", &t.data.ILineAbs12, "ILineAbs12[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("IHemi", &t.data.IHemi, "IHemi[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("E", &t.data.E, "E[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Ampl", &t.data.Ampl, "Ampl[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Sat", &t.data.Sat, "Sat[NoPulses]/b", bufsiz)
-	_, err = t.tree.Branch2("Charge", &t.data.Charge, "Charge[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T10", &t.data.T10, "T10[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T20", &t.data.T20, "T20[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T30", &t.data.T30, "T30[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T80", &t.data.T80, "T80[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("T90", &t.data.T90, "T90[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("Tf20", &t.data.Tf20, "Tf20[NoPulses]/D", bufsiz)
-	_, err = t.tree.Branch2("NoLocMaxRisingFront", &t.data.NoLocMaxRisingFront, "NoLocMaxRisingFront[NoPulses]/s", bufsiz)
-	_, err = t.tree.Branch2("SRout", &t.data.SRout, "SRout[NoPulses]/s", bufsiz)
-
-	_, err = t.tree.Branch2("NoSamples", &t.data.NoSamples, "NoSamples/I", bufsiz)
-	_, err = t.tree.Branch2("SampleTimes", &t.data.SampleTimes, "SampleTimes[NoSamples]/D", bufsiz)
-	_, err = t.tree.Branch2("SampleIndices", &t.data.SampleIndices, "SampleIndices[NoSamples]/s", bufsiz)
-
-	temp := fmt.Sprintf("Pulse[NoPulses][%v]/D", NoSamplesMax)
-	temp2 := fmt.Sprintf("CapaId[NoPulses][%v]/s", NoSamplesMax)
-	// 	fmt.Println(temp, temp2)
-	_, err = t.tree.Branch2("Pulse", &t.data.Pulse, temp, bufsiz)
-	_, err = t.tree.Branch2("CapaId", &t.data.CapaId, temp2, bufsiz)
-
 	return &t
 }
 
@@ -121,6 +87,7 @@ func (t *Tree) Fill(run uint32, event *event.Event) {
 
 	for i := range pulsesFull {
 		pulse := pulsesFull[i]
+		t.extra.Fill(i, pulse)
 		pulse.CalcRisingFront(true)
 		pulse.CalcFallingFront(false)
 		// 		fmt.Println("i=", i)
@@ -151,13 +118,14 @@ func (t *Tree) Fill(run uint32, event *event.Event) {
 		}
 	}
 
-	_, err := t.tree.Fill()
+	err := t.tree.Fill()
 	if err != nil {
 		panic(err)
 	}
 }
 
 func (t *Tree) Close() {
-	t.file.Write("", 0, 0)
-	t.file.Close("")
+	if err := t.tree.Close(); err != nil {
+		panic(err)
+	}
 }
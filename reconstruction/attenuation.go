@@ -0,0 +1,318 @@
+package reconstruction
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/detector"
+)
+
+// Approximate linear attenuation coefficients (in 1/mm) of 511 keV photons
+// in the materials used for phantoms.
+const (
+	MuWater = 0.0096
+	MuPMMA  = 0.0111
+)
+
+// Phantom is the material of a cylindrical phantom whose attenuation is
+// corrected for (see Phantom.MuMap).
+type Phantom byte
+
+const (
+	NoPhantom Phantom = iota // no phantom, no attenuation correction
+	Water                    // phantom of water (MuWater)
+	PMMA                     // phantom of PMMA (MuPMMA)
+)
+
+func (p *Phantom) String() string {
+	switch *p {
+	case NoPhantom:
+		return ""
+	case Water:
+		return "water"
+	case PMMA:
+		return "pmma"
+	default:
+		return fmt.Sprintf("Phantom(%v)", byte(*p))
+	}
+}
+
+// Set is the method to set the flag value.
+func (p *Phantom) Set(value string) error {
+	switch value {
+	case "":
+		*p = NoPhantom
+	case "water":
+		*p = Water
+	case "pmma":
+		*p = PMMA
+	default:
+		return fmt.Errorf("invalid phantom value %q (possible values: water, pmma)", value)
+	}
+	return nil
+}
+
+// MuMap returns the attenuation map of a phantom of radius r and length l
+// (see NewCylinderMuMap), with voxels of 1 mm, or nil for NoPhantom.
+func (p Phantom) MuMap(r, l float64) *MuMap {
+	switch p {
+	case Water:
+		return NewCylinderMuMap(r, l, MuWater, 1)
+	case PMMA:
+		return NewCylinderMuMap(r, l, MuPMMA, 1)
+	}
+	return nil
+}
+
+// MuMap is a voxelised map of linear attenuation coefficients.
+// The voxel (ix, iy, iz) spans
+//
+//	[X0 + ix*Dx, X0 + (ix+1)*Dx[ x [Y0 + iy*Dy, Y0 + (iy+1)*Dy[ x [Z0 + iz*Dz, Z0 + (iz+1)*Dz[
+//
+// Coordinates are in mm and attenuation coefficients in 1/mm, in the DPGA frame.
+type MuMap struct {
+	Nx, Ny, Nz int
+	Dx, Dy, Dz float64
+	X0, Y0, Z0 float64
+	Mu         []float64 // attenuation coefficients, index is ix + Nx*(iy + Ny*iz)
+}
+
+// NewMuMap returns a map with nx*ny*nz voxels of size dx*dy*dz, with lower
+// corner at (x0, y0, z0), filled with zeros.
+func NewMuMap(nx, ny, nz int, dx, dy, dz, x0, y0, z0 float64) *MuMap {
+	return &MuMap{
+		Nx: nx, Ny: ny, Nz: nz,
+		Dx: dx, Dy: dy, Dz: dz,
+		X0: x0, Y0: y0, Z0: z0,
+		Mu: make([]float64, nx*ny*nz),
+	}
+}
+
+// NewCylinderMuMap returns a map describing a uniform cylinder of radius r and
+// length l centered on the origin and whose axis is the Z axis (i.e. the beam axis),
+// made of a material with attenuation coefficient mu.
+// The voxels are cubes of side voxelSize.
+// The fraction of each voxel inside the cylinder is estimated by subdividing it.
+func NewCylinderMuMap(r, l, mu, voxelSize float64) *MuMap {
+	nxy := int(math.Ceil(2 * r / voxelSize))
+	nz := int(math.Ceil(l / voxelSize))
+	m := NewMuMap(nxy, nxy, nz, voxelSize, voxelSize, voxelSize,
+		-float64(nxy)*voxelSize/2, -float64(nxy)*voxelSize/2, -float64(nz)*voxelSize/2)
+	const nsub = 4
+	for iz := 0; iz < nz; iz++ {
+		zc := m.Z0 + (float64(iz)+0.5)*voxelSize
+		if math.Abs(zc) > l/2 {
+			continue
+		}
+		for iy := 0; iy < nxy; iy++ {
+			for ix := 0; ix < nxy; ix++ {
+				inside := 0
+				for sy := 0; sy < nsub; sy++ {
+					for sx := 0; sx < nsub; sx++ {
+						x := m.X0 + (float64(ix)+(float64(sx)+0.5)/nsub)*voxelSize
+						y := m.Y0 + (float64(iy)+(float64(sy)+0.5)/nsub)*voxelSize
+						if x*x+y*y < r*r {
+							inside++
+						}
+					}
+				}
+				m.Set(ix, iy, iz, mu*float64(inside)/(nsub*nsub))
+			}
+		}
+	}
+	return m
+}
+
+func (m *MuMap) index(ix, iy, iz int) int {
+	return ix + m.Nx*(iy+m.Ny*iz)
+}
+
+// At returns the attenuation coefficient of voxel (ix, iy, iz).
+func (m *MuMap) At(ix, iy, iz int) float64 {
+	return m.Mu[m.index(ix, iy, iz)]
+}
+
+// Set sets the attenuation coefficient of voxel (ix, iy, iz).
+func (m *MuMap) Set(ix, iy, iz int, mu float64) {
+	m.Mu[m.index(ix, iy, iz)] = mu
+}
+
+// LineIntegral returns the integral of the attenuation coefficient along the
+// segment joining (x1, y1, z1) and (x2, y2, z2).
+// It follows Siddon's approach: the segment is split at each intersection with
+// the voxel boundaries and the length of each piece is multiplied by the
+// attenuation coefficient of the voxel it lies in.
+func (m *MuMap) LineIntegral(x1, y1, z1, x2, y2, z2 float64) float64 {
+	p1 := [3]float64{x1, y1, z1}
+	d := [3]float64{x2 - x1, y2 - y1, z2 - z1}
+	length := math.Sqrt(d[0]*d[0] + d[1]*d[1] + d[2]*d[2])
+	if length == 0 {
+		return 0
+	}
+	n := [3]int{m.Nx, m.Ny, m.Nz}
+	step := [3]float64{m.Dx, m.Dy, m.Dz}
+	origin := [3]float64{m.X0, m.Y0, m.Z0}
+
+	// Parametric range of the segment inside the map
+	amin, amax := 0., 1.
+	for k := 0; k < 3; k++ {
+		lo, hi := origin[k], origin[k]+float64(n[k])*step[k]
+		if d[k] == 0 {
+			if p1[k] < lo || p1[k] >= hi {
+				return 0
+			}
+			continue
+		}
+		a1, a2 := (lo-p1[k])/d[k], (hi-p1[k])/d[k]
+		if a1 > a2 {
+			a1, a2 = a2, a1
+		}
+		amin = math.Max(amin, a1)
+		amax = math.Min(amax, a2)
+	}
+	if amin >= amax {
+		return 0
+	}
+
+	// Parametric values of the intersections with voxel boundaries
+	alphas := []float64{amin, amax}
+	for k := 0; k < 3; k++ {
+		if d[k] == 0 {
+			continue
+		}
+		for i := 0; i <= n[k]; i++ {
+			a := (origin[k] + float64(i)*step[k] - p1[k]) / d[k]
+			if a > amin && a < amax {
+				alphas = append(alphas, a)
+			}
+		}
+	}
+	sort.Float64s(alphas)
+
+	sum := 0.
+	for i := 0; i < len(alphas)-1; i++ {
+		da := alphas[i+1] - alphas[i]
+		if da <= 0 {
+			continue
+		}
+		amid := 0.5 * (alphas[i] + alphas[i+1])
+		var idx [3]int
+		out := false
+		for k := 0; k < 3; k++ {
+			idx[k] = int(math.Floor((p1[k] + amid*d[k] - origin[k]) / step[k]))
+			if idx[k] < 0 || idx[k] >= n[k] {
+				out = true
+			}
+		}
+		if out {
+			continue
+		}
+		sum += m.At(idx[0], idx[1], idx[2]) * da * length
+	}
+	return sum
+}
+
+// AttCorrection returns the attenuation correction factor of the LOR joining
+// channels ch1 and ch2, that is exp(integral of mu along the LOR).
+// The LOR endpoints are chosen according to pos and to the energies e1 and e2
+// (in keV) measured in ch1 and ch2 (see Endpoints).
+func (m *MuMap) AttCorrection(pos CrystPos, ch1, ch2 *detector.Channel, e1, e2 float64) float64 {
+	p1, p2 := Endpoints(pos, ch1, ch2, e1, e2)
+	return math.Exp(m.LineIntegral(p1.X, p1.Y, p1.Z, p2.X, p2.Y, p2.Z))
+}
+
+// ReadMuMap reads an attenuation map from a text file.
+// Lines starting with # are comments.
+// The first line that is not a comment gives the geometry of the map:
+//
+//	nx ny nz dx dy dz x0 y0 z0
+//
+// Each subsequent line gives the attenuation coefficient of one voxel:
+//
+//	ix iy iz mu
+//
+// Voxels that are not listed have an attenuation coefficient equal to 0.
+func ReadMuMap(fileName string) *MuMap {
+	f, err := os.Open(fileName)
+	if err != nil {
+		log.Fatalf("could not open %s: %v\n", fileName, err)
+	}
+	defer f.Close()
+
+	var m *MuMap
+	scanner := bufio.NewScanner(f)
+	iline := 0
+	for scanner.Scan() {
+		iline++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m == nil {
+			var nx, ny, nz int
+			var dx, dy, dz, x0, y0, z0 float64
+			_, err = fmt.Sscan(line, &nx, &ny, &nz, &dx, &dy, &dz, &x0, &y0, &z0)
+			if err != nil {
+				log.Fatalf("error reading geometry of attenuation map (line %v): %v\n", iline, err)
+			}
+			m = NewMuMap(nx, ny, nz, dx, dy, dz, x0, y0, z0)
+			continue
+		}
+		var ix, iy, iz int
+		var mu float64
+		_, err = fmt.Sscan(line, &ix, &iy, &iz, &mu)
+		if err != nil {
+			log.Fatalf("error reading attenuation map (line %v): %v\n", iline, err)
+		}
+		if ix < 0 || ix >= m.Nx || iy < 0 || iy >= m.Ny || iz < 0 || iz >= m.Nz {
+			log.Fatalf("voxel (%v, %v, %v) out of attenuation map (line %v)\n", ix, iy, iz, iline)
+		}
+		m.Set(ix, iy, iz, mu)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("error reading %s: %v\n", fileName, err)
+	}
+	if m == nil {
+		log.Fatalf("attenuation map %s is empty\n", fileName)
+	}
+	return m
+}
+
+// WriteMuMap writes the attenuation map to a text file in the format
+// expected by ReadMuMap.
+// Voxels with a null attenuation coefficient are not written.
+func (m *MuMap) WriteMuMap(fileName string) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		log.Fatalf("could not create %s: %v\n", fileName, err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	fmt.Fprintf(w, "# nx ny nz dx dy dz x0 y0 z0\n")
+	fmt.Fprintf(w, "%v %v %v %v %v %v %v %v %v\n", m.Nx, m.Ny, m.Nz, m.Dx, m.Dy, m.Dz, m.X0, m.Y0, m.Z0)
+	fmt.Fprintf(w, "# ix iy iz mu\n")
+	for iz := 0; iz < m.Nz; iz++ {
+		for iy := 0; iy < m.Ny; iy++ {
+			for ix := 0; ix < m.Nx; ix++ {
+				if mu := m.At(ix, iy, iz); mu != 0 {
+					fmt.Fprintf(w, "%v %v %v %v\n", ix, iy, iz, mu)
+				}
+			}
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		log.Fatalf("error writing %s: %v\n", fileName, err)
+	}
+	err = f.Close()
+	if err != nil {
+		log.Fatalf("error closing %s: %v\n", fileName, err)
+	}
+}
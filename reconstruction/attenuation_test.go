@@ -0,0 +1,144 @@
+package reconstruction
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLineIntegralUniform(t *testing.T) {
+	const mu = 0.1
+	m := NewMuMap(10, 10, 10, 1, 1, 1, -5, -5, -5)
+	for i := range m.Mu {
+		m.Mu[i] = mu
+	}
+	tests := []struct {
+		name                   string
+		x1, y1, z1, x2, y2, z2 float64
+		want                   float64
+	}{
+		{"through the map", -10, 0.3, 0.2, 10, 0.3, 0.2, 10 * mu},
+		{"diagonal", -5, -5, -5, 5, 5, 5, math.Sqrt(300) * mu},
+		{"inside the map", -2, -1, 0.5, 2, 2, 0.5, 5 * mu},
+		{"leaving the map", 0, 0.5, 0.5, 20, 0.5, 0.5, 5 * mu},
+		{"outside the map", 0, 10, 0, 5, 10, 0, 0},
+		{"missing the map", -10, 6, 0, 10, 6.1, 0, 0},
+		{"null segment", 1, 1, 1, 1, 1, 1, 0},
+	}
+	for _, test := range tests {
+		got := m.LineIntegral(test.x1, test.y1, test.z1, test.x2, test.y2, test.z2)
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%v: integral = %v, want %v", test.name, got, test.want)
+		}
+		rev := m.LineIntegral(test.x2, test.y2, test.z2, test.x1, test.y1, test.z1)
+		if math.Abs(rev-got) > 1e-9 {
+			t.Errorf("%v: integral = %v in the reverse direction, want %v", test.name, rev, got)
+		}
+	}
+}
+
+// TestCylinderMuMap compares the integrals along lines crossing the map of a
+// cylindrical phantom to the analytic lengths of the chords of the cylinder.
+func TestCylinderMuMap(t *testing.T) {
+	const (
+		r  = 50.
+		l  = 200.
+		mu = MuWater
+	)
+	m := NewCylinderMuMap(r, l, mu, 1)
+
+	sum := 0.
+	for _, v := range m.Mu {
+		sum += v * m.Dx * m.Dy * m.Dz
+	}
+	if want := math.Pi * r * r * l * mu; math.Abs(sum-want) > 1e-3*want {
+		t.Errorf("integral of the map = %v, want %v", sum, want)
+	}
+
+	// lines perpendicular to the axis, at a distance b from it: the map
+	// holds the mean over the voxels, that is the mean of the chord
+	// over the row of voxels crossed, between y0 and y0+1
+	area := func(y float64) float64 { // area of the disk between 0 and y
+		return y*math.Sqrt(r*r-y*y) + r*r*math.Asin(y/r)
+	}
+	for _, b := range []float64{0.2, 10.3, 25.7, 40.1, 48.6} {
+		y0 := math.Floor(b)
+		want := (area(y0+1) - area(y0)) * mu
+		got := m.LineIntegral(-100, b, 30.4, 100, b, 30.4)
+		if math.Abs(got-want) > 0.01*want {
+			t.Errorf("line at %v mm from the axis: integral = %v, want %v", b, got, want)
+		}
+	}
+	// lines through the axis, at an angle theta from it in the plane y = 0.3,
+	// crossing the wall of the cylinder and not its ends
+	for _, theta := range []float64{math.Pi / 2, math.Pi / 3, math.Pi / 4} {
+		dx, dz := 150*math.Sin(theta), 150*math.Cos(theta)
+		want := 2 * math.Sqrt(r*r-0.3*0.3) / math.Sin(theta) * mu
+		got := m.LineIntegral(-dx, 0.3, -dz, dx, 0.3, dz)
+		if math.Abs(got-want) > 0.01*want {
+			t.Errorf("line at %v rad from the axis: integral = %v, want %v", theta, got, want)
+		}
+	}
+	// line along the axis, crossing the ends of the cylinder
+	if got, want := m.LineIntegral(0.3, 0.2, -150, 0.3, 0.2, 150), l*mu; math.Abs(got-want) > 1e-9 {
+		t.Errorf("line along the axis: integral = %v, want %v", got, want)
+	}
+	if got := m.LineIntegral(-100, r+1, 0, 100, r+1, 0); got != 0 {
+		t.Errorf("line outside the cylinder: integral = %v, want 0", got)
+	}
+}
+
+func TestAttCorrection(t *testing.T) {
+	ch1, ch2 := testCrystal(-1), testCrystal(1)
+	var p Phantom
+	if err := p.Set("water"); err != nil {
+		t.Fatalf("could not set phantom: %v\n", err)
+	}
+	m := p.MuMap(50, 200)
+	// the LOR joins the centers of the crystals along the axis of the phantom
+	if got, want := m.AttCorrection(CrystCenter, ch1, ch2, 511, 511), math.Exp(200*MuWater); math.Abs(got-want) > 1e-9*want {
+		t.Errorf("attenuation correction = %v, want %v", got, want)
+	}
+
+	for _, test := range []struct {
+		value string
+		p     Phantom
+		mu    float64
+	}{
+		{"", NoPhantom, 0},
+		{"water", Water, MuWater},
+		{"pmma", PMMA, MuPMMA},
+	} {
+		if err := p.Set(test.value); err != nil || p != test.p || p.String() != test.value {
+			t.Errorf("phantom %q: Set = %v, %v, want %v", test.value, p.String(), err, test.p)
+		}
+		m := p.MuMap(10, 10)
+		switch {
+		case test.p == NoPhantom && m != nil:
+			t.Errorf("map for no phantom")
+		case test.p != NoPhantom && (m == nil || m.At(m.Nx/2, m.Ny/2, m.Nz/2) != test.mu):
+			t.Errorf("phantom %q: wrong attenuation map", test.value)
+		}
+	}
+	if err := p.Set("lead"); err == nil {
+		t.Errorf("phantom lead set without error")
+	}
+}
+
+func TestMuMapFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconstruction-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "mumap.txt")
+
+	m := NewCylinderMuMap(5, 8, MuPMMA, 1.5)
+	m.WriteMuMap(fileName)
+	if got := ReadMuMap(fileName); !reflect.DeepEqual(got, m) {
+		t.Errorf("map read back differs:\ngot= %+v\nwant=%+v", got, m)
+	}
+}
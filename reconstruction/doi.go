@@ -0,0 +1,184 @@
+package reconstruction
+
+import (
+	"fmt"
+	"math"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/detector"
+	"gitlab.in2p3.fr/avirm/analysis-go/utils"
+)
+
+// CrystPos indicates which point of a crystal is used as LOR endpoint.
+type CrystPos byte
+
+const (
+	FrontFace   CrystPos = iota // center of the front face of the crystal (Channel.CartCoord)
+	CrystCenter                 // center of the crystal (Channel.CrystCenter)
+	DOI                         // mean interaction point of the gamma in the crystal (depth of interaction)
+)
+
+func (c *CrystPos) String() string {
+	switch *c {
+	case FrontFace:
+		return "front"
+	case CrystCenter:
+		return "center"
+	case DOI:
+		return "doi"
+	default:
+		return fmt.Sprintf("CrystPos(%v)", *c)
+	}
+}
+
+// Set is the method to set the flag value.
+func (c *CrystPos) Set(value string) error {
+	switch value {
+	case "front":
+		*c = FrontFace
+	case "center":
+		*c = CrystCenter
+	case "doi":
+		*c = DOI
+	default:
+		return fmt.Errorf("invalid crystal position value %q", value)
+	}
+	return nil
+}
+
+// AnnihilationEnergy is the energy (in keV) of the gammas produced by the annihilation of a positron.
+const AnnihilationEnergy = 511.
+
+// lysoMu gives the linear attenuation coefficient (in 1/mm) of LYSO as a function
+// of the gamma energy (in keV).
+// Values are approximate (they are obtained from the mass attenuation coefficients of
+// the LYSO components for a density of 7.1 g/cm3) and are linearly interpolated in log-log.
+var lysoMu = []struct{ E, Mu float64 }{
+	{100, 2.6},
+	{200, 0.48},
+	{300, 0.22},
+	{400, 0.13},
+	{511, 0.087},
+	{662, 0.066},
+	{1000, 0.045},
+}
+
+// LYSOMu returns the linear attenuation coefficient (in 1/mm) of LYSO for gammas of energy e (in keV).
+func LYSOMu(e float64) float64 {
+	if e <= lysoMu[0].E {
+		return lysoMu[0].Mu
+	}
+	for i := 1; i < len(lysoMu); i++ {
+		if e <= lysoMu[i].E {
+			lo, hi := lysoMu[i-1], lysoMu[i]
+			t := math.Log(e/lo.E) / math.Log(hi.E/lo.E)
+			return math.Exp(math.Log(lo.Mu) + t*math.Log(hi.Mu/lo.Mu))
+		}
+	}
+	return lysoMu[len(lysoMu)-1].Mu
+}
+
+// MeanInteractionDepth returns the mean depth at which a gamma interacts in a material of
+// linear attenuation coefficient mu, given that it interacts within a path of length l.
+// It is the mean of the exponential interaction probability truncated at l:
+//
+//	<d> = 1/mu - l*exp(-mu*l) / (1 - exp(-mu*l))
+func MeanInteractionDepth(mu, l float64) float64 {
+	if l <= 0 {
+		return 0
+	}
+	if mu*l < 1e-6 {
+		return l / 2
+	}
+	return 1/mu - l*math.Exp(-mu*l)/(1-math.Exp(-mu*l))
+}
+
+// DOIPoint returns the mean interaction point in the crystal of channel ch of a gamma
+// of energy e (in keV) coming from point from.
+// The path of the gamma in the crystal is computed from the full crystal geometry
+// (Channel.ScintCoords) and the interaction depth along this path is weighted by
+// the attenuation in LYSO (see MeanInteractionDepth).
+// If the gamma does not cross the crystal (which can only happen for points
+// located very close to the crystal), the center of the crystal is returned.
+func DOIPoint(ch *detector.Channel, from utils.CartCoord, e float64) utils.CartCoord {
+	// Direction of the gamma
+	dx := ch.CrystCenter.X - from.X
+	dy := ch.CrystCenter.Y - from.Y
+	dz := ch.CrystCenter.Z - from.Z
+	norm := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	if norm == 0 {
+		return ch.CrystCenter
+	}
+	dx, dy, dz = dx/norm, dy/norm, dz/norm
+
+	// The crystal is a rectangular parallelepiped with corner 0 as origin and
+	// edges 0->1, 0->3 (front face) and 0->4 (depth), see detector.RectParallelepiped.
+	c := &ch.ScintCoords
+	edges := [3]utils.CartCoord{
+		{X: c[1].X - c[0].X, Y: c[1].Y - c[0].Y, Z: c[1].Z - c[0].Z},
+		{X: c[3].X - c[0].X, Y: c[3].Y - c[0].Y, Z: c[3].Z - c[0].Z},
+		{X: c[4].X - c[0].X, Y: c[4].Y - c[0].Y, Z: c[4].Z - c[0].Z},
+	}
+	tmin, tmax := math.Inf(-1), math.Inf(1)
+	for _, edge := range edges {
+		l2 := edge.X*edge.X + edge.Y*edge.Y + edge.Z*edge.Z
+		if l2 == 0 {
+			return ch.CrystCenter
+		}
+		// Coordinates along the edge (in units of the edge length) of the ray origin and direction
+		s0 := ((from.X-c[0].X)*edge.X + (from.Y-c[0].Y)*edge.Y + (from.Z-c[0].Z)*edge.Z) / l2
+		ds := (dx*edge.X + dy*edge.Y + dz*edge.Z) / l2
+		if ds == 0 {
+			if s0 < 0 || s0 > 1 {
+				return ch.CrystCenter
+			}
+			continue
+		}
+		t1, t2 := -s0/ds, (1-s0)/ds
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		tmin = math.Max(tmin, t1)
+		tmax = math.Min(tmax, t2)
+	}
+	if tmin >= tmax || tmax <= 0 {
+		return ch.CrystCenter
+	}
+	tmin = math.Max(tmin, 0)
+
+	t := tmin + MeanInteractionDepth(LYSOMu(e), tmax-tmin)
+	return utils.CartCoord{
+		X: from.X + t*dx,
+		Y: from.Y + t*dy,
+		Z: from.Z + t*dz,
+	}
+}
+
+// Endpoints returns the endpoints of the LOR joining channels ch1 and ch2,
+// according to the crystal position pos.
+// For the DOI mode, e1 and e2 are the energies (in keV) measured in ch1 and
+// ch2, and the mean interaction point in each crystal is computed for a gamma
+// of the energy measured in this crystal coming from the center of the other
+// crystal. Energies that are not positive (energies not calibrated) are
+// replaced by AnnihilationEnergy.
+func Endpoints(pos CrystPos, ch1, ch2 *detector.Channel, e1, e2 float64) (p1, p2 utils.CartCoord) {
+	switch pos {
+	case FrontFace:
+		p1 = ch1.CartCoord
+		p2 = ch2.CartCoord
+	case CrystCenter:
+		p1 = ch1.CrystCenter
+		p2 = ch2.CrystCenter
+	case DOI:
+		if e1 <= 0 {
+			e1 = AnnihilationEnergy
+		}
+		if e2 <= 0 {
+			e2 = AnnihilationEnergy
+		}
+		p1 = DOIPoint(ch1, ch2.CrystCenter, e1)
+		p2 = DOIPoint(ch2, ch1.CrystCenter, e2)
+	default:
+		panic("reconstruction: crystal position not known")
+	}
+	return
+}
@@ -0,0 +1,143 @@
+package reconstruction
+
+import (
+	"math"
+	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/detector"
+	"gitlab.in2p3.fr/avirm/analysis-go/utils"
+)
+
+// testCrystal returns a channel whose crystal is a box of 4x4 mm2 section and
+// 20 mm depth, centered on the z axis, with its front face at z = 100 mm if
+// sign is positive and at z = -100 mm otherwise.
+func testCrystal(sign float64) *detector.Channel {
+	const (
+		half  = 2.
+		front = 100.
+		depth = 20.
+	)
+	ch := &detector.Channel{
+		CartCoord:   utils.CartCoord{Z: sign * front},
+		CrystCenter: utils.CartCoord{Z: sign * (front + depth/2)},
+	}
+	// corners 0, 1, 2, 3 on the front face and 4, 5, 6, 7 on the back face
+	for i, c := range [][2]float64{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}} {
+		ch.ScintCoords[i] = utils.CartCoord{X: c[0] * half, Y: c[1] * half, Z: sign * front}
+		ch.ScintCoords[i+4] = utils.CartCoord{X: c[0] * half, Y: c[1] * half, Z: sign * (front + depth)}
+	}
+	return ch
+}
+
+func TestLYSOMu(t *testing.T) {
+	for _, p := range lysoMu {
+		if mu := LYSOMu(p.E); math.Abs(mu-p.Mu) > 1e-12 {
+			t.Errorf("LYSOMu(%v) = %v, want %v", p.E, mu, p.Mu)
+		}
+	}
+	if mu := LYSOMu(50); mu != lysoMu[0].Mu {
+		t.Errorf("LYSOMu(50) = %v, want %v", mu, lysoMu[0].Mu)
+	}
+	if mu := LYSOMu(2000); mu != lysoMu[len(lysoMu)-1].Mu {
+		t.Errorf("LYSOMu(2000) = %v, want %v", mu, lysoMu[len(lysoMu)-1].Mu)
+	}
+	for e := 100.; e < 1000; e += 10 {
+		if LYSOMu(e+10) >= LYSOMu(e) {
+			t.Errorf("LYSOMu not decreasing between %v and %v keV", e, e+10)
+		}
+	}
+}
+
+func TestMeanInteractionDepth(t *testing.T) {
+	tests := []struct {
+		mu, l float64
+	}{
+		{0.087, 20},
+		{2.6, 20},
+		{0.01, 5},
+		{0.5, 1},
+	}
+	for _, test := range tests {
+		// numerical mean of the depth weighted by the interaction probability
+		const n = 100000
+		var sum, sumW float64
+		for i := 0; i < n; i++ {
+			d := (float64(i) + 0.5) * test.l / n
+			w := math.Exp(-test.mu * d)
+			sum += d * w
+			sumW += w
+		}
+		if got, want := MeanInteractionDepth(test.mu, test.l), sum/sumW; math.Abs(got-want) > 1e-6 {
+			t.Errorf("mu = %v, l = %v: depth = %v, want %v", test.mu, test.l, got, want)
+		}
+	}
+	if d := MeanInteractionDepth(0.087, 0); d != 0 {
+		t.Errorf("depth = %v for a null path, want 0", d)
+	}
+	if d := MeanInteractionDepth(0, 10); d != 5 {
+		t.Errorf("depth = %v without attenuation, want 5", d)
+	}
+}
+
+func TestDOIPoint(t *testing.T) {
+	ch := testCrystal(1)
+	// gamma coming from the side, entering the crystal through its face at
+	// x = -2 and leaving it through its face at x = 2 (see below)
+	side := utils.CartCoord{X: -50}
+	sideNorm := math.Hypot(50, 110)
+	tests := []struct {
+		name string
+		from utils.CartCoord
+		e    float64
+		want utils.CartCoord
+	}{
+		{"along the axis", utils.CartCoord{}, 511, utils.CartCoord{Z: 100 + MeanInteractionDepth(LYSOMu(511), 20)}},
+		{"along the axis, low energy", utils.CartCoord{}, 200, utils.CartCoord{Z: 100 + MeanInteractionDepth(LYSOMu(200), 20)}},
+		{"from the back", utils.CartCoord{Z: 300}, 511, utils.CartCoord{Z: 120 - MeanInteractionDepth(LYSOMu(511), 20)}},
+		{"from inside", utils.CartCoord{Z: 115}, 511, utils.CartCoord{Z: 115 - MeanInteractionDepth(LYSOMu(511), 15)}},
+		{"from the center", ch.CrystCenter, 511, ch.CrystCenter},
+		{
+			// the path in the crystal goes from x = -2 (s = 0.96) to x = 2 (s = 1.04),
+			// where s is the fraction of the distance to the center of the crystal
+			"oblique", side, 511,
+			func() utils.CartCoord {
+				s := 0.96 + MeanInteractionDepth(LYSOMu(511), 0.08*sideNorm)/sideNorm
+				return utils.CartCoord{X: -50 + 50*s, Z: 110 * s}
+			}(),
+		},
+	}
+	for _, test := range tests {
+		got := DOIPoint(ch, test.from, test.e)
+		if math.Abs(got.X-test.want.X) > 1e-9 || math.Abs(got.Y-test.want.Y) > 1e-9 || math.Abs(got.Z-test.want.Z) > 1e-9 {
+			t.Errorf("%v: DOI point = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	ch1, ch2 := testCrystal(-1), testCrystal(1)
+	doi := func(e float64) float64 {
+		return 100 + MeanInteractionDepth(LYSOMu(e), 20)
+	}
+	tests := []struct {
+		pos    CrystPos
+		e1, e2 float64
+		z1, z2 float64
+	}{
+		{FrontFace, 511, 511, -100, 100},
+		{CrystCenter, 511, 511, -110, 110},
+		{DOI, 511, 511, -doi(511), doi(511)},
+		{DOI, 300, 650, -doi(300), doi(650)},
+		{DOI, 0, 650, -doi(AnnihilationEnergy), doi(650)},
+		{DOI, 300, -1, -doi(300), doi(AnnihilationEnergy)},
+	}
+	for _, test := range tests {
+		p1, p2 := Endpoints(test.pos, ch1, ch2, test.e1, test.e2)
+		if p1.X != 0 || p1.Y != 0 || p2.X != 0 || p2.Y != 0 ||
+			math.Abs(p1.Z-test.z1) > 1e-9 || math.Abs(p2.Z-test.z2) > 1e-9 {
+			t.Errorf("%v, energies %v and %v: endpoints = %+v, %+v, want z = %v, %v",
+				test.pos.String(), test.e1, test.e2, p1, p2, test.z1, test.z2)
+		}
+	}
+
+}
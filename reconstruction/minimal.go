@@ -21,24 +21,13 @@ import (
 //    - w0 = (x1-x0, y1-y0, z1)
 // The quantity called coeff below corresponds to the quantity called sc in the above web page
 // (multiplied by -1).
-func Minimal(crystCenter bool, ch1, ch2 *detector.Channel, xbeam, ybeam float64) (x, y, z float64) {
-	var x1, x2, y1, y2, z1, z2 float64
-	switch crystCenter {
-	case true:
-		x1 = ch1.CrystCenter.X
-		x2 = ch2.CrystCenter.X
-		y1 = ch1.CrystCenter.Y
-		y2 = ch2.CrystCenter.Y
-		z1 = ch1.CrystCenter.Z
-		z2 = ch2.CrystCenter.Z
-	case false:
-		x1 = ch1.CartCoord.X
-		x2 = ch2.CartCoord.X
-		y1 = ch1.CartCoord.Y
-		y2 = ch2.CartCoord.Y
-		z1 = ch1.CartCoord.Z
-		z2 = ch2.CartCoord.Z
-	}
+// The LOR endpoints are chosen according to pos (see CrystPos). In the DOI mode,
+// the endpoints are the mean interaction points in the crystals of gammas of
+// the energies e1 and e2 (in keV) measured in ch1 and ch2 (see Endpoints).
+func Minimal(pos CrystPos, ch1, ch2 *detector.Channel, e1, e2, xbeam, ybeam float64) (x, y, z float64) {
+	p1, p2 := Endpoints(pos, ch1, ch2, e1, e2)
+	x1, y1, z1 := p1.X, p1.Y, p1.Z
+	x2, y2, z2 := p2.X, p2.Y, p2.Z
 	coeff := ((x2-x1)*(x1-xbeam) + (y2-y1)*(y1-ybeam)) / ((x2-x1)*(x2-x1) + (y2-y1)*(y2-y1))
 	x = x1 - coeff*(x2-x1)
 	y = y1 - coeff*(y2-y1)
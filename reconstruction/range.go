@@ -0,0 +1,355 @@
+package reconstruction
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// BeamDir indicates in which direction the beam travels along the Z axis.
+// It determines which side of the activity profile is the distal one.
+type BeamDir int
+
+const (
+	BeamAlongPlusZ  BeamDir = 1
+	BeamAlongMinusZ BeamDir = -1
+)
+
+// Profile is a binned activity profile along the beam axis, typically
+// the Z distribution of the points reconstructed with the minimal approach
+// (MAR) or with time of flight (TOF).
+type Profile struct {
+	Z []float64 // bin centers (mm)
+	N []float64 // bin contents
+}
+
+// NewProfile makes a profile with nbins bins between zmin and zmax from
+// a set of reconstructed Z coordinates.
+// Coordinates outside [zmin, zmax[ are ignored.
+func NewProfile(zs []float64, nbins int, zmin, zmax float64) Profile {
+	p := Profile{
+		Z: make([]float64, nbins),
+		N: make([]float64, nbins),
+	}
+	width := (zmax - zmin) / float64(nbins)
+	for i := range p.Z {
+		p.Z[i] = zmin + (float64(i)+0.5)*width
+	}
+	for _, z := range zs {
+		if z < zmin || z >= zmax {
+			continue
+		}
+		p.N[int((z-zmin)/width)]++
+	}
+	return p
+}
+
+// DistalEdge holds the result of the fit of the distal fall-off of an
+// activity profile.
+// The fall-off is modelled as
+//
+//	f(z) = Bkg + Plateau/2 * erfc(Dir * (z - R50) / (sqrt(2) * Sigma))
+//
+// so that R50 is the position at which the activity has dropped to half
+// of its plateau value.
+type DistalEdge struct {
+	Dir       BeamDir // direction of the beam (the zero value is treated as BeamAlongPlusZ)
+	R50       float64 // position of the 50% distal point (mm)
+	R50Err    float64 // uncertainty on R50 (mm)
+	Sigma     float64 // width of the fall-off (mm)
+	Plateau   float64 // activity on the plateau (above background)
+	Bkg       float64 // activity beyond the distal edge
+	Chi2      float64 // chi2 of the fit
+	NDF       int     // number of degrees of freedom of the fit
+	Converged bool    // true if the fit converged
+}
+
+// Func returns the fitted fall-off model as a function of z.
+func (d *DistalEdge) Func() func(z float64) float64 {
+	dir := 1.
+	if d.Dir == BeamAlongMinusZ {
+		dir = -1
+	}
+	return func(z float64) float64 {
+		return d.Bkg + 0.5*d.Plateau*math.Erfc(dir*(z-d.R50)/(math.Sqrt2*d.Sigma))
+	}
+}
+
+// RangeShift returns the shift of the distal edge d with respect to the
+// reference distal edge ref, together with its uncertainty.
+// The shift is positive when the distal edge moved in the direction of the beam.
+func RangeShift(d, ref DistalEdge, dir BeamDir) (shift, err float64) {
+	shift = float64(dir) * (d.R50 - ref.R50)
+	err = math.Sqrt(d.R50Err*d.R50Err + ref.R50Err*ref.R50Err)
+	return
+}
+
+// Distal50 returns the position at which the profile, coming from its maximum
+// and moving in the direction of the beam, falls below 50% of the maximum.
+// The position is linearly interpolated between bin centers.
+// The maximum is searched for on the profile smoothed over three bins in order to
+// reduce the sensitivity to statistical fluctuations.
+// NaN is returned if no such position is found.
+func Distal50(p Profile, dir BeamDir) float64 {
+	z, n := oriented(p, dir)
+	imax, max := smoothedMax(n)
+	if imax < 0 {
+		return math.NaN()
+	}
+	half := 0.5 * max
+	for i := imax + 1; i < len(n); i++ {
+		if n[i] < half {
+			// interpolate between bins i-1 and i
+			r := z[i-1] + (n[i-1]-half)/(n[i-1]-n[i])*(z[i]-z[i-1])
+			return float64(dir) * r
+		}
+	}
+	return math.NaN()
+}
+
+// BootstrapDistal50 estimates the 50% distal point and its uncertainty by
+// resampling the bin contents of the profile nboot times according to
+// Poisson statistics and computing Distal50 for each replica.
+// It returns the mean and standard deviation of the replicas.
+func BootstrapDistal50(p Profile, dir BeamDir, nboot int, rnd *rand.Rand) (mean, std float64) {
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(1))
+	}
+	replica := Profile{Z: p.Z, N: make([]float64, len(p.N))}
+	var vals []float64
+	for iboot := 0; iboot < nboot; iboot++ {
+		for i := range p.N {
+			replica.N[i] = poisson(rnd, p.N[i])
+		}
+		r := Distal50(replica, dir)
+		if math.IsNaN(r) {
+			continue
+		}
+		vals = append(vals, r)
+	}
+	if len(vals) < 2 {
+		return Distal50(p, dir), math.NaN()
+	}
+	for _, v := range vals {
+		mean += v
+	}
+	mean /= float64(len(vals))
+	for _, v := range vals {
+		std += (v - mean) * (v - mean)
+	}
+	std = math.Sqrt(std / float64(len(vals)-1))
+	return
+}
+
+// FitDistalEdge fits the distal fall-off of the profile with the model
+// described in the DistalEdge documentation.
+// The fit range extends from the maximum of the profile to its distal end.
+// The chi2 is minimized with the Nelder-Mead simplex method, using the bin
+// contents as variances (with a minimum of 1 for empty bins).
+// The uncertainty on R50 is obtained from the curvature of the chi2 with
+// respect to R50 at the minimum.
+func FitDistalEdge(p Profile, dir BeamDir) DistalEdge {
+	z, n := oriented(p, dir)
+	imax, max := smoothedMax(n)
+	if imax < 0 || len(n)-imax < 5 {
+		return DistalEdge{Dir: dir, R50: math.NaN(), R50Err: math.NaN()}
+	}
+	z = z[imax:]
+	n = n[imax:]
+
+	// Starting values
+	tail := n[len(n)-len(n)/4:]
+	bkg := 0.
+	for _, v := range tail {
+		bkg += v
+	}
+	bkg /= float64(len(tail))
+	r50 := Distal50(Profile{Z: z, N: n}, BeamAlongPlusZ)
+	if math.IsNaN(r50) {
+		r50 = 0.5 * (z[0] + z[len(z)-1])
+	}
+	sigma := 2 * math.Abs(z[1]-z[0])
+
+	chi2 := func(par []float64) float64 {
+		d := DistalEdge{R50: par[0], Sigma: par[1], Plateau: par[2], Bkg: par[3]}
+		if d.Sigma <= 0 {
+			return math.Inf(1)
+		}
+		f := d.Func()
+		c := 0.
+		for i := range z {
+			v := math.Max(n[i], 1)
+			diff := n[i] - f(z[i])
+			c += diff * diff / v
+		}
+		return c
+	}
+
+	par, converged := nelderMead(chi2, []float64{r50, sigma, max - bkg, bkg}, 2000, 1e-8)
+	d := DistalEdge{
+		R50:       par[0],
+		Sigma:     par[1],
+		Plateau:   par[2],
+		Bkg:       par[3],
+		Chi2:      chi2(par),
+		NDF:       len(z) - len(par),
+		Converged: converged,
+	}
+
+	// Uncertainty on R50 from the second derivative of the chi2,
+	// sigma^2 = 2 / (d2chi2/dR50^2)
+	h := 0.01 * math.Abs(z[1]-z[0])
+	pp := append([]float64(nil), par...)
+	pm := append([]float64(nil), par...)
+	pp[0] += h
+	pm[0] -= h
+	d2 := (chi2(pp) - 2*d.Chi2 + chi2(pm)) / (h * h)
+	d.R50Err = math.NaN()
+	if d2 > 0 {
+		d.R50Err = math.Sqrt(2 / d2)
+	}
+
+	d.R50 *= float64(dir)
+	d.Dir = dir
+	return d
+}
+
+// oriented returns the profile such that the beam travels towards increasing z.
+func oriented(p Profile, dir BeamDir) (z, n []float64) {
+	if dir != BeamAlongMinusZ {
+		return p.Z, p.N
+	}
+	z = make([]float64, len(p.Z))
+	n = make([]float64, len(p.N))
+	for i := range p.Z {
+		z[len(z)-1-i] = -p.Z[i]
+		n[len(n)-1-i] = p.N[i]
+	}
+	return
+}
+
+// smoothedMax returns the index and value of the maximum of n after
+// smoothing over three bins.
+// The returned index is -1 if the profile is empty.
+func smoothedMax(n []float64) (int, float64) {
+	imax := -1
+	max := 0.
+	for i := range n {
+		lo := i - 1
+		if lo < 0 {
+			lo = 0
+		}
+		hi := i + 2
+		if hi > len(n) {
+			hi = len(n)
+		}
+		s := 0.
+		for j := lo; j < hi; j++ {
+			s += n[j]
+		}
+		s /= float64(hi - lo)
+		if s > max {
+			imax = i
+			max = s
+		}
+	}
+	return imax, max
+}
+
+// poisson returns a random number distributed according to a Poisson
+// distribution of mean mu.
+// For large means, the gaussian approximation is used.
+func poisson(rnd *rand.Rand, mu float64) float64 {
+	if mu <= 0 {
+		return 0
+	}
+	if mu > 30 {
+		return math.Max(0, math.Floor(mu+math.Sqrt(mu)*rnd.NormFloat64()+0.5))
+	}
+	l := math.Exp(-mu)
+	k := 0.
+	prod := rnd.Float64()
+	for prod > l {
+		k++
+		prod *= rnd.Float64()
+	}
+	return k
+}
+
+// nelderMead minimizes f starting from x0 using the downhill simplex method.
+// It returns the best point found and whether the relative spread of the
+// function values on the simplex fell below tol before maxIter iterations.
+func nelderMead(f func([]float64) float64, x0 []float64, maxIter int, tol float64) ([]float64, bool) {
+	const (
+		alpha = 1.
+		gamma = 2.
+		rho   = 0.5
+		sigma = 0.5
+	)
+	n := len(x0)
+	type vertex struct {
+		x []float64
+		f float64
+	}
+	simplex := make([]vertex, n+1)
+	simplex[0] = vertex{x: append([]float64(nil), x0...), f: f(x0)}
+	for i := 0; i < n; i++ {
+		x := append([]float64(nil), x0...)
+		step := 0.1 * math.Abs(x[i])
+		if step == 0 {
+			step = 0.1
+		}
+		x[i] += step
+		simplex[i+1] = vertex{x: x, f: f(x)}
+	}
+
+	point := func(c, d []float64, t float64) []float64 {
+		x := make([]float64, n)
+		for i := range x {
+			x[i] = c[i] + t*(d[i]-c[i])
+		}
+		return x
+	}
+
+	for iter := 0; iter < maxIter; iter++ {
+		sort.Slice(simplex, func(i, j int) bool { return simplex[i].f < simplex[j].f })
+		best, worst := simplex[0].f, simplex[n].f
+		if math.Abs(worst-best) <= tol*(math.Abs(worst)+math.Abs(best)+1e-300) {
+			return simplex[0].x, true
+		}
+
+		// centroid of all vertices but the worst one
+		c := make([]float64, n)
+		for _, v := range simplex[:n] {
+			for i := range c {
+				c[i] += v.x[i] / float64(n)
+			}
+		}
+
+		xr := point(c, simplex[n].x, -alpha)
+		fr := f(xr)
+		switch {
+		case fr < simplex[0].f:
+			xe := point(c, simplex[n].x, -gamma)
+			if fe := f(xe); fe < fr {
+				simplex[n] = vertex{xe, fe}
+			} else {
+				simplex[n] = vertex{xr, fr}
+			}
+		case fr < simplex[n-1].f:
+			simplex[n] = vertex{xr, fr}
+		default:
+			xc := point(c, simplex[n].x, rho)
+			if fc := f(xc); fc < simplex[n].f {
+				simplex[n] = vertex{xc, fc}
+				continue
+			}
+			for i := 1; i <= n; i++ {
+				x := point(simplex[0].x, simplex[i].x, sigma)
+				simplex[i] = vertex{x, f(x)}
+			}
+		}
+	}
+	sort.Slice(simplex, func(i, j int) bool { return simplex[i].f < simplex[j].f })
+	return simplex[0].x, false
+}
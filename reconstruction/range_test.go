@@ -0,0 +1,65 @@
+package reconstruction
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// erfcProfile returns a profile following the fall-off model of DistalEdge,
+// with Poisson fluctuations, between -100 and 100 mm.
+func erfcProfile(edge DistalEdge, rnd *rand.Rand) Profile {
+	const nbins = 100
+	p := Profile{Z: make([]float64, nbins), N: make([]float64, nbins)}
+	f := edge.Func()
+	for i := range p.Z {
+		p.Z[i] = -100 + (float64(i)+0.5)*200/nbins
+		p.N[i] = poisson(rnd, f(p.Z[i]))
+	}
+	return p
+}
+
+func TestFitDistalEdge(t *testing.T) {
+	tests := []struct {
+		name string
+		edge DistalEdge
+	}{
+		{"plus z", DistalEdge{Dir: BeamAlongPlusZ, R50: 30, Sigma: 4, Plateau: 1000, Bkg: 20}},
+		{"minus z", DistalEdge{Dir: BeamAlongMinusZ, R50: -30, Sigma: 4, Plateau: 1000, Bkg: 20}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			p := erfcProfile(test.edge, rnd)
+			edge := FitDistalEdge(p, test.edge.Dir)
+			if !edge.Converged {
+				t.Fatalf("fit did not converge")
+			}
+			if edge.Dir != test.edge.Dir {
+				t.Errorf("Dir = %v, want %v", edge.Dir, test.edge.Dir)
+			}
+			if math.Abs(edge.R50-test.edge.R50) > 3*edge.R50Err || edge.R50Err > 1 {
+				t.Errorf("R50 = %v +- %v, want %v", edge.R50, edge.R50Err, test.edge.R50)
+			}
+			if math.Abs(edge.Sigma-test.edge.Sigma) > 1 {
+				t.Errorf("Sigma = %v, want %v", edge.Sigma, test.edge.Sigma)
+			}
+
+			// The fitted function must be on the plateau on the proximal side
+			// and on the background on the distal side.
+			f := edge.Func()
+			dir := float64(test.edge.Dir)
+			if v := f(test.edge.R50 - dir*50); math.Abs(v-(test.edge.Plateau+test.edge.Bkg)) > 50 {
+				t.Errorf("proximal value = %v, want %v", v, test.edge.Plateau+test.edge.Bkg)
+			}
+			if v := f(test.edge.R50 + dir*50); math.Abs(v-test.edge.Bkg) > 10 {
+				t.Errorf("distal value = %v, want %v", v, test.edge.Bkg)
+			}
+
+			shift, _ := RangeShift(edge, test.edge, test.edge.Dir)
+			if math.Abs(shift) > 1 {
+				t.Errorf("shift with respect to the generated edge = %v, want 0", shift)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package rf
+
+import (
+	"fmt"
+	"math"
+)
+
+// Class is the category of a detected signal with respect to the RF.
+type Class byte
+
+const (
+	Unknown Class = iota // RF signal not available
+	Prompt               // in time with the beam bunches (prompt gammas)
+	BeamOn               // beam on, out of the prompt window (delayed signal, e.g. annihilation gammas)
+	BeamOff              // no beam (RF amplitude below threshold)
+)
+
+func (c Class) String() string {
+	switch c {
+	case Unknown:
+		return "unknown"
+	case Prompt:
+		return "prompt"
+	case BeamOn:
+		return "beamon"
+	case BeamOff:
+		return "beamoff"
+	default:
+		return fmt.Sprintf("Class(%d)", byte(c))
+	}
+}
+
+// Classifier classifies signals according to their time with respect to the RF.
+type Classifier struct {
+	Freq        float64 // nominal frequency of the RF signal (MHz)
+	PromptMean  float64 // mean of the prompt window, in time since the RF rising front (ns)
+	PromptWidth float64 // half-width of the prompt window (ns)
+	MinAmpl     float64 // minimal amplitude of the RF signal for the beam to be considered on (ADC counts)
+}
+
+// NewClassifier returns a classifier with default parameters.
+func NewClassifier() *Classifier {
+	return &Classifier{
+		Freq:        DefaultFreq,
+		PromptMean:  7,
+		PromptWidth: 5,
+		MinAmpl:     50,
+	}
+}
+
+// Fit fits the RF signal made of the samples (times, ampls) using the
+// nominal frequency of the classifier (see Fit).
+func (c *Classifier) Fit(times, ampls []float64) *Sine {
+	return Fit(times, ampls, c.Freq)
+}
+
+// Classify returns the class of a signal detected at time t (in ns) given
+// the RF signal s of the event.
+// The prompt window is periodic: it is applied to the time elapsed since the
+// last RF rising front and wraps around the RF period.
+func (c *Classifier) Classify(s *Sine, t float64) Class {
+	switch {
+	case s == nil:
+		return Unknown
+	case s.Ampl < c.MinAmpl:
+		return BeamOff
+	}
+	period := s.Period()
+	dt := math.Mod(s.TimeSinceRef(t)-c.PromptMean, period)
+	if dt > period/2 {
+		dt -= period
+	} else if dt < -period/2 {
+		dt += period
+	}
+	if math.Abs(dt) < c.PromptWidth {
+		return Prompt
+	}
+	return BeamOn
+}
@@ -0,0 +1,179 @@
+// Package rf implements the analysis of the accelerator radio frequency (RF) signal
+// recorded together with the detector data.
+// The RF signal is fitted with a sine so that the RF phase of any pulse can be
+// computed, and events can be classified according to their time with respect to the RF.
+package rf
+
+import (
+	"math"
+)
+
+// DefaultFreq is the default frequency (in MHz) of the RF signal (ARRONAX cyclotron).
+const DefaultFreq = 24.85
+
+// freqRange is the relative range around the nominal frequency in which the
+// frequency of the RF signal is searched for.
+const freqRange = 0.02
+
+// minNoSamples is the minimal number of samples needed to fit the RF signal.
+const minNoSamples = 10
+
+// Sine describes the RF signal of one event, modelled as
+//
+//	s(t) = Offset + Ampl * sin(2*pi*Freq*t + Phase)
+//
+// with t in ns and Freq in MHz (the factor 1e-3 is implicit).
+type Sine struct {
+	Freq   float64 // frequency (MHz)
+	Phase  float64 // phase at t = 0 (rad, in [0, 2pi[)
+	Ampl   float64 // amplitude (ADC counts)
+	Offset float64 // baseline (ADC counts)
+	RMS    float64 // RMS of the residuals of the fit (ADC counts)
+}
+
+// Fit fits the RF signal made of the samples (times, ampls) with a sine whose
+// frequency is searched for within 2% of the nominal frequency freq (in MHz).
+// For a given frequency, amplitude, phase and offset are obtained by linear least squares;
+// the frequency minimizing the residuals is then found by a scan followed
+// by a golden section search.
+// It returns nil if there are not enough samples.
+func Fit(times, ampls []float64, freq float64) *Sine {
+	if len(times) != len(ampls) {
+		panic("rf: len(times) != len(ampls)")
+	}
+	if len(times) < minNoSamples {
+		return nil
+	}
+
+	fmin := freq * (1 - freqRange)
+	fmax := freq * (1 + freqRange)
+
+	// Scan
+	const noScan = 20
+	best := fitFixedFreq(times, ampls, fmin)
+	step := (fmax - fmin) / noScan
+	for i := 1; i <= noScan; i++ {
+		s := fitFixedFreq(times, ampls, fmin+float64(i)*step)
+		if s.RMS < best.RMS {
+			best = s
+		}
+	}
+
+	// Golden section search around the best point of the scan
+	const invPhi = 0.6180339887498949
+	a := math.Max(fmin, best.Freq-step)
+	b := math.Min(fmax, best.Freq+step)
+	c := b - invPhi*(b-a)
+	d := a + invPhi*(b-a)
+	sc := fitFixedFreq(times, ampls, c)
+	sd := fitFixedFreq(times, ampls, d)
+	for iter := 0; iter < 40 && b-a > 1e-7*freq; iter++ {
+		if sc.RMS < sd.RMS {
+			b, d, sd = d, c, sc
+			c = b - invPhi*(b-a)
+			sc = fitFixedFreq(times, ampls, c)
+		} else {
+			a, c, sc = c, d, sd
+			d = a + invPhi*(b-a)
+			sd = fitFixedFreq(times, ampls, d)
+		}
+	}
+	if sc.RMS < best.RMS {
+		best = sc
+	}
+	if sd.RMS < best.RMS {
+		best = sd
+	}
+	return &best
+}
+
+// fitFixedFreq fits the samples with a sine of frequency freq (in MHz).
+// The model a*sin(wt) + b*cos(wt) + c being linear in (a, b, c), the normal
+// equations are solved directly.
+func fitFixedFreq(times, ampls []float64, freq float64) Sine {
+	w := 2 * math.Pi * freq * 1e-3
+	var m [3][3]float64
+	var v [3]float64
+	for i, t := range times {
+		sin, cos := math.Sincos(w * t)
+		x := [3]float64{sin, cos, 1}
+		for j := range x {
+			for k := range x {
+				m[j][k] += x[j] * x[k]
+			}
+			v[j] += x[j] * ampls[i]
+		}
+	}
+	p, ok := solve3(m, v)
+	if !ok {
+		return Sine{Freq: freq, RMS: math.Inf(1)}
+	}
+	s := Sine{
+		Freq:   freq,
+		Ampl:   math.Hypot(p[0], p[1]),
+		Phase:  math.Mod(math.Atan2(p[1], p[0])+2*math.Pi, 2*math.Pi),
+		Offset: p[2],
+	}
+	sum := 0.
+	for i, t := range times {
+		r := ampls[i] - s.Value(t)
+		sum += r * r
+	}
+	s.RMS = math.Sqrt(sum / float64(len(times)))
+	return s
+}
+
+// solve3 solves the 3x3 linear system m*x = v with Cramer's rule.
+func solve3(m [3][3]float64, v [3]float64) (x [3]float64, ok bool) {
+	det := func(a [3][3]float64) float64 {
+		return a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+			a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+			a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+	}
+	d := det(m)
+	if d == 0 {
+		return x, false
+	}
+	for k := range x {
+		mk := m
+		for j := range v {
+			mk[j][k] = v[j]
+		}
+		x[k] = det(mk) / d
+	}
+	return x, true
+}
+
+// Period returns the period (in ns) of the RF signal.
+func (s *Sine) Period() float64 {
+	return 1e3 / s.Freq
+}
+
+// Value returns the value of the fitted RF signal at time t (in ns).
+func (s *Sine) Value(t float64) float64 {
+	return s.Offset + s.Ampl*math.Sin(2*math.Pi*s.Freq*1e-3*t+s.Phase)
+}
+
+// PhaseAt returns the RF phase (in rad, in [0, 2pi[) at time t (in ns).
+// The phase is 0 when the RF signal crosses its offset on a rising front.
+func (s *Sine) PhaseAt(t float64) float64 {
+	phi := math.Mod(2*math.Pi*s.Freq*1e-3*t+s.Phase, 2*math.Pi)
+	if phi < 0 {
+		phi += 2 * math.Pi
+	}
+	return phi
+}
+
+// TimeSinceRef returns the time (in ns, in [0, Period()[) elapsed at time t
+// since the last rising front of the RF signal.
+func (s *Sine) TimeSinceRef(t float64) float64 {
+	return s.PhaseAt(t) / (2 * math.Pi) * s.Period()
+}
+
+// RefTime returns the time (in ns) of the last rising front of the RF signal
+// preceding time t.
+// It is the reference time with respect to which the time of flight of the
+// particles is measured.
+func (s *Sine) RefTime(t float64) float64 {
+	return t - s.TimeSinceRef(t)
+}
@@ -0,0 +1,143 @@
+package rf
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// samples returns n samples of the sine s taken every dt ns from t0, with a
+// gaussian noise of standard deviation sigma.
+func samples(s Sine, t0, dt float64, n int, sigma float64, rnd *rand.Rand) (times, ampls []float64) {
+	for i := 0; i < n; i++ {
+		t := t0 + float64(i)*dt
+		times = append(times, t)
+		ampls = append(ampls, s.Value(t)+sigma*rnd.NormFloat64())
+	}
+	return times, ampls
+}
+
+// phaseDiff returns the difference of the phases a and b, in ]-pi, pi].
+func phaseDiff(a, b float64) float64 {
+	d := math.Mod(a-b, 2*math.Pi)
+	if d > math.Pi {
+		d -= 2 * math.Pi
+	} else if d <= -math.Pi {
+		d += 2 * math.Pi
+	}
+	return d
+}
+
+func TestFit(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name  string
+		sine  Sine
+		sigma float64
+		// tolerances on the frequency (MHz), phase (rad), amplitude and offset (ADC counts)
+		tolFreq, tolPhase, tolAmpl float64
+	}{
+		{"nominal", Sine{Freq: DefaultFreq, Phase: 1, Ampl: 500, Offset: 100}, 0, 1e-6, 1e-5, 1e-3},
+		{"shifted frequency", Sine{Freq: DefaultFreq * 1.012, Phase: 5.5, Ampl: 300, Offset: -20}, 0, 1e-6, 1e-5, 1e-3},
+		{"low frequency", Sine{Freq: DefaultFreq * 0.985, Phase: 0.01, Ampl: 800, Offset: 0}, 0, 1e-6, 1e-5, 1e-3},
+		{"noise", Sine{Freq: DefaultFreq * 1.005, Phase: 3, Ampl: 400, Offset: 50}, 10, 0.02, 0.02, 3},
+	}
+	for _, test := range tests {
+		// 1024 samples every 0.2 ns, as recorded by the DRS4
+		times, ampls := samples(test.sine, 0, 0.2, 1024, test.sigma, rnd)
+		s := Fit(times, ampls, DefaultFreq)
+		if s == nil {
+			t.Fatalf("%v: no fit", test.name)
+		}
+		if math.Abs(s.Freq-test.sine.Freq) > test.tolFreq {
+			t.Errorf("%v: frequency = %v, want %v", test.name, s.Freq, test.sine.Freq)
+		}
+		if math.Abs(phaseDiff(s.Phase, test.sine.Phase)) > test.tolPhase {
+			t.Errorf("%v: phase = %v, want %v", test.name, s.Phase, test.sine.Phase)
+		}
+		if math.Abs(s.Ampl-test.sine.Ampl) > test.tolAmpl || math.Abs(s.Offset-test.sine.Offset) > test.tolAmpl {
+			t.Errorf("%v: amplitude = %v, offset = %v, want %v, %v", test.name, s.Ampl, s.Offset, test.sine.Ampl, test.sine.Offset)
+		}
+		if s.Phase < 0 || s.Phase >= 2*math.Pi {
+			t.Errorf("%v: phase %v not in [0, 2pi[", test.name, s.Phase)
+		}
+		if want := test.sigma; math.Abs(s.RMS-want) > 0.1*want+1e-3 {
+			t.Errorf("%v: RMS = %v, want %v", test.name, s.RMS, want)
+		}
+	}
+
+	if s := Fit([]float64{1, 2, 3}, []float64{1, 2, 3}, DefaultFreq); s != nil {
+		t.Errorf("fit with 3 samples = %v, want nil", s)
+	}
+}
+
+func TestSine(t *testing.T) {
+	s := Sine{Freq: 25, Phase: math.Pi / 2, Ampl: 1}
+	if p := s.Period(); p != 40 {
+		t.Errorf("period = %v, want 40", p)
+	}
+	// the rising fronts are at t = 30 + k*40 ns
+	for _, test := range []struct {
+		t, sinceRef, ref float64
+	}{
+		{30, 0, 30},
+		{40, 10, 30},
+		{69, 39, 30},
+		{75, 5, 70},
+		{-5, 5, -10},
+	} {
+		if got := s.TimeSinceRef(test.t); math.Abs(got-test.sinceRef) > 1e-9 {
+			t.Errorf("t = %v: time since ref = %v, want %v", test.t, got, test.sinceRef)
+		}
+		if got := s.RefTime(test.t); math.Abs(got-test.ref) > 1e-9 {
+			t.Errorf("t = %v: ref time = %v, want %v", test.t, got, test.ref)
+		}
+		if v := s.Value(test.ref); math.Abs(v) > 1e-9 || s.Value(test.ref+1) <= 0 {
+			t.Errorf("t = %v: ref time %v not on a rising front", test.t, test.ref)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	c := NewClassifier()
+	rnd := rand.New(rand.NewSource(2))
+	on := Sine{Freq: c.Freq, Phase: 2, Ampl: 400, Offset: 30}
+	times, ampls := samples(on, 0, 0.2, 1024, 5, rnd)
+	s := c.Fit(times, ampls)
+	if s == nil {
+		t.Fatalf("no fit")
+	}
+	off := *s
+	off.Ampl = c.MinAmpl / 2
+
+	// time of the rising front of the RF following t = 200 ns
+	ref := on.RefTime(200) + on.Period()
+	tests := []struct {
+		name string
+		s    *Sine
+		t    float64
+		want Class
+	}{
+		{"no RF", nil, ref + c.PromptMean, Unknown},
+		{"beam off", &off, ref + c.PromptMean, BeamOff},
+		{"prompt", s, ref + c.PromptMean, Prompt},
+		{"prompt window start", s, ref + c.PromptMean - c.PromptWidth + 0.5, Prompt},
+		{"prompt window end", s, ref + c.PromptMean + c.PromptWidth - 0.5, Prompt},
+		{"before the prompt window", s, ref + c.PromptMean - c.PromptWidth - 0.5, BeamOn},
+		{"after the prompt window", s, ref + c.PromptMean + c.PromptWidth + 0.5, BeamOn},
+		{"next period", s, ref + c.PromptMean + 3*on.Period(), Prompt},
+		{"before the first sample", s, ref + c.PromptMean - 10*on.Period(), Prompt},
+		{"half period later", s, ref + c.PromptMean + on.Period()/2, BeamOn},
+	}
+	for _, test := range tests {
+		if got := c.Classify(test.s, test.t); got != test.want {
+			t.Errorf("%v: class = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// the prompt window wraps around the period
+	c.PromptMean = 1
+	if got := c.Classify(s, ref-2); got != Prompt {
+		t.Errorf("prompt window wrapping around the period: class = %v, want %v", got, Prompt)
+	}
+}
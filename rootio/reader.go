@@ -0,0 +1,113 @@
+package rootio
+
+import (
+	"fmt"
+	"reflect"
+
+	"go-hep.org/x/hep/groot"
+	"go-hep.org/x/hep/groot/riofs"
+	"go-hep.org/x/hep/groot/rtree"
+)
+
+// Brancher is the interface implemented by Tree and Reader, allowing the
+// branches of a tree to be declared once for writing and reading.
+type Brancher interface {
+	Branch(name string, ptr interface{}, leaflist string) error
+}
+
+// Reader reads a ROOT tree written by Tree (or by ROOT with the same branches).
+//
+// Branches are declared as for Tree: at each entry, the value of a variable
+// size branch is copied to the first elements of the array pointed to by ptr.
+type Reader struct {
+	file  *groot.File
+	tree  rtree.Tree
+	vars  []rtree.ReadVar
+	count []countVar
+	err   error
+}
+
+// NewReader opens the file fileName and returns a reader of the tree named name in it.
+func NewReader(fileName, name string) (*Reader, error) {
+	f, err := groot.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := riofs.Dir(f).Get(name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tree, ok := obj.(rtree.Tree)
+	if !ok {
+		f.Close()
+		return nil, fmt.Errorf("rootio: %q in %v is not a tree (%T)", name, fileName, obj)
+	}
+	return &Reader{file: f, tree: tree}, nil
+}
+
+// Entries returns the number of entries of the tree.
+func (r *Reader) Entries() int64 {
+	return r.tree.Entries()
+}
+
+// Branch declares a branch to be read in the variable pointed to by ptr.
+// leaflist is the ROOT description of the leaf, as for Tree.Branch.
+func (r *Reader) Branch(name string, ptr interface{}, leaflist string) error {
+	if r.err != nil {
+		return r.err
+	}
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr {
+		r.err = fmt.Errorf("rootio: branch %q: value is not a pointer (%T)", name, ptr)
+		return r.err
+	}
+	if r.tree.Branch(name) == nil {
+		r.err = fmt.Errorf("rootio: no branch %q in tree %q", name, r.tree.Name())
+		return r.err
+	}
+	if leafCount(leaflist) == "" {
+		r.vars = append(r.vars, rtree.ReadVar{Name: name, Value: ptr})
+		return nil
+	}
+	if v.Elem().Kind() != reflect.Array {
+		r.err = fmt.Errorf("rootio: branch %q: value is not a pointer to an array (%T)", name, ptr)
+		return r.err
+	}
+	// the count leaf (the count branch, or the auxiliary count branch of
+	// arrays of arrays, see flatCount) is found and read by rtree
+	arr, size := flatten(v.Elem())
+	slice := reflect.New(reflect.SliceOf(arr.Type().Elem()))
+	r.count = append(r.count, countVar{size: size, arr: arr, slice: slice.Elem()})
+	r.vars = append(r.vars, rtree.ReadVar{Name: name, Value: slice.Interface()})
+	return nil
+}
+
+// Read reads the entries of the tree in order and calls f after each of them,
+// with the index of the entry. The values of the branches are held by the
+// variables given in the calls to Branch. Reading stops at the first error
+// returned by f.
+func (r *Reader) Read(f func(entry int64) error) error {
+	if r.err != nil {
+		return r.err
+	}
+	rr, err := rtree.NewReader(r.tree, r.vars)
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
+	return rr.Read(func(ctx rtree.RCtx) error {
+		for _, c := range r.count {
+			if n := c.slice.Len(); n > c.arr.Len() {
+				return fmt.Errorf("rootio: entry %v: size %v larger than maximal size %v", ctx.Entry, n, c.arr.Len())
+			}
+			reflect.Copy(c.arr, c.slice)
+		}
+		return f(ctx.Entry)
+	})
+}
+
+// Close closes the file of the tree.
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,198 @@
+// Package rootio writes and reads flat ROOT trees using the pure-Go ROOT I/O
+// of go-hep (groot), so that no ROOT installation nor cgo is needed.
+//
+// Branches are declared as with TTree::Branch, giving the address of the
+// variable holding the value of the branch and a ROOT leaf list such as
+// "E[NoPulses]/D". For a variable size branch, the variable is an array large
+// enough for the maximal size and only the first elements, as many as the value
+// of the count branch (which must be declared first), are written at each Fill.
+// The type and the fixed dimensions of the leaves are deduced from the type of
+// the variables: only the name of the count branch is taken from the leaf list.
+//
+// groot does not write variable size arrays of arrays, such as "Pulse[NoPulses][999]/D":
+// they are written as one dimensional arrays of all their values, whose size is
+// held by an additional count branch named "n" followed by the name of the branch
+// (e.g. "Pulse[nPulse]/D", with nPulse = 999*NoPulses). Reader reads them back
+// in their original arrays.
+package rootio
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"go-hep.org/x/hep/groot"
+	"go-hep.org/x/hep/groot/rtree"
+)
+
+// Tree is a ROOT tree written to its own file.
+type Tree struct {
+	file  *groot.File
+	name  string
+	title string
+	vars  []rtree.WriteVar
+	count []countVar
+	w     rtree.Writer
+	err   error
+}
+
+// countVar is a variable size branch.
+type countVar struct {
+	n     reflect.Value // value of the count branch
+	size  int           // number of values per unit of the count branch
+	flat  reflect.Value // value of the additional count branch, for arrays of arrays
+	arr   reflect.Value // array holding the values, seen as a one dimensional array
+	slice reflect.Value // slice of arr written to the tree
+}
+
+// flatten returns the array arr seen as a one dimensional array of all its values,
+// and the number of values per element of arr.
+func flatten(arr reflect.Value) (reflect.Value, int) {
+	elem, size := arr.Type().Elem(), 1
+	for elem.Kind() == reflect.Array {
+		size *= elem.Len()
+		elem = elem.Elem()
+	}
+	if size == 1 {
+		return arr, 1
+	}
+	typ := reflect.ArrayOf(arr.Len()*size, elem)
+	return reflect.NewAt(typ, unsafe.Pointer(arr.UnsafeAddr())).Elem(), size
+}
+
+// flatCount returns the name of the additional count branch of the variable size
+// array of arrays name.
+func flatCount(name string) string {
+	return "n" + name
+}
+
+// NewTree creates the file fileName and returns a new tree named name, written to it.
+func NewTree(fileName, name, title string) (*Tree, error) {
+	f, err := groot.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &Tree{file: f, name: name, title: title}, nil
+}
+
+// Branch declares a new branch whose value is held by the variable pointed to by ptr.
+// leaflist is the ROOT description of the leaf (e.g. "Run/i", "Pulse[NoPulses][999]/D").
+// Branches must be declared before the first call to Fill.
+func (t *Tree) Branch(name string, ptr interface{}, leaflist string) error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.w != nil {
+		t.err = fmt.Errorf("rootio: branch %q declared after the first Fill", name)
+		return t.err
+	}
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr {
+		t.err = fmt.Errorf("rootio: branch %q: value is not a pointer (%T)", name, ptr)
+		return t.err
+	}
+	for _, wvar := range t.vars {
+		if wvar.Name == name {
+			t.err = fmt.Errorf("rootio: branch %q declared twice", name)
+			return t.err
+		}
+	}
+	count := leafCount(leaflist)
+	if count == "" {
+		t.vars = append(t.vars, rtree.WriteVar{Name: name, Value: ptr})
+		return nil
+	}
+
+	var n reflect.Value
+	for _, wvar := range t.vars {
+		if wvar.Name == count {
+			n = reflect.ValueOf(wvar.Value).Elem()
+		}
+	}
+	switch {
+	case !n.IsValid():
+		t.err = fmt.Errorf("rootio: branch %q: unknown count branch %q", name, count)
+	case n.Kind() != reflect.Int32:
+		t.err = fmt.Errorf("rootio: branch %q: count branch %q is not an int32", name, count)
+	case v.Elem().Kind() != reflect.Array:
+		t.err = fmt.Errorf("rootio: branch %q: value is not a pointer to an array (%T)", name, ptr)
+	}
+	if t.err != nil {
+		return t.err
+	}
+	arr, size := flatten(v.Elem())
+	slice := reflect.New(reflect.SliceOf(arr.Type().Elem()))
+	c := countVar{n: n, size: size, arr: arr, slice: slice.Elem()}
+	if size > 1 {
+		flat := reflect.New(n.Type())
+		c.flat = flat.Elem()
+		count = flatCount(name)
+		t.vars = append(t.vars, rtree.WriteVar{Name: count, Value: flat.Interface()})
+	}
+	t.count = append(t.count, c)
+	t.vars = append(t.vars, rtree.WriteVar{Name: name, Value: slice.Interface(), Count: count})
+	return nil
+}
+
+// leafCount returns the name of the count branch of a leaf list, or an empty
+// string if the leaf has a fixed size.
+func leafCount(leaflist string) string {
+	i := strings.Index(leaflist, "[")
+	if i < 0 {
+		return ""
+	}
+	j := strings.Index(leaflist[i:], "]")
+	if j < 0 {
+		return ""
+	}
+	dim := leaflist[i+1 : i+j]
+	if _, err := strconv.Atoi(dim); err == nil {
+		return ""
+	}
+	return dim
+}
+
+// Fill writes the current values of the branches as a new entry of the tree.
+func (t *Tree) Fill() error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.w == nil {
+		t.w, t.err = rtree.NewWriter(t.file, t.name, t.vars, rtree.WithTitle(t.title))
+		if t.err != nil {
+			return t.err
+		}
+	}
+	for _, c := range t.count {
+		n := int(c.n.Int())
+		if n < 0 || n*c.size > c.arr.Len() {
+			return fmt.Errorf("rootio: invalid size %v (maximal size: %v)", n, c.arr.Len()/c.size)
+		}
+		c.slice.Set(c.arr.Slice(0, n*c.size))
+		if c.flat.IsValid() {
+			c.flat.SetInt(int64(n * c.size))
+		}
+	}
+	_, err := t.w.Write()
+	return err
+}
+
+// Close writes the tree and closes its file.
+func (t *Tree) Close() error {
+	err := t.err
+	if t.w == nil && err == nil {
+		// no entry: the tree is written nevertheless
+		t.w, err = rtree.NewWriter(t.file, t.name, t.vars, rtree.WithTitle(t.title))
+	}
+	if t.w != nil {
+		if errw := t.w.Close(); err == nil {
+			err = errw
+		}
+	}
+	if errf := t.file.Close(); err == nil {
+		err = errf
+	}
+	return err
+}
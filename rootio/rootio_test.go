@@ -0,0 +1,113 @@
+package rootio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testData struct {
+	Run      uint32
+	NoPulses int32
+	E        [10]float64    `rootio:"count=NoPulses"`
+	Pulse    [10][5]float64 `rootio:"count=NoPulses"`
+	Capa     [10][5]uint16  `rootio:"count=NoPulses"`
+	Times    [5]float64
+}
+
+// testEntry returns the data of the entry i, with i%4 pulses.
+func testEntry(i int) testData {
+	d := testData{Run: uint32(100 + i), NoPulses: int32(i % 4)}
+	for k := 0; k < int(d.NoPulses); k++ {
+		d.E[k] = float64(10*i + k)
+		for j := range d.Pulse[k] {
+			d.Pulse[k][j] = float64(100*i + 10*k + j)
+			d.Capa[k][j] = uint16(1000*i + 10*k + j)
+		}
+	}
+	for j := range d.Times {
+		d.Times[j] = float64(j) + 0.5
+	}
+	return d
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rootio-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "tree.root")
+
+	const noEntries = 9
+	tree, err := NewTree(fileName, "tree", "test tree")
+	if err != nil {
+		t.Fatalf("could not create tree: %v\n", err)
+	}
+	var wdata testData
+	if err := Branches(tree, &wdata); err != nil {
+		t.Fatalf("could not declare branches: %v\n", err)
+	}
+	for i := 0; i < noEntries; i++ {
+		wdata = testEntry(i)
+		if err := tree.Fill(); err != nil {
+			t.Fatalf("could not fill entry %v: %v\n", i, err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("could not close tree: %v\n", err)
+	}
+
+	r, err := NewReader(fileName, "tree")
+	if err != nil {
+		t.Fatalf("could not open tree: %v\n", err)
+	}
+	defer r.Close()
+	if n := r.Entries(); n != noEntries {
+		t.Fatalf("entries = %v, want %v", n, noEntries)
+	}
+	var rdata testData
+	if err := Branches(r, &rdata); err != nil {
+		t.Fatalf("could not declare branches: %v\n", err)
+	}
+	err = r.Read(func(entry int64) error {
+		want := testEntry(int(entry))
+		// values beyond the number of pulses are not written
+		got := rdata
+		for k := int(got.NoPulses); k < len(got.E); k++ {
+			got.E[k] = 0
+			got.Pulse[k] = [5]float64{}
+			got.Capa[k] = [5]uint16{}
+		}
+		if got != want {
+			t.Errorf("entry %v:\ngot = %+v\nwant= %+v", entry, got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error reading tree: %v\n", err)
+	}
+}
+
+func TestInvalidSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rootio-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree, err := NewTree(filepath.Join(dir, "tree.root"), "tree", "test tree")
+	if err != nil {
+		t.Fatalf("could not create tree: %v\n", err)
+	}
+	defer tree.Close()
+	var data testData
+	if err := Branches(tree, &data); err != nil {
+		t.Fatalf("could not declare branches: %v\n", err)
+	}
+	data.NoPulses = 11
+	if err := tree.Fill(); err == nil {
+		t.Fatalf("size larger than the maximal size: no error")
+	}
+}
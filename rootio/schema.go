@@ -0,0 +1,57 @@
+package rootio
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Branches declares a branch for each exported field of the struct pointed to
+// by ptr, in the order of the fields, so that the variables of a tree are
+// defined once by a struct type and used for writing and reading.
+//
+// The name of the branch is the name of the field. The rootio struct tag
+// modifies the branch declared for a field:
+//
+//	E   [NoPulsesMax]float64 `rootio:"count=NoPulses"` // variable size branch E[NoPulses]
+//	Tf  float64              `rootio:"name=TFall"`     // branch named TFall
+//	Tmp float64              `rootio:"-"`              // no branch
+//
+// Options are separated by commas.
+func Branches(b Brancher, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("rootio: value is not a pointer to a struct (%T)", ptr)
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		tag := field.Tag.Get("rootio")
+		if tag == "-" {
+			continue
+		}
+		name, count := field.Name, ""
+		for _, opt := range strings.Split(tag, ",") {
+			switch {
+			case opt == "":
+			case strings.HasPrefix(opt, "name="):
+				name = strings.TrimPrefix(opt, "name=")
+			case strings.HasPrefix(opt, "count="):
+				count = strings.TrimPrefix(opt, "count=")
+			default:
+				return fmt.Errorf("rootio: field %v: invalid tag option %q", field.Name, opt)
+			}
+		}
+		leaflist := name
+		if count != "" {
+			leaflist += "[" + count + "]"
+		}
+		if err := b.Branch(name, v.Field(i).Addr().Interface(), leaflist); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package rwi
+
+import (
+	dpgarw "gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+)
+
+// Builder splits the reading of events into two steps, so that events can be
+// built concurrently (see package pipeline):
+//   - ReadRaw reads the raw data of the next event. It must be called sequentially.
+//     At the end of the stream, it returns nil and io.EOF.
+//   - Build makes the event from raw data returned by ReadRaw. It can be called concurrently.
+type Builder interface {
+	ReadRaw() (interface{}, error)
+	Build(raw interface{}) (*event.Event, error)
+}
+
+// NewBuilder returns a Builder reading events from r.
+// For the formats whose readers do not separate frame reading from event building,
+// ReadRaw reads the whole event with r.ReadNextEvent and Build returns it.
+func NewBuilder(r Reader) Builder {
+	switch r := r.(type) {
+	case *dpgarw.Reader:
+		return dpgaBuilder{r}
+	default:
+		return seqBuilder{r}
+	}
+}
+
+type dpgaBuilder struct {
+	r *dpgarw.Reader
+}
+
+func (b dpgaBuilder) ReadRaw() (interface{}, error) {
+	raw, err := b.r.ReadNextRawEvent()
+	if raw == nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func (b dpgaBuilder) Build(raw interface{}) (*event.Event, error) {
+	return b.r.BuildEvent(raw.(*dpgarw.RawEvent)), nil
+}
+
+// seqRaw is the raw data of seqBuilder: the event and error returned by ReadNextEvent.
+type seqRaw struct {
+	e   *event.Event
+	err error
+}
+
+type seqBuilder struct {
+	r Reader
+}
+
+func (b seqBuilder) ReadRaw() (interface{}, error) {
+	e, err := b.r.ReadNextEvent()
+	if e == nil {
+		return nil, err
+	}
+	return seqRaw{e, err}, nil
+}
+
+func (b seqBuilder) Build(raw interface{}) (*event.Event, error) {
+	r := raw.(seqRaw)
+	return r.e, r.err
+}
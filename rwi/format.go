@@ -0,0 +1,140 @@
+package rwi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Format describes the data format of a stream.
+type Format byte
+
+const (
+	FormatAuto     Format = iota // format detected from the beginning of the stream
+	FormatDPGA                   // DPGA binary files with HeaderCAL header (dpga/rw)
+	FormatDPGAOld                // DPGA binary files with HeaderOld header (dpga/rw)
+	FormatTCA                    // microTCA binary files (dpgatca/rw)
+	FormatVME                    // DPGA binary files read with the microTCA VME reader (dpgatca/rwvme)
+	FormatRCT                    // RCT binary files (rct/rw)
+	FormatTB                     // test bench binary files with HeaderGANIL header (testbench/rw)
+	FormatTBOld                  // test bench binary files with HeaderOld header (testbench/rw)
+	FormatASCII                  // test bench ASCII files, hexadecimal words (testbench/reader)
+	FormatASCIIDec               // test bench ASCII files, decimal words (testbench/reader)
+)
+
+var formatNames = [...]string{
+	FormatAuto:     "auto",
+	FormatDPGA:     "dpga",
+	FormatDPGAOld:  "dpgaold",
+	FormatTCA:      "tca",
+	FormatVME:      "vme",
+	FormatRCT:      "rct",
+	FormatTB:       "tb",
+	FormatTBOld:    "tbold",
+	FormatASCII:    "ascii",
+	FormatASCIIDec: "asciidec",
+}
+
+func (f *Format) String() string {
+	if int(*f) < len(formatNames) {
+		return formatNames[*f]
+	}
+	return fmt.Sprintf("Format(%d)", byte(*f))
+}
+
+// Set is the method to set the flag value.
+func (f *Format) Set(value string) error {
+	for i, name := range formatNames {
+		if name == value {
+			*f = Format(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("rwi: invalid format value %q", value)
+}
+
+// ErrUnknownFormat is returned by Detect when the format of the stream is not recognized.
+var ErrUnknownFormat = errors.New("rwi: could not detect data format")
+
+const (
+	noPeekBytes    = 512        // number of bytes looked at by Detect
+	ctrlFirstEvent = 0xbabababa // first word of the first frame of an event in DPGA files
+	ctrlCafeDeca   = 0xcafedeca // block header in DPGA and test bench files
+)
+
+// Detect returns the format of the stream read by r, looking at its first bytes.
+// The bytes are not consumed.
+//
+// Binary formats are recognized from the position of the first frame magics, given
+// the size of the file header of each format:
+//   - microTCA and RCT: 24 bytes file header, then 0x1230 start of frame followed by 0xCAFE 0xDECA.
+//     RCT files are distinguished by a front-end id outside the microTCA range (0x10 -> 0x1b).
+//   - DPGA: 64 bytes (HeaderCAL) or 8 bytes (HeaderOld) file header, then 0xbabababa, the
+//     counters and the first block with its 0xCAFEDECA magic.
+//   - test bench: 80 bytes (HeaderGANIL) or 8 bytes (HeaderOld) file header, then the first block.
+//
+// Otherwise, the stream is considered to be an ASCII test bench file if it only contains
+// hexadecimal or decimal words.
+// FormatVME is never returned, since these files have the same structure as FormatDPGA ones.
+func Detect(r *bufio.Reader) (Format, error) {
+	buf, err := r.Peek(noPeekBytes)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return FormatAuto, err
+	}
+	be32 := func(offset int) uint32 {
+		if offset+4 > len(buf) {
+			return 0
+		}
+		return binary.BigEndian.Uint32(buf[offset : offset+4])
+	}
+	const (
+		noCountersBytes = 37 * 4 // counters following the 0xbabababa word in DPGA files
+		blockHdrOffset  = 12     // offset of the 0xCAFEDECA magic from the beginning of a frame
+	)
+	switch {
+	case len(buf) >= 48 &&
+		binary.BigEndian.Uint16(buf[24:26]) == 0x1230 &&
+		be32(44) == ctrlCafeDeca:
+		feid := binary.LittleEndian.Uint16(buf[30:32]) & 0x7f
+		if feid >= 0x10 && feid <= 0x1b {
+			return FormatTCA, nil
+		}
+		return FormatRCT, nil
+	case be32(64) == ctrlFirstEvent && be32(64+4+noCountersBytes+blockHdrOffset) == ctrlCafeDeca:
+		return FormatDPGA, nil
+	case be32(8) == ctrlFirstEvent && be32(8+4+noCountersBytes+blockHdrOffset) == ctrlCafeDeca:
+		return FormatDPGAOld, nil
+	case be32(80+blockHdrOffset) == ctrlCafeDeca:
+		return FormatTB, nil
+	case be32(8+blockHdrOffset) == ctrlCafeDeca:
+		return FormatTBOld, nil
+	}
+	return detectASCII(buf)
+}
+
+// detectASCII returns FormatASCII or FormatASCIIDec if buf only contains
+// hexadecimal or decimal words separated by new lines.
+func detectASCII(buf []byte) (Format, error) {
+	if len(buf) == 0 {
+		return FormatAuto, ErrUnknownFormat
+	}
+	hex := false
+	for _, c := range buf {
+		switch {
+		case c >= '0' && c <= '9', c == '\n', c == '\r':
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			hex = true
+		default:
+			return FormatAuto, ErrUnknownFormat
+		}
+	}
+	if hex {
+		return FormatASCII, nil
+	}
+	return FormatASCIIDec, nil
+}
+
+// FormatUsage is the usage string of the flags setting a Format.
+const FormatUsage = "Data format: auto (detected from the beginning of the file), dpga, dpgaold, tca, vme, rct, tb, tbold, ascii or asciidec"
@@ -0,0 +1,76 @@
+package rwi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testStream returns a stream of n zero bytes with the big endian words
+// words[offset] written at the given offsets.
+func testStream(n int, words map[int]uint32) []byte {
+	buf := make([]byte, n)
+	for offset, w := range words {
+		binary.BigEndian.PutUint32(buf[offset:], w)
+	}
+	return buf
+}
+
+// testFrameTCA returns the beginning of a microTCA or RCT file: the file header
+// and the first frame, with the front-end id feid.
+func testFrameTCA(feid uint16) []byte {
+	buf := testStream(noPeekBytes, map[int]uint32{44: ctrlCafeDeca})
+	binary.BigEndian.PutUint16(buf[24:], 0x1230)
+	binary.LittleEndian.PutUint16(buf[30:], feid)
+	return buf
+}
+
+func TestDetect(t *testing.T) {
+	const (
+		noCountersBytes = 37 * 4
+		blockHdrOffset  = 12
+	)
+	tests := []struct {
+		name string
+		data []byte
+		want Format
+		err  error
+	}{
+		{"tca", testFrameTCA(0x10), FormatTCA, nil},
+		{"tca last front-end", testFrameTCA(0x1b | 0x80), FormatTCA, nil},
+		{"rct", testFrameTCA(0x01), FormatRCT, nil},
+		{"dpga", testStream(noPeekBytes, map[int]uint32{
+			64: ctrlFirstEvent,
+			64 + 4 + noCountersBytes + blockHdrOffset: ctrlCafeDeca,
+		}), FormatDPGA, nil},
+		{"dpga old header", testStream(noPeekBytes, map[int]uint32{
+			8:                                        ctrlFirstEvent,
+			8 + 4 + noCountersBytes + blockHdrOffset: ctrlCafeDeca,
+		}), FormatDPGAOld, nil},
+		{"dpga without first event word", testStream(noPeekBytes, map[int]uint32{
+			64 + 4 + noCountersBytes + blockHdrOffset: ctrlCafeDeca,
+		}), FormatAuto, ErrUnknownFormat},
+		{"tb", testStream(noPeekBytes, map[int]uint32{80 + blockHdrOffset: ctrlCafeDeca}), FormatTB, nil},
+		{"tb old header", testStream(noPeekBytes, map[int]uint32{8 + blockHdrOffset: ctrlCafeDeca}), FormatTBOld, nil},
+		{"short stream", testStream(24, map[int]uint32{8 + blockHdrOffset: ctrlCafeDeca}), FormatTBOld, nil},
+		{"ascii", []byte("cafedeca\n00000001\nFFFF\n"), FormatASCII, nil},
+		{"ascii decimal", []byte("12\r\n345\r\n"), FormatASCIIDec, nil},
+		{"text", []byte("not a data file\n"), FormatAuto, ErrUnknownFormat},
+		{"empty", nil, FormatAuto, ErrUnknownFormat},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(bytes.NewReader(test.data))
+		got, err := Detect(r)
+		if err != test.err {
+			t.Errorf("%v: error = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%v: format = %v, want %v", test.name, got.String(), test.want.String())
+		}
+		if r.Buffered() != len(test.data) {
+			t.Errorf("%v: %v bytes left to read, want %v", test.name, r.Buffered(), len(test.data))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package rwi
+
+import "gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+
+// Seeker is the interface implemented by the readers supporting random access
+// to the events of a file through its index (see package evtindex).
+// Seek and SeekTime position the reader so that the next call to ReadNextEvent
+// returns the first event with an ID, respectively a timestamp, larger or equal
+// to the requested one.
+type Seeker interface {
+	SetIndex(idx *evtindex.Index)
+	Seek(eventID uint64) error
+	SeekTime(t uint64) error
+}
+
+// Indexer is the interface implemented by the readers able to build the index
+// of their stream, from the current position to the end of the stream.
+type Indexer interface {
+	BuildIndex() (*evtindex.Index, error)
+}
+
+// setIndexFor sets on r the index of the data file dataFileName (see evtindex.FileName).
+func setIndexFor(r Reader, dataFileName string) (Seeker, error) {
+	s, ok := r.(Seeker)
+	if !ok {
+		return nil, evtindex.ErrNotSeekable
+	}
+	idx, err := evtindex.ReadFor(dataFileName)
+	if err != nil {
+		return nil, err
+	}
+	s.SetIndex(idx)
+	return s, nil
+}
+
+// SeekFile positions r, reading the data file dataFileName, on the event eventID
+// using the index file written next to the data file.
+// An error is returned if r does not implement Seeker or if the index file
+// cannot be read, in which case the caller has to skip events sequentially.
+func SeekFile(r Reader, dataFileName string, eventID uint64) error {
+	s, err := setIndexFor(r, dataFileName)
+	if err != nil {
+		return err
+	}
+	return s.Seek(eventID)
+}
+
+// SeekFileTime is the same as SeekFile, positioning r on the first event
+// with a timestamp larger or equal to t.
+func SeekFileTime(r Reader, dataFileName string, t uint64) error {
+	s, err := setIndexFor(r, dataFileName)
+	if err != nil {
+		return err
+	}
+	return s.SeekTime(t)
+}
@@ -0,0 +1,56 @@
+// Command mkindex builds the index of an existing raw binary data file
+// (see package evtindex), allowing readers to seek to a given event or time.
+// The index is written next to the data file, in the file returned by evtindex.FileName.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/evtindex"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
+)
+
+var (
+	inFile  = flag.String("i", "", "Name of input file")
+	outFile = flag.String("o", "", "Name of the output index file (default: name of input file + "+evtindex.Ext+")")
+	format  rwi.Format
+)
+
+func main() {
+	log.SetFlags(log.Llongfile | log.LstdFlags)
+
+	flag.Var(&format, "format", rwi.FormatUsage)
+	flag.Parse()
+
+	f, err := os.Open(*inFile)
+	if err != nil {
+		log.Fatalf("could not open data file: %v\n", err)
+	}
+	defer f.Close()
+
+	r, err := rwi.NewReader(f, format)
+	if err != nil {
+		log.Fatalf("could not open stream: %v\n", err)
+	}
+	ri, ok := r.(rwi.Indexer)
+	if !ok {
+		log.Fatalf("indexing not supported by %T\n", r)
+	}
+	idx, err := ri.BuildIndex()
+	if err != nil {
+		log.Fatalf("error building index: %v\n", err)
+	}
+	idx.DataFileName = *inFile
+
+	if *outFile == "" {
+		*outFile = evtindex.FileName(*inFile)
+	}
+	err = idx.Write(*outFile)
+	if err != nil {
+		log.Fatalf("error writing index: %v\n", err)
+	}
+	fmt.Printf("%v events indexed in %v\n", idx.Len(), *outFile)
+}
@@ -0,0 +1,83 @@
+package rwi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	dpgarw "gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	tcarw "gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rwvme"
+	"gitlab.in2p3.fr/avirm/analysis-go/rawz"
+	rctrw "gitlab.in2p3.fr/avirm/analysis-go/rct/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/testbench/reader"
+	tbrw "gitlab.in2p3.fr/avirm/analysis-go/testbench/rw"
+)
+
+// NewReader returns a reader of the given format reading from r.
+// If format is FormatAuto, the format is detected from the beginning of the stream (see Detect).
+// The concrete type of the returned reader (e.g. *rw.Reader of package dpgatca/rw)
+// can be obtained with a type assertion to access format specific settings.
+//
+// If r implements io.Seeker (e.g. *os.File), the readers of the DPGA, microTCA,
+// VME and RCT formats are given r itself, so that they implement Seeker.
+//
+// Compressed streams (see package rawz) are decompressed transparently.
+func NewReader(r io.Reader, format Format) (Reader, error) {
+	r, err := rawz.Open(r)
+	if err != nil {
+		return nil, err
+	}
+	var rs io.ReadSeeker
+	var pos int64
+	if s, ok := r.(io.ReadSeeker); ok {
+		pos, err = s.Seek(0, io.SeekCurrent)
+		if err == nil {
+			rs = s
+		}
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	if format == FormatAuto {
+		format, err = Detect(br)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// seekable is the stream given to the readers supporting Seek
+	var seekable io.Reader = br
+	if rs != nil {
+		// Rewind what was buffered during detection
+		if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		br.Reset(rs)
+		seekable = rs
+	}
+	switch format {
+	case FormatDPGA:
+		return dpgarw.NewReader(seekable, dpgarw.HeaderCAL)
+	case FormatDPGAOld:
+		return dpgarw.NewReader(seekable, dpgarw.HeaderOld)
+	case FormatTCA:
+		return tcarw.NewReader(seekable)
+	case FormatVME:
+		return rwvme.NewReader(seekable, rwvme.HeaderCAL)
+	case FormatRCT:
+		return rctrw.NewReader(seekable)
+	case FormatTB:
+		return tbrw.NewReader(br, tbrw.HeaderGANIL)
+	case FormatTBOld:
+		return tbrw.NewReader(br, tbrw.HeaderOld)
+	case FormatASCII, FormatASCIIDec:
+		s := reader.NewScanner(bufio.NewScanner(br))
+		if format == FormatASCIIDec {
+			s.SetInputType(reader.DecInput)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("rwi: invalid format %v", format.String())
+	}
+}
@@ -0,0 +1,116 @@
+// Command rwconvert converts raw binary data files between the formats
+// supported by package rwi: events are read with the reader of the input
+// format and written with the writer of the output format (see rwi.NewWriter),
+// keeping their pulses, timestamps and counters.
+//
+// Supported output formats are dpga, vme, tca and rct.
+// When the input and output formats do not have the same kind of run header,
+// the output header is made from the first event. Counters which do not exist
+// in the input format are made from the event timestamp and ID.
+//
+// Note that the RCT format only holds the first ASM board (quartets 0 to 5):
+// pulses of the other boards are dropped. In the tca and rct formats, events are
+// written as one frame per quartet having samples.
+//
+// Examples:
+//
+//	rwconvert -i run100.bin -oformat tca -o run100tca.bin
+//	rwconvert -i run100tca.bin -format tca -oformat dpga -full -o run100.bin
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
+)
+
+var (
+	inFile   = flag.String("i", "", "Name of input file")
+	outFile  = flag.String("o", "", "Name of output file")
+	full     = flag.Bool("full", false, "If set, DPGA events are written with all their frames (dpga and vme outputs only)")
+	nEvents  = flag.Uint("n", 0, "Maximal number of events to convert (0: all events)")
+	freq     = flag.Uint("freq", 1000, "Event number printing frequency")
+	sigthres = flag.Uint("sigthres", 800, "Value above which a pulse is considered to have signal")
+	format   rwi.Format
+	oformat  = rwi.FormatDPGA
+)
+
+func main() {
+	log.SetFlags(log.Llongfile | log.LstdFlags)
+
+	flag.Var(&format, "format", rwi.FormatUsage)
+	flag.Var(&oformat, "oformat", "Output data format (dpga, vme, tca or rct)")
+	flag.Parse()
+
+	if *inFile == "" || *outFile == "" {
+		fmt.Println("usage: rwconvert -i input.bin -o output.bin [options]")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	f, err := os.Open(*inFile)
+	if err != nil {
+		log.Fatalf("could not open data file: %v\n", err)
+	}
+	defer f.Close()
+
+	r, err := rwi.NewReader(f, format)
+	if err != nil {
+		log.Fatalf("could not open stream: %v\n", err)
+	}
+	r.SetSigThreshold(*sigthres)
+
+	fo, err := os.Create(*outFile)
+	if err != nil {
+		log.Fatalf("could not create data file: %v\n", err)
+	}
+	bufiow := bufio.NewWriter(fo)
+	w, err := rwi.NewWriter(bufiow, oformat, r, *full)
+	if err != nil {
+		log.Fatalf("could not open output stream: %v\n", err)
+	}
+
+	var noEvents uint32
+	for *nEvents == 0 || uint(noEvents) < *nEvents {
+		event, err := r.ReadNextEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if event == nil {
+				log.Fatalf("error reading event: %v\n", err)
+			}
+			log.Printf("event %v: %v\n", event.ID, err)
+		}
+		if uint(noEvents)%*freq == 0 {
+			fmt.Printf("converting event %v\n", noEvents)
+		}
+		if err := w.Event(event); err != nil {
+			log.Fatalf("error writing event: %v\n", err)
+		}
+		noEvents++
+	}
+	if err := w.Close(); err != nil {
+		log.Fatalf("error closing output stream: %v\n", err)
+	}
+	if err := bufiow.Flush(); err != nil {
+		log.Fatalf("error writing output file: %v\n", err)
+	}
+	if offset, ok := rwi.NoEventsOffset(w); ok {
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], noEvents)
+		if _, err := fo.WriteAt(buf[:], offset); err != nil {
+			log.Fatalf("could not update header: %v\n", err)
+		}
+	}
+	if err := fo.Close(); err != nil {
+		log.Fatalf("could not close %v: %v\n", *outFile, err)
+	}
+	fmt.Printf("%v events converted from %v to %v (%v)\n", noEvents, *inFile, *outFile, oformat.String())
+}
@@ -0,0 +1,22 @@
+// Package rwi defines a common interface to the readers of the various data
+// formats (DPGA, microTCA, RCT and test bench), so that analysis commands,
+// calibration tools and godaq can consume any of them through one code path.
+//
+// NewReader returns the reader corresponding to a given format, detecting it
+// from the beginning of the stream if needed (see Detect).
+package rwi
+
+import "gitlab.in2p3.fr/avirm/analysis-go/event"
+
+// Reader is the interface implemented by all data readers.
+//
+// ReadNextEvent returns the next event of the stream.
+// At the end of the stream, it returns a nil event and io.EOF.
+// A non nil event can be returned together with a non nil error when the
+// event has been read but is not fully consistent (e.g. SRout mismatch).
+type Reader interface {
+	ReadNextEvent() (*event.Event, error)
+	SetSigThreshold(val uint)
+	NoSamples() uint16
+	SetDebug()
+}
@@ -0,0 +1,333 @@
+package rwi
+
+import (
+	"fmt"
+	"io"
+
+	dpgarw "gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	tcarw "gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rwvme"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	rctrw "gitlab.in2p3.fr/avirm/analysis-go/rct/rw"
+)
+
+// Writer is the interface implemented by the writers returned by NewWriter.
+//
+// Event writes the event e, the header of the stream being written together
+// with the first event. Close writes the end of the stream and flushes pending data.
+type Writer interface {
+	Event(e *event.Event) error
+	Close() error
+}
+
+// NewWriter returns a writer of events to w in the given format: FormatDPGA,
+// FormatVME, FormatTCA or FormatRCT.
+//
+// src is the reader of the events, if any. Its run header is copied when it
+// reads a format having the same kind of header, otherwise the header is made
+// from the first event. If full is true, DPGA and VME events are written with all
+// their frames (see Writer.EventFull of packages dpga/rw and dpgatca/rwvme), which
+// requires all clusters to have samples.
+//
+// Counters missing in the events read from another format are made up: the
+// DPGA counters from the timestamp of the event, the microTCA and RCT cluster
+// counters from the timestamp and ID of the event.
+func NewWriter(w io.Writer, format Format, src Reader, full bool) (Writer, error) {
+	switch format {
+	case FormatDPGA:
+		return &dpgaWriter{w: dpgarw.NewWriter(w), src: src, full: full}, nil
+	case FormatVME:
+		return &vmeWriter{w: rwvme.NewWriter(w), src: src, full: full}, nil
+	case FormatTCA:
+		return &tcaWriter{w: tcarw.NewWriter(w), src: src, noFrames: make(map[uint32]uint64)}, nil
+	case FormatRCT:
+		return &rctWriter{w: rctrw.NewWriter(w), src: src, noFrames: make(map[uint32]uint64)}, nil
+	default:
+		return nil, fmt.Errorf("rwi: no writer for format %v", format.String())
+	}
+}
+
+type dpgaWriter struct {
+	w    *dpgarw.Writer
+	src  Reader
+	full bool
+	hdr  *dpgarw.Header
+}
+
+func (w *dpgaWriter) Event(e *event.Event) error {
+	if w.hdr == nil {
+		w.hdr = dpgaHeader(w.src, e)
+		if err := w.w.Header(w.hdr, false); err != nil {
+			return err
+		}
+	}
+	e.Counters = dpgaCounters(e)
+	if w.full {
+		return w.w.EventFull(e)
+	}
+	return w.w.Event(e)
+}
+
+func (w *dpgaWriter) Close() error {
+	return w.w.Close()
+}
+
+type vmeWriter struct {
+	w    *rwvme.Writer
+	src  Reader
+	full bool
+	hdr  *rwvme.Header
+}
+
+func (w *vmeWriter) Event(e *event.Event) error {
+	if w.hdr == nil {
+		w.hdr = vmeHeader(w.src, e)
+		if err := w.w.Header(w.hdr, false); err != nil {
+			return err
+		}
+	}
+	e.Counters = dpgaCounters(e)
+	if w.full {
+		return w.w.EventFull(e)
+	}
+	return w.w.Event(e)
+}
+
+func (w *vmeWriter) Close() error {
+	return w.w.Close()
+}
+
+type tcaWriter struct {
+	w        *tcarw.Writer
+	src      Reader
+	hdr      bool
+	noFrames map[uint32]uint64 // number of frames written per ASM board (see setClusterCounters)
+}
+
+func (w *tcaWriter) Event(e *event.Event) error {
+	if !w.hdr {
+		w.hdr = true
+		f := tcarw.FileHeader{FEId: 0x10, NoSamples: noSamples(e)}
+		if r, ok := w.src.(*tcarw.Reader); ok {
+			f = r.FileHeader
+		}
+		if err := w.w.FileHeader(&f); err != nil {
+			return err
+		}
+	}
+	setClusterCounters(e, w.noFrames)
+	return w.w.Event(e)
+}
+
+func (w *tcaWriter) Close() error {
+	return w.w.Close()
+}
+
+type rctWriter struct {
+	w        *rctrw.Writer
+	src      Reader
+	hdr      bool
+	noFrames map[uint32]uint64 // number of frames written per ASM board (see setClusterCounters)
+}
+
+func (w *rctWriter) Event(e *event.Event) error {
+	if !w.hdr {
+		w.hdr = true
+		f := rctrw.FileHeader{FEId: 0x10, NoSamples: noSamples(e)}
+		if r, ok := w.src.(*rctrw.Reader); ok {
+			f = r.FileHeader
+		}
+		if err := w.w.FileHeader(&f); err != nil {
+			return err
+		}
+	}
+	setClusterCounters(e, w.noFrames)
+	return w.w.Event(e)
+}
+
+func (w *rctWriter) Close() error {
+	return w.w.Close()
+}
+
+// dpgaHeader returns the header of a DPGA stream whose first event is e.
+func dpgaHeader(src Reader, e *event.Event) *dpgarw.Header {
+	switch r := src.(type) {
+	case *dpgarw.Reader:
+		hdr := *r.Header()
+		return &hdr
+	case *rwvme.Reader:
+		h := r.Header()
+		hdr := &dpgarw.Header{
+			HdrType:                 dpgarw.HeaderCAL,
+			History:                 h.History | dpgarw.HistoryConverted,
+			RunNumber:               h.RunNumber,
+			FreeField:               h.FreeField,
+			TimeStart:               h.TimeStart,
+			TimeStop:                h.TimeStop,
+			NoEvents:                h.NoEvents,
+			NoASMCards:              h.NoASMCards,
+			NoSamples:               h.NoSamples,
+			DataToRead:              h.DataToRead,
+			TriggerEq:               h.TriggerEq,
+			TriggerDelay:            h.TriggerDelay,
+			ChanUsedForTrig:         h.ChanUsedForTrig,
+			Threshold:               h.Threshold,
+			LowHighThres:            h.LowHighThres,
+			TrigSigShapingHighThres: h.TrigSigShapingHighThres,
+			TrigSigShapingLowThres:  h.TrigSigShapingLowThres,
+			Size:                    h.Size,
+			NumFrame:                h.NumFrame,
+		}
+		if h.HdrType == rwvme.HeaderOld {
+			hdr.HdrType = dpgarw.HeaderOld
+		}
+		return hdr
+	}
+	// NoSamples is one more than the number of samples (see the readers of dpga/rw and dpgatca/rwvme)
+	return &dpgarw.Header{
+		HdrType:    dpgarw.HeaderCAL,
+		History:    dpgarw.HistoryConverted,
+		NoASMCards: noASMCards(e),
+		NoSamples:  uint32(noSamples(e)) + 1,
+	}
+}
+
+// vmeHeader returns the header of a VME stream whose first event is e.
+func vmeHeader(src Reader, e *event.Event) *rwvme.Header {
+	if r, ok := src.(*rwvme.Reader); ok {
+		hdr := *r.Header()
+		return &hdr
+	}
+	h := dpgaHeader(src, e)
+	hdr := &rwvme.Header{
+		HdrType:                 rwvme.HeaderCAL,
+		History:                 h.History | dpgarw.HistoryConverted,
+		RunNumber:               h.RunNumber,
+		FreeField:               h.FreeField,
+		TimeStart:               h.TimeStart,
+		TimeStop:                h.TimeStop,
+		NoEvents:                h.NoEvents,
+		NoASMCards:              h.NoASMCards,
+		NoSamples:               h.NoSamples,
+		DataToRead:              h.DataToRead,
+		TriggerEq:               h.TriggerEq,
+		TriggerDelay:            h.TriggerDelay,
+		ChanUsedForTrig:         h.ChanUsedForTrig,
+		Threshold:               h.Threshold,
+		LowHighThres:            h.LowHighThres,
+		TrigSigShapingHighThres: h.TrigSigShapingHighThres,
+		TrigSigShapingLowThres:  h.TrigSigShapingLowThres,
+		Size:                    h.Size,
+		NumFrame:                h.NumFrame,
+	}
+	if h.HdrType == dpgarw.HeaderOld {
+		hdr.HdrType = rwvme.HeaderOld
+	}
+	return hdr
+}
+
+// noEventsOffset is the offset of the NoEvents field in the HeaderCAL headers
+// of the DPGA and VME streams.
+const noEventsOffset = 20
+
+// NoEventsOffset returns the offset, in the stream written by w, of the field of
+// the run header holding the number of events, which is to be updated once all
+// events are written, and false if the header has no such field: microTCA and
+// RCT streams, DPGA and VME streams with a HeaderOld header, or streams to which
+// no event was written.
+func NoEventsOffset(w Writer) (int64, bool) {
+	switch w := w.(type) {
+	case *dpgaWriter:
+		if w.hdr != nil && w.hdr.HdrType == dpgarw.HeaderCAL {
+			return noEventsOffset, true
+		}
+	case *vmeWriter:
+		if w.hdr != nil && w.hdr.HdrType == rwvme.HeaderCAL {
+			return noEventsOffset, true
+		}
+	}
+	return 0, false
+}
+
+// filledClusters returns the clusters of e, with and without data, whose pulses have samples.
+func filledClusters(e *event.Event) []*pulse.Cluster {
+	var clusters []*pulse.Cluster
+	for i := range e.Clusters {
+		if e.Clusters[i].Pulses[0].NoSamples() != 0 {
+			clusters = append(clusters, &e.Clusters[i])
+		}
+	}
+	for i := range e.ClustersWoData {
+		if e.ClustersWoData[i].Pulses[0].NoSamples() != 0 {
+			clusters = append(clusters, &e.ClustersWoData[i])
+		}
+	}
+	return clusters
+}
+
+// noSamples returns the number of samples of the pulses of e.
+func noSamples(e *event.Event) uint16 {
+	for _, c := range filledClusters(e) {
+		return c.NoSamples()
+	}
+	return 0
+}
+
+// asmCard returns the absolute index of the ASM board of the cluster c, or false
+// if its pulses have no channel.
+func asmCard(c *pulse.Cluster) (uint32, bool) {
+	if c.Pulses[0].Channel == nil {
+		return 0, false
+	}
+	return uint32(c.Pulses[0].Channel.AbsID288() / 24), true
+}
+
+// noASMCards returns the number of ASM boards needed to hold the pulses of e.
+func noASMCards(e *event.Event) uint32 {
+	var n uint32
+	for _, c := range filledClusters(e) {
+		if iASM, ok := asmCard(c); ok && iASM+1 > n {
+			n = iASM + 1
+		}
+	}
+	return n
+}
+
+// timeStamp returns the timestamp of e, taken from its counters if it was not set by the reader.
+func timeStamp(e *event.Event) uint64 {
+	if e.TimeStamp == 0 && len(e.Counters) >= 4 {
+		return uint64(e.Counters[3])<<32 | uint64(e.Counters[2])
+	}
+	return e.TimeStamp
+}
+
+// dpgaCounters returns the counters of e in the DPGA format.
+// If e was not read from a DPGA file, only the timestamp (counters 2 and 3) is set.
+func dpgaCounters(e *event.Event) []uint32 {
+	if len(e.Counters) == int(dpgarw.NumCounters) {
+		return e.Counters
+	}
+	counters := make([]uint32, dpgarw.NumCounters)
+	ts := timeStamp(e)
+	counters[2] = uint32(ts)
+	counters[3] = uint32(ts >> 32)
+	return counters
+}
+
+// setClusterCounters sets the counters of the clusters of e which were not read
+// from a microTCA or RCT file: the timestamp is the one of the event, the
+// trigger counter is the event ID and the frames of each ASM board are numbered
+// in turn, noFrames holding the number of frames already written per board.
+func setClusterCounters(e *event.Event, noFrames map[uint32]uint64) {
+	ts := timeStamp(e)
+	for _, c := range filledClusters(e) {
+		if c.TimeStampAsm == 0 && c.CptTriggerAsm == 0 {
+			c.TimeStampAsm = ts
+			c.CptTriggerAsm = uint32(e.ID)
+			iASM, _ := asmCard(c)
+			c.NoFrameAsm = noFrames[iASM]
+			noFrames[iASM]++
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package rwi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpga/dpgadetector"
+	dpgarw "gitlab.in2p3.fr/avirm/analysis-go/dpga/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rwvme"
+	"gitlab.in2p3.fr/avirm/analysis-go/event"
+	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+)
+
+const testNoSamples = 16
+
+// testQuartets are the quartets (absolute Id, 0 -> 71) having samples in the
+// test events: more than the two frames per event of the microTCA setup,
+// including a quartet without data (5).
+var testQuartets = []uint8{0, 5, 7, 31, 64}
+
+// testEvent returns an event with pulses in the given quartets (absolute Id, 0 -> 71).
+func testEvent(id uint, timeStamp uint64, quartets []uint8) *event.Event {
+	e := event.NewEvent(int(dpgadetector.Det.NoClusters()), int(dpgadetector.Det.NoClustersWoData()))
+	e.ID = id
+	e.TimeStamp = timeStamp
+	for _, q := range quartets {
+		iHemi, iASM, iDRS, iQuartet := dpgadetector.QuartetAbsIdx72ToRelIdx(q)
+		FEId, ChanId := dpgadetector.QuartetAbsIdx72ToFEIdAndChanId(q)
+		c := &e.ClustersWoData[q/6]
+		if q%6 != 5 {
+			c = &e.Clusters[dpgadetector.FEIdAndChanIdToQuartetAbsIdx60(FEId, ChanId, true)]
+		}
+		for i := range c.Pulses {
+			_, iChannelAbs288 := dpgadetector.RelIdxToAbsIdx288(iHemi, iASM, iDRS, iQuartet, uint8(i))
+			p := pulse.NewPulse(dpgadetector.Det.ChannelFromIdAbs288(iChannelAbs288))
+			p.SRout = uint16(100 + id)
+			for j := 0; j < testNoSamples; j++ {
+				ampl := float64(500 + 100*i + 7*j + int(q) + int(id))
+				p.AddSample(pulse.NewSample(ampl, uint16(j), float64(j)*dpgadetector.Det.SamplingFreq()), nil, 800)
+			}
+			c.Pulses[i] = *p
+		}
+	}
+	return e
+}
+
+// rctEvent returns the part of e held by the RCT format: the clusters of the first ASM board.
+func rctEvent(e *event.Event) *event.Event {
+	r := event.NewEvent(5, 1)
+	r.ID = e.ID
+	r.TimeStamp = e.TimeStamp
+	copy(r.Clusters, e.Clusters)
+	copy(r.ClustersWoData, e.ClustersWoData)
+	return r
+}
+
+// convert writes events in the given format and returns the reader of the written stream.
+// The number of events is written in the run header, if it has such a field (see NoEventsOffset),
+// as rwconvert does.
+func convert(t *testing.T, events []*event.Event, format Format, src Reader) Reader {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format, src, false)
+	if err != nil {
+		t.Fatalf("could not create %v writer: %v\n", format.String(), err)
+	}
+	for _, e := range events {
+		if err := w.Event(e); err != nil {
+			t.Fatalf("error writing event: %v\n", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing writer: %v\n", err)
+	}
+	offset, ok := NoEventsOffset(w)
+	if want := format == FormatDPGA || format == FormatVME; ok != want {
+		t.Errorf("%v: header with number of events = %v, want %v\n", format.String(), ok, want)
+	}
+	if ok {
+		binary.BigEndian.PutUint32(buf.Bytes()[offset:], uint32(len(events)))
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), format)
+	if err != nil {
+		t.Fatalf("could not read %v stream: %v\n", format.String(), err)
+	}
+	var noEvents uint32
+	switch r := r.(type) {
+	case *dpgarw.Reader:
+		noEvents = r.Header().NoEvents
+	case *rwvme.Reader:
+		noEvents = r.Header().NoEvents
+	}
+	if ok && noEvents != uint32(len(events)) {
+		t.Errorf("%v: number of events in the header = %v, want %v\n", format.String(), noEvents, len(events))
+	}
+	return r
+}
+
+// readAll reads the events of r and checks their number and content against want.
+func readAll(t *testing.T, r Reader, format Format, want []*event.Event) []*event.Event {
+	var events []*event.Event
+	for {
+		e, err := r.ReadNextEvent()
+		if err == io.EOF || e == nil {
+			break
+		}
+		if err != nil {
+			t.Errorf("%v: event %v: %v\n", format.String(), len(events), err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != len(want) {
+		t.Fatalf("%v: got %v events, want %v\n", format.String(), len(events), len(want))
+	}
+	for i, e := range events {
+		if timeStamp(e) != timeStamp(want[i]) {
+			t.Errorf("%v: event %v: timestamp = %v, want %v\n", format.String(), i, timeStamp(e), timeStamp(want[i]))
+		}
+		compareClusters(t, format, i, e.Clusters, want[i].Clusters)
+		compareClusters(t, format, i, e.ClustersWoData, want[i].ClustersWoData)
+	}
+	return events
+}
+
+func compareClusters(t *testing.T, format Format, i int, got, want []pulse.Cluster) {
+	for ic := range want {
+		for ip := range want[ic].Pulses {
+			p, w := &got[ic].Pulses[ip], &want[ic].Pulses[ip]
+			if p.NoSamples() != w.NoSamples() {
+				t.Fatalf("%v: event %v, cluster %v, pulse %v: got %v samples, want %v\n", format.String(), i, ic, ip, p.NoSamples(), w.NoSamples())
+			}
+			if w.NoSamples() == 0 {
+				continue
+			}
+			if p.SRout != w.SRout || p.Channel.AbsID288() != w.Channel.AbsID288() {
+				t.Errorf("%v: event %v, cluster %v, pulse %v: got SRout=%v channel=%v, want %v and %v\n", format.String(), i, ic, ip,
+					p.SRout, p.Channel.AbsID288(), w.SRout, w.Channel.AbsID288())
+			}
+			for j := range w.Samples {
+				if p.Samples[j].Amplitude != w.Samples[j].Amplitude {
+					t.Errorf("%v: event %v, cluster %v, pulse %v: amplitudes differ\n", format.String(), i, ic, ip)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	const noEvents = 5
+	var events []*event.Event
+	for i := 0; i < noEvents; i++ {
+		events = append(events, testEvent(uint(i), uint64(1000+64*i), testQuartets))
+	}
+
+	// VME -> microTCA -> DPGA
+	vme := convert(t, events, FormatVME, nil)
+	vmeEvents := readAll(t, vme, FormatVME, events)
+	tca := convert(t, vmeEvents, FormatTCA, vme)
+	tcaEvents := readAll(t, tca, FormatTCA, events)
+	dpga := convert(t, tcaEvents, FormatDPGA, tca)
+	readAll(t, dpga, FormatDPGA, events)
+
+	// VME -> DPGA
+	dpga = convert(t, vmeEvents, FormatDPGA, vme)
+	readAll(t, dpga, FormatDPGA, events)
+	if h := dpga.(*dpgarw.Reader).Header(); h.History&dpgarw.HistoryConverted == 0 {
+		t.Errorf("history of the DPGA header converted from VME = %#x, want the converted bit set\n", h.History)
+	}
+
+	// VME -> RCT, with all the quartets of the first ASM board so that RCT events
+	// are complete: the pulses of the other ASM boards are dropped
+	var rctEvents []*event.Event
+	events = events[:0]
+	for i := 0; i < noEvents; i++ {
+		e := testEvent(uint(i), uint64(1000+64*i), []uint8{0, 1, 2, 3, 4, 5, 31})
+		events = append(events, e)
+		rctEvents = append(rctEvents, rctEvent(e))
+	}
+	vme = convert(t, events, FormatVME, nil)
+	vmeEvents = readAll(t, vme, FormatVME, events)
+	rct := convert(t, vmeEvents, FormatRCT, vme)
+	readAll(t, rct, FormatRCT, rctEvents)
+}
+
+func TestConvertOldHeader(t *testing.T) {
+	const noEvents = 3
+	var events []*event.Event
+	for i := 0; i < noEvents; i++ {
+		events = append(events, testEvent(uint(i), uint64(1000+64*i), testQuartets))
+	}
+
+	// DPGA stream with an old header, of which the size gives the number of samples
+	var buf bytes.Buffer
+	dw := dpgarw.NewWriter(&buf)
+	if err := dw.Header(&dpgarw.Header{HdrType: dpgarw.HeaderOld, Size: testNoSamples + 8}, false); err != nil {
+		t.Fatalf("could not write header: %v\n", err)
+	}
+	for _, e := range events {
+		e.Counters = dpgaCounters(e)
+		if err := dw.Event(e); err != nil {
+			t.Fatalf("error writing event: %v\n", err)
+		}
+	}
+	if err := dw.Close(); err != nil {
+		t.Fatalf("error closing writer: %v\n", err)
+	}
+	old, err := NewReader(bytes.NewReader(buf.Bytes()), FormatAuto)
+	if err != nil {
+		t.Fatalf("could not read old header stream: %v\n", err)
+	}
+	if h := old.(*dpgarw.Reader).Header(); h.HdrType != dpgarw.HeaderOld {
+		t.Fatalf("header type = %v, want %v\n", h.HdrType.String(), "HeaderOld")
+	}
+	oldEvents := readAll(t, old, FormatDPGAOld, events)
+
+	// the header of the VME stream is an old one too, without the number of events
+	buf.Reset()
+	w, err := NewWriter(&buf, FormatVME, old, false)
+	if err != nil {
+		t.Fatalf("could not create writer: %v\n", err)
+	}
+	for _, e := range oldEvents {
+		if err := w.Event(e); err != nil {
+			t.Fatalf("error writing event: %v\n", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing writer: %v\n", err)
+	}
+	if offset, ok := NoEventsOffset(w); ok {
+		t.Errorf("number of events at offset %v of an old header\n", offset)
+	}
+	vme, err := rwvme.NewReader(bytes.NewReader(buf.Bytes()), rwvme.HeaderOld)
+	if err != nil {
+		t.Fatalf("could not read VME stream: %v\n", err)
+	}
+	readAll(t, vme, FormatVME, events)
+}
@@ -0,0 +1,153 @@
+// Package tcaframe implements the parts of the microTCA frame format which are
+// shared by the microTCA (dpgatca/rw) and RCT (rct/rw) readers and writers:
+// the CRC of the frames and the identification of the end of frame word.
+//
+// The CRC is computed over the frame header and sample data, as they appear in
+// the stream, with the CRC-16/CCITT-FALSE algorithm. It is stored in the frame
+// trailer, followed by the end of frame word.
+package tcaframe
+
+import (
+	"fmt"
+)
+
+// LegacyCRC is the fixed word written in place of the CRC by firmwares not computing it.
+const LegacyCRC uint16 = 0x9876
+
+// eofMagic is the low byte of the end of frame word.
+const eofMagic uint16 = 0xfb
+
+// CRCMode defines how readers handle the CRC of frames.
+type CRCMode byte
+
+const (
+	CRCFlag   CRCMode = iota // CRC checked, frames with a wrong CRC are kept and flagged (CRCStatus)
+	CRCDrop                  // CRC checked, frames with a wrong CRC are dropped
+	CRCIgnore                // CRC not checked
+)
+
+func (m *CRCMode) String() string {
+	switch *m {
+	case CRCFlag:
+		return "flag"
+	case CRCDrop:
+		return "drop"
+	case CRCIgnore:
+		return "ignore"
+	default:
+		return fmt.Sprintf("CRCMode(%v)", *m)
+	}
+}
+
+// Set is the method to set the flag value.
+func (m *CRCMode) Set(value string) error {
+	switch value {
+	case "flag":
+		*m = CRCFlag
+	case "drop":
+		*m = CRCDrop
+	case "ignore":
+		*m = CRCIgnore
+	default:
+		return fmt.Errorf("invalid CRC mode value %q", value)
+	}
+	return nil
+}
+
+// CRCStatus is the result of the CRC check of a frame.
+type CRCStatus byte
+
+const (
+	CRCNotChecked CRCStatus = iota // CRC not checked (CRCIgnore mode)
+	CRCOK                          // CRC matches header and sample data
+	CRCLegacy                      // trailer holds the fixed LegacyCRC word
+	CRCFailed                      // CRC does not match header and sample data
+)
+
+func (s CRCStatus) String() string {
+	switch s {
+	case CRCNotChecked:
+		return "NotChecked"
+	case CRCOK:
+		return "OK"
+	case CRCLegacy:
+		return "Legacy"
+	case CRCFailed:
+		return "Failed"
+	default:
+		return fmt.Sprintf("CRCStatus(%d)", byte(s))
+	}
+}
+
+// CRC returns the CRC of data (header and sample data of a frame) using the
+// CRC-16/CCITT-FALSE algorithm (polynomial 0x1021, initial value 0xFFFF, no reflection).
+func CRC(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// CheckCRC compares the CRC stored in a frame trailer with the one computed
+// from the frame header and sample data, and returns the result.
+func CheckCRC(stored, computed uint16) CRCStatus {
+	switch stored {
+	case computed:
+		return CRCOK
+	case LegacyCRC:
+		return CRCLegacy
+	default:
+		return CRCFailed
+	}
+}
+
+// CRCFailures counts frames with a wrong CRC per front-end (index: FEId).
+type CRCFailures [128]uint64
+
+// Add counts a frame with a wrong CRC from the front-end feid.
+func (c *CRCFailures) Add(feid uint16) {
+	c[feid&0x7f]++
+}
+
+// Print prints the number of frames with a wrong CRC per front-end.
+func (c *CRCFailures) Print() {
+	fmt.Println("Frames with wrong CRC:")
+	noFailures := uint64(0)
+	for feid, n := range c {
+		if n == 0 {
+			continue
+		}
+		fmt.Printf("  FEId %#x: %v\n", feid, n)
+		noFailures += n
+	}
+	if noFailures == 0 {
+		fmt.Println("  none")
+	}
+}
+
+// EoFWord returns the end of frame word of the frames of the front-end feid:
+// the front-end Id in the high byte and the 0xfb magic in the low byte.
+func EoFWord(feid uint16) uint16 {
+	return (feid&0x7f)<<8 | eofMagic
+}
+
+// IsEoF returns whether w is the end of frame word of a frame whose header
+// holds the front-end word feIdK30 (FrameHeader.FEIdK30): its low byte is the
+// 0xfb magic and its high byte holds the same front-end Id as the header
+// (the parity bit is not checked).
+func IsEoF(w, feIdK30 uint16) bool {
+	return w&0xff == eofMagic && (w>>8)&0x7f == feIdK30&0x7f
+}
+
+// HasEoFMagic returns whether the low byte of w is the 0xfb magic of the end of frame word.
+func HasEoFMagic(w uint16) bool {
+	return w&0xff == eofMagic
+}
@@ -0,0 +1,55 @@
+package tcaframe
+
+import "testing"
+
+func TestCRC(t *testing.T) {
+	// check value of the CRC-16/CCITT-FALSE algorithm
+	if crc := CRC([]byte("123456789")); crc != 0x29B1 {
+		t.Errorf("CRC = %#x, want 0x29b1\n", crc)
+	}
+	tests := []struct {
+		stored, computed uint16
+		want             CRCStatus
+	}{
+		{0x1234, 0x1234, CRCOK},
+		{LegacyCRC, 0x1234, CRCLegacy},
+		{0x1235, 0x1234, CRCFailed},
+	}
+	for _, test := range tests {
+		if got := CheckCRC(test.stored, test.computed); got != test.want {
+			t.Errorf("CheckCRC(%#x, %#x) = %v, want %v\n", test.stored, test.computed, got, test.want)
+		}
+	}
+}
+
+func TestEoF(t *testing.T) {
+	w := EoFWord(0x11)
+	if w != 0x11fb {
+		t.Errorf("EoFWord(0x11) = %#x, want 0x11fb\n", w)
+	}
+	if !IsEoF(w, 0x11) || !IsEoF(w, 0x91) {
+		t.Errorf("%#x not recognized as end of frame word of FEId 0x11\n", w)
+	}
+	if IsEoF(w, 0x12) || IsEoF(0x11fa, 0x11) {
+		t.Errorf("end of frame word recognized for wrong FEId or magic\n")
+	}
+	if !HasEoFMagic(0x42fb) || HasEoFMagic(0xfb42) {
+		t.Errorf("wrong end of frame magic detection\n")
+	}
+}
+
+func TestCRCMode(t *testing.T) {
+	for _, s := range []string{"flag", "drop", "ignore"} {
+		var m CRCMode
+		if err := m.Set(s); err != nil {
+			t.Fatalf("Set(%q): %v\n", s, err)
+		}
+		if m.String() != s {
+			t.Errorf("String() = %q, want %q\n", m.String(), s)
+		}
+	}
+	var m CRCMode
+	if err := m.Set("bogus"); err == nil {
+		t.Errorf("Set(\"bogus\") did not fail\n")
+	}
+}
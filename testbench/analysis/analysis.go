@@ -1,26 +1,18 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 
 	"gitlab.in2p3.fr/avirm/analysis-go/applyCorrCalib"
-	"gitlab.in2p3.fr/avirm/analysis-go/event"
 	"gitlab.in2p3.fr/avirm/analysis-go/pulse"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
 	"gitlab.in2p3.fr/avirm/analysis-go/testbench/dq"
-	"gitlab.in2p3.fr/avirm/analysis-go/testbench/reader"
-	"gitlab.in2p3.fr/avirm/analysis-go/testbench/rw"
 	"gitlab.in2p3.fr/avirm/analysis-go/testbench/tbdetector"
 )
 
-type ReadNextEventer interface {
-	ReadNextEvent() (*event.Event, bool)
-}
-
 func main() {
 	log.SetFlags(log.Llongfile | log.LstdFlags)
 
@@ -28,22 +20,22 @@ func main() {
 		infileName = flag.String("i", "testdata/tenevents_hex.txt", "Name of the input file")
 		//outFileNamePulses = flag.String("oP", "output/pulses.csv", "Name of the output file containing pulse data")
 		//outFileNameGlobal = flag.String("oG", "output/globalEventVariables.csv", "Name of the output file containing global event variables")
-		noEvents  = flag.Int("n", -1, "Number of events to process (-1 means all events are processed)")
-		pedCorr   = flag.String("ped", "", "Name of the csv file containing pedestal constants. If not set, pedestal corrections are not applied.")
-		wGob      = flag.String("wgob", "dqplots.gob", "Name of the output gob file containing dq plots. If not set, the gob file is not produced.")
-		inputType = reader.HexInput
+		noEvents = flag.Int("n", -1, "Number of events to process (-1 means all events are processed)")
+		pedCorr  = flag.String("ped", "", "Name of the csv file containing pedestal constants. If not set, pedestal corrections are not applied.")
+		wGob     = flag.String("wgob", "dqplots.gob", "Name of the output gob file containing dq plots. If not set, the gob file is not produced.")
+		format   = rwi.FormatAuto
 	)
-	flag.Var(&inputType, "inType", "Type of input file. Relevant only with hexadecimal or decimal input. Possible values: Dec,Hex.")
+	flag.Var(&format, "format", rwi.FormatUsage)
 	flag.Parse()
 
 	err := os.RemoveAll("output")
 	if err != nil {
-		log.Fatalf("error removing output directory", err)
+		log.Fatalf("error removing output directory: %v\n", err)
 	}
 
 	err = os.Mkdir("output", 0777)
 	if err != nil {
-		log.Fatalf("error creating output directory", err)
+		log.Fatalf("error creating output directory: %v\n", err)
 	}
 
 	file, err := os.Open(*infileName)
@@ -52,21 +44,9 @@ func main() {
 	}
 	defer file.Close()
 
-	var rner ReadNextEventer
-
-	if filepath.Ext(*infileName) == ".bin" {
-		// Binary file reader
-		rner, err = rw.NewReader(bufio.NewReader(file), rw.HeaderCAL)
-		if err != nil {
-			log.Fatalf("could not open asm file: %v\n", err)
-		}
-	} else if filepath.Ext(*infileName) == ".txt" {
-		// ASCII file scanner
-		s := reader.NewScanner(bufio.NewScanner(file))
-		s.SetInputType(inputType)
-		rner = s
-	} else {
-		log.Fatalf("file extension not recognized.")
+	rner, err := rwi.NewReader(file, format)
+	if err != nil {
+		log.Fatalf("could not open file: %v\n", err)
 	}
 
 	// Start doing concrete analysis
@@ -78,14 +58,14 @@ func main() {
 
 	//var dataCorrelation plotter.XYZs
 
-	for event, status := rner.ReadNextEvent(); status && (*noEvents == -1 || int(event.ID) < *noEvents); event, status = rner.ReadNextEvent() {
+	for event, _ := rner.ReadNextEvent(); event != nil && (*noEvents == -1 || int(event.ID) < *noEvents); event, _ = rner.ReadNextEvent() {
 		if event.ID%500 == 0 {
 			fmt.Printf("Processing event %v\n", event.ID)
 		}
 		///////////////////////////////////////////////////////////
 		// Corrections
 		if *pedCorr != "" {
-			event = applyCorrCalib.RemovePedestal(event)
+			event = applyCorrCalib.CorrectEvent(event, true, false, false)
 		}
 		///////////////////////////////////////////////////////////
 
@@ -93,10 +73,10 @@ func main() {
 		// Plotting
 		// pulses
 		if event.ID < 40 {
-			event.PlotPulses(pulse.XaxisIndex, false)
+			event.PlotPulses(pulse.XaxisIndex, false, pulse.YRangeAuto, pulse.XRangeAuto)
 		}
 		// dq
-		dqplot.FillHistos(event)
+		dqplot.FillHistos(event, false)
 		// correlation
 		/*
 			cluster := event.Clusters[0]
@@ -117,10 +97,7 @@ func main() {
 	}
 
 	/*
-		p, err := plot.New()
-		if err != nil {
-			panic(err)
-		}
+		p := plot.New()
 		p.Title.Text = "Correlation of amplitudes for clusters with 2 pulses"
 		p.X.Label.Text = "amplitude 1"
 		p.Y.Label.Text = "amplitude 2"
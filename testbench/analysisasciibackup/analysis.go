@@ -1,3 +1,5 @@
+//go:build ignore
+
 package main
 
 import (
@@ -56,7 +58,7 @@ func main() {
 
 	dqplot := dq.NewDQPlot()
 
-	for event, status := s.ReadNextEvent(); status && event.ID < *noEvents; event, status = s.ReadNextEvent() {
+	for event, _ := s.ReadNextEvent(); event != nil && event.ID < *noEvents; event, _ = s.ReadNextEvent() {
 		if event.ID%500 == 0 {
 			fmt.Printf("Processing event %v\n", event.ID)
 		}
@@ -1,3 +1,5 @@
+//go:build ignore
+
 package main
 
 import (
@@ -64,7 +66,7 @@ func main() {
 
 	dqplot := dq.NewDQPlot()
 
-	for event, status := r.ReadNextEvent(); status && (*noEvents == -1 || int(event.ID) < *noEvents); event, status = r.ReadNextEvent() {
+	for event, _ := r.ReadNextEvent(); event != nil && (*noEvents == -1 || int(event.ID) < *noEvents); event, _ = r.ReadNextEvent() {
 		if event.ID%100 == 0 {
 			fmt.Printf("Processing event %v\n", event.ID)
 		}
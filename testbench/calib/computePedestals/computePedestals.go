@@ -1,23 +1,15 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 
-	"gitlab.in2p3.fr/avirm/analysis-go/event"
-	"gitlab.in2p3.fr/avirm/analysis-go/testbench/reader"
-	"gitlab.in2p3.fr/avirm/analysis-go/testbench/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
 	"gitlab.in2p3.fr/avirm/analysis-go/testbench/tbdetector"
 )
 
-type ReadNextEventer interface {
-	ReadNextEvent() (*event.Event, bool)
-}
-
 func main() {
 	log.SetFlags(log.Llongfile | log.LstdFlags)
 
@@ -27,22 +19,20 @@ func main() {
 		outrootfileName = flag.String("oroot", "outputPedestals/pedestals.root", "Name of the output pedestal root file")
 		//outFileNamePulses = flag.String("oP", "outputPedestals/pulses.csv", "Name of the output file containing pulse data")
 		//outFileNameGlobal = flag.String("oG", "outputPedestals/globalEventVariables.csv", "Name of the output file containing global event variables")
-		noEvents  = flag.Int("n", -1, "Number of events to process (-1 means all events are processed)")
-		inputType = reader.HexInput
-		hdrType   = rw.HeaderGANIL
+		noEvents = flag.Int("n", -1, "Number of events to process (-1 means all events are processed)")
+		format   = rwi.FormatAuto
 	)
-	flag.Var(&inputType, "inType", "Type of input file (possible values: Dec,Hex,Bin)")
-	flag.Var(&hdrType, "h", "Type of header: HeaderGANIL or HeaderOld")
+	flag.Var(&format, "format", rwi.FormatUsage)
 	flag.Parse()
 
 	err := os.RemoveAll("outputPedestals")
 	if err != nil {
-		log.Fatalf("error removing outputPedestals directory", err)
+		log.Fatalf("error removing outputPedestals directory: %v\n", err)
 	}
 
 	err = os.Mkdir("outputPedestals", 0777)
 	if err != nil {
-		log.Fatalf("error creating outputPedestals directory", err)
+		log.Fatalf("error creating outputPedestals directory: %v\n", err)
 	}
 
 	file, err := os.Open(*infileName)
@@ -51,24 +41,12 @@ func main() {
 	}
 	defer file.Close()
 
-	var rner ReadNextEventer
-
-	if filepath.Ext(*infileName) == ".bin" {
-		// Binary file reader
-		rner, err = rw.NewReader(bufio.NewReader(file), hdrType)
-		if err != nil {
-			log.Fatalf("could not open asm file: %v\n", err)
-		}
-	} else if filepath.Ext(*infileName) == ".txt" {
-		// ASCII file scanner
-		s := reader.NewScanner(bufio.NewScanner(file))
-		s.SetInputType(inputType)
-		rner = s
-	} else {
-		log.Fatalf("file extension not recognized.")
+	rner, err := rwi.NewReader(file, format)
+	if err != nil {
+		log.Fatalf("could not open file: %v\n", err)
 	}
 
-	for event, status := rner.ReadNextEvent(); status && (*noEvents == -1 || int(event.ID) < *noEvents); event, status = rner.ReadNextEvent() {
+	for event, _ := rner.ReadNextEvent(); event != nil && (*noEvents == -1 || int(event.ID) < *noEvents); event, _ = rner.ReadNextEvent() {
 		if event.ID%500 == 0 {
 			fmt.Printf("Processing event %v\n", event.ID)
 		}
@@ -3,14 +3,13 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 
 	"gitlab.in2p3.fr/avirm/analysis-go/applyCorrCalib"
-	"gitlab.in2p3.fr/avirm/analysis-go/testbench/rw"
+	"gitlab.in2p3.fr/avirm/analysis-go/rwi"
 	"gitlab.in2p3.fr/avirm/analysis-go/testbench/tbdetector"
 )
 
@@ -22,20 +21,20 @@ func main() {
 		outfileName = flag.String("o", "outputTDO/timeDepOffsets.csv", "Name of the output file")
 		noEvents    = flag.Uint("n", 10000000, "Number of events to process")
 		ped         = flag.String("ped", "", "Name of the csv file containing pedestal constants. If not set, pedestal corrections are not applied.")
-		hdrType     = rw.HeaderGANIL
+		format      = rwi.FormatAuto
 	)
 
-	flag.Var(&hdrType, "h", "Type of header: HeaderGANIL or HeaderOld")
+	flag.Var(&format, "format", rwi.FormatUsage)
 	flag.Parse()
 
 	err := os.RemoveAll("outputTDO")
 	if err != nil {
-		log.Fatalf("error removing outputTDO directory", err)
+		log.Fatalf("error removing outputTDO directory: %v\n", err)
 	}
 
 	err = os.Mkdir("outputTDO", 0777)
 	if err != nil {
-		log.Fatalf("error creating outputTDO directory", err)
+		log.Fatalf("error creating outputTDO directory: %v\n", err)
 	}
 
 	file, err := os.Open(*infileName)
@@ -44,7 +43,7 @@ func main() {
 	}
 	defer file.Close()
 
-	r, err := rw.NewReader(bufio.NewReader(file), hdrType)
+	r, err := rwi.NewReader(file, format)
 	if err != nil {
 		log.Fatalf("could not open asm file: %v\n", err)
 	}
@@ -56,13 +55,13 @@ func main() {
 		tbdetector.Det.ReadPedestalsFile(*ped)
 	}
 
-	for event, status := r.ReadNextEvent(); status && event.ID < *noEvents; event, status = r.ReadNextEvent() {
+	for event, _ := r.ReadNextEvent(); event != nil && event.ID < *noEvents; event, _ = r.ReadNextEvent() {
 		if event.ID%500 == 0 {
 			fmt.Printf("Processing event %v\n", event.ID)
 		}
 
 		// this should be safe as pedestals calibration coefficients have been loaded previously.
-		event = applyCorrCalib.HV(event, true, false)
+		event = applyCorrCalib.CorrectEvent(event, true, false, false)
 
 		event.PushTimeDepOffsetSamples()
 	}
@@ -5,7 +5,6 @@ import (
 	"encoding/gob"
 	"fmt"
 	"image/color"
-	"log"
 	"os"
 	"strconv"
 
@@ -158,26 +157,17 @@ const (
 )
 
 func (d *DQPlot) MakeFreqTiledPlot() *hplot.TiledPlot {
-	tp, err := hplot.NewTiledPlot(draw.Tiles{Cols: 1, Rows: 2, PadY: 1 * vg.Centimeter})
-	if err != nil {
-		panic(err)
-	}
+	tp := hplot.NewTiledPlot(draw.Tiles{Cols: 1, Rows: 2, PadY: 1 * vg.Centimeter})
 
 	p1 := tp.Plot(0, 0)
 	p1.X.Min = 0
 	p1.X.Max = 24
 	p1.X.Tick.Marker = &hplot.FreqTicks{N: 25, Freq: 4}
 	p1.Add(hplot.NewGrid())
-	hplotfreq, err := hplot.NewH1D(d.HFrequency)
-	if err != nil {
-		panic(err)
-	}
+	hplotfreq := hplot.NewH1D(d.HFrequency)
 	hplotfreq.FillColor = color.RGBA{R: 255, G: 204, B: 153, A: 255}
 	hplotfreq.Color = plotutil.Color(3)
 	p1.Add(hplotfreq)
-	if err != nil {
-		panic(err)
-	}
 	p1.Title.Text = fmt.Sprintf("Number of pulses vs channel\n")
 
 	p2 := tp.Plot(1, 0)
@@ -185,26 +175,17 @@ func (d *DQPlot) MakeFreqTiledPlot() *hplot.TiledPlot {
 	p2.X.Max = 24
 	p2.X.Tick.Marker = &hplot.FreqTicks{N: 25, Freq: 4}
 	p2.Add(hplot.NewGrid())
-	hplotsatfreq, err := hplot.NewH1D(d.HSatFrequency)
-	if err != nil {
-		panic(err)
-	}
+	hplotsatfreq := hplot.NewH1D(d.HSatFrequency)
 	hplotsatfreq.FillColor = color.RGBA{R: 255, G: 204, B: 153, A: 255}
 	hplotsatfreq.Color = plotutil.Color(3)
 	p2.Add(hplotsatfreq)
-	if err != nil {
-		log.Fatalf("error creating histogram \n")
-	}
 	p2.Title.Text = fmt.Sprintf("Number of saturating pulses vs channel\n")
 	return tp
 }
 
 func (d *DQPlot) MakeChargeAmplTiledPlot(whichV WhichVar) *hplot.TiledPlot {
 	NoClusters := int(tbdetector.Det.NoClusters())
-	tp, err := hplot.NewTiledPlot(draw.Tiles{Cols: 1, Rows: NoClusters, PadY: 0.5 * vg.Centimeter})
-	if err != nil {
-		panic(err)
-	}
+	tp := hplot.NewTiledPlot(draw.Tiles{Cols: 1, Rows: NoClusters, PadY: 0.5 * vg.Centimeter})
 
 	histos := make([]hbook.H1D, len(d.HCharge[0]))
 	var histosref []hbook.H1D
@@ -239,22 +220,10 @@ func (d *DQPlot) MakeChargeAmplTiledPlot(whichV WhichVar) *hplot.TiledPlot {
 		p.Plot.X.Tick.LineStyle.Width = 2
 		p.Plot.Y.Tick.LineStyle.Width = 2
 		p.Plot.X.Tick.Marker = &hplot.FreqTicks{N: 31, Freq: 2}
-		hplot0, err := hplot.NewH1D(&histos[0])
-		if err != nil {
-			panic(err)
-		}
-		hplot1, err := hplot.NewH1D(&histos[1])
-		if err != nil {
-			panic(err)
-		}
-		hplot2, err := hplot.NewH1D(&histos[2])
-		if err != nil {
-			panic(err)
-		}
-		hplot3, err := hplot.NewH1D(&histos[3])
-		if err != nil {
-			panic(err)
-		}
+		hplot0 := hplot.NewH1D(&histos[0])
+		hplot1 := hplot.NewH1D(&histos[1])
+		hplot2 := hplot.NewH1D(&histos[2])
+		hplot3 := hplot.NewH1D(&histos[3])
 		hplot0.Color = color.RGBA{R: 238, G: 46, B: 47, A: 255}  // red
 		hplot1.Color = color.RGBA{R: 0, G: 140, B: 72, A: 255}   // green
 		hplot2.Color = color.RGBA{R: 24, G: 90, B: 169, A: 255}  // blue
@@ -284,22 +253,10 @@ func (d *DQPlot) MakeChargeAmplTiledPlot(whichV WhichVar) *hplot.TiledPlot {
 				histosref[3].Scale(histos[3].Integral() / histosref[3].Integral())
 			}
 
-			hplot0ref, err := hplot.NewH1D(&histosref[0])
-			if err != nil {
-				panic(err)
-			}
-			hplot1ref, err := hplot.NewH1D(&histosref[1])
-			if err != nil {
-				panic(err)
-			}
-			hplot2ref, err := hplot.NewH1D(&histosref[2])
-			if err != nil {
-				panic(err)
-			}
-			hplot3ref, err := hplot.NewH1D(&histosref[3])
-			if err != nil {
-				panic(err)
-			}
+			hplot0ref := hplot.NewH1D(&histosref[0])
+			hplot1ref := hplot.NewH1D(&histosref[1])
+			hplot2ref := hplot.NewH1D(&histosref[2])
+			hplot3ref := hplot.NewH1D(&histosref[3])
 			hplot0ref.Color = color.RGBA{R: 238, G: 46, B: 47, A: 255}  // red
 			hplot1ref.Color = color.RGBA{R: 0, G: 140, B: 72, A: 255}   // green
 			hplot2ref.Color = color.RGBA{R: 24, G: 90, B: 169, A: 255}  // blue
@@ -319,10 +276,7 @@ func (d *DQPlot) MakeChargeAmplTiledPlot(whichV WhichVar) *hplot.TiledPlot {
 }
 
 func (d *DQPlot) MakeHVTiledPlot() *hplot.TiledPlot {
-	tp, err := hplot.NewTiledPlot(draw.Tiles{Cols: 2, Rows: 6, PadX: 3.5 * vg.Centimeter, PadY: 1 * vg.Centimeter})
-	if err != nil {
-		panic(err)
-	}
+	tp := hplot.NewTiledPlot(draw.Tiles{Cols: 2, Rows: 6, PadX: 3.5 * vg.Centimeter, PadY: 1 * vg.Centimeter})
 	//var TextsAndXYs []interface{}
 
 	//color := color.RGBA{R: 224, G: 242, B: 247, A: 255}
@@ -414,10 +368,7 @@ func (d *DQPlot) MakeHVTiledPlot() *hplot.TiledPlot {
 // MakeClustersXYTilePlot makes a tiled plot with the X vs Y scatter
 // plots for each cluster
 func (d *DQPlot) MakeClustersXYTilePlot() *hplot.TiledPlot {
-	tp, err := hplot.NewTiledPlot(draw.Tiles{Cols: len(d.ClustersXYs), Rows: 1, PadX: .5 * vg.Centimeter})
-	if err != nil {
-		panic(err)
-	}
+	tp := hplot.NewTiledPlot(draw.Tiles{Cols: len(d.ClustersXYs), Rows: 1, PadX: .5 * vg.Centimeter})
 	for i := range d.ClustersXYs {
 		p := tp.Plot(0, i)
 		// 		grid := hplot.NewGrid()
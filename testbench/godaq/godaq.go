@@ -115,13 +115,6 @@ func NewHVexec(execName string, coefDir string) *HVexec {
 	if !utils.Exists(coefDir) {
 		fmt.Printf("could not find directory %v\n", coefDir)
 		return nil
-		if !utils.Exists(coefDir+"/Coef_poly_C001.txt") ||
-			!utils.Exists(coefDir+"/Coef_poly_C002.txt") ||
-			!utils.Exists(coefDir+"/Coef_poly_C003.txt") ||
-			!utils.Exists(coefDir+"/Coef_poly_C004.txt") {
-			fmt.Printf("could not find at least one of the Coef_poly_C00?.txt file\n")
-			return nil
-		}
 	}
 	_, linkName := path.Split(coefDir)
 	if !utils.Exists(linkName) {
@@ -414,7 +407,7 @@ func getPreviousRunNumber(fileName string) uint32 {
 
 	nLines, err := utils.LineCounter(fileName)
 	if err != nil {
-		log.Fatalf("error reading the number of lines in runs.csv\n", err)
+		log.Fatalf("error reading the number of lines in runs.csv: %v\n", err)
 	}
 
 	// the -2 is because there are two lines of header at the beginning
@@ -571,9 +564,9 @@ func stream(terminateStream chan bool, cevent chan event.Event, r *rw.Reader, w
 				if *iEvent%*evtFreq == 0 {
 					fmt.Printf("event %v\n", *iEvent)
 				}
-				event, status := r.ReadNextEvent()
-				if status == false {
-					panic("error: status is false\n")
+				event, err := r.ReadNextEvent()
+				if err != nil {
+					panic(err)
 				}
 				switch event.IsCorrupted {
 				case false:
@@ -591,12 +584,12 @@ func stream(terminateStream chan bool, cevent chan event.Event, r *rw.Reader, w
 					if *tdo != "" {
 						doTimeDepOffset = true
 					}
-					event = applyCorrCalib.HV(event, doPedestal, doTimeDepOffset)
+					event = applyCorrCalib.CorrectEvent(event, doPedestal, doTimeDepOffset, false)
 
 					//////////////////////////////////////////////////////
 					// Fill histos
 					dqplots.FillHistos(event, *bgo)
-					mult, pulsesWithSignal := event.Multiplicity()
+					mult, pulsesWithSignal, _, _, _, _ := event.Multiplicity()
 					if *pet {
 						if mult == 2 {
 							if len(pulsesWithSignal) != 2 {
@@ -612,8 +605,8 @@ func stream(terminateStream chan bool, cevent chan event.Event, r *rw.Reader, w
 							}
 							if doRec {
 								if doPedestal {
-									T30_0 := pulsesWithSignal[0].T30(true)
-									T30_1 := pulsesWithSignal[1].T30(true)
+									_, _, T30_0, _, _, _ := pulsesWithSignal[0].CalcRisingFront(true)
+									_, _, T30_1, _, _, _ := pulsesWithSignal[1].CalcRisingFront(true)
 									if T30_0 != 0 && T30_1 != 0 {
 										dqplots.DeltaT30.Fill(T30_0-T30_1, 1)
 									}
@@ -693,17 +686,11 @@ func stream(terminateStream chan bool, cevent chan event.Event, r *rw.Reader, w
 						DeltaT30svg := ""
 						if *pet {
 							// Make DeltaT30 plot
-							pDeltaT30, err := hplot.New()
-							if err != nil {
-								panic(err)
-							}
+							pDeltaT30 := hplot.New()
 							pDeltaT30.X.Label.Text = "Delta T30 (ns)"
 							pDeltaT30.Y.Label.Text = "No entries"
 							pDeltaT30.X.Tick.Marker = &hplot.FreqTicks{N: 31, Freq: 5}
-							hpDeltaT30, err := hplot.NewH1D(dqplots.DeltaT30)
-							if err != nil {
-								panic(err)
-							}
+							hpDeltaT30 := hplot.NewH1D(dqplots.DeltaT30)
 							pDeltaT30.Add(hpDeltaT30)
 							pDeltaT30.Add(hplot.NewGrid())
 							DeltaT30svg = utils.RenderSVG(pDeltaT30, 15, 7)
@@ -770,7 +757,7 @@ func monitoring(cevent chan event.Event) {
 	for {
 		//fmt.Println("receiving from cframe1")
 		event := <-cevent
-		event.Clusters[0].PlotPulses(0, pulse.XaxisIndex, pulse.YRangeAuto)
+		event.Clusters[0].PlotPulses(0, pulse.XaxisIndex, pulse.YRangeAuto, pulse.XRangeAuto)
 	}
 }
 
@@ -18,21 +18,26 @@ const (
 	SecondFrameOfEvent
 )
 
+// noSamples is the number of samples per frame in ASCII files
+const noSamples = 999
+
 type Frame struct {
-	lines     []string
-	frameType TypeOfFrame
+	lines        []string
+	frameType    TypeOfFrame
+	sigThreshold uint
 }
 
 func NewFrame(lines []string, frameType TypeOfFrame) *Frame {
 	frame := &Frame{
-		lines:     lines,
-		frameType: frameType,
+		lines:        lines,
+		frameType:    frameType,
+		sigThreshold: 800,
 	}
 	return frame
 }
 
 func (f *Frame) RemoveHeaderAndCounters() []string {
-	linesWoHeaderCounters := f.lines[1:1000]
+	linesWoHeaderCounters := f.lines[1 : noSamples+1]
 	return linesWoHeaderCounters
 }
 
@@ -90,8 +95,8 @@ func (f *Frame) MakePulses() (*pulse.Pulse, *pulse.Pulse) {
 		sample1 := pulse.NewSample(ampl1, uint16(i), float64(i)*tbdetector.Det.SamplingFreq())
 		sample2 := pulse.NewSample(ampl2, uint16(i), float64(i)*tbdetector.Det.SamplingFreq())
 
-		pulse1.AddSample(sample1, tbdetector.Det.Capacitor(0, 0, pulse1.Channel.ID(), sample1.CapaIndex(pulse1.SRout)), 800)
-		pulse2.AddSample(sample2, tbdetector.Det.Capacitor(0, 0, pulse2.Channel.ID(), sample2.CapaIndex(pulse2.SRout)), 800)
+		pulse1.AddSample(sample1, tbdetector.Det.Capacitor(0, 0, pulse1.Channel.ID(), sample1.CapaIndex(pulse1.SRout)), float64(f.sigThreshold))
+		pulse2.AddSample(sample2, tbdetector.Det.Capacitor(0, 0, pulse2.Channel.ID(), sample2.CapaIndex(pulse2.SRout)), float64(f.sigThreshold))
 	}
 
 	//pulse1.Print()
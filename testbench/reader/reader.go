@@ -4,13 +4,12 @@ package reader
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"strconv"
 	"strings"
 
 	"gitlab.in2p3.fr/avirm/analysis-go/event"
-
-	//"io"
 )
 
 // InputType describes the type (decimal/ASCII, hex/ASCII, binary) of an input file.
@@ -53,15 +52,18 @@ const (
 )
 
 type Scanner struct {
-	s         *bufio.Scanner
-	inputType InputType
-	evtID     uint
+	s            *bufio.Scanner
+	inputType    InputType
+	evtID        uint
+	sigThreshold uint
+	debug        bool
 }
 
 func NewScanner(s *bufio.Scanner) *Scanner {
 	ss := &Scanner{
-		s:     s,
-		evtID: 0,
+		s:            s,
+		evtID:        0,
+		sigThreshold: 800,
 	}
 	return ss
 }
@@ -70,6 +72,21 @@ func (s *Scanner) SetInputType(inputType InputType) {
 	s.inputType = inputType
 }
 
+// SetSigThreshold sets the signal threshold
+func (s *Scanner) SetSigThreshold(val uint) {
+	s.sigThreshold = val
+}
+
+// SetDebug() sets debug mode
+func (s *Scanner) SetDebug() {
+	s.debug = true
+}
+
+// NoSamples returns the number of samples
+func (s *Scanner) NoSamples() uint16 {
+	return noSamples
+}
+
 func (s *Scanner) readNextFrame(frameType TypeOfFrame) (*Frame, bool) {
 	var lines []string
 	status := true
@@ -97,17 +114,25 @@ func (s *Scanner) readNextFrame(frameType TypeOfFrame) (*Frame, bool) {
 		lines = append(lines, text)
 	}
 	frame := NewFrame(lines, frameType)
-	//frame.PrintWoHeadersCounters()
+	frame.sigThreshold = s.sigThreshold
+	if s.debug && status {
+		frame.PrintWoHeadersCounters()
+	}
 	return frame, status
 }
 
-func (s *Scanner) ReadNextEvent() (*event.Event, bool) {
+// ReadNextEvent returns the next event.
+// At the end of the input, it returns a nil event and io.EOF.
+func (s *Scanner) ReadNextEvent() (*event.Event, error) {
 	frame, _ := s.readNextFrame(FirstFrameOfEvent)
 	frameNext, status := s.readNextFrame(SecondFrameOfEvent)
 	if status == false {
-		return &event.Event{}, false
+		if err := s.s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
 	}
 	event := NewEvent(frame, frameNext, s.evtID)
 	s.evtID++
-	return event, status
+	return event, nil
 }
@@ -34,6 +34,11 @@ func (r *Reader) NoSamples() uint16 {
 	return r.noSamples
 }
 
+// SetDebug() sets debug mode
+func (r *Reader) SetDebug() {
+	r.Debug = true
+}
+
 // Err return the reader error
 func (r *Reader) Err() error {
 	return r.err
@@ -56,6 +61,11 @@ func NewReader(r io.Reader, ht HeaderType) (*Reader, error) {
 	return rr, rr.err
 }
 
+// SetSigThreshold sets the signal SetSigThreshold
+func (r *Reader) SetSigThreshold(val uint) {
+	r.SigThreshold = val
+}
+
 // Read implements io.Reader
 func (r *Reader) Read(data []byte) (int, error) {
 	return r.r.Read(data)
@@ -323,14 +333,14 @@ func MakePulses(f *Frame, iCluster uint8, sigThreshold uint) (*pulse.Pulse, *pul
 	return pulse1, pulse2
 }
 
-func (r *Reader) ReadNextEvent() (*event.Event, bool) {
-	event := event.NewEvent(tbdetector.Det.NoClusters())
+func (r *Reader) ReadNextEvent() (*event.Event, error) {
+	event := event.NewEvent(int(tbdetector.Det.NoClusters()), 0)
 	for iCluster := uint8(0); iCluster < uint8(event.NoClusters()); iCluster++ {
 		frame1, err := r.Frame()
 		if err != nil {
 			switch {
 			case err == io.EOF:
-				return nil, false
+				return nil, err
 			case err == MissCAFEDECA || err == MissBADCAFEi:
 				event.IsCorrupted = true
 			default:
@@ -342,7 +352,7 @@ func (r *Reader) ReadNextEvent() (*event.Event, bool) {
 		if err != nil {
 			switch {
 			case err == io.EOF:
-				return nil, false
+				return nil, err
 			case err == MissCAFEDECA || err == MissBADCAFEi:
 				event.IsCorrupted = true
 			default:
@@ -388,5 +398,5 @@ func (r *Reader) ReadNextEvent() (*event.Event, bool) {
 
 	}
 
-	return event, true
+	return event, nil
 }
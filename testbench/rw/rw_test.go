@@ -15,7 +15,7 @@ func TestReader(t *testing.T) {
 	}
 	defer f.Close()
 
-	r, err := NewReader(bufio.NewReader(f))
+	r, err := NewReader(bufio.NewReader(f), HeaderOld)
 	if err != nil {
 		t.Fatalf("could not open asm file: %v\n", err)
 	}
@@ -85,13 +85,13 @@ func TestReadWrite(t *testing.T) {
 		t.Fatalf("could not open asm file: %v\n", err)
 	}
 
-	err = w.Header(rhdr)
+	err = w.Header(rhdr, false)
 	if err != nil {
 		t.Fatalf("error: %v\n", err)
 	}
 
-	for _, frame := range rframes {
-		err = w.Frame(frame)
+	for i := range rframes {
+		err = w.Frame(&rframes[i])
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -121,14 +121,14 @@ func TestReadWrite(t *testing.T) {
 	}
 }
 
-func testRead(t *testing.T, name string) (Header, []Frame) {
+func testRead(t *testing.T, name string) (*Header, []Frame) {
 	f, err := os.Open(name)
 	if err != nil {
 		t.Fatalf("could not open data file [%s]: %v\n", name, err)
 	}
 	defer f.Close()
 
-	r, err := NewReader(bufio.NewReader(f))
+	r, err := NewReader(bufio.NewReader(f), HeaderOld)
 	if err != nil {
 		t.Fatalf("could not open asm file [%s]: %v\n", name, err)
 	}
@@ -0,0 +1,204 @@
+package udp
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"time"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+)
+
+// maxMissing is the maximal number of missing counter values remembered per
+// counter to recognize reordered packets. Larger gaps are counted as losses only.
+const maxMissing = 4096
+
+// CounterStats are the statistics of a frame counter.
+type CounterStats struct {
+	Received   uint64 // number of packets received
+	Lost       uint64 // number of packets missing (not received yet, or lost)
+	Reordered  uint64 // number of packets received after a packet with a larger counter
+	Duplicated uint64 // number of packets received with an already seen counter
+	Resets     uint64 // number of counter resets (e.g. restart of the AMC or ASM board)
+
+	wrap    uint64 // number of counter values (0: 64 bits counter)
+	offset  uint64 // added to the counter values to unwrap them
+	last    uint64 // last unwrapped counter value
+	started bool
+	missing map[uint64]bool // missing unwrapped counter values
+}
+
+// add adds the counter value c and returns the number of newly missing packets,
+// which is negative if c was missing (reordered packet).
+//
+// A counter value lower than the last one by more than maxMissing is not a
+// reordered or duplicated packet: if the counter wrapped (see wrap), c is
+// unwrapped, otherwise the counter was reset and the statistics restart from c.
+func (s *CounterStats) add(c uint64) int64 {
+	s.Received++
+	if s.missing == nil {
+		s.missing = make(map[uint64]bool)
+	}
+	c += s.offset
+	if s.started && s.offset > 0 && c > s.last+maxMissing && c-s.wrap <= s.last {
+		// packet sent before the last wrap
+		c -= s.wrap
+	}
+	if s.started && c < s.last && s.last-c > maxMissing {
+		switch {
+		case s.wrap > 0 && c+s.wrap > s.last && c+s.wrap-s.last <= maxMissing+1:
+			s.offset += s.wrap
+			c += s.wrap
+		default:
+			s.Resets++
+			s.last = c
+			s.missing = make(map[uint64]bool)
+			return 0
+		}
+	}
+	switch {
+	case !s.started:
+		s.started = true
+		s.last = c
+		return 0
+	case c == s.last+1:
+		s.last = c
+		return 0
+	case c > s.last:
+		gap := c - s.last - 1
+		if gap <= maxMissing {
+			for i := s.last + 1; i < c; i++ {
+				s.missing[i] = true
+			}
+			s.pruneMissing(c)
+		}
+		s.last = c
+		s.Lost += gap
+		return int64(gap)
+	case s.missing[c]:
+		delete(s.missing, c)
+		s.Lost--
+		s.Reordered++
+		return -1
+	default:
+		s.Duplicated++
+		return 0
+	}
+}
+
+// pruneMissing forgets the missing counter values too old to be received, to bound memory.
+func (s *CounterStats) pruneMissing(last uint64) {
+	if len(s.missing) <= maxMissing {
+		return
+	}
+	for c := range s.missing {
+		if c+maxMissing < last {
+			delete(s.missing, c)
+		}
+	}
+}
+
+// LossRate returns the fraction of packets lost.
+func (s *CounterStats) LossRate() float64 {
+	if s.Received+s.Lost == 0 {
+		return 0
+	}
+	return float64(s.Lost) / float64(s.Received+s.Lost)
+}
+
+func (s *CounterStats) String() string {
+	return fmt.Sprintf("received=%v lost=%v (%.3g %%) reordered=%v duplicated=%v resets=%v",
+		s.Received, s.Lost, 100*s.LossRate(), s.Reordered, s.Duplicated, s.Resets)
+}
+
+// Monitor tracks the frame counters of the packets to detect lost and reordered
+// packets, per front-end (NoFrameAsm) and for the AMC (NbFrameAmc).
+type Monitor struct {
+	AMC  CounterStats             // NbFrameAmc statistics
+	FE   map[uint16]*CounterStats // NoFrameAsm statistics, key: FEId
+	Bad  uint64                   // number of packets too short to be checked
+	Loss []float64                // number of lost packets (from NbFrameAmc) per time bin
+
+	BinWidth time.Duration // width of the time bins of Loss
+	start    time.Time
+}
+
+// NewMonitor returns a monitor filling the loss histogram with time bins of width binWidth.
+func NewMonitor(binWidth time.Duration) *Monitor {
+	return &Monitor{
+		AMC:      CounterStats{wrap: 1 << 32}, // NbFrameAmc is a 32 bits counter
+		FE:       make(map[uint16]*CounterStats),
+		BinWidth: binWidth,
+	}
+}
+
+// Add checks the counters of packet p.
+func (m *Monitor) Add(p *Packet) {
+	c, err := ParseCounters(p.Data)
+	if err != nil {
+		m.Bad++
+		return
+	}
+	if m.start.IsZero() {
+		m.start = p.Time
+	}
+	lost := m.AMC.add(uint64(c.NbFrameAmc))
+	if m.FE[c.FEId] == nil {
+		m.FE[c.FEId] = &CounterStats{}
+	}
+	m.FE[c.FEId].add(c.NoFrameAsm)
+
+	bin := int(p.Time.Sub(m.start) / m.BinWidth)
+	if bin < 0 {
+		bin = 0
+	}
+	for len(m.Loss) <= bin {
+		m.Loss = append(m.Loss, 0)
+	}
+	m.Loss[bin] += float64(lost)
+}
+
+// Print prints the statistics of the counters.
+func (m *Monitor) Print() {
+	fmt.Printf("UDP packets:\n")
+	fmt.Printf("   o AMC (NbFrameAmc): %v\n", &m.AMC)
+	ids := make([]int, 0, len(m.FE))
+	for id := range m.FE {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Printf("   o FE %v (NoFrameAsm): %v\n", id, m.FE[uint16(id)])
+	}
+	if m.Bad > 0 {
+		fmt.Printf("   o %v packets too short to be checked\n", m.Bad)
+	}
+}
+
+// LossH1D returns the histogram of the number of lost packets versus time (in seconds).
+func (m *Monitor) LossH1D() *hbook.H1D {
+	n := len(m.Loss)
+	if n == 0 {
+		n = 1
+	}
+	w := m.BinWidth.Seconds()
+	h := hbook.NewH1D(n, 0, float64(n)*w)
+	for i, v := range m.Loss {
+		h.Fill((float64(i)+0.5)*w, v)
+	}
+	return h
+}
+
+// MakeLossPlot returns the plot of the number of lost packets versus time.
+func (m *Monitor) MakeLossPlot() *hplot.Plot {
+	p := hplot.New()
+	p.X.Label.Text = "Time (s)"
+	p.Y.Label.Text = fmt.Sprintf("No lost packets / %v", m.BinWidth)
+	hp := hplot.NewH1D(m.LossH1D())
+	hp.FillColor = color.RGBA{R: 255, G: 102, B: 102, A: 255}
+	p.Add(hp)
+	p.Add(hplot.NewGrid())
+	p.BackgroundColor = color.RGBA{R: 230, G: 247, B: 255, A: 255}
+	return p
+}
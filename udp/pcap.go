@@ -0,0 +1,227 @@
+package udp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Capture files use the classic libpcap format, readable by tcpdump and wireshark.
+// Packets are written as raw IPv4 packets (link type LINKTYPE_RAW) with synthesized
+// IPv4 and UDP headers. Captures of IPv4 packets made by tcpdump on an ethernet
+// interface (link type LINKTYPE_ETHERNET) can also be read.
+const (
+	pcapMagic      = 0xa1b2c3d4 // microsecond timestamps
+	pcapMagicNano  = 0xa1b23c4d // nanosecond timestamps
+	pcapSnapLen    = 65535
+	linkTypeEther  = 1
+	linkTypeRaw    = 101
+	ipv4HeaderSize = 20
+	udpHeaderSize  = 8
+	etherHeaderLen = 14
+	ipProtoUDP     = 17
+)
+
+// ErrPcapFormat is returned when reading a file which is not a pcap capture
+// or has an unsupported link type.
+var ErrPcapFormat = errors.New("udp: not a supported pcap file")
+
+// PcapWriter writes packets to a pcap capture file.
+type PcapWriter struct {
+	w   io.Writer
+	err error
+}
+
+// NewPcapWriter returns a writer of packets to w, after having written the pcap file header.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	pw := &PcapWriter{w: w}
+	pw.write(uint32(pcapMagic))
+	pw.write(uint16(2)) // version major
+	pw.write(uint16(4)) // version minor
+	pw.write(int32(0))  // time zone
+	pw.write(uint32(0)) // timestamp accuracy
+	pw.write(uint32(pcapSnapLen))
+	pw.write(uint32(linkTypeRaw))
+	return pw, pw.err
+}
+
+func (w *PcapWriter) write(v interface{}) {
+	if w.err != nil {
+		return
+	}
+	w.err = binary.Write(w.w, binary.LittleEndian, v)
+}
+
+// WritePacket writes the packet p.
+// Missing addresses are replaced by 0.0.0.0:0.
+func (w *PcapWriter) WritePacket(p *Packet) error {
+	if w.err != nil {
+		return w.err
+	}
+	buf := make([]byte, ipv4HeaderSize+udpHeaderSize+len(p.Data))
+	putIPv4UDPHeaders(buf, p.Src, p.Dst, len(p.Data))
+	copy(buf[ipv4HeaderSize+udpHeaderSize:], p.Data)
+
+	n := len(buf)
+	if n > pcapSnapLen {
+		n = pcapSnapLen
+	}
+	w.write(uint32(p.Time.Unix()))
+	w.write(uint32(p.Time.Nanosecond() / 1000))
+	w.write(uint32(n))
+	w.write(uint32(len(buf)))
+	if w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.Write(buf[:n])
+	return w.err
+}
+
+// Flush flushes the underlying io.Writer if it is a *bufio.Writer.
+func (w *PcapWriter) Flush() error {
+	if ww, ok := w.w.(*bufio.Writer); ok && w.err == nil {
+		w.err = ww.Flush()
+	}
+	return w.err
+}
+
+func ip4(addr *net.UDPAddr) ([]byte, uint16) {
+	if addr == nil || addr.IP.To4() == nil {
+		return net.IPv4zero.To4(), 0
+	}
+	return addr.IP.To4(), uint16(addr.Port)
+}
+
+// putIPv4UDPHeaders writes the IPv4 and UDP headers of a packet with a payload of n bytes.
+// The UDP checksum is not computed (0 is allowed for IPv4).
+func putIPv4UDPHeaders(buf []byte, src, dst *net.UDPAddr, n int) {
+	srcIP, srcPort := ip4(src)
+	dstIP, dstPort := ip4(dst)
+	ip := buf[:ipv4HeaderSize]
+	ip[0] = 0x45 // version 4, header length 5*32 bits
+	binary.BigEndian.PutUint16(ip[2:4], uint16(ipv4HeaderSize+udpHeaderSize+n))
+	ip[8] = 64 // TTL
+	ip[9] = ipProtoUDP
+	copy(ip[12:16], srcIP)
+	copy(ip[16:20], dstIP)
+	var sum uint32
+	for i := 0; i < ipv4HeaderSize; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(ip[i : i+2]))
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	binary.BigEndian.PutUint16(ip[10:12], ^uint16(sum))
+
+	udp := buf[ipv4HeaderSize : ipv4HeaderSize+udpHeaderSize]
+	binary.BigEndian.PutUint16(udp[0:2], srcPort)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpHeaderSize+n))
+}
+
+// PcapReader reads the UDP packets of a pcap capture file.
+type PcapReader struct {
+	r         io.Reader
+	byteOrder binary.ByteOrder
+	nano      bool
+	linkType  uint32
+	hdr       [16]byte
+}
+
+// NewPcapReader returns a reader of the UDP packets of the capture read from r.
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	pr := &PcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(hdr[:4]) == pcapMagic:
+		pr.byteOrder = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr[:4]) == pcapMagic:
+		pr.byteOrder = binary.BigEndian
+	case binary.LittleEndian.Uint32(hdr[:4]) == pcapMagicNano:
+		pr.byteOrder = binary.LittleEndian
+		pr.nano = true
+	case binary.BigEndian.Uint32(hdr[:4]) == pcapMagicNano:
+		pr.byteOrder = binary.BigEndian
+		pr.nano = true
+	default:
+		return nil, ErrPcapFormat
+	}
+	pr.linkType = pr.byteOrder.Uint32(hdr[20:24])
+	if pr.linkType != linkTypeRaw && pr.linkType != linkTypeEther {
+		return nil, fmt.Errorf("udp: pcap link type %v not supported", pr.linkType)
+	}
+	return pr, nil
+}
+
+// ReadPacket returns the next UDP packet of the capture.
+// Records which are not complete IPv4 UDP packets are skipped.
+// At the end of the capture, it returns nil and io.EOF.
+func (r *PcapReader) ReadPacket() (*Packet, error) {
+	for {
+		if _, err := io.ReadFull(r.r, r.hdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return nil, err
+		}
+		sec := r.byteOrder.Uint32(r.hdr[0:4])
+		frac := r.byteOrder.Uint32(r.hdr[4:8])
+		inclLen := r.byteOrder.Uint32(r.hdr[8:12])
+		origLen := r.byteOrder.Uint32(r.hdr[12:16])
+		buf := make([]byte, inclLen)
+		if _, err := io.ReadFull(r.r, buf); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return nil, err
+		}
+		if inclLen != origLen { // truncated packet
+			continue
+		}
+		nsec := int64(frac)
+		if !r.nano {
+			nsec *= 1000
+		}
+		p := parseIPv4UDP(buf, r.linkType)
+		if p == nil {
+			continue
+		}
+		p.Time = time.Unix(int64(sec), nsec)
+		return p, nil
+	}
+}
+
+// parseIPv4UDP returns the UDP packet in the captured data, or nil if it is not an IPv4 UDP packet.
+func parseIPv4UDP(buf []byte, linkType uint32) *Packet {
+	if linkType == linkTypeEther {
+		if len(buf) < etherHeaderLen || binary.BigEndian.Uint16(buf[12:14]) != 0x0800 {
+			return nil
+		}
+		buf = buf[etherHeaderLen:]
+	}
+	if len(buf) < ipv4HeaderSize || buf[0]>>4 != 4 || buf[9] != ipProtoUDP {
+		return nil
+	}
+	ihl := int(buf[0]&0x0f) * 4
+	totLen := int(binary.BigEndian.Uint16(buf[2:4]))
+	if ihl < ipv4HeaderSize || totLen > len(buf) || ihl+udpHeaderSize > totLen {
+		return nil
+	}
+	udp := buf[ihl:totLen]
+	udpLen := int(binary.BigEndian.Uint16(udp[4:6]))
+	if udpLen < udpHeaderSize || udpLen > len(udp) {
+		return nil
+	}
+	return &Packet{
+		Src:  &net.UDPAddr{IP: net.IP(append([]byte(nil), buf[12:16]...)), Port: int(binary.BigEndian.Uint16(udp[0:2]))},
+		Dst:  &net.UDPAddr{IP: net.IP(append([]byte(nil), buf[16:20]...)), Port: int(binary.BigEndian.Uint16(udp[2:4]))},
+		Data: udp[udpHeaderSize:udpLen],
+	}
+}
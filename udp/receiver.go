@@ -0,0 +1,124 @@
+package udp
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// Receiver reads the packets sent to a UDP socket.
+// Each packet is checked by Monitor and, if Pcap is not nil, written to it.
+type Receiver struct {
+	Monitor *Monitor
+	Pcap    *PcapWriter
+
+	conn *net.UDPConn
+	buf  []byte
+	data []byte // payload of the current packet not yet returned by Read
+}
+
+// Listen returns a receiver of the packets sent to the local address addr (e.g. ":60000").
+// The loss histogram of the monitor has time bins of 1 second.
+func Listen(addr string) (*Receiver, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadBuffer(8 << 20)
+	return NewReceiver(conn), nil
+}
+
+// NewReceiver returns a receiver of the packets read from conn.
+func NewReceiver(conn *net.UDPConn) *Receiver {
+	return &Receiver{
+		Monitor: NewMonitor(time.Second),
+		conn:    conn,
+		buf:     make([]byte, MaxPacketSize),
+	}
+}
+
+// ReadPacket reads the next packet.
+func (r *Receiver) ReadPacket() (*Packet, error) {
+	n, src, err := r.conn.ReadFromUDP(r.buf)
+	if err != nil {
+		return nil, err
+	}
+	p := &Packet{
+		Time: time.Now(),
+		Src:  src,
+		Data: append([]byte(nil), r.buf[:n]...),
+	}
+	if laddr, ok := r.conn.LocalAddr().(*net.UDPAddr); ok {
+		p.Dst = laddr
+	}
+	r.Monitor.Add(p)
+	if r.Pcap != nil {
+		if err := r.Pcap.WritePacket(p); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// Read implements io.Reader: it returns the payloads of the packets, one after
+// the other, so that the frames can be decoded by a rw.Reader as from a file.
+func (r *Receiver) Read(data []byte) (int, error) {
+	for len(r.data) == 0 {
+		p, err := r.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+		r.data = p.Data
+	}
+	n := copy(data, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// Close flushes the pcap writer, if any, and closes the socket.
+func (r *Receiver) Close() error {
+	if r.Pcap != nil {
+		r.Pcap.Flush()
+	}
+	return r.conn.Close()
+}
+
+// Replay sends the packets of the capture read from r to w (e.g. a UDP socket
+// connected to godaq with net.DialUDP), one packet per call to w.Write.
+// If speed is positive, packets are sent at the pace of the capture accelerated
+// by a factor speed (speed = 1: original pace); otherwise they are sent as fast as possible.
+// It returns the number of packets sent.
+func Replay(r *PcapReader, w io.Writer, speed float64) (int, error) {
+	var (
+		first time.Time
+		start time.Time
+		n     int
+	)
+	for {
+		p, err := r.ReadPacket()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if speed > 0 {
+			if n == 0 {
+				first = p.Time
+				start = time.Now()
+			}
+			due := start.Add(time.Duration(float64(p.Time.Sub(first)) / speed))
+			if d := time.Until(due); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		if _, err := w.Write(p.Data); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
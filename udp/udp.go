@@ -0,0 +1,56 @@
+// Package udp implements the UDP front-end layer of the µTCA acquisition
+// (dpgatca and rct), in which each UDP packet sent by the AMC carries one frame.
+//
+// A Receiver reads the packets from a socket, checks their frame counters with a
+// Monitor to detect lost and reordered packets, and can write them to a
+// pcap-compatible capture file (see PcapWriter). Captures (written by a Receiver or
+// by tcpdump) can then be replayed to a socket with Replay, at the original pace or
+// faster, in order to debug acquisition problems offline.
+package udp
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// HeaderSize is the number of bytes of the frame header needed by ParseCounters.
+const HeaderSize = 20
+
+// MaxPacketSize is the size of the buffer used to read packets.
+const MaxPacketSize = 9000
+
+// ErrShortPacket is returned for packets too short to contain a frame header.
+var ErrShortPacket = errors.New("udp: packet shorter than frame header")
+
+// Packet is a UDP packet.
+type Packet struct {
+	Time time.Time    // reception time
+	Src  *net.UDPAddr // source address (nil if unknown)
+	Dst  *net.UDPAddr // destination address (nil if unknown)
+	Data []byte       // UDP payload, i.e. the frame
+}
+
+// Counters are the frame counters in the header of a frame.
+type Counters struct {
+	NbFrameAmc uint32 // frame counter of the AMC, common to all front-ends
+	FEId       uint16 // front-end ID
+	NoFrameAsm uint64 // frame counter of the front-end (ASM board)
+}
+
+// ParseCounters returns the frame counters from the header of the frame in data.
+// The layout is the one of rw.FrameHeader in dpgatca and rct: big endian 16 bits
+// words, except for the FEIdK30 word (little endian).
+func ParseCounters(data []byte) (Counters, error) {
+	var c Counters
+	if len(data) < HeaderSize {
+		return c, ErrShortPacket
+	}
+	u16 := func(i int) uint64 {
+		return uint64(data[2*i])<<8 | uint64(data[2*i+1])
+	}
+	c.NbFrameAmc = uint32(u16(1)<<16 | u16(2))
+	c.FEId = uint16(data[6]) & 0x7f
+	c.NoFrameAsm = u16(6)<<48 | u16(7)<<32 | u16(8)<<16 | u16(9)
+	return c, nil
+}
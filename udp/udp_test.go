@@ -0,0 +1,115 @@
+package udp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"gitlab.in2p3.fr/avirm/analysis-go/dpgatca/rw"
+)
+
+func TestParseCounters(t *testing.T) {
+	f := &rw.Frame{}
+	f.Header.NbFrameAmcMsb = 0x1234
+	f.Header.NbFrameAmcLsb = 0x5678
+	f.Header.FEIdK30 = 0x91 // parity bit set
+	f.Header.NoFrameAsmMsb = 1
+	f.Header.NoFrameAsmOsb = 2
+	f.Header.NoFrameAsmUsb = 3
+	f.Header.NoFrameAsmLsb = 4
+	var buf bytes.Buffer
+	if err := rw.NewWriter(&buf).Frame(f); err != nil {
+		t.Fatalf("error writing frame: %v\n", err)
+	}
+	data := buf.Bytes()
+
+	c, err := ParseCounters(data)
+	if err != nil {
+		t.Fatalf("error parsing counters: %v\n", err)
+	}
+	want := Counters{NbFrameAmc: 0x12345678, FEId: 0x11, NoFrameAsm: 1<<48 | 2<<32 | 3<<16 | 4}
+	if c != want {
+		t.Errorf("got %+v, want %+v\n", c, want)
+	}
+	if _, err := ParseCounters(data[:HeaderSize-1]); err != ErrShortPacket {
+		t.Errorf("got error %v for a short packet, want %v\n", err, ErrShortPacket)
+	}
+}
+
+func TestPcapRoundTrip(t *testing.T) {
+	t0 := time.Date(2026, 5, 4, 12, 0, 0, 123456000, time.UTC)
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20).To4(), Port: 4660}
+	dst := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 1).To4(), Port: 60000}
+	packets := []*Packet{
+		{Time: t0, Src: src, Dst: dst, Data: []byte{1, 2, 3, 4, 5}},
+		{Time: t0.Add(1500 * time.Microsecond), Src: src, Dst: dst, Data: bytes.Repeat([]byte{0xab}, 8000)},
+		{Time: t0.Add(time.Second), Src: src, Dst: dst, Data: []byte{}},
+	}
+	var buf bytes.Buffer
+	w, err := NewPcapWriter(&buf)
+	if err != nil {
+		t.Fatalf("error creating pcap writer: %v\n", err)
+	}
+	for _, p := range packets {
+		if err := w.WritePacket(p); err != nil {
+			t.Fatalf("error writing packet: %v\n", err)
+		}
+	}
+
+	r, err := NewPcapReader(&buf)
+	if err != nil {
+		t.Fatalf("error creating pcap reader: %v\n", err)
+	}
+	for i, want := range packets {
+		p, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("error reading packet %v: %v\n", i, err)
+		}
+		if !p.Time.Equal(want.Time) {
+			t.Errorf("packet %v: time = %v, want %v\n", i, p.Time, want.Time)
+		}
+		if !reflect.DeepEqual(p.Src, want.Src) || !reflect.DeepEqual(p.Dst, want.Dst) {
+			t.Errorf("packet %v: addresses = %v -> %v, want %v -> %v\n", i, p.Src, p.Dst, want.Src, want.Dst)
+		}
+		if !bytes.Equal(p.Data, want.Data) {
+			t.Errorf("packet %v: data differ\n", i)
+		}
+	}
+	if p, err := r.ReadPacket(); p != nil || err != io.EOF {
+		t.Errorf("got %v, %v at the end of the capture, want nil, io.EOF\n", p, err)
+	}
+}
+
+func TestCounterStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		wrap     uint64
+		counters []uint64
+		want     CounterStats
+	}{
+		{"in order", 0, []uint64{1, 2, 3}, CounterStats{Received: 3}},
+		{"loss", 0, []uint64{1, 4, 5}, CounterStats{Received: 3, Lost: 2}},
+		{"reordered", 0, []uint64{1, 4, 3, 2, 5}, CounterStats{Received: 5, Reordered: 2}},
+		{"duplicated", 0, []uint64{1, 2, 2, 3}, CounterStats{Received: 4, Duplicated: 1}},
+		{"wrap", 1 << 32, []uint64{0xfffffffe, 0xffffffff, 0, 1}, CounterStats{Received: 4}},
+		{"wrap with loss", 1 << 32, []uint64{0xfffffffe, 1, 2}, CounterStats{Received: 3, Lost: 2}},
+		{"reordered across wrap", 1 << 32, []uint64{0xfffffffe, 0, 0xffffffff, 1}, CounterStats{Received: 4, Reordered: 1}},
+		{"reset", 0, []uint64{100000, 100001, 5, 6, 6}, CounterStats{Received: 5, Duplicated: 1, Resets: 1}},
+		{"reset of wrapping counter", 1 << 32, []uint64{100000, 100001, 5, 6}, CounterStats{Received: 4, Resets: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := CounterStats{wrap: test.wrap}
+			for _, c := range test.counters {
+				s.add(c)
+			}
+			if s.Received != test.want.Received || s.Lost != test.want.Lost || s.Reordered != test.want.Reordered ||
+				s.Duplicated != test.want.Duplicated || s.Resets != test.want.Resets {
+				t.Errorf("got %v, want %v", &s, &test.want)
+			}
+		})
+	}
+}
@@ -18,7 +18,7 @@ import (
 func H1DToGonum(histo ...hbook.H1D) []plotter.Histogram {
 	output := make([]plotter.Histogram, len(histo))
 	for i, h := range histo {
-		h, err := plotter.NewHistogram(&h, len(h.Binning().Bins()))
+		h, err := plotter.NewHistogram(&h, len(h.Binning.Bins))
 		if err != nil {
 			panic(err)
 		}
@@ -100,10 +100,7 @@ func MakeHPl(xTitle string, yTitle string, outFile string, hHplot ...hplot.H1D)
 
 // MakeGonumPlot makes a plot with hbook.H1D objects.
 func MakeGonumPlot(xTitle string, yTitle string, outFile string, histo ...hbook.H1D) {
-	p, err := plot.New()
-	if err != nil {
-		panic(err)
-	}
+	p := plot.New()
 
 	p.X.Label.Text = xTitle
 	p.Y.Label.Text = yTitle